@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// -admin-api lets operators adjust limits and disable routes at runtime
+// with the admin routes, without editing the config file and reloading.
+// Changes are written back to the config file, so they survive restarts
+// and reloads.
+var adminAPI = flag.Bool("admin-api", false, "serve /admin/settings and /admin/counter/reset to change settings and reset the counter at runtime")
+
+func init() {
+	registerFeature(feature{name: "admin API", kind: "subsystem", active: func() bool {
+		return *adminAPI
+	}})
+}
+
+// adminSettings are the settings that can be changed with the admin API.
+var adminSettings = []string{
+	"rate-limit",
+	"rate-burst",
+	"quota",
+	"quota-window",
+	"min-length",
+	"max-length",
+	"max-count",
+	"suggestions",
+	"disabled-routes",
+}
+
+type adminSetting struct {
+	Name  string `json:"name" xml:"name,attr"`
+	Value string `json:"value" xml:",chardata"`
+	// Fixed settings were given on the command line, which takes precedence
+	// over the config file, so they can't be changed.
+	Fixed bool `json:"fixed,omitempty" xml:"fixed,attr,omitempty"`
+}
+
+type settingsResult struct {
+	XMLName  xml.Name       `json:"-" xml:"settings"`
+	Settings []adminSetting `json:"settings" xml:"setting"`
+}
+
+func (r settingsResult) text() string {
+	var sb strings.Builder
+	for _, s := range r.Settings {
+		fmt.Fprintf(&sb, "%s: %s", s.Name, s.Value)
+		if s.Fixed {
+			sb.WriteString(" (command line)")
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (r settingsResult) csvRecords() [][]string {
+	records := [][]string{{"name", "value", "fixed"}}
+	for _, s := range r.Settings {
+		records = append(records, []string{s.Name, s.Value, fmt.Sprint(s.Fixed)})
+	}
+	return records
+}
+
+func currentSettings() settingsResult {
+	var r settingsResult
+	for _, name := range adminSettings {
+		r.Settings = append(r.Settings, adminSetting{name, flag.Lookup(name).Value.String(), commandLineFlags[name]})
+	}
+	return r
+}
+
+// settingsHandler shows the settings that can be changed with the admin
+// API and, when POSTed, changes those given as form values.
+func settingsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		render(w, req, currentSettings())
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	adjustable := make(map[string]bool)
+	for _, name := range adminSettings {
+		adjustable[name] = true
+	}
+	changes := make(map[string]string)
+	for name, values := range req.PostForm {
+		switch {
+		case !adjustable[name]:
+			http.Error(w, fmt.Sprintf("%s can't be changed at runtime", name), http.StatusBadRequest)
+			return
+		case commandLineFlags[name]:
+			http.Error(w, fmt.Sprintf("%s was given on the command line", name), http.StatusConflict)
+			return
+		case clusterSettings[name] != nil:
+			http.Error(w, fmt.Sprintf("%s is set by the cluster primary", name), http.StatusConflict)
+			return
+		}
+		changes[name] = values[len(values)-1]
+	}
+
+	// Other requests have to finish before the settings they use change.
+	releaseConfigLock(req)
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	before := settingValues()
+	if status, err := changeSettings(changes); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordConfigDiff(&configDiff{Time: time.Now(), Source: "admin API", Identity: requestIdentity(req),
+		Changes: diffSettings(before, settingValues())})
+	render(w, req, currentSettings())
+}
+
+// changeSettings sets the flags in changes and writes them to the config
+// file. If the new settings are invalid or can't be saved the current ones
+// are kept. configLock must be held for writing.
+func changeSettings(changes map[string]string) (int, error) {
+	current := make(map[string]string)
+	for name := range changes {
+		current[name] = flag.Lookup(name).Value.String()
+	}
+	restore := func() {
+		for name, v := range current {
+			flag.Set(name, v)
+		}
+	}
+
+	for name, v := range changes {
+		if err := flag.Set(name, v); err != nil {
+			restore()
+			return http.StatusBadRequest, fmt.Errorf("setting %s: %s", name, err)
+		}
+	}
+	if err := validateConfig(); err != nil {
+		restore()
+		return http.StatusBadRequest, err
+	}
+	if err := saveSettings(changes); err != nil {
+		restore()
+		log.Print("Failed to save settings: ", err)
+		return http.StatusInternalServerError, errors.New("failed to save settings")
+	}
+	atomic.StoreInt32(&bufferedLength, int32(*maxPasswordLength))
+	return 0, nil
+}
+
+// saveSettings writes changes to the config file, keeping its other
+// settings.
+func saveSettings(changes map[string]string) error {
+	settings, err := readConfig(*configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if settings == nil {
+		settings = make(map[string][]string)
+	}
+	for name, v := range changes {
+		settings[name] = []string{v}
+	}
+
+	tmp := *configPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = writeConfig(f, settings)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, *configPath)
+}
+
+// resetCounterHandler resets the counter and usage stats to zero.
+func resetCounterHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "resets must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	old := counter.value()
+	counter.reset()
+	stats.reset()
+	saveCounter()
+	logf(req, "Counter reset by %s", requestIdentity(req))
+	adminAudit.recordReset(requestIdentity(req), old)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *passwordCounter) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n = 0
+	c.pushed = 0
+	c.started = time.Now()
+	c.endpoints = make(map[string]uint64)
+	c.approx = 0
+	c.updated = time.Time{}
+}
+
+func (s *usageStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Hours = make(map[int64]map[string]uint64)
+	s.Previews = make(map[int64]map[string]uint64)
+}
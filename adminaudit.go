@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// For change-management evidence, -admin-audit-log records every change to
+// the settings, whether by a reload, the admin API or a cluster primary, and
+// every counter reset, as JSON lines appended to a file or sent to syslog.
+// Each line is one setting changed, with who changed it and its old and new
+// values, redacted like the config diff. The file is only ever appended to,
+// and synced after each action so a crash can't lose it.
+var adminAuditLog = flag.String("admin-audit-log", "", "`file` to append a JSON line to for each settings change and counter reset, or syslog")
+
+func init() {
+	registerFeature(feature{name: "admin audit log", kind: "subsystem", active: func() bool {
+		return *adminAuditLog != ""
+	}})
+}
+
+type adminAuditEntry struct {
+	Time time.Time `json:"time"`
+	// Actor is the authenticated client for the admin API, SIGHUP for
+	// reloads and the primary's URL for cluster policy changes.
+	Actor  string `json:"actor"`
+	Source string `json:"source"`
+	// Action is "set" for a setting changed, "set on restart" for a change
+	// to a setting that only takes effect on restart, or "reset counter".
+	Action  string   `json:"action"`
+	Setting string   `json:"setting,omitempty"`
+	Old     []string `json:"old"`
+	New     []string `json:"new"`
+}
+
+// adminAuditor writes audit entries to w, syncing them if it is a file.
+type adminAuditor struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// adminAudit is nil unless -admin-audit-log is set.
+var adminAudit *adminAuditor
+
+// setupAdminAudit opens -admin-audit-log, if it is set.
+func setupAdminAudit() error {
+	var w io.Writer
+	switch *adminAuditLog {
+	case "":
+		return nil
+	case "syslog":
+		var err error
+		if w, err = newSyslogWriter("random-password-please-audit"); err != nil {
+			return err
+		}
+	default:
+		f, err := os.OpenFile(*adminAuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	adminAudit = &adminAuditor{w: w}
+	return nil
+}
+
+// write appends entries, logging if they can't be written.
+func (a *adminAuditor) write(entries []adminAuditEntry) {
+	if a == nil || len(entries) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err == nil {
+			_, err = a.w.Write(append(data, '\n'))
+		}
+		if err != nil {
+			log.Printf("Failed to write the admin audit log: %s", err)
+			return
+		}
+	}
+	if f, ok := a.w.(*os.File); ok {
+		if err := f.Sync(); err != nil {
+			log.Printf("Failed to sync the admin audit log: %s", err)
+		}
+	}
+}
+
+// recordDiff audits the changes of d.
+func (a *adminAuditor) recordDiff(d *configDiff) {
+	if a == nil {
+		return
+	}
+	actor := d.Identity
+	switch {
+	case actor != "":
+	case d.Source == "reload":
+		actor = "SIGHUP"
+	case d.Source == "cluster primary":
+		actor = *clusterPrimary
+	}
+	entries := make([]adminAuditEntry, len(d.Changes))
+	for i, c := range d.Changes {
+		action := "set"
+		if c.Pending {
+			action = "set on restart"
+		}
+		entries[i] = adminAuditEntry{Time: d.Time.UTC(), Actor: actor, Source: d.Source, Action: action,
+			Setting: c.Name, Old: c.Old, New: c.New}
+	}
+	a.write(entries)
+}
+
+// recordReset audits identity resetting the counter from old.
+func (a *adminAuditor) recordReset(identity string, old uint64) {
+	a.write([]adminAuditEntry{{Time: time.Now().UTC(), Actor: identity, Source: "admin API", Action: "reset counter",
+		Old: []string{fmt.Sprint(old)}, New: []string{"0"}}})
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// argon2id derives a key as in RFC 9106, version 0x13, with the Blake2b of
+// RFC 7693 that it's built on. Lanes are filled one after another rather
+// than in parallel, which gives the same result.
+
+const (
+	argon2Version     = 0x13
+	argon2dType       = 0
+	argon2iType       = 1
+	argon2idType      = 2
+	argon2BlockWords  = 128
+	argon2SyncPoints  = 4
+	argon2SaltBytes   = 16
+	argon2KeyBytes    = 32
+	argon2AddressSize = argon2BlockWords
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+// argon2id returns the tag of tagLen bytes for password and salt, using
+// time passes over memory KiB with threads lanes.
+func argon2id(password, salt []byte, time, memory uint32, threads uint8, tagLen uint32) []byte {
+	return argon2Key(argon2idType, password, salt, nil, nil, time, memory, threads, tagLen)
+}
+
+// argon2Key derives a tag with Argon2 of type typ, with the optional secret
+// key and associated data.
+func argon2Key(typ uint32, password, salt, secret, data []byte, time, memory uint32, threads uint8, tagLen uint32) []byte {
+	lanes := uint32(threads)
+	if memory < 8*lanes {
+		memory = 8 * lanes
+	}
+	segmentLen := memory / (argon2SyncPoints * lanes)
+	laneLen := segmentLen * argon2SyncPoints
+	blocks := make([]argon2Block, laneLen*lanes)
+
+	h0 := blake2b(64,
+		le32(lanes), le32(tagLen), le32(memory), le32(time), le32(argon2Version), le32(typ),
+		le32(uint32(len(password))), password, le32(uint32(len(salt))), salt,
+		le32(uint32(len(secret))), secret, le32(uint32(len(data))), data)
+	for lane := uint32(0); lane < lanes; lane++ {
+		for i := uint32(0); i < 2; i++ {
+			b := argon2Hash(1024, h0, le32(i), le32(lane))
+			for j := range blocks[lane*laneLen+i] {
+				blocks[lane*laneLen+i][j] = binary.LittleEndian.Uint64(b[8*j:])
+			}
+		}
+	}
+
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			for lane := uint32(0); lane < lanes; lane++ {
+				argon2Segment(blocks, typ, pass, slice, lane, lanes, laneLen, segmentLen, time)
+			}
+		}
+	}
+
+	var final argon2Block
+	for lane := uint32(0); lane < lanes; lane++ {
+		last := &blocks[lane*laneLen+laneLen-1]
+		for i := range final {
+			final[i] ^= last[i]
+		}
+	}
+	b := make([]byte, 1024)
+	for i, w := range final {
+		binary.LittleEndian.PutUint64(b[8*i:], w)
+	}
+	return argon2Hash(tagLen, b)
+}
+
+// argon2Segment fills one segment of a lane. Argon2i picks reference blocks
+// independently of the data and Argon2d from the previous block; Argon2id
+// does as Argon2i for the first two slices of the first pass and as
+// Argon2d after.
+func argon2Segment(blocks []argon2Block, typ, pass, slice, lane, lanes, laneLen, segmentLen, time uint32) {
+	independent := typ == argon2iType || typ == argon2idType && pass == 0 && slice < argon2SyncPoints/2
+	var address, input, zero argon2Block
+	if independent {
+		input[0] = uint64(pass)
+		input[1] = uint64(lane)
+		input[2] = uint64(slice)
+		input[3] = uint64(laneLen * lanes)
+		input[4] = uint64(time)
+		input[5] = uint64(typ)
+	}
+	nextAddresses := func() {
+		input[6]++
+		argon2Fill(&address, &zero, &input, false)
+		argon2Fill(&address, &zero, &address, false)
+	}
+
+	start := uint32(0)
+	if pass == 0 && slice == 0 {
+		start = 2
+		if independent {
+			nextAddresses()
+		}
+	}
+	offset := lane*laneLen + slice*segmentLen + start
+	for i := start; i < segmentLen; i, offset = i+1, offset+1 {
+		prev := offset - 1
+		if offset%laneLen == 0 {
+			prev = offset + laneLen - 1
+		}
+		var random uint64
+		if independent {
+			if i%argon2AddressSize == 0 {
+				nextAddresses()
+			}
+			random = address[i%argon2AddressSize]
+		} else {
+			random = blocks[prev][0]
+		}
+		refLane := uint32(random>>32) % lanes
+		if pass == 0 && slice == 0 {
+			refLane = lane
+		}
+
+		// The reference block is drawn, biased towards recent blocks,
+		// from those already finished and not being filled by other lanes.
+		var area uint32
+		switch {
+		case pass == 0 && slice == 0:
+			area = i - 1
+		case pass == 0 && refLane == lane:
+			area = slice*segmentLen + i - 1
+		case pass == 0:
+			area = slice * segmentLen
+		case refLane == lane:
+			area = laneLen - segmentLen + i - 1
+		default:
+			area = laneLen - segmentLen
+		}
+		if refLane != lane && i == 0 {
+			area--
+		}
+		x := random & 0xffffffff
+		x = x * x >> 32
+		rel := uint64(area) - 1 - uint64(area)*x>>32
+		startPos := uint64(0)
+		if pass != 0 && slice != argon2SyncPoints-1 {
+			startPos = uint64(slice+1) * uint64(segmentLen)
+		}
+		ref := refLane*laneLen + uint32((startPos+rel)%uint64(laneLen))
+
+		argon2Fill(&blocks[offset], &blocks[prev], &blocks[ref], pass > 0)
+	}
+}
+
+// argon2Fill sets out to the compression G of x and y, XORed with out's old
+// value if xor is set.
+func argon2Fill(out, x, y *argon2Block, xor bool) {
+	var r, t argon2Block
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	t = r
+	if xor {
+		for i := range t {
+			t[i] ^= out[i]
+		}
+	}
+	for i := 0; i < 8; i++ {
+		v := r[16*i : 16*i+16]
+		argon2Round(&v[0], &v[1], &v[2], &v[3], &v[4], &v[5], &v[6], &v[7],
+			&v[8], &v[9], &v[10], &v[11], &v[12], &v[13], &v[14], &v[15])
+	}
+	for i := 0; i < 8; i++ {
+		c := 2 * i
+		argon2Round(&r[c], &r[c+1], &r[c+16], &r[c+17], &r[c+32], &r[c+33], &r[c+48], &r[c+49],
+			&r[c+64], &r[c+65], &r[c+80], &r[c+81], &r[c+96], &r[c+97], &r[c+112], &r[c+113])
+	}
+	for i := range out {
+		out[i] = t[i] ^ r[i]
+	}
+}
+
+// argon2Round is Blake2b's round without message words, with the
+// multiplications Argon2 adds to its mixing function.
+func argon2Round(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14, v15 *uint64) {
+	argon2G(v0, v4, v8, v12)
+	argon2G(v1, v5, v9, v13)
+	argon2G(v2, v6, v10, v14)
+	argon2G(v3, v7, v11, v15)
+	argon2G(v0, v5, v10, v15)
+	argon2G(v1, v6, v11, v12)
+	argon2G(v2, v7, v8, v13)
+	argon2G(v3, v4, v9, v14)
+}
+
+func argon2G(a, b, c, d *uint64) {
+	fBlaMka := func(x, y uint64) uint64 {
+		return x + y + 2*(x&0xffffffff)*(y&0xffffffff)
+	}
+	*a = fBlaMka(*a, *b)
+	*d = bits.RotateLeft64(*d^*a, -32)
+	*c = fBlaMka(*c, *d)
+	*b = bits.RotateLeft64(*b^*c, -24)
+	*a = fBlaMka(*a, *b)
+	*d = bits.RotateLeft64(*d^*a, -16)
+	*c = fBlaMka(*c, *d)
+	*b = bits.RotateLeft64(*b^*c, -63)
+}
+
+// argon2Hash is Argon2's variable-length hash H', which chains Blake2b for
+// outputs longer than 64 bytes.
+func argon2Hash(outLen uint32, in ...[]byte) []byte {
+	in = append([][]byte{le32(outLen)}, in...)
+	if outLen <= 64 {
+		return blake2b(int(outLen), in...)
+	}
+	out := make([]byte, 0, outLen)
+	v := blake2b(64, in...)
+	for int(outLen)-len(out) > 64 {
+		out = append(out, v[:32]...)
+		// The last hash is sized to make up the rest.
+		v = blake2b(min64(int(outLen)-len(out)), v)
+	}
+	return append(out, v...)
+}
+
+func min64(n int) int {
+	if n > 64 {
+		return 64
+	}
+	return n
+}
+
+func le32(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, n)
+	return b
+}
+
+var blake2bIV = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+var blake2bSigma = [10][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// blake2b returns the unkeyed Blake2b hash, of outLen bytes, of the
+// concatenation of in.
+func blake2b(outLen int, in ...[]byte) []byte {
+	var data []byte
+	for _, b := range in {
+		data = append(data, b...)
+	}
+	h := blake2bIV
+	h[0] ^= 0x01010000 ^ uint64(outLen)
+	var t uint64
+	for {
+		var block [128]byte
+		n := copy(block[:], data)
+		data = data[n:]
+		t += uint64(n)
+		blake2bCompress(&h, &block, t, len(data) == 0)
+		if len(data) == 0 {
+			break
+		}
+	}
+	out := make([]byte, 64)
+	for i, w := range h {
+		binary.LittleEndian.PutUint64(out[8*i:], w)
+	}
+	return out[:outLen]
+}
+
+func blake2bCompress(h *[8]uint64, block *[128]byte, t uint64, last bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(block[8*i:])
+	}
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= t
+	if last {
+		v[14] = ^v[14]
+	}
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	for r := 0; r < 12; r++ {
+		s := &blake2bSigma[r%10]
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestArgon2Vectors checks the test vectors of RFC 9106, section 5, for
+// each type: 32-byte tags over 32 KiB in 3 passes with 4 lanes, a secret
+// key and associated data.
+func TestArgon2Vectors(t *testing.T) {
+	password := bytes.Repeat([]byte{1}, 32)
+	salt := bytes.Repeat([]byte{2}, 16)
+	secret := bytes.Repeat([]byte{3}, 8)
+	data := bytes.Repeat([]byte{4}, 12)
+	for _, tt := range []struct {
+		name string
+		typ  uint32
+		tag  string
+	}{
+		{"argon2d", argon2dType, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{"argon2i", argon2iType, "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8"},
+		{"argon2id", argon2idType, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+	} {
+		if got := hex.EncodeToString(argon2Key(tt.typ, password, salt, secret, data, 3, 32, 4, 32)); got != tt.tag {
+			t.Errorf("%s: tag %s, want %s", tt.name, got, tt.tag)
+		}
+	}
+}
+
+// TestBlake2bVector checks the BLAKE2b-512 of "abc" from RFC 7693,
+// appendix A.
+func TestBlake2bVector(t *testing.T) {
+	const want = "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d1" +
+		"7d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"
+	if got := hex.EncodeToString(blake2b(64, []byte("abc"))); got != want {
+		t.Errorf("BLAKE2b-512(abc) = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// Passwords and secrets must never be written to logs. The audit log only
+// records the fields of auditEntry, none of which can hold generated values:
+// requests are identified by the pattern of the route that served them
+// rather than their path, and only the names of query parameters are
+// recorded, never their values. Request and response bodies are never
+// logged.
+var auditLog = flag.Bool("audit-log", false, "log metadata of every request, without query values, bodies or generated values")
+
+type auditEntry struct {
+	XMLName   xml.Name  `json:"-" xml:"request"`
+	Time      time.Time `json:"time" xml:"time"`
+	RequestID string    `json:"request_id" xml:"request_id"`
+	Client    string    `json:"client" xml:"client"`
+	Identity  string    `json:"identity,omitempty" xml:"identity,omitempty"`
+	Method    string    `json:"method" xml:"method"`
+	Route     string    `json:"route" xml:"route"`
+	Params    []string  `json:"params,omitempty" xml:"param,omitempty"`
+	Status    int       `json:"status" xml:"status"`
+	Bytes     int       `json:"bytes" xml:"bytes"`
+	Duration  float64   `json:"duration_ms" xml:"duration_ms"`
+}
+
+func newAuditEntry(req *http.Request, route string, rec *statusRecorder, start time.Time) auditEntry {
+	e := auditEntry{
+		Time:      start.UTC(),
+		RequestID: requestID(req),
+		Client:    clientIP(req),
+		Identity:  rec.identity,
+		Method:    req.Method,
+		Route:     route,
+		Status:    rec.code,
+		Bytes:     rec.bytes,
+		Duration:  float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	for name := range req.URL.Query() {
+		e.Params = append(e.Params, name)
+	}
+	sort.Strings(e.Params)
+	return e
+}
+
+func logRequest(e auditEntry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	log.Printf("audit %s", data)
+}
+
+// recoverPanic recovers from a panic in a handler serving req, logging its
+// type and a stack trace but not its value, which could contain a password,
+// and responds with a 500 error. It must be deferred.
+func recoverPanic(w http.ResponseWriter, req *http.Request, route string) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	logf(req, "panic serving %s: value of type %T\n%s", route, v, debug.Stack())
+	http.Error(w, "internal server error (request "+requestID(req)+")", http.StatusInternalServerError)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestNoPasswordsLogged serves requests that return or take passwords or
+// secret links through the full middleware chain, with the audit log and
+// tracing on, and checks that none of them end up in the log or the spans.
+func TestNoPasswordsLogged(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(e *spanExporter) { tracer = e }(tracer)
+	tracer = &spanExporter{
+		sampler: func(*spanContext, [16]byte) bool { return true },
+		flushed: make(chan struct{}, 1),
+	}
+	useSecrets(t)
+	setFlag(t, "audit-log", "true")
+	setFlag(t, "rate-limit", "1000")
+	setFlag(t, "rate-burst", "1000")
+	setFlag(t, "max-stream-count", "1000")
+	s := newServer()
+
+	var passwords []string
+	for _, target := range []string{
+		"/password.txt?len=16",
+		"/password.txt?len=16&count=5",
+		"/password.txt?len=16&count=500", // streamed
+		"/password.txt?len=16&count=5&format=json",
+	} {
+		rec := do(s, "", target, nil)
+		if rec.Code != 200 {
+			t.Fatalf("%s: status %d", target, rec.Code)
+		}
+		body := rec.Body.String()
+		if strings.HasSuffix(target, "json") {
+			for _, f := range strings.FieldsFunc(body, func(r rune) bool { return strings.ContainsRune(`{}[]":,`, r) }) {
+				if len(f) == 16 {
+					passwords = append(passwords, f)
+				}
+			}
+			continue
+		}
+		passwords = append(passwords, strings.Fields(body)...)
+	}
+	if len(passwords) != 1+5+500+5 {
+		t.Fatalf("got %d passwords, want %d", len(passwords), 1+5+500+5)
+	}
+
+	// Passwords sent to be rated must not be logged either, even when
+	// they're wrongly put in the URL and refused.
+	rated := []string{"correct horse battery staple", "Tr0ub4dor&3xyz"}
+	if rec := do(s, "POST", "/strength?password="+url.QueryEscape(rated[0]), url.Values{}); rec.Code != 400 {
+		t.Errorf("password in the URL: status %d, want 400", rec.Code)
+	}
+	if rec := do(s, "POST", "/strength", url.Values{"password": {rated[1]}}); rec.Code != 200 {
+		t.Errorf("password in the body: status %d", rec.Code)
+	}
+	passwords = append(passwords, rated...)
+
+	// A secret link's id is as good as the secret.
+	const secret = "the launch code is 0000"
+	rec := do(s, http.MethodPost, "/secrets?format=json", url.Values{"secret": {secret}})
+	var link secretLink
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &link) != nil {
+		t.Fatalf("creating a secret failed with status %d: %s", rec.Code, rec.Body)
+	}
+	id := link.URL[strings.LastIndex(link.URL, "/")+1:]
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if rec := do(s, method, "/s/"+id, url.Values{}); rec.Code != http.StatusOK {
+			t.Errorf("%s /s/: status %d", method, rec.Code)
+		}
+	}
+	passwords = append(passwords, secret, id)
+
+	logged := buf.String()
+	if !strings.Contains(logged, "audit ") {
+		t.Fatalf("nothing audited:\n%s", logged)
+	}
+	var spans []byte
+	tracer.mu.Lock()
+	for _, sp := range tracer.queue {
+		b, err := json.Marshal(sp.attributes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spans = append(append(spans, sp.name...), b...)
+	}
+	tracer.mu.Unlock()
+	if !bytes.Contains(spans, []byte("/s/")) {
+		t.Fatalf("no /s/ spans:\n%s", spans)
+	}
+	for _, p := range passwords {
+		if strings.Contains(logged, p) || strings.Contains(logged, url.QueryEscape(p)) {
+			t.Errorf("%q logged:\n%s", p, logged)
+		}
+		if bytes.Contains(spans, []byte(p)) {
+			t.Errorf("%q in a span:\n%s", p, spans)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Routes are registered in groups, each of which can be protected by a
+// different authentication scheme, e.g. a public page, an API that requires
+// keys and monitoring endpoints restricted to an OIDC identity provider.
+var (
+	authSchemes  = authGroups{}
+	apiKeysPath  = flag.String("api-keys", "", "`file` of API keys, one \"name key\" pair per line")
+	hmacKeysPath = flag.String("hmac-keys", "", "`file` of HMAC request signing keys, one \"id secret\" pair per line")
+	clientCAPath = flag.String("client-ca", "", "PEM `file` of CAs that issue client certificates")
+	oidcIssuer   = flag.String("oidc-issuer", "", "OpenID Connect issuer `url`")
+	oidcAudience = flag.String("oidc-audience", "", "`client-id` that OpenID Connect ID tokens must be issued for")
+)
+
+func init() {
+	flag.Var(&authSchemes, "auth", "authentication for a route group as `group=scheme` (may be repeated)")
+	for _, scheme := range authSchemeNames[1:] {
+		scheme := scheme
+		registerFeature(feature{name: scheme, kind: "authentication", active: func() bool {
+			return usesAuthScheme(scheme)
+		}})
+	}
+}
+
+// routeGroups are the groups routes can be registered in.
+var routeGroups = []string{"ui", "api", "monitoring", "admin"}
+
+// authenticator authenticates requests using a particular scheme.
+type authenticator interface {
+	// authenticate returns the identity of the client that made req.
+	authenticate(req *http.Request) (string, error)
+	// challenge returns the WWW-Authenticate header sent with a 401
+	// response, if any.
+	challenge() string
+}
+
+// authSchemeNames are the supported schemes. "none" allows all requests.
+var authSchemeNames = []string{"none", "apikey", "hmac", "mtls", "oidc"}
+
+// authGroups is a flag.Value holding group=scheme pairs.
+type authGroups map[string]string
+
+func (g authGroups) String() string {
+	return strings.Join(g.Values(), ",")
+}
+
+func (g authGroups) Values() []string {
+	s := make([]string, 0, len(g))
+	for group, scheme := range g {
+		s = append(s, group+"="+scheme)
+	}
+	sort.Strings(s)
+	return s
+}
+
+func (g authGroups) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid route group authentication %q, want group=scheme", value)
+	}
+	group, scheme := value[:i], value[i+1:]
+	if !contains(routeGroups, group) {
+		return fmt.Errorf("unknown route group %q, want one of %s", group, strings.Join(routeGroups, ", "))
+	}
+	if !contains(authSchemeNames, scheme) {
+		return fmt.Errorf("unknown authentication scheme %q, want one of %s", scheme, strings.Join(authSchemeNames, ", "))
+	}
+	g[group] = scheme
+	return nil
+}
+
+// usesAuthScheme reports whether any route group is authenticated with
+// scheme.
+func usesAuthScheme(scheme string) bool {
+	for _, s := range authSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticators holds the authenticator for each route group that requires
+// authentication.
+var authenticators = make(map[string]authenticator)
+
+// setupAuth creates the authenticators for the configured route groups.
+func setupAuth() error {
+	authenticators = make(map[string]authenticator)
+	for group, scheme := range authSchemes {
+		var a authenticator
+		var err error
+		switch scheme {
+		case "none":
+			continue
+		case "apikey":
+			a, err = newAPIKeyAuth(*apiKeysPath)
+		case "hmac":
+			a, err = newHMACAuth(*hmacKeysPath)
+		case "mtls":
+			a, err = newMTLSAuth(*clientCAPath)
+		case "oidc":
+			a, err = newOIDCAuth(*oidcIssuer, *oidcAudience)
+		}
+		if err != nil {
+			return fmt.Errorf("%s authentication for %s: %s", scheme, group, err)
+		}
+		authenticators[group] = a
+	}
+	return nil
+}
+
+type identityKey struct{}
+
+// requestIdentity returns the identity of the authenticated client that made
+// req, or an empty string if the route doesn't require authentication.
+func requestIdentity(req *http.Request) string {
+	id, _ := req.Context().Value(identityKey{}).(string)
+	return id
+}
+
+// requireAuth authenticates requests with the route group's scheme before
+// passing them on to h.
+func requireAuth(group string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a := authenticators[group]
+		if a == nil {
+			h.ServeHTTP(w, req)
+			return
+		}
+		id, err := a.authenticate(req)
+		if err != nil {
+			if c := a.challenge(); c != "" {
+				w.Header().Set("WWW-Authenticate", c)
+			}
+			http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if rec, ok := w.(*statusRecorder); ok {
+			rec.identity = id
+		}
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), identityKey{}, id)))
+	})
+}
+
+// readKeyFile reads a file of "name secret" lines. Blank lines and lines
+// starting with # are ignored.
+func readKeyFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, errors.New("no key file given")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"name secret\"", path, lineNum)
+		}
+		keys[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return keys, nil
+}
+
+// apiKeyAuth accepts requests carrying a known key, either as a bearer token
+// or in the X-API-Key header.
+type apiKeyAuth struct {
+	// names maps the SHA-256 digests of keys to their names, so keys are
+	// looked up without comparing them byte by byte.
+	names map[[sha256.Size]byte]string
+}
+
+func newAPIKeyAuth(path string) (*apiKeyAuth, error) {
+	keys, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &apiKeyAuth{names: make(map[[sha256.Size]byte]string)}
+	for name, key := range keys {
+		a.names[sha256.Sum256([]byte(key))] = name
+	}
+	return a, nil
+}
+
+func (a *apiKeyAuth) authenticate(req *http.Request) (string, error) {
+	key := req.Header.Get("X-API-Key")
+	if key == "" {
+		key = bearerToken(req)
+	}
+	if key == "" {
+		return "", errors.New("missing API key")
+	}
+	name, ok := a.names[sha256.Sum256([]byte(key))]
+	if !ok {
+		return "", errors.New("invalid API key")
+	}
+	return name, nil
+}
+
+func (a *apiKeyAuth) challenge() string {
+	return "Bearer"
+}
+
+func bearerToken(req *http.Request) string {
+	h := req.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// hmacAuth accepts requests signed with a shared secret. The X-Signature
+// header is the hex HMAC-SHA256 of the X-Timestamp header (Unix seconds), the
+// method, the request URI and the hex SHA-256 of the body, separated by
+// newlines, using the secret named by the X-Key-Id header.
+type hmacAuth struct {
+	secrets map[string]string
+}
+
+// hmacMaxSkew is how far a signed request's timestamp may be from the
+// server's clock.
+const hmacMaxSkew = 5 * time.Minute
+
+// hmacMaxBody is the largest request body that is read to check a signature.
+const hmacMaxBody = 1 << 20
+
+func newHMACAuth(path string) (*hmacAuth, error) {
+	secrets, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &hmacAuth{secrets}, nil
+}
+
+func (a *hmacAuth) authenticate(req *http.Request) (string, error) {
+	id := req.Header.Get("X-Key-Id")
+	secret, ok := a.secrets[id]
+	if !ok {
+		return "", errors.New("missing or unknown X-Key-Id")
+	}
+	ts := req.Header.Get("X-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", errors.New("missing or invalid X-Timestamp")
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > hmacMaxSkew || skew < -hmacMaxSkew {
+		return "", errors.New("X-Timestamp is too far from the server's time")
+	}
+	sig, err := hex.DecodeString(req.Header.Get("X-Signature"))
+	if err != nil || len(sig) == 0 {
+		return "", errors.New("missing or invalid X-Signature")
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, hmacMaxBody))
+		if err != nil {
+			return "", errors.New("request body is too large")
+		}
+		// Leave the body for the handler.
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%x", ts, req.Method, req.URL.RequestURI(), bodyHash)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errors.New("signature mismatch")
+	}
+	return id, nil
+}
+
+func (a *hmacAuth) challenge() string {
+	return ""
+}
+
+// mtlsAuth accepts requests made with a client certificate issued by one of
+// the configured CAs. This requires the server to be serving TLS.
+type mtlsAuth struct {
+	roots *x509.CertPool
+}
+
+func newMTLSAuth(path string) (*mtlsAuth, error) {
+	if path == "" {
+		return nil, errors.New("no -client-ca given")
+	}
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return &mtlsAuth{roots}, nil
+}
+
+func (a *mtlsAuth) authenticate(req *http.Request) (string, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return "", errors.New("client certificate required")
+	}
+	certs := req.TLS.PeerCertificates
+	opts := x509.VerifyOptions{
+		Roots:         a.roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return "", fmt.Errorf("invalid client certificate: %s", err)
+	}
+	return certs[0].Subject.CommonName, nil
+}
+
+func (a *mtlsAuth) challenge() string {
+	return ""
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Passwords that happen to contain a keyboard walk such as "qwer" or a
+// fragment of a breached password such as "dragon" are no weaker than any
+// other with the same entropy, since an attacker can't know which were
+// drawn, but they alarm reviewers and fail some password checkers. With
+// -avoid-walks or -breach-patterns they are regenerated instead, which
+// costs a fraction of a bit for any realistic corpus. The metrics count how
+// many were regenerated by each check.
+var (
+	avoidWalks     = flag.Int("avoid-walks", 0, "regenerate passwords with keyboard walks of this many `keys` or more (0 to allow them)")
+	breachPatterns = flag.String("breach-patterns", "", "`file` of fragments of breached passwords, one per line, that passwords must not contain")
+)
+
+func init() {
+	registerFeature(feature{name: "weak pattern avoidance", kind: "generator", active: func() bool {
+		return *avoidWalks > 0 || *breachPatterns != ""
+	}})
+}
+
+// minBreachFragment is the length of the shortest fragment that is checked
+// for; shorter ones would rule out too many passwords.
+const minBreachFragment = 4
+
+var errOnlyWeakPatterns = errors.New("every password generated matched -avoid-walks or -breach-patterns; use a longer password or another pattern")
+
+// breachFragments are the lowercase fragments in -breach-patterns, and
+// longestFragment the length of the longest in runes. They are only changed
+// at startup.
+var (
+	breachFragments = map[string]bool{}
+	longestFragment int
+)
+
+// loadBreachPatterns reads the fragments in path, skipping blank lines,
+// comments starting with # and fragments shorter than minBreachFragment.
+func loadBreachPatterns(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fragment := strings.ToLower(strings.TrimSpace(sc.Text()))
+		n := len([]rune(fragment))
+		if n < minBreachFragment || strings.HasPrefix(fragment, "#") {
+			continue
+		}
+		breachFragments[fragment] = true
+		if n > longestFragment {
+			longestFragment = n
+		}
+	}
+	return sc.Err()
+}
+
+// avoidedStats counts the passwords regenerated by each check.
+var avoidedStats = struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}{counts: make(map[string]uint64)}
+
+// The checks passwords are regenerated by.
+const (
+	avoidWalk   = "walk"
+	avoidBreach = "breach"
+)
+
+// weakPattern returns the check password fails, or an empty string if it
+// passes them all.
+func weakPattern(password string) string {
+	runes := []rune(password)
+	if n := *avoidWalks; n > 0 && longestWalk(runes) >= n {
+		return avoidWalk
+	}
+	if len(breachFragments) > 0 {
+		lower := []rune(strings.ToLower(password))
+		for i := range lower {
+			for j := i + minBreachFragment; j <= len(lower) && j-i <= longestFragment; j++ {
+				if breachFragments[string(lower[i:j])] {
+					return avoidBreach
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// longestWalk returns the number of keys in the longest run of password
+// typed on adjacent keys.
+func longestWalk(password []rune) int {
+	longest, run := 0, 0
+	for i := range password {
+		if i > 0 && keyDirection(password[i-1], password[i]) >= 0 {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longest {
+			longest = run
+		}
+	}
+	return longest
+}
+
+// avoided reports whether password should be regenerated, counting it if
+// so.
+func avoided(password string) bool {
+	check := weakPattern(password)
+	if check == "" {
+		return false
+	}
+	avoidedStats.mu.Lock()
+	avoidedStats.counts[check]++
+	avoidedStats.mu.Unlock()
+	return true
+}
+
+func avoidedSnapshot() map[string]uint64 {
+	avoidedStats.mu.Lock()
+	defer avoidedStats.mu.Unlock()
+	counts := make(map[string]uint64, len(avoidedStats.counts))
+	for k, v := range avoidedStats.counts {
+		counts[k] = v
+	}
+	return counts
+}
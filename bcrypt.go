@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// bcrypt hashes passwords as in OpenBSD's bcrypt(3), producing $2b$ hashes
+// that crypt(3), PHP's password_verify and golang.org/x/crypto/bcrypt all
+// accept.
+
+const (
+	bcryptMinCost = 4
+	bcryptMaxCost = 31
+	// bcryptMaxPassword is the number of password bytes bcrypt uses,
+	// including the terminating NUL.
+	bcryptMaxPassword = 72
+	bcryptSaltBytes   = 16
+)
+
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+var errBcryptPasswordTooLong = errors.New("bcrypt only uses the first 71 bytes of a password")
+
+// bcryptHash returns the $2b$ hash of password with salt at cost.
+func bcryptHash(password []byte, salt []byte, cost int) (string, error) {
+	if cost < bcryptMinCost || cost > bcryptMaxCost {
+		return "", fmt.Errorf("bcrypt cost must be from %d to %d", bcryptMinCost, bcryptMaxCost)
+	}
+	if len(password) >= bcryptMaxPassword {
+		return "", errBcryptPasswordTooLong
+	}
+	if len(salt) != bcryptSaltBytes {
+		return "", fmt.Errorf("bcrypt salt must be %d bytes", bcryptSaltBytes)
+	}
+	key := append(append([]byte(nil), password...), 0)
+	c := newEksBlowfish(cost, salt, key)
+
+	var text [6]uint32
+	for i, b := 0, []byte("OrpheanBeholderScryDoubt"); i < len(text); i++ {
+		text[i] = uint32(b[4*i])<<24 | uint32(b[4*i+1])<<16 | uint32(b[4*i+2])<<8 | uint32(b[4*i+3])
+	}
+	for i := 0; i < 64; i++ {
+		for j := 0; j < len(text); j += 2 {
+			text[j], text[j+1] = c.encrypt(text[j], text[j+1])
+		}
+	}
+	sum := make([]byte, 0, 4*len(text))
+	for _, w := range text {
+		sum = append(sum, byte(w>>24), byte(w>>16), byte(w>>8), byte(w))
+	}
+	// Only 23 of the 24 bytes are encoded, as in the original.
+	return fmt.Sprintf("$2b$%02d$%s%s", cost, bcryptEncoding.EncodeToString(salt), bcryptEncoding.EncodeToString(sum[:23])), nil
+}
+
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+// newEksBlowfish runs bcrypt's expensive key schedule.
+func newEksBlowfish(cost int, salt, key []byte) *blowfish {
+	c := &blowfish{p: blowfishP, s: blowfishS}
+	c.expandKey(key, salt)
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		c.expandKey(key, nil)
+		c.expandKey(salt, nil)
+	}
+	return c
+}
+
+// expandKey mixes key into the P-array, then replaces the P-array and
+// S-boxes with successive encryptions, mixing in salt if it isn't nil.
+func (c *blowfish) expandKey(key, salt []byte) {
+	j := 0
+	for i := range c.p {
+		c.p[i] ^= cyclicWord(key, &j)
+	}
+	var l, r uint32
+	k := 0
+	next := func() (uint32, uint32) {
+		if salt != nil {
+			l ^= cyclicWord(salt, &k)
+			r ^= cyclicWord(salt, &k)
+		}
+		l, r = c.encrypt(l, r)
+		return l, r
+	}
+	for i := 0; i < len(c.p); i += 2 {
+		c.p[i], c.p[i+1] = next()
+	}
+	for s := range c.s {
+		for i := 0; i < len(c.s[s]); i += 2 {
+			c.s[s][i], c.s[s][i+1] = next()
+		}
+	}
+}
+
+// cyclicWord returns the big-endian word at *j in b, wrapping around, and
+// advances *j past it.
+func cyclicWord(b []byte, j *int) uint32 {
+	var w uint32
+	for i := 0; i < 4; i++ {
+		w = w<<8 | uint32(b[*j])
+		*j = (*j + 1) % len(b)
+	}
+	return w
+}
+
+func (c *blowfish) f(x uint32) uint32 {
+	return ((c.s[0][x>>24] + c.s[1][x>>16&0xff]) ^ c.s[2][x>>8&0xff]) + c.s[3][x&0xff]
+}
+
+func (c *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	l ^= c.p[0]
+	for i := 1; i < 17; i += 2 {
+		r ^= c.f(l) ^ c.p[i]
+		l ^= c.f(r) ^ c.p[i+1]
+	}
+	r ^= c.p[17]
+	return r, l
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// TestBcryptVectors checks the $2a$ test vectors of OpenBSD's regress tests
+// and crypt_blowfish, which $2b$ hashes match for passwords under 256 bytes.
+func TestBcryptVectors(t *testing.T) {
+	for _, tt := range []struct {
+		password, hash string
+	}{
+		{"U*U", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+		{"U*U*", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.VGOzA784oUp/Z0DY336zx7pLYAy0lwK"},
+		{"U*U*U", "$2a$05$XXXXXXXXXXXXXXXXXXXXXOAcXxm9kjPGEMsLznoKqmqw7tc8WCx4a"},
+		{"", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy"},
+	} {
+		salt, err := bcryptEncoding.DecodeString(tt.hash[7:29])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := bcryptHash([]byte(tt.password), salt, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "$2b$" + strings.TrimPrefix(tt.hash, "$2a$"); got != want {
+			t.Errorf("bcrypt(%q) = %s, want %s", tt.password, got, want)
+		}
+	}
+}
+
+// TestBlowfishVectors checks the cipher bcrypt is built on against Eric
+// Young's Blowfish ECB test vectors.
+func TestBlowfishVectors(t *testing.T) {
+	for _, tt := range []struct {
+		key, plain, cipher string
+	}{
+		{"0000000000000000", "0000000000000000", "4ef997456198dd78"},
+		{"ffffffffffffffff", "ffffffffffffffff", "51866fd5b85ecb8a"},
+		{"3000000000000000", "1000000000000001", "7d856f9a613063f2"},
+		{"1111111111111111", "1111111111111111", "2466dd878b963c9d"},
+		{"0123456789abcdef", "1111111111111111", "61f9c3802281b096"},
+		{"fedcba9876543210", "0123456789abcdef", "0aceab0fc6a0a28d"},
+	} {
+		key, _ := hex.DecodeString(tt.key)
+		plain, _ := hex.DecodeString(tt.plain)
+		c := &blowfish{p: blowfishP, s: blowfishS}
+		c.expandKey(key, nil)
+		l, r := c.encrypt(binary.BigEndian.Uint32(plain), binary.BigEndian.Uint32(plain[4:]))
+		out := make([]byte, 8)
+		binary.BigEndian.PutUint32(out, l)
+		binary.BigEndian.PutUint32(out[4:], r)
+		if got := hex.EncodeToString(out); got != tt.cipher {
+			t.Errorf("key %s, plaintext %s: %s, want %s", tt.key, tt.plain, got, tt.cipher)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Branding options so the default page can be customised without having to
+// maintain a separate index.html.
+var (
+	pageTitle       = flag.String("title", "Random Password Please", "page title")
+	logoURL         = flag.String("logo", "", "optional logo image URL")
+	themeColor      = flag.String("theme-color", "#2c6ebd", "accent color for buttons and slider")
+	backgroundColor = flag.String("background-color", "#ffffff", "page background color")
+	textColor       = flag.String("text-color", "#222222", "page text color")
+
+	// Used instead of the above when the browser prefers a dark color scheme.
+	darkBackgroundColor = flag.String("dark-background-color", "#121212", "page background color in dark mode")
+	darkTextColor       = flag.String("dark-text-color", "#e8e8e8", "page text color in dark mode")
+
+	footerLinks linkList
+)
+
+// For generating passwords while sharing a screen, -mask shows them masked,
+// each revealed on demand and masked again after -remask-after.
+var (
+	maskPasswords = flag.Bool("mask", false, "show passwords on the page masked until revealed")
+	remaskAfter   = flag.Duration("remask-after", 30*time.Second, "how long a revealed password stays shown while masking (0 to keep it shown)")
+)
+
+func init() {
+	flag.Var(&footerLinks, "footer-link", "footer link as `label=url` (may be repeated)")
+}
+
+type link struct {
+	Label, URL string
+}
+
+// linkList is a flag.Value that accumulates label=url pairs.
+type linkList []link
+
+func (l *linkList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.Values(), ",")
+}
+
+func (l *linkList) Values() []string {
+	s := make([]string, len(*l))
+	for i, lnk := range *l {
+		s[i] = lnk.Label + "=" + lnk.URL
+	}
+	return s
+}
+
+func (l *linkList) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		return fmt.Errorf("invalid link %q, want label=url", value)
+	}
+	*l = append(*l, link{Label: value[:i], URL: value[i+1:]})
+	return nil
+}
+
+// pageFooterLinks returns the configured footer links, or a link to the
+// source repository if none were given.
+func pageFooterLinks() []link {
+	if len(footerLinks) == 0 {
+		return []link{{"Source", "https://github.com/jbarham/random-password-please"}}
+	}
+	return footerLinks
+}
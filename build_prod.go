@@ -0,0 +1,7 @@
+//go:build !dev
+// +build !dev
+
+package main
+
+// devBuild is set in development builds, made with -tags dev.
+const devBuild = false
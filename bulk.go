@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// Bulk passwords are for onboarding: a list of usernames is POSTed as JSON
+// or as a CSV or TSV file, and each is paired with a new password in a CSV
+// or TSV file that is written as the passwords are generated.
+
+var maxBulkCount = flag.Int("max-bulk-count", 1000, "maximum number of usernames per /passwords/bulk request")
+
+const (
+	maxUsernameLength = 256
+	// maxBulkBody limits the size of the list of usernames.
+	maxBulkBody = 1 << 20
+)
+
+// readUsernames returns the usernames in req's body: a JSON array or an
+// object with a usernames array, or the first column of a CSV or TSV file
+// sent as the body or as the usernames field of a multipart form. A first
+// row whose first column is "username" is taken to be a header.
+func readUsernames(req *http.Request) ([]string, error) {
+	ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch ct {
+	case "application/json":
+		var body json.RawMessage
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %s", err)
+		}
+		var usernames []string
+		if json.Unmarshal(body, &usernames) == nil {
+			return usernames, nil
+		}
+		var obj struct {
+			Usernames []string `json:"usernames"`
+		}
+		if err := json.Unmarshal(body, &obj); err != nil {
+			return nil, errors.New(`JSON must be an array of usernames or {"usernames": [...]}`)
+		}
+		return obj.Usernames, nil
+	case "multipart/form-data":
+		f, fh, err := req.FormFile("usernames")
+		if err != nil {
+			return nil, errors.New("multipart forms must have a usernames file")
+		}
+		defer f.Close()
+		fileType, _, _ := mime.ParseMediaType(fh.Header.Get("Content-Type"))
+		return readUsernameRecords(f, fileType == "text/tab-separated-values" || path.Ext(fh.Filename) == ".tsv")
+	case "text/csv", "text/plain":
+		return readUsernameRecords(req.Body, false)
+	case "text/tab-separated-values":
+		return readUsernameRecords(req.Body, true)
+	}
+	return nil, errors.New("usernames must be sent as JSON, CSV, TSV or a multipart form")
+}
+
+func readUsernameRecords(r io.Reader, tabs bool) ([]string, error) {
+	cr := csv.NewReader(r)
+	if tabs {
+		cr.Comma = '\t'
+	}
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var usernames []string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return usernames, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(usernames) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "username") {
+			continue
+		}
+		usernames = append(usernames, record[0])
+	}
+}
+
+// checkUsernames trims the usernames and checks that there are between
+// one and -max-bulk-count of them, each distinct and printable.
+func checkUsernames(usernames []string) error {
+	if len(usernames) == 0 || len(usernames) > *maxBulkCount {
+		return fmt.Errorf("send between 1 and %d usernames", *maxBulkCount)
+	}
+	seen := make(map[string]bool)
+	for i, u := range usernames {
+		u = strings.TrimSpace(u)
+		usernames[i] = u
+		if u == "" || len(u) > maxUsernameLength || strings.IndexFunc(u, unicode.IsControl) >= 0 {
+			return fmt.Errorf("username %d must be between 1 and %d bytes without control characters", i+1, maxUsernameLength)
+		}
+		if seen[u] {
+			return fmt.Errorf("username %q is repeated", u)
+		}
+		seen[u] = true
+	}
+	return nil
+}
+
+func bulkHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "usernames must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, maxBulkBody)
+	n, ok := readLength(w, req)
+	if !ok {
+		return
+	}
+	format := req.FormValue("format")
+	if format != "" && format != "csv" && format != "tsv" {
+		http.Error(w, "format must be csv or tsv", http.StatusBadRequest)
+		return
+	}
+	if format == "" {
+		format = "csv"
+	}
+	opts, err := readGenOptions(req, n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	usernames, err := readUsernames(req)
+	if err == nil {
+		err = checkUsernames(usernames)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startGenerateSpan(req.Context(), opts, len(usernames))
+	defer span.end()
+	issued := make(batchPasswords)
+	uncounted := opts
+	uncounted.Uncounted = true
+	next := func() (string, error) {
+		password, err := issued.issue(func() (string, error) {
+			return generate(ctx, uncounted)
+		}, !opts.Uncounted)
+		if err != nil {
+			span.setError(err.Error())
+		}
+		return password, err
+	}
+	// Generate the first password before writing anything, so that a
+	// policy that can't be met gets an error status.
+	password, err := next()
+	if err != nil {
+		generationFailed(w, req, err)
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == "tsv" {
+		contentType = "text/tab-separated-values; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="passwords.`+format+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	if format == "tsv" {
+		cw.Comma = '\t'
+	}
+	cw.Write([]string{"username", "password"})
+	written := 0
+	for i, u := range usernames {
+		if i > 0 {
+			if password, err = next(); err != nil {
+				break
+			}
+		}
+		cw.Write([]string{u, password})
+		written++
+	}
+	cw.Flush()
+	bw.Flush()
+	stats.record("bulk", written)
+	counter.record(format, written)
+	if err != nil {
+		// The status has been sent, so the response can only be cut
+		// short for the client to see that it is incomplete.
+		logf(req, "Bulk passwords: %s", err)
+		panic(http.ErrAbortHandler)
+	}
+}
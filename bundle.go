@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An instance bundle captures everything needed to clone an instance onto
+// another host: the explicitly set flags, the contents of the files they
+// name, and the counter with the stats and rate limiter state kept next to
+// its file. Files holding keys or credentials are encrypted with a key
+// derived from the -bundle-passphrase. Left out are the config file, which
+// the settings replace, -template-dir, log and audit log files, and the
+// files jobs refer to, such as a Vault token_file.
+var (
+	exportBundlePath     = flag.String("export", "", "write an instance bundle to `file` and exit")
+	importBundlePath     = flag.String("import", "", "restore settings, files and counter from an instance bundle `file`")
+	bundlePassphrasePath = flag.String("bundle-passphrase", "", "`file` holding the passphrase that encrypts the keys in instance bundles")
+)
+
+func init() {
+	registerFeature(feature{name: "instance bundles", kind: "subsystem", version: strconv.Itoa(bundleVersion), active: func() bool {
+		return *importBundlePath != ""
+	}})
+}
+
+// bundleVersion 2 added files and state. Version 1 bundles, with only
+// settings and the counter, can still be imported.
+const bundleVersion = 2
+
+type bundle struct {
+	Version  int                 `json:"version"`
+	Created  time.Time           `json:"created"`
+	Settings map[string][]string `json:"settings"`
+	Counter  uint64              `json:"counter"`
+	Files    []bundleFile        `json:"files,omitempty"`
+	// KDF derives the key sealed files are encrypted with.
+	KDF *bundleKDF `json:"kdf,omitempty"`
+}
+
+// bundleFile is a file named by a setting, or one next to the counter file
+// for the "counter" setting.
+type bundleFile struct {
+	Setting string `json:"setting"`
+	// Path is the file's path on the exporting host, relative to the
+	// setting's directory for directory settings.
+	Path string `json:"path"`
+	// Data is the file's contents, or Sealed its contents encrypted with
+	// AES-256-GCM, with the nonce first and the setting and path as
+	// additional data.
+	Data   []byte `json:"data,omitempty"`
+	Sealed []byte `json:"sealed,omitempty"`
+}
+
+// bundleKDF are the argon2id parameters of the bundle's key.
+type bundleKDF struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// validate checks the parameters are in the ranges allowed for
+// -argon2-threads, -argon2-time and -argon2-memory, so a bundle can't make
+// the import divide by zero, skip the KDF or exhaust memory.
+func (kdf *bundleKDF) validate() error {
+	if kdf.Threads < 1 {
+		return errors.New("the bundle's key threads must be from 1 to 255")
+	}
+	if kdf.Time < 1 {
+		return errors.New("the bundle's key time must be at least 1")
+	}
+	if kdf.Memory < 8*uint32(kdf.Threads) || kdf.Memory > 4<<20 {
+		return fmt.Errorf("the bundle's key memory must be from 8 KiB per thread, %d, to 4 GiB", 8*uint32(kdf.Threads))
+	}
+	return nil
+}
+
+// The settings naming files that are bundled: bundleKeySettings hold keys
+// or credentials and are sealed, bundleFileSettings are copied as they are,
+// and bundleDirSettings name directories whose files are copied.
+var (
+	bundleKeySettings = []string{
+		"tls-key",
+		"api-keys",
+		"hmac-keys",
+		"session-ticket-keys",
+		"secrets-key",
+		"attestation-key",
+		"web-push-key",
+		"web-push-subscriptions",
+		"challenge-secret",
+		"cluster-key",
+	}
+	bundleFileSettings = []string{
+		"tls-cert",
+		"client-ca",
+		"breach-patterns",
+		"favicon",
+		"jobs",
+	}
+	bundleDirSettings = []string{"wordlists"}
+)
+
+// bundleSettings are the settings of the imported bundle, if any.
+var bundleSettings map[string][]string
+
+// multiValue is implemented by flag values that may be given more than once.
+type multiValue interface {
+	Values() []string
+}
+
+// bundleExcluded lists flags that describe the bundle operation itself and so
+// must not be carried over to another instance.
+var bundleExcluded = map[string]bool{
+	"config":            true,
+	"export":            true,
+	"import":            true,
+	"bundle-passphrase": true,
+}
+
+func flagValues(f *flag.Flag) []string {
+	if mv, ok := f.Value.(multiValue); ok {
+		return mv.Values()
+	}
+	return []string{f.Value.String()}
+}
+
+// bundleKey derives the key sealed files are encrypted with from the
+// -bundle-passphrase.
+func bundleKey(kdf *bundleKDF) ([]byte, error) {
+	if err := kdf.validate(); err != nil {
+		return nil, err
+	}
+	if *bundlePassphrasePath == "" {
+		return nil, errors.New("the bundle has keys, which need -bundle-passphrase to be encrypted and decrypted")
+	}
+	data, err := ioutil.ReadFile(*bundlePassphrasePath)
+	if err != nil {
+		return nil, fmt.Errorf("-bundle-passphrase: %s", err)
+	}
+	passphrase := bytes.TrimSpace(data)
+	if len(passphrase) == 0 {
+		return nil, errors.New("-bundle-passphrase is empty")
+	}
+	return argon2id(passphrase, kdf.Salt, kdf.Time, kdf.Memory, kdf.Threads, 32), nil
+}
+
+func bundleAEAD(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+func (f *bundleFile) additionalData() []byte {
+	return []byte(f.Setting + "\x00" + f.Path)
+}
+
+func (f *bundleFile) seal(aead cipher.AEAD) error {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := cryptorand.Read(nonce); err != nil {
+		return err
+	}
+	f.Sealed = aead.Seal(nonce, nonce, f.Data, f.additionalData())
+	f.Data = nil
+	return nil
+}
+
+func (f *bundleFile) open(aead cipher.AEAD) error {
+	if len(f.Sealed) < aead.NonceSize() {
+		return fmt.Errorf("%s: sealed file is truncated", f.Path)
+	}
+	nonce, sealed := f.Sealed[:aead.NonceSize()], f.Sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, sealed, f.additionalData())
+	if err != nil {
+		return fmt.Errorf("%s: can't decrypt it; is -bundle-passphrase right?", f.Path)
+	}
+	f.Data, f.Sealed = data, nil
+	return nil
+}
+
+// bundledFiles reads the files the settings name, and the counter's state
+// files.
+func bundledFiles() ([]bundleFile, error) {
+	var files []bundleFile
+	add := func(setting, path, name string) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("-%s: %s", setting, err)
+		}
+		files = append(files, bundleFile{Setting: setting, Path: name, Data: data})
+		return nil
+	}
+	for _, name := range append(append([]string{}, bundleKeySettings...), bundleFileSettings...) {
+		if path := flag.Lookup(name).Value.String(); path != "" {
+			if err := add(name, path, path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range bundleDirSettings {
+		dir := flag.Lookup(name).Value.String()
+		if dir == "" {
+			continue
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %s", name, err)
+		}
+		for _, e := range entries {
+			if e.Mode().IsRegular() {
+				if err := add(name, filepath.Join(dir, e.Name()), e.Name()); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if *counterFilePath != "" {
+		for _, path := range []string{statsPath(), limiterPath()} {
+			if _, err := os.Stat(path); err == nil {
+				if err := add("counter", path, strings.TrimPrefix(path, *counterFilePath)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+func exportBundle(path string) error {
+	b := bundle{
+		Version:  bundleVersion,
+		Created:  time.Now().UTC(),
+		Settings: make(map[string][]string),
+		Counter:  counter.value(),
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if !bundleExcluded[f.Name] {
+			b.Settings[f.Name] = flagValues(f)
+		}
+	})
+	files, err := bundledFiles()
+	if err != nil {
+		return err
+	}
+	var aead cipher.AEAD
+	for i := range files {
+		if !contains(bundleKeySettings, files[i].Setting) {
+			continue
+		}
+		if aead == nil {
+			b.KDF = &bundleKDF{Salt: make([]byte, 16), Time: 3, Memory: 64 << 10, Threads: 1}
+			if _, err := cryptorand.Read(b.KDF.Salt); err != nil {
+				return err
+			}
+			key, err := bundleKey(b.KDF)
+			if err != nil {
+				return err
+			}
+			aead = bundleAEAD(key)
+		}
+		if err := files[i].seal(aead); err != nil {
+			return err
+		}
+	}
+	b.Files = files
+
+	data, err := json.MarshalIndent(b, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// readBundle loads a bundle and applies its settings to any flags that were
+// not given explicitly on the command line or in the config file, then
+// restores its files. The counter is returned so the caller can restore it
+// once the counter file (if any) has been opened.
+func readBundle(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var b bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return 0, err
+	}
+	if b.Version < 1 || b.Version > bundleVersion {
+		return 0, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+
+	if err := applySettings(b.Settings); err != nil {
+		return 0, err
+	}
+	bundleSettings = b.Settings
+	if err := restoreFiles(&b); err != nil {
+		return 0, err
+	}
+	return b.Counter, nil
+}
+
+// restoreFiles writes the bundle's files where the settings now in effect
+// expect them. A file that already exists is kept if it is the same, and
+// the import fails if it differs, except for the counter's state files,
+// which are replaced like the counter is.
+func restoreFiles(b *bundle) error {
+	var aead cipher.AEAD
+	for i := range b.Files {
+		f := &b.Files[i]
+		var path string
+		mode := os.FileMode(0644)
+		switch {
+		case f.Setting == "counter":
+			if *counterFilePath == "" {
+				log.Printf("Not importing the bundle's %s state without -counter", f.Path)
+				continue
+			}
+			path = *counterFilePath + f.Path
+		case contains(bundleDirSettings, f.Setting):
+			if f.Path != filepath.Base(f.Path) {
+				return fmt.Errorf("-%s file %q isn't in the directory", f.Setting, f.Path)
+			}
+			path = filepath.Join(flag.Lookup(f.Setting).Value.String(), f.Path)
+		case contains(bundleKeySettings, f.Setting) || contains(bundleFileSettings, f.Setting):
+			path = flag.Lookup(f.Setting).Value.String()
+		default:
+			return fmt.Errorf("the bundle has a file for unknown setting %q", f.Setting)
+		}
+		if path == "" {
+			// The setting was overridden on the command line.
+			continue
+		}
+		if f.Sealed != nil {
+			if aead == nil {
+				if b.KDF == nil {
+					return errors.New("the bundle has encrypted files but no key parameters")
+				}
+				key, err := bundleKey(b.KDF)
+				if err != nil {
+					return err
+				}
+				aead = bundleAEAD(key)
+			}
+			if err := f.open(aead); err != nil {
+				return err
+			}
+			mode = 0600
+		}
+		if err := restoreFile(path, f.Data, mode, f.Setting == "counter"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreFile(path string, data []byte, mode os.FileMode, replace bool) error {
+	if old, err := ioutil.ReadFile(path); err == nil && !replace {
+		if bytes.Equal(old, data) {
+			return nil
+		}
+		return fmt.Errorf("%s already exists and differs from the bundle's; move it aside to import it", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, mode)
+}
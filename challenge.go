@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -challenge makes anonymous clients that generate passwords faster than
+// -challenge-after a minute, from the page or /password.txt, prove they
+// aren't bots before generating more, so bots can't burn CPU or inflate the
+// counter of a public instance. Clients that pass get a cookie that lets
+// them through for -challenge-ttl, limited only by the rate limits. The
+// challenge is one of:
+//
+//	pow        a hashcash proof of work the page's script solves in a
+//	           second or two, with no third party involved
+//	hcaptcha   an hCaptcha widget
+//	turnstile  a Cloudflare Turnstile widget
+//
+// Authenticated clients are never challenged.
+var (
+	challengeKind       = flag.String("challenge", "", "challenge for anonymous clients generating passwords rapidly: pow, hcaptcha or turnstile (empty for none)")
+	challengeAfter      = flag.Int("challenge-after", 10, "generation `requests` per minute an anonymous client may make before being challenged")
+	challengeTTL        = flag.Duration("challenge-ttl", time.Hour, "how long a client that passes the challenge isn't challenged again")
+	challengeDifficulty = flag.Int("challenge-difficulty", 16, "leading zero `bits` proof of work hashes need")
+	challengeSiteKey    = flag.String("challenge-site-key", "", "hCaptcha or Turnstile site `key`")
+	challengeSecretPath = flag.String("challenge-secret", "", "`file` holding the hCaptcha or Turnstile secret key")
+)
+
+func init() {
+	registerFeature(feature{name: "challenge", kind: "subsystem", active: func() bool {
+		return *challengeKind != ""
+	}})
+}
+
+var challengeKinds = []string{"pow", "hcaptcha", "turnstile"}
+
+// captchaProvider describes a third-party challenge.
+type captchaProvider struct {
+	script, widgetClass, responseField, verifyURL string
+	// origins are what the page must be allowed to load scripts and
+	// frames from and connect to.
+	origins string
+}
+
+var captchaProviders = map[string]captchaProvider{
+	"hcaptcha": {
+		script:        "https://js.hcaptcha.com/1/api.js",
+		widgetClass:   "h-captcha",
+		responseField: "h-captcha-response",
+		verifyURL:     "https://api.hcaptcha.com/siteverify",
+		origins:       "https://hcaptcha.com https://*.hcaptcha.com",
+	},
+	"turnstile": {
+		script:        "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		widgetClass:   "cf-turnstile",
+		responseField: "cf-turnstile-response",
+		verifyURL:     "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		origins:       "https://challenges.cloudflare.com",
+	},
+}
+
+// powTTL is how long a proof of work challenge can be solved in.
+const powTTL = 5 * time.Minute
+
+// challengeCookie holds a client's pass.
+const challengeCookie = "challenge_pass"
+
+// challengeHeader tells the page's script where a challenged request
+// should go to be let through.
+const challengeHeader = "X-Challenge"
+
+var captchaClient = &http.Client{Timeout: 10 * time.Second}
+
+// challengeKey signs passes and proof of work challenges. Passes don't
+// survive restarts, which only means being challenged again.
+var challengeKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := cryptorand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// challengeMAC returns the MAC of the parts of a pass or challenge, bound to
+// the client's address so it can't be shared.
+func challengeMAC(client string, parts ...string) []byte {
+	return hmacSHA256(challengeKey, []byte(client+"\x00"+strings.Join(parts, "\x00")))[:16]
+}
+
+// challengeStats counts the challenges passed and failed.
+var challengeStats = struct {
+	sync.Mutex
+	passed, failed uint64
+}{}
+
+// challengeAllowance is a token bucket per client of the requests it can
+// make without a pass, refilled at -challenge-after a minute.
+var challengeAllowance = struct {
+	sync.Mutex
+	buckets map[string]*clientLimit
+	swept   time.Time
+}{buckets: make(map[string]*clientLimit)}
+
+// allowedUnchallenged reports whether client can make another request
+// without a pass.
+func allowedUnchallenged(client string, now time.Time) bool {
+	a := &challengeAllowance
+	a.Lock()
+	defer a.Unlock()
+	limit := float64(*challengeAfter)
+	// Forget clients whose allowance has refilled.
+	if now.Sub(a.swept) > time.Minute {
+		for c, b := range a.buckets {
+			if now.Sub(b.Updated) > time.Minute {
+				delete(a.buckets, c)
+			}
+		}
+		a.swept = now
+	}
+	b, ok := a.buckets[client]
+	if !ok {
+		b = &clientLimit{Tokens: limit, Updated: now}
+		a.buckets[client] = b
+	}
+	b.Tokens = math.Min(limit, b.Tokens+now.Sub(b.Updated).Minutes()*limit)
+	b.Updated = now
+	if b.Tokens < 1 {
+		return false
+	}
+	b.Tokens--
+	return true
+}
+
+// hasPass reports whether req carries a valid pass for its client.
+func hasPass(req *http.Request) bool {
+	c, err := req.Cookie(challengeCookie)
+	if err != nil {
+		return false
+	}
+	i := strings.Index(c.Value, ".")
+	if i < 0 {
+		return false
+	}
+	expiry, err := strconv.ParseInt(c.Value[:i], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return false
+	}
+	mac, err := b64url.DecodeString(c.Value[i+1:])
+	return err == nil && hmac.Equal(mac, challengeMAC(clientIP(req), "pass", c.Value[:i]))
+}
+
+func setPass(w http.ResponseWriter, req *http.Request) {
+	expiry := strconv.FormatInt(time.Now().Add(*challengeTTL).Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     challengeCookie,
+		Value:    expiry + "." + b64url.EncodeToString(challengeMAC(clientIP(req), "pass", expiry)),
+		Path:     "/",
+		MaxAge:   int(challengeTTL.Seconds()),
+		Secure:   req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// challenged sends anonymous clients without a pass that have used up
+// their allowance to the challenge before calling h for requests for
+// pattern: the page is redirected to it, and other requests get 403
+// Forbidden with the challenge's URL in the X-Challenge header.
+func challenged(pattern string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if *challengeKind == "" || req.URL.Path != pattern || requestIdentity(req) != "" || hasPass(req) ||
+			allowedUnchallenged(clientIP(req), time.Now()) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if req.URL.Path == "/" && req.Method == http.MethodGet {
+			http.Redirect(w, req, "/challenge?next="+url.QueryEscape(req.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		w.Header().Set(challengeHeader, "/challenge")
+		http.Error(w, "too many passwords generated; pass the challenge at /challenge to continue", http.StatusForbidden)
+	})
+}
+
+// localPath returns next if it is a path on this server, or else "/", so
+// the challenge can't redirect clients elsewhere.
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// challengeHandler serves the challenge and, when it is POSTed back
+// solved, gives the client a pass and sends it back to where it was.
+func challengeHandler(w http.ResponseWriter, req *http.Request) {
+	next := localPath(req.FormValue("next"))
+	status := http.StatusOK
+	if req.Method == http.MethodPost {
+		err := verifyChallenge(req)
+		challengeStats.Lock()
+		if err == nil {
+			challengeStats.passed++
+		} else {
+			challengeStats.failed++
+		}
+		challengeStats.Unlock()
+		if err == nil {
+			setPass(w, req)
+			http.Redirect(w, req, next, http.StatusSeeOther)
+			return
+		}
+		status = http.StatusForbidden
+	}
+
+	var widget string
+	if p, ok := captchaProviders[*challengeKind]; ok {
+		if csp := w.Header().Get("Content-Security-Policy"); csp != "" {
+			w.Header().Set("Content-Security-Policy", cspAllowingIn(csp, p.origins, "script-src", "style-src", "frame-src", "connect-src"))
+		}
+		widget = fmt.Sprintf(`<div class="%s" data-sitekey="%s" data-callback="challengeSolved"></div>
+		<script src="%s" async defer></script>`, p.widgetClass, html.EscapeString(*challengeSiteKey), p.script)
+	} else {
+		widget = fmt.Sprintf(`<input type="hidden" name="token" value="%s" data-difficulty="%d">
+		<input type="hidden" name="counter">
+		<p id="progress" role="status">Checking your browser…</p>`, newPowChallenge(clientIP(req)), *challengeDifficulty)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, challengeHTML, html.EscapeString(*pageTitle), html.EscapeString(next), widget)
+}
+
+var challengeHTML = `<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>%s</title>
+</head>
+<body>
+	<main style="text-align: center">
+		<p>You've generated a lot of passwords. Please confirm you're not a bot to continue.</p>
+		<form id="challenge" method="post" action="/challenge">
+		<input type="hidden" name="next" value="%s">
+		%s
+		<noscript><p>This check needs JavaScript.</p></noscript>
+		</form>
+	</main>
+	<script src="/static/challenge.js"></script>
+</body>
+</html>
+`
+
+func verifyChallenge(req *http.Request) error {
+	if p, ok := captchaProviders[*challengeKind]; ok {
+		return verifyCaptcha(req, p)
+	}
+	return verifyPow(clientIP(req), req.FormValue("token"), req.FormValue("counter"))
+}
+
+// newPowChallenge returns a proof of work challenge for client: its expiry,
+// difficulty and a nonce, with their MAC.
+func newPowChallenge(client string) string {
+	nonce := make([]byte, 12)
+	cryptorand.Read(nonce)
+	payload := fmt.Sprintf("%d.%d.%s", time.Now().Add(powTTL).Unix(), *challengeDifficulty, b64url.EncodeToString(nonce))
+	return payload + "." + b64url.EncodeToString(challengeMAC(client, "pow", payload))
+}
+
+// verifyPow checks that the SHA-256 hash of token, a colon and counter
+// starts with as many zero bits as token demands.
+func verifyPow(client, token, counter string) error {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return errors.New("malformed challenge")
+	}
+	payload := token[:i]
+	mac, err := b64url.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(mac, challengeMAC(client, "pow", payload)) {
+		return errors.New("invalid challenge")
+	}
+	parts := strings.Split(payload, ".")
+	expiry, _ := strconv.ParseInt(parts[0], 10, 64)
+	if time.Now().Unix() > expiry {
+		return errors.New("expired challenge")
+	}
+	difficulty, _ := strconv.Atoi(parts[1])
+	if _, err := strconv.ParseUint(counter, 10, 64); err != nil {
+		return errors.New("missing solution")
+	}
+	hash := sha256.Sum256([]byte(token + ":" + counter))
+	zeros := 0
+	for j := 0; j < len(hash); j += 8 {
+		n := bits.LeadingZeros64(binary.BigEndian.Uint64(hash[j:]))
+		zeros += n
+		if n < 64 {
+			break
+		}
+	}
+	if zeros < difficulty {
+		return errors.New("wrong solution")
+	}
+	return nil
+}
+
+// verifyCaptcha checks the widget's response with the provider.
+func verifyCaptcha(req *http.Request, p captchaProvider) error {
+	response := req.FormValue(p.responseField)
+	if response == "" {
+		return errors.New("missing response")
+	}
+	secret, err := ioutil.ReadFile(*challengeSecretPath)
+	if err != nil {
+		logf(req, "Failed to read challenge secret: %s", err)
+		return err
+	}
+	resp, err := captchaClient.PostForm(p.verifyURL, url.Values{
+		"secret":   {strings.TrimSpace(string(secret))},
+		"response": {response},
+		"remoteip": {clientIP(req)},
+		"sitekey":  {*challengeSiteKey},
+	})
+	if err != nil {
+		logf(req, "Failed to verify %s response: %s", *challengeKind, err)
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("%s rejected the response: %s", *challengeKind, strings.Join(result.ErrorCodes, ", "))
+	}
+	return nil
+}
+
+func challengeSnapshot() (passed, failed uint64) {
+	challengeStats.Lock()
+	defer challengeStats.Unlock()
+	return challengeStats.passed, challengeStats.failed
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Passwords in the default mode are drawn from a charset: the built-in
+// alphabet without look-alikes, one of the presets below chosen per request
+// with charset=, or the default set with -charset, which may also list the
+// characters themselves.
+var defaultCharset = flag.String("charset", "unambiguous", "charset of passwords in the default mode: "+strings.Join(charsetNames(), ", ")+", or the printable ASCII characters to draw from")
+
+// charsetPreset is a named alphabet passwords can be drawn from.
+type charsetPreset struct {
+	Name  string
+	Label string
+	Chars string
+}
+
+// Ambiguous reports whether the charset contains easily confused
+// characters.
+func (p charsetPreset) Ambiguous() bool {
+	return strings.ContainsAny(p.Chars, ambiguousChars)
+}
+
+var charsetPresets = []charsetPreset{
+	{"unambiguous", "Letters and digits, without look-alikes", alphabet},
+	{"alnum", "Letters and digits", "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"},
+	{"ascii-printable", "All printable ASCII", printableASCII()},
+	{"safe", "Without look-alikes, with - _ and .", alphabet + "-_."},
+	{"hex", "Hexadecimal", "0123456789abcdef"},
+	{"base58", "Base58", "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"},
+}
+
+// customCharset is the name of the charset -charset lists the characters
+// of.
+const customCharset = "custom"
+
+func charsetNames() []string {
+	names := make([]string, len(charsetPresets))
+	for i, p := range charsetPresets {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// printableASCII returns the printable ASCII characters other than space.
+func printableASCII() string {
+	var sb strings.Builder
+	for c := byte('!'); c <= '~'; c++ {
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// availableCharsets returns the presets, and the custom charset if
+// -charset lists characters.
+func availableCharsets() []charsetPreset {
+	if lookupCharset(*defaultCharset) != "" {
+		return charsetPresets
+	}
+	return append(charsetPresets[:len(charsetPresets):len(charsetPresets)],
+		charsetPreset{customCharset, "Custom", *defaultCharset})
+}
+
+// lookupCharset returns the characters of the preset called name, or an
+// empty string if there is none.
+func lookupCharset(name string) string {
+	for _, p := range charsetPresets {
+		if p.Name == name {
+			return p.Chars
+		}
+	}
+	return ""
+}
+
+// defaultCharsetName returns the name of -charset: a preset's or "custom".
+func defaultCharsetName() string {
+	if lookupCharset(*defaultCharset) != "" {
+		return *defaultCharset
+	}
+	return customCharset
+}
+
+// validateCharset checks that -charset is a preset or distinct printable
+// ASCII characters, so a password's length is its number of characters.
+func validateCharset() error {
+	if lookupCharset(*defaultCharset) != "" {
+		return nil
+	}
+	if len(*defaultCharset) < 2 {
+		return fmt.Errorf("-charset must be one of %s, or at least 2 characters", strings.Join(charsetNames(), ", "))
+	}
+	for i := 0; i < len(*defaultCharset); i++ {
+		c := (*defaultCharset)[i]
+		if c < '!' || c > '~' {
+			return fmt.Errorf("-charset must only contain printable ASCII characters other than space")
+		}
+		if strings.IndexByte((*defaultCharset)[:i], c) >= 0 {
+			return fmt.Errorf("-charset lists %q more than once", c)
+		}
+	}
+	return nil
+}
+
+// characters returns the characters of opts' charset, or an empty string if
+// it is unknown.
+func (opts genOptions) characters() string {
+	name := opts.Charset
+	if name == "" {
+		name = defaultCharsetName()
+	}
+	if name == customCharset && defaultCharsetName() == customCharset {
+		return *defaultCharset
+	}
+	return lookupCharset(name)
+}
+
+// drawCharset returns a password of length n drawn uniformly from chars.
+func drawCharset(chars string, n int) string {
+	password := make([]byte, n)
+	for i := range password {
+		password[i] = chars[randIntn(len(chars))]
+	}
+	return string(password)
+}
@@ -0,0 +1,454 @@
+// Package client is a Go client for the random-password-please JSON API.
+//
+//	c := client.New("https://passwords.example.com")
+//	password, err := c.Password(ctx, &client.PasswordOptions{Length: 16})
+//
+// Requests that fail with a network error, 429 Too Many Requests or a 502,
+// 503 or 504 are retried, following the backoff policy of the server's
+// 429 responses, waiting as long as its Retry-After asks, or else backing
+// off exponentially.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of the clients New returns.
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxRetries   = 3
+	DefaultRetryWait    = 250 * time.Millisecond
+	DefaultMaxRetryWait = 30 * time.Second
+)
+
+// Client calls a random-password-please server. Its fields must not be
+// changed while it is in use.
+type Client struct {
+	// BaseURL is the server's URL, such as https://passwords.example.com.
+	BaseURL string
+	// HTTPClient makes the requests. Its Timeout limits each attempt, and
+	// the context passed to each method limits them all.
+	HTTPClient *http.Client
+	// APIKey, if set, is sent as a bearer token, for servers using -auth
+	// api=apikey.
+	APIKey string
+	// MaxRetries is how many times a failed request is retried.
+	MaxRetries int
+	// RetryWait is how long to wait before the first retry, doubled for
+	// each one after, unless the server sends Retry-After.
+	RetryWait time.Duration
+	// MaxRetryWait limits any wait between retries.
+	MaxRetryWait time.Duration
+	// UserAgent, if set, is sent as the User-Agent header.
+	UserAgent string
+}
+
+// New returns a client for the server at baseURL with the default timeout
+// and retries.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		HTTPClient:   &http.Client{Timeout: DefaultTimeout},
+		MaxRetries:   DefaultMaxRetries,
+		RetryWait:    DefaultRetryWait,
+		MaxRetryWait: DefaultMaxRetryWait,
+	}
+}
+
+// Error is an error response from the server.
+type Error struct {
+	StatusCode int
+	// Message is the server's explanation.
+	Message string
+	// Parameter is the query parameter at fault, if the server said.
+	Parameter string
+	// RetryAfter is how long the server asked clients to wait, if it did.
+	RetryAfter time.Duration
+	// Backoff is how the server asked clients to space out retries, if it
+	// did.
+	Backoff *Backoff
+}
+
+// Backoff is a server's retry policy: the nth retry waits for Initial *
+// Multiplier^(n-1) seconds, up to Max, or for Retry-After if that is
+// longer. With Jitter "full" it waits a random time up to that instead.
+type Backoff struct {
+	Strategy   string  `json:"strategy"`
+	Initial    float64 `json:"initial_seconds"`
+	Multiplier float64 `json:"multiplier"`
+	Max        float64 `json:"max_seconds"`
+	Jitter     string  `json:"jitter"`
+}
+
+// wait returns how long to wait before retry n, counting from 1.
+func (b *Backoff) wait(n int) time.Duration {
+	secs := b.Initial
+	if b.Strategy == "exponential" && b.Multiplier > 1 {
+		secs *= math.Pow(b.Multiplier, float64(n-1))
+	}
+	if b.Max > 0 && secs > b.Max {
+		secs = b.Max
+	}
+	d := time.Duration(secs * float64(time.Second))
+	if b.Jitter == "full" && d > 0 {
+		d = time.Duration(rand.Int63n(int64(d) + 1))
+	}
+	return d
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("random-password-please: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("random-password-please: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// PasswordOptions choose the policy of generated passwords. The zero value
+// is the server's default policy.
+type PasswordOptions struct {
+	// Length is the number of characters, clamped by the server to its
+	// -min-length and -max-length.
+	Length int
+	// Mode is "", "mobile", "memorable" or "passphrase".
+	Mode string
+	// Words, Separator and Capitalize shape passphrases.
+	Words      int
+	Separator  string
+	Capitalize bool
+	// Pattern gives the structure of the password, such as u{2}l{4}D{2}S.
+	Pattern string
+	// Lang is the language of memorable passwords' words.
+	Lang string
+	// Charset is the preset characters are drawn from in the default mode,
+	// such as "alnum", "ascii-printable", "safe", "hex" or "base58".
+	Charset string
+	// RequireEach guarantees a character of each of the charset's classes.
+	RequireEach bool
+	// Sampler is how words are drawn: "uniform" or "weighted".
+	Sampler string
+	// Start and End constrain the first and last characters to "alpha",
+	// "alnum", "lower" or "upper".
+	Start, End     string
+	NoLeadingDigit bool
+}
+
+func (o *PasswordOptions) values() url.Values {
+	v := url.Values{}
+	if o == nil {
+		return v
+	}
+	set := func(name, value string) {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	if o.Length > 0 {
+		v.Set("len", strconv.Itoa(o.Length))
+	}
+	set("mode", o.Mode)
+	if o.Words > 0 {
+		v.Set("words", strconv.Itoa(o.Words))
+	}
+	set("separator", o.Separator)
+	if o.Capitalize {
+		v.Set("capitalize", "1")
+	}
+	set("pattern", o.Pattern)
+	set("lang", o.Lang)
+	set("charset", o.Charset)
+	if o.RequireEach {
+		v.Set("require-each", "1")
+	}
+	set("sampler", o.Sampler)
+	set("start", o.Start)
+	set("end", o.End)
+	if o.NoLeadingDigit {
+		v.Set("no-leading-digit", "1")
+	}
+	return v
+}
+
+// Password generates a password.
+func (c *Client) Password(ctx context.Context, opts *PasswordOptions) (string, error) {
+	var r struct {
+		Password string `json:"password"`
+	}
+	if err := c.get(ctx, "/password.txt", opts.values(), &r); err != nil {
+		return "", err
+	}
+	return r.Password, nil
+}
+
+// Passwords generates count passwords, up to the server's -max-count.
+func (c *Client) Passwords(ctx context.Context, count int, opts *PasswordOptions) ([]string, error) {
+	v := opts.values()
+	v.Set("count", strconv.Itoa(count))
+	// A single password isn't wrapped in a batch.
+	var r struct {
+		Password  string `json:"password"`
+		Passwords []struct {
+			Password string `json:"password"`
+		} `json:"passwords"`
+	}
+	if err := c.get(ctx, "/password.txt", v, &r); err != nil {
+		return nil, err
+	}
+	if r.Passwords == nil {
+		return []string{r.Password}, nil
+	}
+	passwords := make([]string, len(r.Passwords))
+	for i, p := range r.Passwords {
+		passwords[i] = p.Password
+	}
+	return passwords, nil
+}
+
+// HashedPassword is a password and its hash.
+type HashedPassword struct {
+	Password string `json:"password"`
+	// Algorithm is "argon2id" or "bcrypt".
+	Algorithm string `json:"algorithm"`
+	// Hash is in the PHC string format for argon2id and crypt's $2b$
+	// format for bcrypt.
+	Hash string `json:"hash"`
+}
+
+// HashedPassword generates a password and hashes it with algorithm,
+// "argon2id" or "bcrypt", or the server's -hash-algorithm if it is empty.
+func (c *Client) HashedPassword(ctx context.Context, algorithm string, opts *PasswordOptions) (*HashedPassword, error) {
+	v := opts.values()
+	if algorithm != "" {
+		v.Set("algorithm", algorithm)
+	}
+	var r HashedPassword
+	if err := c.get(ctx, "/password/hashed", v, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Token returns n random bytes, from 1 to 1024, in encoding: "hex",
+// "base64url" or "base32", or hex if it is empty.
+func (c *Client) Token(ctx context.Context, n int, encoding string) (string, error) {
+	v := url.Values{"bytes": {strconv.Itoa(n)}}
+	if encoding != "" {
+		v.Set("encoding", encoding)
+	}
+	var r struct {
+		Token string `json:"token"`
+	}
+	if err := c.get(ctx, "/token", v, &r); err != nil {
+		return "", err
+	}
+	return r.Token, nil
+}
+
+// UUIDs generates count UUIDs of version 4 or 7.
+func (c *Client) UUIDs(ctx context.Context, version, count int) ([]string, error) {
+	v := url.Values{"version": {strconv.Itoa(version)}, "count": {strconv.Itoa(count)}}
+	var r struct {
+		UUIDs []string `json:"uuids"`
+	}
+	if err := c.get(ctx, "/uuid", v, &r); err != nil {
+		return nil, err
+	}
+	return r.UUIDs, nil
+}
+
+// Strength is an estimate of a password's strength.
+type Strength struct {
+	// Score is from 0 (too guessable) to 4 (very unguessable).
+	Score        int         `json:"score"`
+	Guesses      float64     `json:"guesses"`
+	GuessesLog10 float64     `json:"guesses_log10"`
+	CrackTimes   []CrackTime `json:"crack_times"`
+}
+
+// CrackTime is the estimated time to guess a password in a scenario, such
+// as "offline_slow_hash".
+type CrackTime struct {
+	Scenario string  `json:"scenario"`
+	Seconds  float64 `json:"seconds"`
+	Display  string  `json:"display"`
+}
+
+// Strength estimates the strength of password, which is sent in the
+// request body.
+func (c *Client) Strength(ctx context.Context, password string) (*Strength, error) {
+	var r Strength
+	if err := c.do(ctx, http.MethodPost, "/strength", nil, url.Values{"password": {password}}, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// SecretLink is the link to a one-time secret.
+type SecretLink struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// CreateSecret stores secret behind a link that works once and lasts ttl,
+// or the server's -secret-ttl if it is zero. If secret is empty a password
+// generated with opts is stored instead, and isn't returned.
+func (c *Client) CreateSecret(ctx context.Context, secret string, ttl time.Duration, opts *PasswordOptions) (*SecretLink, error) {
+	v := opts.values()
+	if ttl > 0 {
+		v.Set("ttl", ttl.String())
+	}
+	form := url.Values{}
+	if secret != "" {
+		form.Set("secret", secret)
+	}
+	var r SecretLink
+	if err := c.do(ctx, http.MethodPost, "/secrets", v, form, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	return c.do(ctx, http.MethodGet, path, query, nil, v)
+}
+
+// do makes a request, retrying it as the client allows, and decodes the
+// JSON response into v.
+func (c *Client) do(ctx context.Context, method, path string, query, form url.Values, v interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, method, u, form, v)
+		if err == nil || attempt >= c.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		wait := c.RetryWait << uint(attempt)
+		switch err := err.(type) {
+		case *Error:
+			if !err.Temporary() {
+				return err
+			}
+			if err.Backoff != nil {
+				wait = err.Backoff.wait(attempt + 1)
+				if wait < err.RetryAfter {
+					wait = err.RetryAfter
+				}
+			} else if err.RetryAfter > 0 {
+				wait = err.RetryAfter
+			}
+		case *decodeError:
+			return err
+		}
+		if c.MaxRetryWait > 0 && wait > c.MaxRetryWait {
+			wait = c.MaxRetryWait
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+// decodeError is a response that isn't the JSON expected, which retrying
+// won't fix.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return "random-password-please: decoding response: " + e.err.Error()
+}
+
+func (c *Client) attempt(ctx context.Context, method, u string, form url.Values, v interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &decodeError{err}
+	}
+	return nil
+}
+
+// responseError returns the error a response describes, as an RFC 7807
+// problem or plain text.
+func responseError(resp *http.Response) *Error {
+	e := &Error{StatusCode: resp.StatusCode}
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if secs, err := strconv.Atoi(s); err == nil && secs > 0 {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(s); err == nil && time.Until(t) > 0 {
+			e.RetryAfter = time.Until(t)
+		}
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/problem+json" {
+		var problem struct {
+			Title     string   `json:"title"`
+			Detail    string   `json:"detail"`
+			Parameter string   `json:"parameter"`
+			Backoff   *Backoff `json:"backoff"`
+		}
+		if json.Unmarshal(b, &problem) == nil {
+			e.Message, e.Parameter, e.Backoff = problem.Detail, problem.Parameter, problem.Backoff
+			if e.Message == "" {
+				e.Message = problem.Title
+			}
+			return e
+		}
+	}
+	e.Message = strings.TrimSpace(string(b))
+	return e
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serve starts a server that responds to the nth request, counting from
+// 1, with respond(n, w), and returns a client for it that retries quickly.
+func serve(t *testing.T, respond func(n int, w http.ResponseWriter)) (*Client, *int32) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		respond(int(atomic.AddInt32(&requests, 1)), w)
+	}))
+	t.Cleanup(ts.Close)
+	c := New(ts.URL)
+	c.RetryWait = time.Millisecond
+	return c, &requests
+}
+
+func okPassword(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"password":"hunter2hunter2"}`)
+}
+
+// tooManyRequests responds like the server's rate limiter.
+func tooManyRequests(w http.ResponseWriter, retryAfter string, backoff string) {
+	if retryAfter != "" {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, `{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"rate limit exceeded","backoff":%s}`, backoff)
+}
+
+func TestRetryAfter(t *testing.T) {
+	c, requests := serve(t, func(n int, w http.ResponseWriter) {
+		if n == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		okPassword(w)
+	})
+	c.MaxRetryWait = 100 * time.Millisecond
+	start := time.Now()
+	password, err := c.Password(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "hunter2hunter2" || *requests != 2 {
+		t.Errorf("got %q after %d requests", password, *requests)
+	}
+	// Retry-After overrides RetryWait, and MaxRetryWait caps it.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Errorf("retried after %s, want MaxRetryWait", elapsed)
+	}
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	c, _ := serve(t, func(n int, w http.ResponseWriter) {
+		w.Header().Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	})
+	c.MaxRetries = 0
+	_, err := c.Password(context.Background(), nil)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("got %v, want an *Error", err)
+	}
+	if e.RetryAfter < 59*time.Minute || e.RetryAfter > time.Hour {
+		t.Errorf("RetryAfter is %s, want an hour", e.RetryAfter)
+	}
+}
+
+// TestBackoffPolicy checks that 429s are retried as their backoff policy
+// says rather than from RetryWait.
+func TestBackoffPolicy(t *testing.T) {
+	const policy = `{"strategy":"exponential","initial_seconds":0.02,"multiplier":2,"max_seconds":0.05,"jitter":"none"}`
+	c, requests := serve(t, func(n int, w http.ResponseWriter) {
+		if n <= 3 {
+			tooManyRequests(w, "", policy)
+			return
+		}
+		okPassword(w)
+	})
+	start := time.Now()
+	if _, err := c.Password(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if *requests != 4 {
+		t.Errorf("%d requests, want 4", *requests)
+	}
+	// 20ms, then 40ms, then 80ms capped to 50ms.
+	if elapsed := time.Since(start); elapsed < 110*time.Millisecond || elapsed > time.Second {
+		t.Errorf("retried for %s, want 110ms", elapsed)
+	}
+
+	b := &Backoff{Strategy: "exponential", Initial: 1, Multiplier: 2, Max: 5, Jitter: "full"}
+	for n := 1; n <= 5; n++ {
+		if d := b.wait(n); d < 0 || d > 5*time.Second {
+			t.Errorf("retry %d with full jitter waits %s", n, d)
+		}
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(w http.ResponseWriter)
+		want    Error
+	}{
+		{"problem", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"len must be between 8 and 128","parameter":"len"}`)
+		}, Error{StatusCode: 400, Message: "len must be between 8 and 128", Parameter: "len"}},
+		{"problem without detail", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"title":"Forbidden","status":403}`)
+		}, Error{StatusCode: 403, Message: "Forbidden"}},
+		{"text", func(w http.ResponseWriter) {
+			http.Error(w, "unknown charset \"x\"", http.StatusBadRequest)
+		}, Error{StatusCode: 400, Message: `unknown charset "x"`}},
+		{"empty", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+		}, Error{StatusCode: 404}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, requests := serve(t, func(n int, w http.ResponseWriter) { tt.respond(w) })
+			_, err := c.Password(context.Background(), nil)
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("got %v, want an *Error", err)
+			}
+			if *e != tt.want {
+				t.Errorf("got %+v, want %+v", *e, tt.want)
+			}
+			if *requests != 1 {
+				t.Errorf("a %d was retried", e.StatusCode)
+			}
+		})
+	}
+
+	// The rate limiter's problem keeps its policy.
+	c, _ := serve(t, func(n int, w http.ResponseWriter) {
+		tooManyRequests(w, "4", `{"strategy":"exponential","initial_seconds":0.5,"multiplier":2,"max_seconds":300,"jitter":"none"}`)
+	})
+	c.MaxRetries = 0
+	_, err := c.Password(context.Background(), nil)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("got %v, want an *Error", err)
+	}
+	want := Backoff{Strategy: "exponential", Initial: 0.5, Multiplier: 2, Max: 300, Jitter: "none"}
+	if e.StatusCode != 429 || e.RetryAfter != 4*time.Second || e.Backoff == nil || *e.Backoff != want || !e.Temporary() {
+		t.Errorf("got %+v with backoff %+v", *e, e.Backoff)
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	c, requests := serve(t, func(n int, w http.ResponseWriter) {
+		fmt.Fprint(w, "not JSON")
+	})
+	if _, err := c.Password(context.Background(), nil); err == nil {
+		t.Fatal("malformed response accepted")
+	}
+	if *requests != 1 {
+		t.Errorf("a malformed response was retried %d times", *requests-1)
+	}
+}
+
+func TestContextCancelsRetries(t *testing.T) {
+	c, _ := serve(t, func(n int, w http.ResponseWriter) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Password(ctx, nil); err == nil {
+		t.Fatal("no error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("waited %s after the context ended", elapsed)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Replicas behind a load balancer must enforce the same policy, or a
+// password refused by one is issued by the next. With -cluster-serve an
+// instance serves its generation policy, the settings in
+// clusterPolicySettings, at /admin/cluster/policy. Replicas started with
+// -cluster-primary fetch it before serving and then long-poll for changes,
+// which the primary answers as soon as a reload or the admin API changes the
+// policy. On a replica the policy settings can't be set locally, and if the
+// primary is unreachable it keeps the last policy it fetched.
+var (
+	clusterServe   = flag.Bool("cluster-serve", false, "serve the generation policy to replicas at /admin/cluster/policy")
+	clusterPrimary = flag.String("cluster-primary", "", "base `url` of the instance to fetch the generation policy from, making this a replica")
+	clusterKeyPath = flag.String("cluster-key", "", "`file` holding the API key to fetch the policy from -cluster-primary with")
+)
+
+func init() {
+	registerFeature(feature{name: "cluster policy", kind: "subsystem", active: func() bool {
+		return *clusterServe || *clusterPrimary != ""
+	}})
+}
+
+// clusterPolicySettings are the settings replicas take from the primary.
+var clusterPolicySettings = []string{
+	"min-length",
+	"max-length",
+	"max-count",
+	"max-bulk-count",
+	"max-stream-count",
+	"charset",
+	"avoid-walks",
+	"weighted-min-entropy",
+}
+
+// clusterPolicy is a generation policy and its version, a digest of the
+// settings.
+type clusterPolicy struct {
+	Version  string              `json:"version"`
+	Settings map[string][]string `json:"settings"`
+}
+
+// currentClusterPolicy returns the policy in effect. configLock must be
+// held.
+func currentClusterPolicy() clusterPolicy {
+	p := clusterPolicy{Settings: make(map[string][]string)}
+	for _, name := range clusterPolicySettings {
+		p.Settings[name] = flagValues(flag.Lookup(name))
+	}
+	// Maps are marshalled with sorted keys, so equal policies have equal
+	// digests.
+	data, _ := json.Marshal(p.Settings)
+	sum := sha256.Sum256(data)
+	p.Version = hex.EncodeToString(sum[:8])
+	return p
+}
+
+// policyChanged is closed, and replaced, when the settings change, to wake
+// the replicas waiting for a new policy. configLock must be held to use it.
+var policyChanged = make(chan struct{})
+
+// notifyPolicyChange wakes the replicas waiting for a new policy.
+// configLock must be held for writing.
+func notifyPolicyChange() {
+	close(policyChanged)
+	policyChanged = make(chan struct{})
+}
+
+// clusterPollWait is the longest a request for a new policy is held open. It
+// is cut short to leave time to respond within -write-timeout.
+func clusterPollWait() time.Duration {
+	wait := 55 * time.Second
+	if *writeTimeout > 0 && *writeTimeout/2 < wait {
+		wait = *writeTimeout / 2
+	}
+	return wait
+}
+
+// clusterPolicyHandler serves the policy. With wait set to the version the
+// replica has, it responds once the policy differs, or with 304 Not
+// Modified if it doesn't within clusterPollWait.
+func clusterPolicyHandler(w http.ResponseWriter, req *http.Request) {
+	p := currentClusterPolicy()
+	if have := req.FormValue("wait"); have != "" && have == p.Version {
+		changed := policyChanged
+		// Don't hold up reloads while waiting for one.
+		releaseConfigLock(req)
+		select {
+		case <-changed:
+		case <-time.After(clusterPollWait()):
+		case <-req.Context().Done():
+		}
+		configLock.RLock()
+		p = currentClusterPolicy()
+		configLock.RUnlock()
+		if p.Version == have {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// clusterSettings are the settings taken from the primary, which override
+// the config file when it is reloaded. configLock must be held to use it.
+var clusterSettings map[string][]string
+
+// clusterClient fetches the policy. Its timeout allows for the primary to
+// hold a request for up to a minute.
+var clusterClient = &http.Client{Timeout: 90 * time.Second}
+
+// clusterKey is the API key to fetch the policy with, if any.
+var clusterKey string
+
+// setupClusterPolicy fetches and applies the primary's policy, if this is a
+// replica, and starts watching for changes to it.
+func setupClusterPolicy() error {
+	if *clusterPrimary == "" {
+		return nil
+	}
+	if *clusterServe {
+		return errors.New("-cluster-serve and -cluster-primary can't both be set; replicas all fetch from the primary")
+	}
+	if u, err := url.Parse(*clusterPrimary); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-cluster-primary %q must be an http or https URL", *clusterPrimary)
+	}
+	for _, name := range clusterPolicySettings {
+		if commandLineFlags[name] {
+			return fmt.Errorf("-%s is set by the -cluster-primary, so it can't be given on a replica", name)
+		}
+	}
+	if *clusterKeyPath != "" {
+		data, err := ioutil.ReadFile(*clusterKeyPath)
+		if err != nil {
+			return fmt.Errorf("-cluster-key: %s", err)
+		}
+		clusterKey = strings.TrimSpace(string(data))
+	}
+	p, err := fetchClusterPolicy("")
+	if err != nil {
+		return err
+	}
+	if err := applyClusterPolicy(p); err != nil {
+		return err
+	}
+	go watchClusterPolicy(p.Version)
+	return nil
+}
+
+// fetchClusterPolicy fetches the primary's policy. With have set, the
+// primary holds the request until its policy differs from that version, and
+// a nil policy is returned if it doesn't.
+func fetchClusterPolicy(have string) (*clusterPolicy, error) {
+	u := strings.TrimSuffix(*clusterPrimary, "/") + "/admin/cluster/policy"
+	if have != "" {
+		u += "?wait=" + url.QueryEscape(have)
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if clusterKey != "" {
+		req.Header.Set("Authorization", "Bearer "+clusterKey)
+	}
+	resp, err := clusterClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && have != "" {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+	var p clusterPolicy
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("fetching %s: %s", u, err)
+	}
+	for name := range p.Settings {
+		if !contains(clusterPolicySettings, name) {
+			return nil, fmt.Errorf("the primary's policy has unknown setting %q", name)
+		}
+	}
+	return &p, nil
+}
+
+// applyClusterPolicy sets the policy's settings. If they are invalid here
+// the current ones are kept.
+func applyClusterPolicy(p *clusterPolicy) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	before := settingValues()
+	restore := func() {
+		for name := range p.Settings {
+			flag.Set(name, before[name][0])
+		}
+	}
+	for name, values := range p.Settings {
+		for _, v := range values {
+			if err := flag.Set(name, v); err != nil {
+				restore()
+				return fmt.Errorf("the primary's policy sets %s: %s", name, err)
+			}
+		}
+	}
+	if err := validateConfig(); err != nil {
+		restore()
+		return fmt.Errorf("the primary's policy is invalid here: %s", err)
+	}
+	clusterSettings = p.Settings
+	atomic.StoreInt32(&bufferedLength, int32(*maxPasswordLength))
+	recordConfigDiff(&configDiff{Time: time.Now(), Source: "cluster primary",
+		Changes: diffSettings(before, settingValues())})
+	return nil
+}
+
+// watchClusterPolicy applies the primary's policy whenever it changes from
+// version, logging when fetching it starts and stops failing.
+func watchClusterPolicy(version string) {
+	failing := false
+	for {
+		p, err := fetchClusterPolicy(version)
+		if err == nil && p != nil {
+			if err = applyClusterPolicy(p); err == nil {
+				version = p.Version
+			}
+		}
+		switch {
+		case err != nil && !failing:
+			log.Printf("Failed to update the cluster policy, keeping the current one: %s", err)
+		case err == nil && failing:
+			log.Print("Updating the cluster policy again")
+		}
+		failing = err != nil
+		if failing {
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
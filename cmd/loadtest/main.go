@@ -0,0 +1,190 @@
+// Command loadtest sends requests to a random-password-please server from
+// a number of concurrent clients and reports the throughput, the status
+// codes and the latency percentiles, so performance regressions can be
+// measured against a running server.
+//
+//	$ go run ./cmd/loadtest -url 'http://localhost:8080/password.txt?len=16' -c 50 -d 30s
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	targetURL   = flag.String("url", "http://localhost:8080/password.txt", "`url` to request")
+	concurrency = flag.Int("c", 10, "number of concurrent clients")
+	requests    = flag.Int("n", 0, "total number of requests, or 0 to run for -d")
+	duration    = flag.Duration("d", 10*time.Second, "how long to run for if -n isn't set")
+	timeout     = flag.Duration("timeout", 10*time.Second, "timeout of each request")
+	headers     = headerList{}
+)
+
+func init() {
+	flag.Var(&headers, "H", "request header as `name: value`, e.g. an X-API-Key (may be repeated)")
+}
+
+// headerList is a flag.Value holding request headers.
+type headerList map[string]string
+
+func (h headerList) String() string {
+	var s []string
+	for name, value := range h {
+		s = append(s, name+": "+value)
+	}
+	return strings.Join(s, ", ")
+}
+
+func (h headerList) Set(value string) error {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return fmt.Errorf("invalid header %q, want name: value", value)
+	}
+	h[strings.TrimSpace(value[:i])] = strings.TrimSpace(value[i+1:])
+	return nil
+}
+
+// result is the outcome of one request.
+type result struct {
+	latency time.Duration
+	status  int
+	err     error
+}
+
+func main() {
+	flag.Parse()
+	if *concurrency < 1 {
+		log.Fatal("-c must be at least 1")
+	}
+	req, err := http.NewRequest(http.MethodGet, *targetURL, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		// Keep a connection open for each client, as clients of the API
+		// would.
+		Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+	}
+	// Each client takes a token from work for each request. Without -n,
+	// work is closed when the duration is up.
+	work := make(chan struct{}, *concurrency)
+	go func() {
+		defer close(work)
+		if *requests > 0 {
+			for i := 0; i < *requests; i++ {
+				work <- struct{}{}
+			}
+			return
+		}
+		deadline := time.After(*duration)
+		for {
+			select {
+			case work <- struct{}{}:
+			case <-deadline:
+				return
+			}
+		}
+	}()
+
+	results := make(chan result, *concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range work {
+				results <- do(client, req)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var all []result
+	for r := range results {
+		all = append(all, r)
+	}
+	report(os.Stdout, all, time.Since(start))
+}
+
+// do makes the request and reads the response.
+func do(client *http.Client, req *http.Request) result {
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return result{latency: time.Since(start), err: err}
+	}
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return result{latency: time.Since(start), status: resp.StatusCode, err: err}
+}
+
+// report writes the throughput, the count of each status code and error,
+// and the latency percentiles of the successful requests to w.
+func report(w io.Writer, results []result, elapsed time.Duration) {
+	statuses := make(map[string]int)
+	var latencies []time.Duration
+	for _, r := range results {
+		if r.err != nil {
+			statuses["error"]++
+			continue
+		}
+		statuses[fmt.Sprint(r.status)]++
+		if r.status < 400 {
+			latencies = append(latencies, r.latency)
+		}
+	}
+	fmt.Fprintf(w, "%d requests in %s, %.1f requests/s\n", len(results), elapsed.Round(time.Millisecond), float64(len(results))/elapsed.Seconds())
+	names := make([]string, 0, len(statuses))
+	for s := range statuses {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+	for _, s := range names {
+		fmt.Fprintf(w, "  %s: %d\n", s, statuses[s])
+	}
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(w, "first error: %s\n", r.err)
+			break
+		}
+	}
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Fprintf(w, "latency of successful requests:\n")
+	for _, p := range []float64{50, 90, 99, 100} {
+		fmt.Fprintf(w, "  p%g: %s\n", p, percentile(latencies, p))
+	}
+}
+
+// percentile returns the pth percentile of the sorted latencies, by the
+// nearest rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
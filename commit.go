@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Verifiable randomness uses a commit-reveal protocol so that auditors can
+// check the server didn't cherry-pick a result, e.g. for a raffle:
+//
+//  1. POST /commit: the server draws a secret and returns an id and the
+//     SHA-256 hash of the secret (the commitment).
+//  2. The client chooses a nonce, which the server can't predict when it
+//     commits.
+//  3. POST /reveal?id=...&nonce=...: the server reveals the secret and the
+//     result derived from it and the nonce with HKDF-SHA256 (secret as the
+//     input keying material, nonce as the salt).
+//
+// Anyone can then check that the secret hashes to the commitment and
+// recompute the result.
+var commitTTL = flag.Duration("commit-ttl", 10*time.Minute, "how long commitments can be revealed for")
+
+func init() {
+	registerFeature(feature{name: "commit-reveal", kind: "subsystem", active: alwaysActive})
+}
+
+const (
+	commitSecretBytes = 32
+	maxCommitments    = 10000
+	revealInfo        = "random-password-please reveal"
+)
+
+type commitment struct {
+	secret  []byte
+	expires time.Time
+}
+
+// commitmentStore holds unrevealed commitments until they expire.
+type commitmentStore struct {
+	mu sync.Mutex
+	m  map[string]commitment
+}
+
+var commitments = &commitmentStore{m: make(map[string]commitment)}
+
+func (s *commitmentStore) add(id string, c commitment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.m) >= maxCommitments {
+		return fmt.Errorf("too many outstanding commitments")
+	}
+	s.m[id] = c
+	return nil
+}
+
+// take removes and returns the commitment with the given id.
+func (s *commitmentStore) take(id string) (commitment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.m[id]
+	delete(s.m, id)
+	if ok && time.Now().After(c.expires) {
+		return commitment{}, false
+	}
+	return c, ok
+}
+
+// expire periodically removes expired commitments.
+func (s *commitmentStore) expire(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		s.mu.Lock()
+		for id, c := range s.m {
+			if now.After(c.expires) {
+				delete(s.m, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+type commitResult struct {
+	XMLName    xml.Name  `json:"-" xml:"commitment"`
+	ID         string    `json:"id" xml:"id"`
+	Commitment string    `json:"commitment" xml:"hash"`
+	Expires    time.Time `json:"expires" xml:"expires"`
+}
+
+func (c commitResult) text() string {
+	return fmt.Sprintf("id: %s\ncommitment: %s\nexpires: %s\n", c.ID, c.Commitment, c.Expires.Format(time.RFC3339))
+}
+
+func (c commitResult) csvRecords() [][]string {
+	return [][]string{{"id", "commitment", "expires"}, {c.ID, c.Commitment, c.Expires.Format(time.RFC3339)}}
+}
+
+type revealResult struct {
+	XMLName    xml.Name `json:"-" xml:"reveal"`
+	ID         string   `json:"id" xml:"id"`
+	Commitment string   `json:"commitment" xml:"hash"`
+	Secret     string   `json:"secret" xml:"secret"`
+	Nonce      string   `json:"nonce" xml:"nonce"`
+	Password   string   `json:"password,omitempty" xml:"password,omitempty"`
+	Pick       *uint64  `json:"pick,omitempty" xml:"pick,omitempty"`
+}
+
+func (r revealResult) text() string {
+	s := fmt.Sprintf("id: %s\ncommitment: %s\nsecret: %s\nnonce: %s\n", r.ID, r.Commitment, r.Secret, r.Nonce)
+	if r.Password != "" {
+		s += "password: " + r.Password + "\n"
+	}
+	if r.Pick != nil {
+		s += "pick: " + strconv.FormatUint(*r.Pick, 10) + "\n"
+	}
+	return s
+}
+
+func (r revealResult) csvRecords() [][]string {
+	pick := ""
+	if r.Pick != nil {
+		pick = strconv.FormatUint(*r.Pick, 10)
+	}
+	return [][]string{
+		{"id", "commitment", "secret", "nonce", "password", "pick"},
+		{r.ID, r.Commitment, r.Secret, r.Nonce, r.Password, pick},
+	}
+}
+
+func commitHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "commitments must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b := make([]byte, 16+commitSecretBytes)
+	if _, err := readRandom(b); err != nil {
+		http.Error(w, "failed to read random bytes", http.StatusInternalServerError)
+		return
+	}
+	id, secret := hex.EncodeToString(b[:16]), b[16:]
+	c := commitment{secret: secret, expires: time.Now().Add(*commitTTL)}
+	if err := commitments.add(id, c); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	hash := sha256.Sum256(secret)
+	render(w, req, commitResult{ID: id, Commitment: hex.EncodeToString(hash[:]), Expires: c.expires.UTC()})
+}
+
+func revealHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "reveals must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nonce := req.FormValue("nonce")
+	if nonce == "" {
+		http.Error(w, "missing nonce", http.StatusBadRequest)
+		return
+	}
+	var pickRange uint64
+	if s := req.FormValue("pick"); s != "" {
+		var err error
+		if pickRange, err = strconv.ParseUint(s, 10, 64); err != nil || pickRange < 1 {
+			http.Error(w, "pick must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	n := 0
+	if s := req.FormValue("len"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < *minPasswordLength || n > *maxPasswordLength {
+			http.Error(w, fmt.Sprintf("len must be between %d and %d", *minPasswordLength, *maxPasswordLength), http.StatusBadRequest)
+			return
+		}
+	}
+	if n == 0 && pickRange == 0 {
+		n = *minPasswordLength
+	}
+
+	id := req.FormValue("id")
+	c, ok := commitments.take(id)
+	if !ok {
+		http.Error(w, "unknown or expired commitment", http.StatusNotFound)
+		return
+	}
+
+	hash := sha256.Sum256(c.secret)
+	result := revealResult{
+		ID:         id,
+		Commitment: hex.EncodeToString(hash[:]),
+		Secret:     hex.EncodeToString(c.secret),
+		Nonce:      nonce,
+	}
+	stream := newHKDF(sha256.New, c.secret, []byte(nonce), []byte(revealInfo))
+	var err error
+	if n > 0 {
+		if result.Password, err = passwordFromStream(stream, n); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if pickRange > 0 {
+		// The password, if any, is drawn first so verifiers must do the
+		// same.
+		pick, err := uniformUint64(stream, pickRange)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Pick = &pick
+	}
+	render(w, req, result)
+}
+
+// passwordFromStream draws a password of length n from the alphabet using
+// random bytes from r, rejecting bytes that would bias the selection.
+func passwordFromStream(r io.Reader, n int) (string, error) {
+	limit := 256 - 256%len(alphabet)
+	password := make([]byte, n)
+	b := make([]byte, 1)
+	for i := 0; i < n; {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if int(b[0]) < limit {
+			password[i] = alphabet[int(b[0])%len(alphabet)]
+			i++
+		}
+	}
+	return string(password), nil
+}
+
+// uniformUint64 returns a uniformly distributed number in [0, n) using
+// random bytes from r.
+func uniformUint64(r io.Reader, n uint64) (uint64, error) {
+	limit := math.MaxUint64 - math.MaxUint64%n
+	var b [8]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		if v := binary.BigEndian.Uint64(b[:]); v < limit {
+			return v % n, nil
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Responses in text formats, such as the page, large JSON batches and the
+// front-end assets, are gzipped for clients that accept it. Passwords are
+// fresh in every response, so compression doesn't expose them to attacks
+// such as BREACH that recover a secret repeated across responses.
+var compress = flag.Bool("compress", true, "gzip text responses for clients that accept it")
+
+func init() {
+	registerFeature(feature{name: "gzip", kind: "transport", active: func() bool {
+		return *compress
+	}})
+}
+
+// compressMinLength is the shortest response worth compressing; below it
+// the gzip header and trailer outweigh the savings.
+const compressMinLength = 1024
+
+// compressedTypes are the media types that are compressed.
+var compressedTypes = map[string]bool{
+	"text/html":              true,
+	"text/plain":             true,
+	"text/csv":               true,
+	"text/css":               true,
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+}
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// compressed gzips h's responses when -compress is set and the client
+// accepts gzip.
+func compressed(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !*compress {
+			h.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if req.Method == http.MethodHead || !acceptsGzip(req) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		h.ServeHTTP(gw, req)
+	})
+}
+
+// acceptsGzip reports whether req's Accept-Encoding allows gzip.
+func acceptsGzip(req *http.Request) bool {
+	for _, part := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, p := range fields[1:] {
+			if s := strings.TrimSpace(p); strings.HasPrefix(s, "q=") {
+				q, _ = strconv.ParseFloat(s[2:], 64)
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// gzipResponseWriter decides whether to compress when the response's
+// header is written, since that is when its type and length are known.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if h := w.Header(); compressible(h, code) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the compressor.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+	}
+}
+
+// compressible reports whether a response with header h and status code is
+// worth compressing.
+func compressible(h http.Header, code int) bool {
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < compressMinLength {
+		return false
+	}
+	typ, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && compressedTypes[typ]
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config files use a small subset of YAML: one "flag-name: value" pair per
+// line, with repeatable flags written as a list of "- value" items. Any flag
+// can be set this way; flags given on the command line take precedence.
+var configPath = flag.String("config", "", "configuration `file`")
+
+func loadConfig(path string) error {
+	settings, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	return applySettings(settings)
+}
+
+func readConfig(path string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := parseConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return settings, nil
+}
+
+func parseConfig(r io.Reader) (map[string][]string, error) {
+	settings := make(map[string][]string)
+	scanner := bufio.NewScanner(r)
+	var listKey string
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", lineNum)
+			}
+			v, err := unquote(strings.TrimSpace(line[2:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+			settings[listKey] = append(settings[listKey], v)
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected \"name: value\"", lineNum)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if _, dup := settings[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate setting %q", lineNum, key)
+		}
+		if value == "" {
+			// Start of a list.
+			listKey = key
+			settings[key] = nil
+			continue
+		}
+		listKey = ""
+		v, err := unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNum, err)
+		}
+		settings[key] = []string{v}
+	}
+	return settings, scanner.Err()
+}
+
+func unquote(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", errors.New("unterminated quoted string")
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	// Strip trailing comments from plain values.
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// writeConfig writes settings in the format read by parseConfig.
+func writeConfig(w io.Writer, settings map[string][]string) error {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		values := settings[name]
+		if f := flag.Lookup(name); f != nil {
+			if _, ok := f.Value.(multiValue); ok {
+				fmt.Fprintf(bw, "%s:\n", name)
+				for _, v := range values {
+					fmt.Fprintf(bw, "  - %s\n", strconv.Quote(v))
+				}
+				continue
+			}
+		}
+		for _, v := range values {
+			fmt.Fprintf(bw, "%s: %s\n", name, strconv.Quote(v))
+		}
+	}
+	return bw.Flush()
+}
+
+// applySettings sets flags from settings, skipping any given explicitly on
+// the command line.
+func applySettings(settings map[string][]string) error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for name, values := range settings {
+		if explicit[name] || bundleExcluded[name] {
+			continue
+		}
+		if flag.Lookup(name) == nil {
+			log.Printf("Ignoring unknown setting %q", name)
+			continue
+		}
+		for _, v := range values {
+			if err := flag.Set(name, v); err != nil {
+				return fmt.Errorf("setting %s: %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateConfig checks settings that can't be validated by the flag package.
+func validateConfig() error {
+	if *minPasswordLength < 1 {
+		return errors.New("-min-length must be at least 1")
+	}
+	if *maxPasswordLength < *minPasswordLength {
+		return errors.New("-max-length must not be less than -min-length")
+	}
+	if *randomStreamMaxBytes < 1 || *randomStreamRate < 1 {
+		return errors.New("-random-stream-max-bytes and -random-stream-rate must be positive")
+	}
+	if *randomStream && *writeTimeout > 0 && time.Duration(float64(*randomStreamMaxBytes)/float64(*randomStreamRate)*float64(time.Second)) >= *writeTimeout {
+		return errors.New("streaming -random-stream-max-bytes at -random-stream-rate must take less than -write-timeout")
+	}
+	if _, err := parseRotationPolicy(); err != nil {
+		return err
+	}
+	if *avoidWalks < 0 {
+		return errors.New("-avoid-walks must not be negative")
+	}
+	if *maxStreamCount < 0 {
+		return errors.New("-max-stream-count must not be negative")
+	}
+	if *suggestions < 1 || *suggestions > *maxCount {
+		return errors.New("-suggestions must be between 1 and -max-count")
+	}
+	if !contains(publicCounterModes, *publicCounter) {
+		return errors.New("-public-counter must be exact, rounded, approximate or hidden")
+	}
+	if *publicCounterStep < 1 || *publicCounterInterval < 0 {
+		return errors.New("-public-counter-step must be positive and -public-counter-interval must not be negative")
+	}
+	if *sloAvailability <= 0 || *sloAvailability > 1 {
+		return errors.New("-slo-availability must be greater than 0 and at most 1")
+	}
+	if *sloLatency <= 0 {
+		return errors.New("-slo-latency must be positive")
+	}
+	if *entropyInterval <= 0 || *entropyAlert < 0 {
+		return errors.New("-entropy-interval must be positive and -entropy-alert must not be negative")
+	}
+	if *deterministicSeed != 0 && !devBuild && !*insecureOK {
+		return errors.New("-deterministic-seed makes every password predictable; it requires a dev build or -insecure-ok")
+	}
+	if *weightedMinEntropy < 0 {
+		return errors.New("-weighted-min-entropy must not be negative")
+	}
+	if *noRepeatWindow < 0 || *noRepeatSize < 1 {
+		return errors.New("-no-repeat-window must not be negative and -no-repeat-size must be positive")
+	}
+	if *remaskAfter < 0 {
+		return errors.New("-remask-after must not be negative")
+	}
+	if *generators < 0 {
+		return errors.New("-generators must not be negative")
+	}
+	if *streamBuffer < 1 {
+		return errors.New("-stream-buffer must be positive")
+	}
+	if *sessionTicketRotation < 0 {
+		return errors.New("-session-ticket-rotation must not be negative")
+	}
+	if (*sessionTicketRotation > 0 || *sessionTicketKeyFile != "") && len(httpsAddrs.addrs) == 0 {
+		return errors.New("-session-ticket-rotation and -session-ticket-keys require -https")
+	}
+	if *readTimeout < 0 || *writeTimeout < 0 || *idleTimeout < 0 {
+		return errors.New("-read-timeout, -write-timeout and -idle-timeout must not be negative")
+	}
+	if *rateLimit < 0 || *rateBurst < 1 || *quota < 0 || *quotaWindow <= 0 || *banThreshold < 0 || *banDuration <= 0 {
+		return errors.New("rate limits must not be negative, and -rate-burst, -quota-window and -ban-duration must be positive")
+	}
+	if *canonicalHost != "" && !validHost.MatchString(*canonicalHost) {
+		return fmt.Errorf("invalid -canonical-host %q", *canonicalHost)
+	}
+	if !contains(robotsPolicies, *robotsPolicy) {
+		return errors.New("-robots must be allow or deny")
+	}
+	if !contains(canonicalHostModes, *canonicalHostMode) {
+		return errors.New("-canonical-host-mode must be redirect or reject")
+	}
+	if err := validateListenAddrs(); err != nil {
+		return err
+	}
+	if err := validateInternalAddr(); err != nil {
+		return err
+	}
+	if err := validateCharset(); err != nil {
+		return err
+	}
+	if err := validateHashParams(); err != nil {
+		return err
+	}
+	if len(httpsAddrs.addrs) > 0 && (*tlsCertPath == "" || *tlsKeyPath == "") {
+		return errors.New("-https requires -tls-cert and -tls-key")
+	}
+	if *redirectHTTP && len(httpsAddrs.addrs) == 0 {
+		return errors.New("-redirect-http requires an -https address")
+	}
+	if *maxQueueWait < 0 || *maxQueueLength < 1 {
+		return errors.New("-max-queue-wait must not be negative and -max-queue-length must be positive")
+	}
+	if *maxQueueWait > *writeTimeout && *writeTimeout > 0 {
+		return errors.New("-max-queue-wait must not be longer than -write-timeout")
+	}
+	if *journalWindow < 0 || *journalSize < 1 {
+		return errors.New("-journal must not be negative and -journal-size must be positive")
+	}
+	if *journalWindow > 0 && (authSchemes["admin"] == "" || authSchemes["admin"] == "none") {
+		return errors.New("-journal requires authentication for the admin routes, e.g. -auth admin=apikey")
+	}
+	if *adminAPI && (authSchemes["admin"] == "" || authSchemes["admin"] == "none") {
+		return errors.New("-admin-api requires authentication for the admin routes, e.g. -auth admin=apikey")
+	}
+	if *clusterServe && (authSchemes["admin"] == "" || authSchemes["admin"] == "none") {
+		return errors.New("-cluster-serve requires authentication for the admin routes, e.g. -auth admin=apikey")
+	}
+	if *webPushKeyPath != "" {
+		if *jobsPath == "" {
+			return errors.New("-web-push-key requires -jobs, whose rotations are notified")
+		}
+		if !strings.HasPrefix(*webPushSubject, "mailto:") && !strings.HasPrefix(*webPushSubject, "https://") {
+			return errors.New("-web-push-key requires -web-push-subject, a mailto: or https: URL")
+		}
+		if authSchemes["admin"] == "" || authSchemes["admin"] == "none" {
+			return errors.New("-web-push-key requires authentication for the admin routes, e.g. -auth admin=mtls")
+		}
+	}
+	if *adminAPI && *configPath == "" {
+		return errors.New("-admin-api requires -config to save changes to")
+	}
+	if _, _, err := parseTrustedProxies(); err != nil {
+		return err
+	}
+	if *challengeKind != "" {
+		if !contains(challengeKinds, *challengeKind) {
+			return fmt.Errorf("-challenge must be one of %s", strings.Join(challengeKinds, ", "))
+		}
+		if *challengeAfter < 1 || *challengeTTL <= 0 {
+			return errors.New("-challenge-after and -challenge-ttl must be positive")
+		}
+		if *challengeKind == "pow" && (*challengeDifficulty < 1 || *challengeDifficulty > 32) {
+			return errors.New("-challenge-difficulty must be between 1 and 32")
+		}
+		if *challengeKind != "pow" && (*challengeSiteKey == "" || *challengeSecretPath == "") {
+			return fmt.Errorf("-challenge %s requires -challenge-site-key and -challenge-secret", *challengeKind)
+		}
+	}
+	if _, err := parseNetworks("allow-cidrs", *allowCIDRs); err != nil {
+		return err
+	}
+	if _, err := parseNetworks("deny-cidrs", *denyCIDRs); err != nil {
+		return err
+	}
+	for _, pattern := range disabledPatterns() {
+		if !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("-disabled-routes: %q is not a path", pattern)
+		}
+		if alwaysEnabled(pattern) {
+			return fmt.Errorf("-disabled-routes: %s can't be disabled", pattern)
+		}
+	}
+	if *maxHeaderBytes < 1024 {
+		return errors.New("-max-header-bytes must be at least 1024")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// High-frequency API clients should reuse their connections and resume TLS
+// sessions rather than pay for a full handshake every call. Session ticket
+// keys can be rotated on a schedule, and shared between instances behind a
+// load balancer through -session-ticket-keys so a ticket issued by one is
+// accepted by all of them. Connection and resumption counts are exported as
+// metrics to check that clients are actually reusing connections.
+var (
+	keepAlives            = flag.Bool("keep-alives", true, "keep connections open between requests")
+	tcpKeepAlive          = flag.Duration("tcp-keep-alive", 15*time.Second, "`period` of the TCP keep-alive probes that detect dead peers (negative to disable)")
+	sessionTicketRotation = flag.Duration("session-ticket-rotation", 0, "`period` to rotate TLS session ticket keys, or reread -session-ticket-keys, at (0 for Go's built-in keys)")
+	sessionTicketKeyFile  = flag.String("session-ticket-keys", "", "`file` of hex 32-byte TLS session ticket keys, newest first, shared between instances")
+)
+
+func init() {
+	registerFeature(feature{name: "session ticket rotation", kind: "transport", active: func() bool {
+		return *sessionTicketRotation > 0 || *sessionTicketKeyFile != ""
+	}})
+}
+
+// listenTCP listens on addr with the configured TCP keep-alive period.
+func listenTCP(network, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: *tcpKeepAlive}
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// sessionTicketKeysKept is how many generated keys are kept, so tickets stay
+// valid for that many rotations.
+const sessionTicketKeysKept = 3
+
+// setupSessionTickets sets config's session ticket keys and, with
+// -session-ticket-rotation, keeps rotating them.
+func setupSessionTickets(config *tls.Config) error {
+	if *sessionTicketKeyFile == "" && *sessionTicketRotation == 0 {
+		return nil
+	}
+	var keys [][32]byte
+	next := func() error {
+		if *sessionTicketKeyFile != "" {
+			var err error
+			if keys, err = readSessionTicketKeys(*sessionTicketKeyFile); err != nil {
+				return err
+			}
+		} else {
+			var key [32]byte
+			if _, err := readRandom(key[:]); err != nil {
+				return err
+			}
+			keys = append([][32]byte{key}, keys...)
+			if len(keys) > sessionTicketKeysKept {
+				keys = keys[:sessionTicketKeysKept]
+			}
+		}
+		config.SetSessionTicketKeys(keys)
+		return nil
+	}
+	if err := next(); err != nil {
+		return err
+	}
+	if *sessionTicketRotation > 0 {
+		go func() {
+			for range time.Tick(*sessionTicketRotation) {
+				if err := next(); err != nil {
+					log.Printf("Failed to rotate session ticket keys, keeping the current ones: %s", err)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// readSessionTicketKeys reads hex keys, one per line, ignoring blank lines
+// and # comments.
+func readSessionTicketKeys(path string) ([][32]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys [][32]byte
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		b, err := hex.DecodeString(s)
+		if err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("%s:%d: want a key of 64 hex digits", path, line)
+		}
+		var key [32]byte
+		copy(key[:], b)
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return keys, nil
+}
+
+// connStats counts connections and how well they are reused. Requests are
+// counted per connection through its context rather than by ConnState, which
+// only reports the first request of an HTTP/2 connection.
+type connStats struct {
+	mu                                            sync.Mutex
+	accepted, closed, reused, handshakes, resumed uint64
+}
+
+var connections = &connStats{}
+
+type connKey struct{}
+
+// connRequests counts the requests served on a connection.
+type connRequests struct {
+	n int32
+}
+
+// track is an http.Server ConnState hook.
+func (c *connStats) track(conn net.Conn, state http.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		c.accepted++
+	case http.StateHijacked, http.StateClosed:
+		c.closed++
+	}
+}
+
+// connContext is an http.Server ConnContext hook.
+func (c *connStats) connContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, &connRequests{})
+}
+
+// request counts req against its connection.
+func (c *connStats) request(req *http.Request) {
+	r, ok := req.Context().Value(connKey{}).(*connRequests)
+	if !ok {
+		return
+	}
+	n := atomic.AddInt32(&r.n, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n == 2 {
+		c.reused++
+	}
+	if n == 1 && req.TLS != nil {
+		c.handshakes++
+		if req.TLS.DidResume {
+			c.resumed++
+		}
+	}
+}
+
+type connSnapshot struct {
+	open, accepted, reused, handshakes, resumed uint64
+}
+
+func (c *connStats) snapshot() connSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return connSnapshot{c.accepted - c.closed, c.accepted, c.reused, c.handshakes, c.resumed}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Legacy systems often forbid passwords that start with a digit or end
+// with a symbol. Passwords are constrained by rejection sampling: ones
+// whose first or last character isn't allowed are drawn again, so the
+// passwords that are issued are uniformly distributed among those allowed,
+// and their entropy is lowered only by the share rejected.
+
+// maxConstraintRetries limits how many passwords are drawn for one that
+// satisfies its constraints, which patterns can make impossible.
+const maxConstraintRetries = 1000
+
+// positionClasses are the classes the first and last characters can be
+// constrained to.
+var positionClasses = map[string]func(rune) bool{
+	"alpha": unicode.IsLetter,
+	"alnum": func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	"lower": unicode.IsLower,
+	"upper": unicode.IsUpper,
+}
+
+var positionClassNames = []string{"alpha", "alnum", "lower", "upper"}
+
+// readConstraints sets opts' constraints from req's start, end and
+// no-leading-digit parameters.
+func readConstraints(req *http.Request, opts *genOptions) error {
+	opts.Start = req.FormValue("start")
+	opts.End = req.FormValue("end")
+	for _, class := range []string{opts.Start, opts.End} {
+		if class != "" && positionClasses[class] == nil {
+			return fmt.Errorf("start and end must be one of %s", strings.Join(positionClassNames, ", "))
+		}
+	}
+	switch req.FormValue("no-leading-digit") {
+	case "":
+	case "1":
+		opts.NoLeadingDigit = true
+	default:
+		return fmt.Errorf("no-leading-digit must be 1")
+	}
+	return nil
+}
+
+// constrained reports whether opts constrain the first or last character.
+func (opts genOptions) constrained() bool {
+	return opts.Start != "" || opts.End != "" || opts.NoLeadingDigit
+}
+
+// startAllowed and endAllowed report whether r may be the first or last
+// character of opts' passwords.
+func (opts genOptions) startAllowed(r rune) bool {
+	if opts.NoLeadingDigit && unicode.IsDigit(r) {
+		return false
+	}
+	return opts.Start == "" || positionClasses[opts.Start](r)
+}
+
+func (opts genOptions) endAllowed(r rune) bool {
+	return opts.End == "" || positionClasses[opts.End](r)
+}
+
+// satisfiesConstraints reports whether password's first and last
+// characters are allowed by opts.
+func (opts genOptions) satisfiesConstraints(password string) bool {
+	first, _ := utf8.DecodeRuneInString(password)
+	last, _ := utf8.DecodeLastRuneInString(password)
+	return password != "" && opts.startAllowed(first) && opts.endAllowed(last)
+}
+
+// composeConstrained returns a password composed according to opts that
+// satisfies its constraints.
+func composeConstrained(ctx context.Context, opts genOptions) (string, error) {
+	if !opts.constrained() {
+		return compose(ctx, opts)
+	}
+	for i := 0; i < maxConstraintRetries; i++ {
+		password, err := compose(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		if opts.satisfiesConstraints(password) {
+			return password, nil
+		}
+	}
+	return "", fmt.Errorf("no password satisfying start, end and no-leading-digit after %d tries; the pattern or mode may not allow them", maxConstraintRetries)
+}
+
+// constraintLoss returns the bits of entropy opts' constraints remove, the
+// log of the share of passwords that are rejected, or +Inf if all are.
+func constraintLoss(opts genOptions) float64 {
+	first, last := positionChoices(opts)
+	share := func(choices []string, allowed func(string) bool) float64 {
+		n := 0
+		for _, c := range choices {
+			if allowed(c) {
+				n++
+			}
+		}
+		return float64(n) / float64(len(choices))
+	}
+	startOK := func(c string) bool {
+		r, _ := utf8.DecodeRuneInString(c)
+		return opts.startAllowed(r)
+	}
+	endOK := func(c string) bool {
+		r, _ := utf8.DecodeLastRuneInString(c)
+		return opts.endAllowed(r)
+	}
+	if last == nil {
+		// A single character or word is both the first and the last.
+		return -math.Log2(share(first, func(c string) bool { return startOK(c) && endOK(c) }))
+	}
+	return -math.Log2(share(first, startOK)) - math.Log2(share(last, endOK))
+}
+
+// positionChoices returns the equally likely characters or words that
+// begin and end opts' passwords. last is nil if they are the same one.
+func positionChoices(opts genOptions) (first, last []string) {
+	chars := func(s string) []string {
+		return strings.Split(s, "")
+	}
+	if pattern := opts.pattern(); pattern != "" {
+		elems, err := parsePattern(pattern)
+		if err != nil {
+			return nil, nil
+		}
+		choices := func(e patternElem) []string {
+			switch e.kind {
+			case 'l':
+				return chars(alphabetLower)
+			case 'u':
+				return chars(alphabetUpper)
+			case 'd':
+				return chars(patternDigits)
+			case '!':
+				return chars(patternSymbols)
+			case 'W':
+				words := make([]string, 0, len(opts.wordlist()))
+				for _, w := range opts.wordlist() {
+					words = append(words, capitalize(w))
+				}
+				return words
+			case 'w':
+				return opts.wordlist()
+			}
+			return []string{string(e.literal)}
+		}
+		first = choices(elems[0])
+		if len(elems) > 1 {
+			last = choices(elems[len(elems)-1])
+		}
+		return first, last
+	}
+	if opts.Mode == "mobile" {
+		letters, digits, symbols := mobileLayout(opts.Length)
+		first, last = chars(alphabetUpper), chars(alphabetLower)
+		switch {
+		case symbols > 0:
+			last = chars(mobileSymbols)
+		case digits > 0:
+			last = chars(alphabetDigits)
+		case letters == 1:
+			last = nil
+		}
+		return first, last
+	}
+	all := chars(opts.characters())
+	if opts.Length == 1 {
+		return all, nil
+	}
+	return all, all
+}
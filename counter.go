@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operators who don't want to advertise how much the service is used can
+// round or approximate the counter shown to the public or hide it
+// altogether. Metrics, stats and clients that authenticated to see the page
+// still get exact values.
+var (
+	publicCounter         = flag.String("public-counter", "exact", "how the counter is shown to unauthenticated clients: exact, rounded, approximate or hidden")
+	publicCounterStep     = flag.Uint64("public-counter-step", 1000, "what an approximate public counter is rounded to the nearest multiple of")
+	publicCounterInterval = flag.Duration("public-counter-interval", time.Minute, "how often an approximate public counter is updated")
+)
+
+var publicCounterModes = []string{"exact", "rounded", "approximate", "hidden"}
+
+// passwordCounter counts the passwords generated, in total and by the
+// endpoint that generated them since the server started.
+type passwordCounter struct {
+	mu        sync.Mutex
+	n         uint64
+	started   time.Time
+	endpoints map[string]uint64
+	// approx is the approximate public count, last updated at updated.
+	approx  uint64
+	updated time.Time
+	// global is the count aggregated across replicas with -counter-redis
+	// when it was last synced, which included the first pushed of n.
+	global uint64
+	pushed uint64
+	synced time.Time
+}
+
+// counter counts the passwords generated since the counter file was
+// created.
+var counter = &passwordCounter{started: time.Now(), endpoints: make(map[string]uint64)}
+
+// inc counts a password and returns the new count.
+func (c *passwordCounter) inc() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n++
+	return c.n
+}
+
+// value returns the exact count of passwords generated here.
+func (c *passwordCounter) value() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+func (c *passwordCounter) set(n uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n = n
+	c.pushed = n
+	c.updated = time.Time{}
+}
+
+// aggregated returns the exact count across replicas, as of the last sync.
+func (c *passwordCounter) aggregated() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total()
+}
+
+// record counts n passwords generated by endpoint.
+func (c *passwordCounter) record(endpoint string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.endpoints[endpoint] += uint64(n)
+}
+
+type endpointCount struct {
+	Endpoint string `json:"endpoint" xml:"name,attr"`
+	Count    uint64 `json:"count" xml:",chardata"`
+}
+
+// breakdown returns the passwords generated by each endpoint since the
+// server started, sorted by endpoint, and how long ago that was.
+func (c *passwordCounter) breakdown() ([]endpointCount, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make([]endpointCount, 0, len(c.endpoints))
+	for endpoint, n := range c.endpoints {
+		counts = append(counts, endpointCount{endpoint, n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Endpoint < counts[j].Endpoint
+	})
+	return counts, time.Since(c.started)
+}
+
+// public returns the count, aggregated across replicas if they are, as
+// shown to unauthenticated clients at now, whether it is rounded or
+// approximate and whether it is shown at all.
+func (c *passwordCounter) public(now time.Time) (n uint64, approximate, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch *publicCounter {
+	case "rounded":
+		return roundCounter(c.total()), true, true
+	case "approximate":
+		// Updating it only occasionally stops the public from working
+		// out the exact count by watching it change.
+		if now.Sub(c.updated) >= *publicCounterInterval {
+			c.approx = (c.total() + *publicCounterStep/2) / *publicCounterStep * *publicCounterStep
+			c.updated = now
+		}
+		return c.approx, true, true
+	case "hidden":
+		return 0, false, false
+	}
+	return c.total(), false, true
+}
+
+// forRequest returns the count as the client that made req may see it.
+func (c *passwordCounter) forRequest(req *http.Request) (n uint64, approximate, ok bool) {
+	if requestIdentity(req) != "" {
+		return c.aggregated(), false, true
+	}
+	return c.public(time.Now())
+}
+
+// roundCounter rounds n down to two significant figures.
+func roundCounter(n uint64) uint64 {
+	var scale uint64 = 1
+	for n/scale >= 100 {
+		scale *= 10
+	}
+	return n / scale * scale
+}
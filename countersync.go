@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// With -counter-redis, replicas behind a load balancer share one counter:
+// each periodically adds the passwords it has generated since its last
+// push to a Redis key with INCRBY, and the page and /counter show the total
+// that returns plus what the replica has generated since. If Redis is down
+// the replica keeps counting locally and pushes the backlog once it is back.
+var (
+	counterRedis        = flag.String("counter-redis", "", "redis:// or rediss:// `url` to aggregate the counters of replicas in")
+	counterRedisKey     = flag.String("counter-redis-key", "random-password-please:counter", "Redis key of the aggregated counter")
+	counterSyncInterval = flag.Duration("counter-sync-interval", 10*time.Second, "how often the counter is pushed to -counter-redis")
+)
+
+func init() {
+	registerFeature(feature{name: "counter aggregation", kind: "subsystem", active: func() bool {
+		return *counterRedis != ""
+	}})
+}
+
+// setupCounterSync starts pushing the counter to -counter-redis, if it is
+// set. The counter must have been loaded first, since only passwords
+// generated after are pushed.
+func setupCounterSync() error {
+	if *counterRedis == "" {
+		return nil
+	}
+	if *counterSyncInterval <= 0 {
+		return errors.New("-counter-sync-interval must be positive")
+	}
+	c, err := newRedisClient(*counterRedis)
+	if err != nil {
+		return fmt.Errorf("-counter-redis: %s", err)
+	}
+	go syncCounter(c, *counterSyncInterval)
+	return nil
+}
+
+// syncCounter pushes the counter to Redis every period, logging when it
+// starts and stops failing.
+func syncCounter(c *redisClient, period time.Duration) {
+	failing := false
+	for {
+		err := counter.push(c, *counterRedisKey)
+		switch {
+		case err != nil && !failing:
+			log.Printf("Failed to push the counter to Redis, counting locally until it is back: %s", err)
+		case err == nil && failing:
+			log.Print("Pushing the counter to Redis again")
+		}
+		failing = err != nil
+		time.Sleep(period)
+	}
+}
+
+// push adds the passwords counted since the last push to the aggregated
+// count under key and remembers the total.
+func (c *passwordCounter) push(rc *redisClient, key string) error {
+	c.mu.Lock()
+	n, delta := c.n, c.n-c.pushed
+	c.mu.Unlock()
+
+	reply, err := rc.do("INCRBY", key, strconv.FormatUint(delta, 10))
+	if err != nil {
+		return err
+	}
+	total, ok := reply.(int64)
+	if !ok || total < 0 {
+		return fmt.Errorf("unexpected INCRBY reply %v", reply)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.n >= n {
+		c.pushed = n
+	} else {
+		// The counter was reset while pushing.
+		c.pushed = 0
+	}
+	c.global = uint64(total)
+	c.synced = time.Now()
+	return nil
+}
+
+// total returns the aggregated count, if it has been synced, plus the
+// passwords counted here since, or else the local count. c.mu must be held.
+func (c *passwordCounter) total() uint64 {
+	if c.synced.IsZero() {
+		return c.n
+	}
+	return c.global + c.n - c.pushed
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// Many systems reject passwords without a character of each class, which
+// for short passwords happens often: over a quarter of 8 character ones
+// from the default alphabet have no digit. With require-each=1, passwords
+// in the default mode get one character drawn uniformly from each class
+// their charset has, upper, lower, digit and symbol, and the rest from the
+// whole charset, in an order shuffled by Fisher-Yates so that every class
+// is as likely to be in every position.
+
+// readRequireEach sets opts.RequireEach from req's require-each parameter.
+func readRequireEach(req *http.Request, opts *genOptions) error {
+	switch req.FormValue("require-each") {
+	case "":
+	case "1":
+		opts.RequireEach = true
+	default:
+		return errors.New("require-each must be 1")
+	}
+	return nil
+}
+
+// characterClasses splits chars into its uppercase, lowercase, digit and
+// symbol characters, leaving out the classes it has none of.
+func characterClasses(chars string) []string {
+	var upper, lower, digits, symbols strings.Builder
+	for _, r := range chars {
+		switch {
+		case unicode.IsUpper(r):
+			upper.WriteRune(r)
+		case unicode.IsLower(r):
+			lower.WriteRune(r)
+		case unicode.IsDigit(r):
+			digits.WriteRune(r)
+		default:
+			symbols.WriteRune(r)
+		}
+	}
+	var classes []string
+	for _, class := range []string{upper.String(), lower.String(), digits.String(), symbols.String()} {
+		if class != "" {
+			classes = append(classes, class)
+		}
+	}
+	return classes
+}
+
+// checkRequireEach returns an error if opts can't require each class.
+func checkRequireEach(opts genOptions) error {
+	if !opts.RequireEach {
+		return nil
+	}
+	if opts.Mode != "" || opts.pattern() != "" {
+		return errors.New("require-each only applies to the default mode, without a pattern; patterns and mode=mobile already fix the classes")
+	}
+	if n := len(characterClasses(opts.characters())); opts.Length < n {
+		return fmt.Errorf("require-each needs passwords of at least %d characters for the charset's %d classes", n, n)
+	}
+	return nil
+}
+
+// coveringPassword returns a password of length n drawn from chars with at
+// least one character of each of its classes. n must be at least the number
+// of classes.
+func coveringPassword(chars string, n int) string {
+	password := make([]byte, 0, n)
+	for _, class := range characterClasses(chars) {
+		password = append(password, class[randIntn(len(class))])
+	}
+	for len(password) < n {
+		password = append(password, chars[randIntn(len(chars))])
+	}
+	for i := len(password) - 1; i > 0; i-- {
+		j := randIntn(i + 1)
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password)
+}
+
+// coversClasses reports whether password has a character of each of chars'
+// classes.
+func coversClasses(password, chars string) bool {
+	for _, class := range characterClasses(chars) {
+		if !strings.ContainsAny(password, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// coveringEntropy returns a lower bound on the min-entropy of passwords of
+// length n from coveringPassword: that of the characters drawn from each
+// class plus those drawn from the whole charset, since the shuffle can only
+// make a password less likely.
+func coveringEntropy(chars string, n int) float64 {
+	classes := characterClasses(chars)
+	var bits float64
+	for _, class := range classes {
+		bits += math.Log2(float64(len(class)))
+	}
+	return bits + float64(n-len(classes))*math.Log2(float64(len(chars)))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule returns the next time a job should run after t.
+type schedule interface {
+	next(t time.Time) time.Time
+}
+
+// parseSchedule accepts "@every <duration>", one of the @hourly, @daily,
+// @weekly, @monthly or @yearly shorthands, or a standard five field cron
+// expression (minute hour day-of-month month day-of-week).
+func parseSchedule(s string) (schedule, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(s[len("@every "):]))
+		if err != nil {
+			return nil, err
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("interval must be at least a minute")
+		}
+		return everySchedule(d), nil
+	}
+	switch s {
+	case "@hourly":
+		s = "0 * * * *"
+	case "@daily":
+		s = "0 0 * * *"
+	case "@weekly":
+		s = "0 0 * * 0"
+	case "@monthly":
+		s = "0 0 1 * *"
+	case "@yearly":
+		s = "0 0 1 1 *"
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", s)
+	}
+	var c cronSchedule
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	sets := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		set, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron field %q: %s", f, err)
+		}
+		*sets[i] = set
+	}
+	c.domStar = fields[2] == "*"
+	c.dowStar = fields[4] == "*"
+	return c, nil
+}
+
+type everySchedule time.Duration
+
+func (e everySchedule) next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// cronSchedule holds the allowed values of each field as bit sets.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+func parseCronField(f string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step")
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			if i := strings.Index(part, "-"); i >= 0 {
+				if lo, err = strconv.Atoi(part[:i]); err == nil {
+					hi, err = strconv.Atoi(part[i+1:])
+				}
+			} else if lo, err = strconv.Atoi(part); err == nil {
+				hi = lo
+			}
+			if err != nil {
+				return 0, fmt.Errorf("invalid value")
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("values must be between %d and %d", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (c cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every valid expression matches at least once in four years.
+	for end := t.AddDate(4, 0, 0); t.Before(end); t = t.Add(time.Minute) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour - time.Minute)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) != 0 {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron's rule that if both day fields are restricted, a
+// day matching either is allowed.
+func (c cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// The debug routes expose Go's runtime diagnostics: pprof profiles, expvar
+// variables such as the memory and GC statistics, and a dump of every
+// goroutine's stack. They describe the server's internals, so they are only
+// served on -internal-addr, or on the public addresses if the monitoring
+// group requires authentication.
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("passwords_buffered", expvar.Func(func() interface{} {
+		return len(passwords)
+	}))
+}
+
+// debugRoutes returns the debug routes.
+func debugRoutes() []route {
+	rs := []route{
+		{pattern: "/debug/pprof/", handler: pprof.Index},
+		{pattern: "/debug/pprof/cmdline", handler: pprof.Cmdline},
+		{pattern: "/debug/pprof/profile", handler: pprof.Profile},
+		{pattern: "/debug/pprof/symbol", handler: pprof.Symbol},
+		{pattern: "/debug/pprof/trace", handler: pprof.Trace},
+		{pattern: "/debug/vars", handler: expvar.Handler().ServeHTTP},
+		{pattern: "/debug/goroutines", handler: goroutinesHandler},
+	}
+	for i := range rs {
+		rs[i].group = "monitoring"
+		rs[i].handler = debugOnly(rs[i].handler)
+		rs[i].internal = true
+		rs[i].undocumented = true
+	}
+	return rs
+}
+
+// debugOnly responds with 404 Not Found instead of calling h unless the
+// debug routes may be served.
+func debugOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if *internalAddr == "" && authenticators["monitoring"] == nil {
+			http.NotFound(w, req)
+			return
+		}
+		h(w, req)
+	}
+}
+
+// goroutinesHandler dumps the stacks of all goroutines, as a panic would.
+func goroutinesHandler(w http.ResponseWriter, req *http.Request) {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf)
+}
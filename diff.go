@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each reload and change made with the admin API logs what it changed, as a
+// diff of the settings, so operators can confirm it took effect. The last
+// diff is served at /admin/config/diff. Values that would weaken the
+// server if they leaked are redacted, as are passwords in URLs.
+
+// redactedSettings are the settings whose values are never shown.
+var redactedSettings = map[string]bool{
+	"deterministic-seed": true,
+}
+
+type settingChange struct {
+	Name string   `json:"name" xml:"name,attr"`
+	Old  []string `json:"old" xml:"old"`
+	New  []string `json:"new" xml:"new"`
+	// Pending changes only take effect on restart.
+	Pending bool `json:"pending,omitempty" xml:"pending,attr,omitempty"`
+}
+
+type configDiff struct {
+	XMLName xml.Name  `json:"-" xml:"diff"`
+	Time    time.Time `json:"time" xml:"time,attr"`
+	// Source is what made the changes: "reload", "admin API" or "cluster
+	// primary".
+	Source string `json:"source" xml:"source,attr"`
+	// Identity is the authenticated client that made admin API changes.
+	Identity string          `json:"identity,omitempty" xml:"identity,attr,omitempty"`
+	Changes  []settingChange `json:"changes" xml:"change"`
+}
+
+func (d configDiff) text() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s by %s", d.Time.Format(time.RFC3339), d.Source)
+	if d.Identity != "" {
+		fmt.Fprintf(&sb, " (%s)", d.Identity)
+	}
+	if len(d.Changes) == 0 {
+		sb.WriteString(": no changes")
+	}
+	for _, c := range d.Changes {
+		fmt.Fprintf(&sb, "\n%s", c)
+	}
+	return sb.String()
+}
+
+func (d configDiff) csvRecords() [][]string {
+	records := [][]string{{"name", "old", "new", "pending"}}
+	for _, c := range d.Changes {
+		records = append(records, []string{c.Name, strings.Join(c.Old, ","), strings.Join(c.New, ","), strconv.FormatBool(c.Pending)})
+	}
+	return records
+}
+
+func (c settingChange) String() string {
+	s := fmt.Sprintf("%s: %s -> %s", c.Name, formatValues(c.Old), formatValues(c.New))
+	if c.Pending {
+		s += " (restart to apply)"
+	}
+	return s
+}
+
+func formatValues(values []string) string {
+	if len(values) == 1 {
+		return strconv.Quote(values[0])
+	}
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// lastConfigDiff is the diff of the last reload or admin API change, or nil
+// if there hasn't been one. configLock guards it.
+var lastConfigDiff *configDiff
+
+// settingValues returns the current values of every flag.
+func settingValues() map[string][]string {
+	values := make(map[string][]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = flagValues(f)
+	})
+	return values
+}
+
+// diffSettings returns the settings that differ between before and after,
+// sorted by name, with their values redacted.
+func diffSettings(before, after map[string][]string) []settingChange {
+	var changes []settingChange
+	for name, values := range after {
+		// Compare joined so that an empty default matches no values.
+		if strings.Join(values, "\n") != strings.Join(before[name], "\n") {
+			changes = append(changes, settingChange{Name: name, Old: redact(name, before[name]), New: redact(name, values)})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// redact returns values with secrets replaced.
+func redact(name string, values []string) []string {
+	redacted := make([]string, len(values))
+	for i, v := range values {
+		if redactedSettings[name] {
+			v = "REDACTED"
+		} else if u, err := url.Parse(v); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), "REDACTED")
+				v = u.String()
+			}
+		}
+		redacted[i] = v
+	}
+	return redacted
+}
+
+// recordConfigDiff logs d and keeps it as the last diff. configLock must be
+// held for writing.
+func recordConfigDiff(d *configDiff) {
+	by := capitalize(d.Source)
+	if d.Identity != "" {
+		by += " by " + d.Identity
+	}
+	if len(d.Changes) == 0 {
+		log.Printf("%s: no settings changed", by)
+	}
+	for _, c := range d.Changes {
+		log.Printf("%s: %s", by, c)
+	}
+	lastConfigDiff = d
+	adminAudit.recordDiff(d)
+	notifyPolicyChange()
+}
+
+// configDiffHandler serves the last diff. Settings can reveal how the
+// server is protected, so it is only served when the admin routes require
+// authentication.
+func configDiffHandler(w http.ResponseWriter, req *http.Request) {
+	if authenticators["admin"] == nil {
+		http.NotFound(w, req)
+		return
+	}
+	if lastConfigDiff == nil {
+		http.Error(w, "the settings haven't changed since the server started", http.StatusNotFound)
+		return
+	}
+	render(w, req, *lastConfigDiff)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// Clients that don't fully trust the server's random number generator can
+// POST their own entropy, for example from a hardware RNG. It is mixed with
+// fresh server entropy using HKDF (RFC 5869), with the server's random bytes
+// as the input keying material and the client's as the salt, so the result
+// is never weaker than the server's entropy alone and the client can't
+// choose or predict the password. This is defence in depth, not a
+// replacement for server entropy.
+
+const (
+	serverEntropyBytes = 32
+	maxClientEntropy   = 4096
+)
+
+var errEntropyTooLarge = errors.New("client entropy must be at most 4096 bytes")
+
+func init() {
+	registerFeature(feature{name: "client entropy", kind: "subsystem", active: alwaysActive})
+}
+
+// clientEntropy returns the entropy POSTed by the client, or nil if there is
+// none. It is taken from the raw body for application/octet-stream requests
+// and from the "entropy" form field otherwise.
+func clientEntropy(req *http.Request) ([]byte, error) {
+	if req.Method != http.MethodPost {
+		return nil, nil
+	}
+	ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if ct == "application/octet-stream" {
+		b, err := ioutil.ReadAll(io.LimitReader(req.Body, maxClientEntropy+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > maxClientEntropy {
+			return nil, errEntropyTooLarge
+		}
+		if len(b) == 0 {
+			return nil, nil
+		}
+		if fipsMode() {
+			return nil, errEntropyFIPS
+		}
+		return b, nil
+	}
+
+	s := req.PostFormValue("entropy")
+	if len(s) > maxClientEntropy {
+		return nil, errEntropyTooLarge
+	}
+	if s == "" {
+		return nil, nil
+	}
+	if fipsMode() {
+		return nil, errEntropyFIPS
+	}
+	return []byte(s), nil
+}
+
+// mixedPassword returns a password of length n generated from server
+// entropy mixed with the client's, and counts it if counted is set.
+func mixedPassword(n int, clientEntropy []byte, counted bool) (string, error) {
+	return issuePassword(func() (string, error) {
+		secret := make([]byte, serverEntropyBytes)
+		if _, err := readRandom(secret); err != nil {
+			return "", err
+		}
+		stream := newHKDF(sha256.New, secret, clientEntropy, []byte("random-password-please password"))
+		return passwordFromStream(stream, n)
+	}, counted)
+}
+
+// hkdf implements HKDF-Expand as an io.Reader over the key derived by
+// HKDF-Extract.
+type hkdf struct {
+	expander hash.Hash
+	info     []byte
+	counter  byte
+	prev     []byte
+	buf      []byte
+}
+
+func newHKDF(h func() hash.Hash, secret, salt, info []byte) io.Reader {
+	if salt == nil {
+		salt = make([]byte, h().Size())
+	}
+	extractor := hmac.New(h, salt)
+	extractor.Write(secret)
+	prk := extractor.Sum(nil)
+	return &hkdf{expander: hmac.New(h, prk), info: info}
+}
+
+func (f *hkdf) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(f.buf) == 0 {
+			if f.counter == 255 {
+				return n, errors.New("hkdf: entropy limit reached")
+			}
+			f.counter++
+			f.expander.Reset()
+			f.expander.Write(f.prev)
+			f.expander.Write(f.info)
+			f.expander.Write([]byte{f.counter})
+			f.prev = f.expander.Sum(f.prev[:0])
+			f.buf = f.prev
+		}
+		c := copy(p[n:], f.buf)
+		f.buf = f.buf[c:]
+		n += c
+	}
+	return n, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Optional subsystems, backends and integrations register themselves in the
+// feature registry where they are defined. /admin/capabilities reports
+// which are compiled in and which the configuration enables, along with
+// the build's versions, so a deployed instance can be reviewed without
+// access to how it was built.
+
+type feature struct {
+	name string
+	// kind groups related features, such as "authentication".
+	kind string
+	// version is the version of the protocol, format or API the feature
+	// implements, if it has one.
+	version string
+	// active reports whether the configuration enables the feature.
+	active func() bool
+}
+
+var features []feature
+
+func registerFeature(f feature) {
+	features = append(features, f)
+}
+
+type featureInfo struct {
+	Name    string `json:"name" xml:"name,attr"`
+	Kind    string `json:"kind" xml:"kind,attr"`
+	Version string `json:"version,omitempty" xml:"version,attr,omitempty"`
+	Active  bool   `json:"active" xml:"active,attr"`
+}
+
+type dependency struct {
+	Path    string `json:"path" xml:"path,attr"`
+	Version string `json:"version" xml:"version,attr"`
+}
+
+type buildDetails struct {
+	Module       string       `json:"module,omitempty" xml:"module,omitempty"`
+	Version      string       `json:"version,omitempty" xml:"version,omitempty"`
+	Commit       string       `json:"commit,omitempty" xml:"commit,omitempty"`
+	GoVersion    string       `json:"go_version" xml:"go_version"`
+	Dev          bool         `json:"dev" xml:"dev"`
+	Dependencies []dependency `json:"dependencies,omitempty" xml:"dependency"`
+}
+
+type capabilitiesResult struct {
+	XMLName  xml.Name      `json:"-" xml:"capabilities"`
+	Build    buildDetails  `json:"build" xml:"build"`
+	Features []featureInfo `json:"features" xml:"feature"`
+}
+
+func (r capabilitiesResult) text() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "module %s %s\n", r.Build.Module, r.Build.Version)
+	if r.Build.Commit != "" {
+		fmt.Fprintf(&sb, "commit %s\n", r.Build.Commit)
+	}
+	fmt.Fprintf(&sb, "go %s\n", r.Build.GoVersion)
+	if r.Build.Dev {
+		sb.WriteString("development build\n")
+	}
+	for _, d := range r.Build.Dependencies {
+		fmt.Fprintf(&sb, "dependency %s %s\n", d.Path, d.Version)
+	}
+	for _, f := range r.Features {
+		state := "inactive"
+		if f.Active {
+			state = "active"
+		}
+		fmt.Fprintf(&sb, "%s\t%s\t%s\t%s\n", f.Kind, f.Name, f.Version, state)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// csvRecords returns the features; the build details are only in the
+// other formats.
+func (r capabilitiesResult) csvRecords() [][]string {
+	records := [][]string{{"kind", "name", "version", "active"}}
+	for _, f := range r.Features {
+		records = append(records, []string{f.Kind, f.Name, f.Version, strconv.FormatBool(f.Active)})
+	}
+	return records
+}
+
+func capabilities() capabilitiesResult {
+	r := capabilitiesResult{Build: buildDetails{Version: buildVersion(), Commit: commit, GoVersion: runtime.Version(), Dev: devBuild}}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		r.Build.Module = info.Main.Path
+		for _, d := range info.Deps {
+			r.Build.Dependencies = append(r.Build.Dependencies, dependency{d.Path, d.Version})
+		}
+	}
+	for _, f := range features {
+		r.Features = append(r.Features, featureInfo{f.name, f.kind, f.version, f.active()})
+	}
+	sort.Slice(r.Features, func(i, j int) bool {
+		a, b := r.Features[i], r.Features[j]
+		return a.Kind < b.Kind || a.Kind == b.Kind && a.Name < b.Name
+	})
+	return r
+}
+
+// capabilitiesHandler reports the build and its features. It describes
+// the deployment's attack surface, so it is only served when the admin
+// routes require authentication.
+func capabilitiesHandler(w http.ResponseWriter, req *http.Request) {
+	if authenticators["admin"] == nil {
+		http.NotFound(w, req)
+		return
+	}
+	render(w, req, capabilities())
+}
+
+// alwaysActive is the active func of features that are always enabled.
+func alwaysActive() bool {
+	return true
+}
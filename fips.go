@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// In FIPS mode everything secret is drawn from the DRBG of the Go
+// Cryptographic Module, which must be a validated version in FIPS 140-3
+// mode, and the routes relying on primitives FIPS 140-3 doesn't approve,
+// argon2id, bcrypt and X25519, aren't served. Client entropy is refused too,
+// since mixing it in would make passwords depend on more than the DRBG. FIPS
+// builds, made with make fips, are in FIPS mode unless -fips=false is given.
+var fipsFlag = flag.Bool("fips", fipsBuild, "only use FIPS 140-3 approved primitives from a validated Go Cryptographic Module; needs a FIPS build")
+
+func init() {
+	registerFeature(feature{name: "FIPS mode", kind: "subsystem", active: func() bool {
+		return *fipsFlag
+	}})
+}
+
+func fipsMode() bool {
+	return *fipsFlag
+}
+
+var errEntropyFIPS = errors.New("client entropy isn't accepted in FIPS mode")
+
+// validateFIPS checks that the Go Cryptographic Module is usable in FIPS
+// mode, and that nothing else is drawn from.
+func validateFIPS() error {
+	if !*fipsFlag {
+		return nil
+	}
+	enabled, version := fipsModule()
+	switch {
+	case !fipsBuild:
+		return errors.New("-fips needs a FIPS build; build with make fips")
+	case !enabled:
+		return errors.New("-fips needs the Go Cryptographic Module in FIPS 140-3 mode; build with make fips, which sets GOFIPS140, and don't set GODEBUG=fips140=off")
+	case version == "latest":
+		return errors.New("-fips needs a validated Go Cryptographic Module; build with make fips, which sets GOFIPS140")
+	case *deterministicSeed != 0:
+		return errors.New("-deterministic-seed can't be used in FIPS mode")
+	case *randomSource != "crypto":
+		return errors.New("-random-source must be crypto in FIPS mode, the Go Cryptographic Module's DRBG")
+	}
+	return nil
+}
+
+type complianceResult struct {
+	XMLName xml.Name `json:"-" xml:"compliance"`
+	// Mode is "fips" or "standard".
+	Mode      string `json:"mode" xml:"mode"`
+	FIPSBuild bool   `json:"fips_build" xml:"fips_build"`
+	// Module is the version of the Go Cryptographic Module, and
+	// ModuleFIPS whether it is in FIPS 140-3 mode, in FIPS builds.
+	Module       string `json:"module,omitempty" xml:"module,omitempty"`
+	ModuleFIPS   bool   `json:"module_fips140" xml:"module_fips140"`
+	RandomSource string `json:"random_source" xml:"random_source"`
+	// Unavailable are the routes and features not served in FIPS mode.
+	Unavailable []string `json:"unavailable,omitempty" xml:"unavailable>feature,omitempty"`
+}
+
+func (r complianceResult) text() string {
+	s := fmt.Sprintf("mode: %s\nfips build: %t\n", r.Mode, r.FIPSBuild)
+	if r.Module != "" {
+		s += fmt.Sprintf("module: Go Cryptographic Module %s\n", r.Module)
+	}
+	s += fmt.Sprintf("module fips140: %t\nrandom source: %s", r.ModuleFIPS, r.RandomSource)
+	if len(r.Unavailable) > 0 {
+		s += "\nunavailable: " + strings.Join(r.Unavailable, ", ")
+	}
+	return s
+}
+
+func (r complianceResult) csvRecords() [][]string {
+	return [][]string{
+		{"mode", "fips_build", "module", "module_fips140", "random_source", "unavailable"},
+		{r.Mode, fmt.Sprint(r.FIPSBuild), r.Module, fmt.Sprint(r.ModuleFIPS), r.RandomSource, strings.Join(r.Unavailable, ",")},
+	}
+}
+
+// complianceHandler reports whether the server is in FIPS mode, for
+// auditors and deployment checks.
+func (s *server) complianceHandler(w http.ResponseWriter, req *http.Request) {
+	r := complianceResult{Mode: "standard", FIPSBuild: fipsBuild, RandomSource: *randomSource}
+	r.ModuleFIPS, r.Module = fipsModule()
+	if *deterministicSeed != 0 {
+		r.RandomSource = "deterministic-seed"
+	}
+	if fipsMode() {
+		r.Mode = "fips"
+		for _, rt := range s.configuredRoutes() {
+			if rt.unapproved {
+				r.Unavailable = append(r.Unavailable, rt.pattern)
+			}
+		}
+		r.Unavailable = append(r.Unavailable, "client entropy")
+	}
+	render(w, req, r)
+}
@@ -0,0 +1,13 @@
+//go:build !fips
+// +build !fips
+
+package main
+
+// fipsBuild is set in FIPS builds, made with -tags fips.
+const fipsBuild = false
+
+// fipsModule reports whether the Go Cryptographic Module is in FIPS 140-3
+// mode, and its version. Only FIPS builds check for it.
+func fipsModule() (enabled bool, version string) {
+	return false, ""
+}
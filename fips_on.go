@@ -0,0 +1,16 @@
+//go:build fips
+// +build fips
+
+package main
+
+import "crypto/fips140"
+
+// fipsBuild is set in FIPS builds, made with -tags fips.
+const fipsBuild = true
+
+// fipsModule reports whether the Go Cryptographic Module is in FIPS 140-3
+// mode, and its version, which is "latest" unless the build was made with
+// GOFIPS140 against a frozen module.
+func fipsModule() (enabled bool, version string) {
+	return fips140.Enabled(), fips140.Version()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// -random-stream serves raw random bytes at /random/stream, for clients
+// seeding simulations or generating test fixtures. They are read from the
+// same source as tokens, so -deterministic-seed makes them reproducible.
+// Each response is capped in size and paced to a maximum rate, so a few
+// clients can't drain the host's entropy or bandwidth, and the bytes sent
+// are reported separately in the metrics.
+var (
+	randomStream         = flag.Bool("random-stream", false, "serve random bytes at /random/stream")
+	randomStreamMaxBytes = flag.Int("random-stream-max-bytes", 16<<20, "maximum `bytes` per /random/stream response")
+	randomStreamRate     = flag.Int("random-stream-rate", 1<<20, "maximum `bytes` per second sent to each /random/stream client")
+)
+
+func init() {
+	registerFeature(feature{name: "random byte stream", kind: "subsystem", active: func() bool {
+		return *randomStream
+	}})
+}
+
+// randomStreamChunk is how many bytes are read and written at a time.
+const randomStreamChunk = 32 << 10
+
+// randomStreamStats counts the random bytes streamed and the streams open.
+var randomStreamStats struct {
+	bytes uint64
+	open  int64
+}
+
+func randomStreamHandler(w http.ResponseWriter, req *http.Request) {
+	limit, rate := *randomStreamMaxBytes, *randomStreamRate
+	n, err := strconv.Atoi(req.FormValue("bytes"))
+	if err != nil || n < 1 || n > limit {
+		http.Error(w, fmt.Sprintf("bytes must be between 1 and %d", limit), http.StatusBadRequest)
+		return
+	}
+	// The stream can take a while, and mustn't hold up reloads.
+	releaseConfigLock(req)
+
+	atomic.AddInt64(&randomStreamStats.open, 1)
+	defer atomic.AddInt64(&randomStreamStats.open, -1)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+	buf := make([]byte, randomStreamChunk)
+	start := time.Now()
+	for sent := 0; sent < n; {
+		chunk := buf
+		if n-sent < len(chunk) {
+			chunk = chunk[:n-sent]
+		}
+		if _, err := readRandom(chunk); err != nil {
+			// The length has been sent, so cut the response short.
+			panic(http.ErrAbortHandler)
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return
+		}
+		sent += len(chunk)
+		atomic.AddUint64(&randomStreamStats.bytes, uint64(len(chunk)))
+
+		// Wait until sending what has been sent at the rate would have
+		// taken.
+		due := start.Add(time.Duration(float64(sent) / float64(rate) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 && sent < n {
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-req.Context().Done():
+				t.Stop()
+				return
+			}
+		}
+	}
+}
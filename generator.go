@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Derived from https://docs.djangoproject.com/en/dev/topics/auth/#django.contrib.auth.models.UserManager.make_random_password
+// Characters that are easily confused, such as 0/O and 1/l, are excluded.
+const alphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// ambiguousChars are characters that are hard to tell apart in many fonts.
+const ambiguousChars = "0O1lI|"
+
+// The alphabet's characters by class.
+var alphabetLower, alphabetUpper, alphabetDigits = alphabetClasses(alphabet)
+
+// alphabetClasses splits an alphabet into its lowercase, uppercase and digit
+// characters.
+func alphabetClasses(alphabet string) (lower, upper, digits string) {
+	var l, u, d strings.Builder
+	for _, r := range alphabet {
+		switch {
+		case unicode.IsLower(r):
+			l.WriteRune(r)
+		case unicode.IsUpper(r):
+			u.WriteRune(r)
+		case unicode.IsDigit(r):
+			d.WriteRune(r)
+		}
+	}
+	return l.String(), u.String(), d.String()
+}
+
+// bufferedLength is the length of the passwords generatePasswords buffers,
+// kept equal to -max-length. It is accessed atomically since the generator
+// runs outside of any request.
+var bufferedLength int32
+
+// Passwords are generated ahead of requests into a buffer, so requests
+// don't have to wait for one, by a pool of -generators workers so that a
+// burst of requests doesn't queue behind a single one. Requests that find
+// the buffer empty wait for it anyway, which is counted as backpressure.
+var generators = flag.Int("generators", 0, "number of goroutines generating passwords ahead of requests (0 for GOMAXPROCS)")
+
+// passwordsPerGenerator is how many passwords the buffer holds for each
+// generator.
+const passwordsPerGenerator = 16
+
+// bufferWaits counts the requests that found the buffer empty and the
+// nanoseconds they waited for it, accessed atomically.
+var bufferWaits struct {
+	count, nanos uint64
+}
+
+// generatorCount returns how many generators draw from src. A seeded source
+// has only one, since how several interleaved would vary from run to run.
+func generatorCount(src randomness) int {
+	if _, seeded := src.(*seededRandomness); seeded {
+		return 1
+	}
+	if *generators > 0 {
+		return *generators
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// generatePasswords creates the password buffer and starts the generators
+// filling it with passwords drawn from src. It returns once the buffer
+// exists, so must be called before anything reads it.
+func generatePasswords(src randomness) {
+	n := generatorCount(src)
+	passwords = make(chan string, n*passwordsPerGenerator)
+	for i := 0; i < n; i++ {
+		go fillBuffer(src)
+	}
+}
+
+// fillBuffer adds passwords drawn from src to the buffer, forever.
+func fillBuffer(src randomness) {
+	for {
+		password := make([]byte, atomic.LoadInt32(&bufferedLength))
+		for i := 0; i < len(password); i++ {
+			password[i] = alphabet[src.Intn(len(alphabet))]
+		}
+		rngUsage.add(intnSource(), intnBytes*len(password))
+		passwords <- string(password)
+	}
+}
+
+// getPassword returns a password of length n from the buffer, or ctx's
+// error if it is done first.
+func getPassword(ctx context.Context, n int) (string, error) {
+	var waiting time.Time
+	defer func() {
+		if !waiting.IsZero() {
+			atomic.AddUint64(&bufferWaits.nanos, uint64(time.Since(waiting)))
+		}
+	}()
+	for {
+		var password string
+		select {
+		case password = <-passwords:
+		default:
+			if waiting.IsZero() {
+				waiting = time.Now()
+				atomic.AddUint64(&bufferWaits.count, 1)
+			}
+			select {
+			case password = <-passwords:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		if len(password) < n {
+			// Buffered before -max-length was raised.
+			continue
+		}
+		return password[:n], nil
+	}
+}
+
+func countPassword() {
+	if n := counter.inc(); counterFile != nil && n%100 == 0 {
+		go saveCounter()
+	}
+}
+
+// genOptions describes the password a client asked for.
+type genOptions struct {
+	Mode    string `json:"mode,omitempty" xml:"mode,omitempty"`
+	Length  int    `json:"length,omitempty" xml:"length,omitempty"`
+	Pattern string `json:"pattern,omitempty" xml:"pattern,omitempty"`
+	Lang    string `json:"lang,omitempty" xml:"lang,omitempty"`
+	// Charset is the preset the default mode draws from, by default
+	// -charset.
+	Charset string `json:"charset,omitempty" xml:"charset,omitempty"`
+	// RequireEach guarantees a character of each of the charset's classes.
+	RequireEach bool `json:"require_each,omitempty" xml:"require_each,omitempty"`
+
+	// Words, Separator and Capitalize are the options of passphrases.
+	Words      int    `json:"words,omitempty" xml:"words,omitempty"`
+	Separator  string `json:"separator,omitempty" xml:"separator,omitempty"`
+	Capitalize bool   `json:"capitalize,omitempty" xml:"capitalize,omitempty"`
+	// Sampler is how words are drawn: "uniform", the default, or
+	// "weighted".
+	Sampler string `json:"sampler,omitempty" xml:"sampler,omitempty"`
+
+	// Start and End are the classes the first and last characters must be
+	// in, if set, and NoLeadingDigit forbids a digit first.
+	Start          string `json:"start,omitempty" xml:"start,omitempty"`
+	End            string `json:"end,omitempty" xml:"end,omitempty"`
+	NoLeadingDigit bool   `json:"no_leading_digit,omitempty" xml:"no_leading_digit,omitempty"`
+
+	// Uncounted passwords are previews, left out of the counter.
+	Uncounted bool `json:"-" xml:"-"`
+}
+
+// wordlist returns the list the words of opts' passwords are drawn from, or
+// nil if its language is unknown.
+func (opts genOptions) wordlist() []string {
+	if opts.Lang == "" {
+		return wordlists[defaultLang]
+	}
+	return wordlists[opts.Lang]
+}
+
+// weightedList returns the weighted list of opts' language, or nil if it
+// doesn't have one.
+func (opts genOptions) weightedList() *weightedList {
+	if opts.Lang == "" {
+		return weightedWordlists[defaultLang]
+	}
+	return weightedWordlists[opts.Lang]
+}
+
+// drawWord returns a func that draws the words of opts' passwords.
+func (opts genOptions) drawWord() func() string {
+	if opts.Sampler == "weighted" {
+		return opts.weightedList().draw
+	}
+	list := opts.wordlist()
+	return func() string {
+		return list[randIntn(len(list))]
+	}
+}
+
+// charset returns the characters the passwords of opts are drawn from, in
+// order and without repeats, or an empty string if they have words, which
+// are drawn whole. Literal characters in patterns aren't drawn, so aren't
+// included.
+func (opts genOptions) charset() string {
+	var classes []string
+	if pattern := opts.pattern(); pattern != "" {
+		elems, err := parsePattern(pattern)
+		if err != nil {
+			return ""
+		}
+		for _, e := range elems {
+			switch e.kind {
+			case 'l':
+				classes = append(classes, alphabetLower)
+			case 'u':
+				classes = append(classes, alphabetUpper)
+			case 'd':
+				classes = append(classes, patternDigits)
+			case '!':
+				classes = append(classes, patternSymbols)
+			case 'W', 'w':
+				return ""
+			}
+		}
+	} else if opts.Mode == "mobile" {
+		classes = []string{alphabetUpper, alphabetLower, alphabetDigits, mobileSymbols}
+	} else {
+		classes = []string{opts.characters()}
+	}
+	seen := make(map[rune]bool)
+	var sb strings.Builder
+	for _, class := range classes {
+		for _, r := range class {
+			if !seen[r] {
+				seen[r] = true
+				sb.WriteRune(r)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// pattern returns the pattern passwords are generated from, or an empty
+// string if opts doesn't use one.
+func (opts genOptions) pattern() string {
+	if opts.Mode == "memorable" && opts.Pattern == "" {
+		return defaultMemorablePattern
+	}
+	if opts.Mode == "passphrase" {
+		pattern, _ := opts.passphrasePattern()
+		return pattern
+	}
+	return opts.Pattern
+}
+
+// generate returns a password generated according to opts and counts it.
+func generate(ctx context.Context, opts genOptions) (string, error) {
+	return issuePassword(func() (string, error) {
+		return composeConstrained(ctx, opts)
+	}, !opts.Uncounted)
+}
+
+// compose returns a password composed according to opts.
+func compose(ctx context.Context, opts genOptions) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if opts.wordlist() == nil {
+		return "", fmt.Errorf("unknown lang %q", opts.Lang)
+	}
+	pattern := opts.pattern()
+	if opts.Charset != "" && (opts.Mode != "" || pattern != "") {
+		return "", fmt.Errorf("charset only applies to the default mode, without a pattern")
+	}
+	chars := opts.characters()
+	if chars == "" {
+		return "", fmt.Errorf("unknown charset %q", opts.Charset)
+	}
+	if err := checkRequireEach(opts); err != nil {
+		return "", err
+	}
+	switch opts.Sampler {
+	case "", "uniform":
+	case "weighted":
+		if opts.Mode == "mobile" || opts.Mode == "" && pattern == "" {
+			return "", fmt.Errorf("sampler=weighted needs words, from mode=passphrase, mode=memorable or a pattern")
+		}
+	default:
+		return "", fmt.Errorf("unknown sampler %q", opts.Sampler)
+	}
+	switch opts.Mode {
+	case "":
+		if opts.RequireEach {
+			return coveringPassword(chars, opts.Length), nil
+		}
+		if pattern == "" && chars == alphabet {
+			return getPassword(ctx, opts.Length)
+		}
+		if pattern == "" {
+			return drawCharset(chars, opts.Length), nil
+		}
+	case "mobile":
+		if pattern != "" {
+			return "", fmt.Errorf("mode=mobile can't be combined with a pattern")
+		}
+		return mobilePassword(opts.Length), nil
+	case "memorable":
+	case "passphrase":
+		if opts.Pattern != "" {
+			return "", fmt.Errorf("mode=passphrase can't be combined with a pattern")
+		}
+		if _, err := opts.passphrasePattern(); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown mode %q", opts.Mode)
+	}
+
+	if opts.Sampler == "weighted" {
+		if opts.weightedList() == nil {
+			return "", fmt.Errorf("there is no weighted wordlist for lang=%s", opts.Lang)
+		}
+		if bits := policyEntropy(opts); bits < *weightedMinEntropy {
+			return "", fmt.Errorf("passwords drawn from the weighted wordlist would have %.1f bits of min-entropy, at least %g are needed; add words", bits, *weightedMinEntropy)
+		}
+	}
+	elems, err := parsePattern(pattern)
+	if err != nil {
+		return "", err
+	}
+	return composePattern(elems, opts.drawWord()), nil
+}
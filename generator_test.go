@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// coverageSamples is how many passwords TestRequireEachDistribution draws.
+const coverageSamples = 20000
+
+// TestRequireEachDistribution checks that require-each passwords, as
+// compose draws them, have every class and that drawing them doesn't skew
+// which characters are picked: each class must be as likely in every
+// position, and each character as likely as the others of its class.
+func TestRequireEachDistribution(t *testing.T) {
+	const length = 6
+	opts := genOptions{Length: length, Charset: "safe", RequireEach: true}
+	chars := opts.characters()
+	classes := characterClasses(chars)
+	// byPosition counts each class's characters in each position, and
+	// byChar each character anywhere.
+	byPosition := make([][]float64, len(classes))
+	for i := range byPosition {
+		byPosition[i] = make([]float64, length)
+	}
+	byChar := make(map[byte]float64)
+	ctx := context.Background()
+	for n := 0; n < coverageSamples; n++ {
+		password, err := compose(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !coversClasses(password, chars) {
+			t.Fatalf("%q lacks a class", password)
+		}
+		for pos := 0; pos < length; pos++ {
+			for i, class := range classes {
+				if strings.IndexByte(class, password[pos]) >= 0 {
+					byPosition[i][pos]++
+				}
+			}
+			byChar[password[pos]]++
+		}
+	}
+	for i, class := range classes {
+		if chiSquareSkewed(byPosition[i]) {
+			t.Errorf("%q is more likely in some positions, with counts %v", class, byPosition[i])
+		}
+		counts := make([]float64, len(class))
+		for j := range counts {
+			counts[j] = byChar[class[j]]
+		}
+		if chiSquareSkewed(counts) {
+			t.Errorf("the characters of %q aren't equally likely, with counts %v", class, counts)
+		}
+	}
+}
+
+// burstConcurrency is how many passwords BenchmarkBurst asks for at once,
+// as a burst of concurrent requests would.
+const burstConcurrency = 10000
+
+// benchLengths are the password lengths benchmarked.
+var benchLengths = []int{8, 16, 32, 64}
+
+// benchAlphabets are the alphabets passwords are drawn from, with the
+// options that draw a password of length n from each.
+var benchAlphabets = []struct {
+	name string
+	opts func(n int) genOptions
+}{
+	// The default alphabet's passwords come from the buffer filled by
+	// generatePasswords, so this includes the channel receive.
+	{"default", func(n int) genOptions { return genOptions{Length: n} }},
+	{"lower", func(n int) genOptions { return genOptions{Pattern: fmt.Sprintf("l{%d}", n)} }},
+	{"upper", func(n int) genOptions { return genOptions{Pattern: fmt.Sprintf("u{%d}", n)} }},
+	{"digits", func(n int) genOptions { return genOptions{Pattern: fmt.Sprintf("d{%d}", n)} }},
+	{"symbols", func(n int) genOptions { return genOptions{Pattern: fmt.Sprintf("!{%d}", n)} }},
+	{"mobile", func(n int) genOptions { return genOptions{Mode: "mobile", Length: n} }},
+}
+
+// BenchmarkGenerate benchmarks the generator for each alphabet and a range
+// of lengths, so that changes to it can be compared with benchstat.
+func BenchmarkGenerate(b *testing.B) {
+	ctx := context.Background()
+	for _, a := range benchAlphabets {
+		for _, n := range benchLengths {
+			opts := a.opts(n)
+			b.Run(fmt.Sprintf("%s/len=%d", a.name, n), func(b *testing.B) {
+				if _, err := compose(ctx, opts); err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					compose(ctx, opts)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkBurst takes passwords from the buffer from burstConcurrency
+// goroutines, to measure whether the generators keep up.
+func BenchmarkBurst(b *testing.B) {
+	ctx := context.Background()
+	opts := genOptions{Length: 16}
+	b.Run(fmt.Sprintf("generators=%d/concurrency=%d", generatorCount(systemRandomness{}), burstConcurrency), func(b *testing.B) {
+		b.ReportAllocs()
+		procs := runtime.GOMAXPROCS(0)
+		b.SetParallelism((burstConcurrency + procs - 1) / procs)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				compose(ctx, opts)
+			}
+		})
+	})
+}
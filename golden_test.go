@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestGoldenOutput checks the exact bytes of each format, single and batch,
+// with and without newline=, drawing the passwords from a seeded source.
+// Batches are checked both rendered whole and streamed.
+func TestGoldenOutput(t *testing.T) {
+	const (
+		xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+		one       = `1f7b169c`
+		two       = `846f218a`
+	)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"format=text", one},
+		{"format=text&newline=1", one + "\n"},
+		{"format=text&count=2", one + "\n" + two + "\n"},
+		{"format=text&count=2&trim=1", one + "\n" + two},
+		{"format=json", `{"password":"` + one + `"}` + "\n"},
+		{"format=json&newline=0", `{"password":"` + one + `"}`},
+		{"format=json&count=2", `{"passwords":[{"password":"` + one + `"},{"password":"` + two + `"}]}` + "\n"},
+		{"format=json&count=2&newline=0", `{"passwords":[{"password":"` + one + `"},{"password":"` + two + `"}]}`},
+		{"format=yaml", `password: "` + one + `"` + "\n"},
+		{"format=yaml&newline=0", `password: "` + one + `"`},
+		{"format=yaml&count=2", "passwords:\n" + `- password: "` + one + `"` + "\n" + `- password: "` + two + `"` + "\n"},
+		{"format=csv", "password\n" + one + "\n"},
+		{"format=csv&newline=0", "password\n" + one},
+		{"format=csv&count=2", "password\n" + one + "\n" + two + "\n"},
+		{"format=xml", xmlHeader + "<password><value>" + one + "</value></password>\n"},
+		{"format=xml&newline=0", xmlHeader + "<password><value>" + one + "</value></password>"},
+		{"format=xml&count=2", xmlHeader + "<passwords><password><value>" + one + "</value></password><password><value>" + two + "</value></password></passwords>\n"},
+	}
+	old := random
+	defer func() { random = old }()
+	get := func(s *server, query string) string {
+		random = newSeededRandomness(1)
+		rec := do(s, "", "/password.txt?charset=hex&len=8&"+query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		return rec.Body.String()
+	}
+
+	s := newServer()
+	for _, tt := range tests {
+		if got := get(s, tt.query); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.query, got, tt.want)
+		}
+	}
+
+	setFlag(t, "max-count", "1")
+	setFlag(t, "max-stream-count", "10")
+	s = newServer()
+	for _, tt := range tests {
+		if got := get(s, tt.query); got != tt.want {
+			t.Errorf("%s streamed:\ngot  %q\nwant %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"runtime"
+)
+
+// /password/hashed returns a generated password with its hash, in the
+// PHC string format for argon2id or crypt's $2b$ format for bcrypt, so
+// provisioning scripts can store the hash without hashing it themselves.
+var (
+	hashAlgorithm = flag.String("hash-algorithm", "argon2id", "default algorithm of /password/hashed: argon2id or bcrypt")
+	bcryptCost    = flag.Int("bcrypt-cost", 12, "bcrypt cost, the log2 of its iterations, of /password/hashed")
+	argon2Memory  = flag.Int("argon2-memory", 19456, "memory in KiB argon2id uses for /password/hashed")
+	argon2Time    = flag.Int("argon2-time", 2, "argon2id passes over memory for /password/hashed")
+	argon2Threads = flag.Int("argon2-threads", 1, "argon2id lanes for /password/hashed")
+)
+
+var hashAlgorithms = []string{"argon2id", "bcrypt"}
+
+// hashSlots limits the hashes computed at once, since each takes a CPU and
+// argon2id its memory too.
+var hashSlots = make(chan struct{}, runtime.NumCPU())
+
+// validateHashParams checks the hashing flags.
+func validateHashParams() error {
+	if !contains(hashAlgorithms, *hashAlgorithm) {
+		return fmt.Errorf("invalid -hash-algorithm %q, want argon2id or bcrypt", *hashAlgorithm)
+	}
+	if *bcryptCost < bcryptMinCost || *bcryptCost > bcryptMaxCost {
+		return fmt.Errorf("-bcrypt-cost must be from %d to %d", bcryptMinCost, bcryptMaxCost)
+	}
+	if *argon2Threads < 1 || *argon2Threads > 255 {
+		return errors.New("-argon2-threads must be from 1 to 255")
+	}
+	if *argon2Time < 1 {
+		return errors.New("-argon2-time must be at least 1")
+	}
+	if *argon2Memory < 8**argon2Threads || *argon2Memory > 4<<20 {
+		return fmt.Errorf("-argon2-memory must be from 8 KiB per thread, %d, to 4 GiB", 8**argon2Threads)
+	}
+	return nil
+}
+
+// hashPassword hashes password with algorithm and the flags' parameters.
+func hashPassword(algorithm, password string) (string, error) {
+	switch algorithm {
+	case "bcrypt":
+		salt := make([]byte, bcryptSaltBytes)
+		if _, err := readRandom(salt); err != nil {
+			return "", err
+		}
+		return bcryptHash([]byte(password), salt, *bcryptCost)
+	case "argon2id":
+		salt := make([]byte, argon2SaltBytes)
+		if _, err := readRandom(salt); err != nil {
+			return "", err
+		}
+		m, t, p := uint32(*argon2Memory), uint32(*argon2Time), uint8(*argon2Threads)
+		key := argon2id([]byte(password), salt, t, m, p, argon2KeyBytes)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2Version, m, t, p,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q", algorithm)
+}
+
+type hashedResult struct {
+	XMLName   xml.Name `json:"-" xml:"password"`
+	Password  string   `json:"password" xml:"value"`
+	Algorithm string   `json:"algorithm" xml:"algorithm"`
+	Hash      string   `json:"hash" xml:"hash"`
+}
+
+// text returns the password and its hash on separate lines.
+func (r hashedResult) text() string {
+	return r.Password + "\n" + r.Hash
+}
+
+func (r hashedResult) csvRecords() [][]string {
+	return [][]string{{"password", "algorithm", "hash"}, {r.Password, r.Algorithm, r.Hash}}
+}
+
+// hashedHandler generates a password with the policy parameters of
+// /password.txt and hashes it.
+func hashedHandler(w http.ResponseWriter, req *http.Request) {
+	algorithm := *hashAlgorithm
+	if s := req.FormValue("algorithm"); s != "" {
+		if !contains(hashAlgorithms, s) {
+			http.Error(w, "algorithm must be argon2id or bcrypt", http.StatusBadRequest)
+			return
+		}
+		algorithm = s
+	}
+	n, ok := readLength(w, req)
+	if !ok {
+		return
+	}
+	opts, err := readGenOptions(req, n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, span := startGenerateSpan(req.Context(), opts, 1)
+	password, err := generate(ctx, opts)
+	span.end()
+	if err != nil {
+		generationFailed(w, req, err)
+		return
+	}
+
+	select {
+	case hashSlots <- struct{}{}:
+	case <-req.Context().Done():
+		return
+	}
+	hash, err := hashPassword(algorithm, password)
+	<-hashSlots
+	if err == errBcryptPasswordTooLong {
+		http.Error(w, "the password is too long for bcrypt, which only uses the first 71 bytes: ask for a shorter one or use argon2id", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logf(req, "Hashing password: %s", err)
+		http.Error(w, "failed to hash the password", http.StatusInternalServerError)
+		return
+	}
+	stats.record("hashed", 1)
+	counter.record("hashed", 1)
+	w.Header().Set("Cache-Control", "no-store")
+	render(w, req, hashedResult{Password: password, Algorithm: algorithm, Hash: hash})
+}
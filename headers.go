@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+)
+
+// Security headers set on every response. An empty value disables a header.
+var (
+	hstsHeader = flag.String("hsts", "max-age=63072000; includeSubDomains",
+		"Strict-Transport-Security header `value`, sent over HTTPS only")
+	cspHeader = flag.String("csp",
+		"default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src * data:; "+
+			"object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'",
+		"Content-Security-Policy header `value`")
+	referrerPolicy = flag.String("referrer-policy", "no-referrer", "Referrer-Policy header `value`")
+	frameOptions   = flag.String("frame-options", "DENY", "X-Frame-Options header `value`")
+	contentTypeOpt = flag.String("content-type-options", "nosniff", "X-Content-Type-Options header `value`")
+)
+
+// securityHeaders sets the configured security headers before calling h.
+// Strict-Transport-Security is only sent over HTTPS, to the server or to a
+// trusted proxy, as RFC 6797 requires.
+func securityHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := w.Header()
+		if *hstsHeader != "" && isHTTPS(req) {
+			header.Set("Strict-Transport-Security", *hstsHeader)
+		}
+		for _, hdr := range []struct {
+			name  string
+			value *string
+		}{
+			{"Content-Security-Policy", cspHeader},
+			{"Referrer-Policy", referrerPolicy},
+			{"X-Frame-Options", frameOptions},
+			{"X-Content-Type-Options", contentTypeOpt},
+		} {
+			if *hdr.value != "" {
+				header.Set(hdr.name, *hdr.value)
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// noStore keeps the responses of h out of every cache, whatever h set:
+// they are sent with Cache-Control: no-store, Pragma: no-cache for HTTP/1.0
+// caches, and without ETag and Last-Modified, which would let a cache that
+// kept a copy anyway revalidate it instead of asking again.
+func noStore(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h.ServeHTTP(&noStoreResponseWriter{ResponseWriter: w}, req)
+	})
+}
+
+// noStoreHeaders are the headers noStore sets.
+var noStoreHeaders = map[string]string{
+	"Cache-Control": "no-store",
+	"Pragma":        "no-cache",
+}
+
+// noStoreResponseWriter overrides the caching headers as the response's
+// header is written.
+type noStoreResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *noStoreResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		header := w.Header()
+		for name, value := range noStoreHeaders {
+			header.Set(name, value)
+		}
+		header.Del("ETag")
+		header.Del("Last-Modified")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *noStoreResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush.
+func (w *noStoreResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
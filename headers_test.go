@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSecurityHeaders checks the security headers of the page, the API and
+// error responses.
+func TestSecurityHeaders(t *testing.T) {
+	s := newServer()
+	for _, target := range []string{
+		"/",
+		"/password.txt?len=12",
+		"/password.txt?format=json",
+		"/password.txt?strict=1&len=1",
+		"/no-such-page",
+		"/strength",
+	} {
+		rec := do(s, "", target, nil)
+		for name, value := range map[string]string{
+			"Content-Security-Policy": *cspHeader,
+			"Referrer-Policy":         *referrerPolicy,
+			"X-Frame-Options":         *frameOptions,
+			"X-Content-Type-Options":  *contentTypeOpt,
+		} {
+			if got := rec.Header().Get(name); got != value {
+				t.Errorf("%s (status %d): %s is %q, want %q", target, rec.Code, name, got, value)
+			}
+		}
+	}
+
+	rec := do(s, "", "/", nil)
+	if err := notCached(rec.Header()); err != nil {
+		t.Error(err)
+	}
+
+	// An empty value disables a header.
+	setFlag(t, "frame-options", "")
+	if got := do(s, "", "/", nil).Header().Get("X-Frame-Options"); got != "" {
+		t.Errorf("X-Frame-Options is %q with -frame-options empty", got)
+	}
+}
+
+// TestHSTS checks Strict-Transport-Security is only sent over HTTPS, to the
+// server or to a trusted proxy.
+func TestHSTS(t *testing.T) {
+	setFlag(t, "trusted-proxies", "10.0.0.0/8")
+	s := newServer()
+	for _, tt := range []struct {
+		name   string
+		tls    bool
+		remote string
+		proto  string
+		hsts   bool
+	}{
+		{"HTTP", false, "192.0.2.1:1234", "", false},
+		{"HTTPS", true, "192.0.2.1:1234", "", true},
+		{"HTTPS claimed by a client", false, "192.0.2.1:1234", "https", false},
+		{"HTTPS to a trusted proxy", false, "10.0.0.1:1234", "https", true},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		want := ""
+		if tt.hsts {
+			want = *hstsHeader
+		}
+		if got := rec.Header().Get("Strict-Transport-Security"); got != want {
+			t.Errorf("over %s: Strict-Transport-Security is %q, want %q", tt.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"sync"
+	"time"
+)
+
+// Some auditors require that no password is ever issued twice. With
+// -no-repeat-window set, the passwords issued within the window are
+// remembered and any repeat is regenerated. Only a keyed hash of each
+// password is kept, under a key chosen at startup, so the history can't be
+// used to recover them. At most -no-repeat-size are remembered; beyond that
+// the oldest are forgotten early.
+var (
+	noRepeatWindow = flag.Duration("no-repeat-window", 0, "never issue the same password twice within this `duration` (0 to disable)")
+	noRepeatSize   = flag.Int("no-repeat-size", 1000000, "maximum `number` of passwords remembered for -no-repeat-window")
+)
+
+func init() {
+	registerFeature(feature{name: "no-repeat history", kind: "subsystem", active: func() bool {
+		return *noRepeatWindow > 0
+	}})
+}
+
+// maxRepeatRetries limits how many times a repeated password is regenerated
+// before giving up, for policies with too few possible passwords.
+const maxRepeatRetries = 100
+
+var errNoUnusedPassword = errors.New("no password unused within -no-repeat-window could be generated; use a longer pattern")
+
+type historyEntry struct {
+	sum    [16]byte
+	issued time.Time
+}
+
+// passwordHistory remembers the passwords issued recently.
+type passwordHistory struct {
+	mu   sync.Mutex
+	key  []byte
+	seen map[[16]byte]bool
+	// queue holds the entries in the order they were issued.
+	queue []historyEntry
+	// regenerated counts the repeats that were regenerated.
+	regenerated uint64
+}
+
+var history = &passwordHistory{seen: make(map[[16]byte]bool)}
+
+// setupHistory chooses the key passwords are hashed with, for the history
+// and for batches.
+func setupHistory() error {
+	history.key = make([]byte, sha256.Size)
+	_, err := readRandom(history.key)
+	return err
+}
+
+// sum returns the keyed hash password is remembered by.
+func (h *passwordHistory) sum(password string) [16]byte {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(password))
+	var sum [16]byte
+	copy(sum[:], mac.Sum(nil))
+	return sum
+}
+
+// add records password as issued now, reporting whether it is new in the
+// window.
+func (h *passwordHistory) add(password string, now time.Time) bool {
+	if *noRepeatWindow <= 0 {
+		return true
+	}
+	sum := h.sum(password)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for len(h.queue) > 0 && (now.Sub(h.queue[0].issued) >= *noRepeatWindow || len(h.queue) >= *noRepeatSize) {
+		delete(h.seen, h.queue[0].sum)
+		h.queue = h.queue[1:]
+	}
+	if h.seen[sum] {
+		h.regenerated++
+		return false
+	}
+	h.seen[sum] = true
+	h.queue = append(h.queue, historyEntry{sum, now})
+	return true
+}
+
+// snapshot returns the number of passwords remembered and of repeats
+// regenerated.
+func (h *passwordHistory) snapshot() (size int, regenerated uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.queue), h.regenerated
+}
+
+// issuePassword returns a password from gen that wasn't issued within
+// -no-repeat-window and doesn't match the weak patterns avoided, regenerating
+// any that do, and counts it if counted is set.
+func issuePassword(gen func() (string, error), counted bool) (string, error) {
+	err := errNoUnusedPassword
+	for i := 0; i <= maxRepeatRetries; i++ {
+		password, genErr := gen()
+		if genErr != nil {
+			return "", genErr
+		}
+		if avoided(password) {
+			err = errOnlyWeakPatterns
+			continue
+		}
+		if history.add(password, time.Now()) {
+			if counted {
+				countPassword()
+			}
+			return password, nil
+		}
+		err = errNoUnusedPassword
+	}
+	return "", err
+}
+
+// Downstream systems provisioning many accounts at once assume the
+// passwords of a batch are distinct, which chance alone doesn't guarantee
+// for short PINs and small alphabets, so repeats within a batch are
+// regenerated unless -distinct-batches=false.
+var distinctBatches = flag.Bool("distinct-batches", true, "regenerate passwords that repeat another of the same request")
+
+var errBatchNotDistinct = errors.New("not enough distinct passwords for count; use a longer pattern or a smaller count")
+
+// batchPasswords are the keyed hashes of the passwords issued so far for a
+// request, like the history's, so a streamed batch takes 16 bytes a
+// password rather than the passwords themselves.
+type batchPasswords map[[16]byte]bool
+
+// issue returns a password from gen that isn't already in the batch and
+// adds it, counting it if counted is set. gen must not count the passwords
+// it generates, since repeats aren't issued.
+func (b batchPasswords) issue(gen func() (string, error), counted bool) (string, error) {
+	for i := 0; i <= maxRepeatRetries; i++ {
+		password, err := gen()
+		if err != nil {
+			return "", err
+		}
+		if *distinctBatches {
+			sum := history.sum(password)
+			if b[sum] {
+				continue
+			}
+			b[sum] = true
+		}
+		if counted {
+			countPassword()
+		}
+		return password, nil
+	}
+	return "", errBatchNotDistinct
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestBatchCountsIssuedOnly asks for all ten one-digit passwords, most of
+// which are regenerated as repeats, and checks that only the ten issued are
+// counted.
+func TestBatchCountsIssuedOnly(t *testing.T) {
+	s := newServer()
+	before := counter.value()
+	rec := do(s, "", "/password.txt?pattern=d&count=10", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	digits := strings.Fields(rec.Body.String())
+	seen := make(map[string]bool)
+	for _, d := range digits {
+		seen[d] = true
+	}
+	if len(digits) != 10 || len(seen) != 10 {
+		t.Fatalf("got %q, want the ten digits", digits)
+	}
+	if n := counter.value() - before; n != 10 {
+		t.Errorf("counted %d passwords, want 10", n)
+	}
+}
+
+func TestBatchRepeats(t *testing.T) {
+	b := make(batchPasswords)
+	gen := func() (string, error) { return "same", nil }
+	if _, err := b.issue(gen, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.issue(gen, false); err != errBatchNotDistinct {
+		t.Errorf("repeat issued, with error %v", err)
+	}
+	for sum := range b {
+		if strings.Contains(string(sum[:]), "same") {
+			t.Error("the batch keeps the password")
+		}
+	}
+
+	setFlag(t, "distinct-batches", "false")
+	b = make(batchPasswords)
+	for i := 0; i < 2; i++ {
+		if _, err := b.issue(gen, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(b) != 0 {
+		t.Errorf("%d passwords remembered with -distinct-batches=false", len(b))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+)
+
+// Public instances can be pinned to one host name with -canonical-host.
+// Requests for any other host, which could poison a shared cache or come
+// from a lookalike domain pointed at the instance, are redirected to the
+// canonical host, or rejected with 421 Misdirected Request if
+// -canonical-host-mode is reject. Health checks are exempt since load
+// balancers probe instances by address.
+var (
+	canonicalHost     = flag.String("canonical-host", "", "`host` clients must address the server as, with a port if it isn't the default")
+	canonicalHostMode = flag.String("canonical-host-mode", "redirect", "how to answer requests for other hosts: redirect or reject")
+)
+
+func init() {
+	registerFeature(feature{name: "canonical host", kind: "subsystem", active: func() bool {
+		return *canonicalHost != ""
+	}})
+}
+
+var canonicalHostModes = []string{"redirect", "reject"}
+
+// withCanonicalHost redirects or rejects requests for hosts other than
+// -canonical-host before calling h.
+func withCanonicalHost(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if *canonicalHost == "" || isCanonicalHost(req) || req.URL.Path == "/healthz" || req.URL.Path == "/readyz" {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if *canonicalHostMode == "reject" {
+			http.Error(w, "unknown host", http.StatusMisdirectedRequest)
+			return
+		}
+		scheme := "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+		code := http.StatusMovedPermanently
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			// Don't turn a POST into a GET.
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, req, scheme+"://"+*canonicalHost+req.URL.RequestURI(), code)
+	})
+}
+
+// isCanonicalHost reports whether req is for -canonical-host, ignoring case
+// and the scheme's default port.
+func isCanonicalHost(req *http.Request) bool {
+	host := strings.ToLower(req.Host)
+	if req.TLS != nil {
+		host = strings.TrimSuffix(host, ":443")
+	} else {
+		host = strings.TrimSuffix(host, ":80")
+	}
+	return host == strings.ToLower(*canonicalHost)
+}
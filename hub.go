@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Live updates, such as the counter and job status, are streamed to
+// browsers as server-sent events through a hub. Each subscriber has its own
+// send buffer and a subscriber that falls behind by a full buffer is
+// evicted, so one stalled tab can't hold up everyone else's updates. An
+// evicted EventSource reconnects by itself.
+var streamBuffer = flag.Int("stream-buffer", 16, "events buffered per stream subscriber before it is evicted as too slow")
+
+func init() {
+	registerFeature(feature{name: "event streams", kind: "subsystem", active: alwaysActive})
+}
+
+// counterStreamPeriod is how often the counter stream checks for changes.
+const counterStreamPeriod = time.Second
+
+// subscriber receives a topic's events.
+type subscriber struct {
+	events chan []byte
+	// evicted is closed if the subscriber couldn't keep up.
+	evicted chan struct{}
+}
+
+// topicStats counts a topic's events.
+type topicStats struct {
+	published, evicted uint64
+}
+
+type eventHub struct {
+	mu     sync.Mutex
+	topics map[string]map[*subscriber]bool
+	stats  map[string]*topicStats
+}
+
+var streams = &eventHub{
+	topics: make(map[string]map[*subscriber]bool),
+	stats:  make(map[string]*topicStats),
+}
+
+func (h *eventHub) topicStats(topic string) *topicStats {
+	s, ok := h.stats[topic]
+	if !ok {
+		s = &topicStats{}
+		h.stats[topic] = s
+	}
+	return s
+}
+
+func (h *eventHub) subscribe(topic string) *subscriber {
+	s := &subscriber{events: make(chan []byte, *streamBuffer), evicted: make(chan struct{})}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*subscriber]bool)
+	}
+	h.topics[topic][s] = true
+	h.topicStats(topic)
+	return s
+}
+
+func (h *eventHub) unsubscribe(topic string, s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.topics[topic], s)
+}
+
+// publish sends data to the topic's subscribers without blocking, evicting
+// any whose buffer is full.
+func (h *eventHub) publish(topic string, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := h.topicStats(topic)
+	stats.published++
+	for s := range h.topics[topic] {
+		select {
+		case s.events <- data:
+		default:
+			delete(h.topics[topic], s)
+			close(s.evicted)
+			stats.evicted++
+		}
+	}
+}
+
+// publishJSON publishes v encoded as JSON.
+func (h *eventHub) publishJSON(topic string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %s", topic, err)
+		return
+	}
+	h.publish(topic, data)
+}
+
+type hubTopicSnapshot struct {
+	topic                           string
+	subscribers, published, evicted uint64
+}
+
+// snapshot returns the hub's metrics by topic, sorted by topic.
+func (h *eventHub) snapshot() []hubTopicSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var topics []hubTopicSnapshot
+	for topic, s := range h.stats {
+		topics = append(topics, hubTopicSnapshot{topic, uint64(len(h.topics[topic])), s.published, s.evicted})
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].topic < topics[j].topic
+	})
+	return topics
+}
+
+// streamHandler streams a topic's events to the client as server-sent
+// events until it disconnects, is evicted or the write timeout passes.
+func streamHandler(topic string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		s := streams.subscribe(topic)
+		defer streams.unsubscribe(topic, s)
+		releaseConfigLock(req)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Reconnect promptly after the write timeout ends the stream.
+		fmt.Fprint(w, "retry: 1000\n\n")
+		flusher.Flush()
+		for {
+			select {
+			case data := <-s.events:
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				flusher.Flush()
+			case <-s.evicted:
+				return
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// counterEventsHandler streams the public counter as it changes.
+func counterEventsHandler(w http.ResponseWriter, req *http.Request) {
+	if _, _, ok := counter.public(time.Now()); !ok {
+		http.NotFound(w, req)
+		return
+	}
+	streamHandler("counter")(w, req)
+}
+
+// publishCounter publishes the public counter to the "counter" topic
+// whenever it changes.
+func publishCounter() {
+	var last uint64
+	published := false
+	for range time.Tick(counterStreamPeriod) {
+		configLock.RLock()
+		n, approximate, ok := counter.public(time.Now())
+		configLock.RUnlock()
+		if !ok || published && n == last {
+			continue
+		}
+		last, published = n, true
+		streams.publishJSON("counter", counterResult{Counter: n, Rounded: approximate})
+	}
+}
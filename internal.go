@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// With -internal-addr the counter, stats, metrics, version and profiling
+// endpoints are served on a separate listener bound to the loopback
+// interface, for sidecars and operators on the host, and the public
+// addresses only serve the password endpoints. The health checks are served
+// on both, since load balancers probe the public addresses.
+var internalAddr = flag.String("internal-addr", "", "loopback listen `address`, such as localhost:9090, for the counter, stats, metrics and pprof instead of the public addresses")
+
+// internalHost is the host an -internal-addr without one is bound to.
+const internalHost = "127.0.0.1"
+
+// validateInternalAddr checks that -internal-addr, if set, is a loopback
+// address.
+func validateInternalAddr() error {
+	if *internalAddr == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(*internalAddr)
+	if err == nil {
+		_, err = net.LookupPort("tcp", port)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid -internal-addr address %q: %s", *internalAddr, err)
+	}
+	if ip := net.ParseIP(host); host != "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("-internal-addr %q must be a loopback address, such as localhost:9090", *internalAddr)
+	}
+	return nil
+}
+
+// internalListener binds -internal-addr, or returns nil if it isn't set.
+func internalListener() (net.Listener, error) {
+	if *internalAddr == "" {
+		return nil, nil
+	}
+	host, port, _ := net.SplitHostPort(*internalAddr)
+	if host == "" || host == "localhost" {
+		host = internalHost
+	}
+	return listenTCP("tcp", net.JoinHostPort(host, port))
+}
+
+// serveInternal serves the internal routes on l.
+func serveInternal(l net.Listener) error {
+	log.Print("Running internal endpoints at address ", l.Addr())
+	return newHTTPServer(newInternalServer()).Serve(l)
+}
+
+// newInternalServer returns a server for the internal routes. It isn't
+// subject to the IP filter or canonical host, which are for public clients.
+func newInternalServer() *server {
+	s := &server{mux: http.NewServeMux(), internal: true}
+	for _, r := range s.configuredRoutes() {
+		if s.serves(r) {
+			s.register(r)
+		}
+	}
+	s.handler = withConfigLock(securityHeaders(compressed(instrument(s.mux))))
+	return s
+}
+
+// serves reports whether s serves r.
+func (s *server) serves(r route) bool {
+	if r.unapproved && fipsMode() {
+		return false
+	}
+	if *internalAddr == "" {
+		return !s.internal
+	}
+	if r.pattern == "/healthz" || r.pattern == "/readyz" {
+		return true
+	}
+	return r.internal == s.internal
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// -allow-cidrs and -deny-cidrs restrict which networks can reach the server
+// at all, e.g. to an office network on a shared host. They are checked
+// before any handler, including the health checks, against the client
+// address as rate limits see it, so behind a proxy -trusted-proxies must be
+// set for them to see the real client. Denied networks take precedence over
+// allowed ones, so a range can be allowed with holes in it.
+var (
+	allowCIDRs = flag.String("allow-cidrs", "", "comma-separated `networks` in CIDR notation that may connect; all others get 403 Forbidden (empty to allow all)")
+	denyCIDRs  = flag.String("deny-cidrs", "", "comma-separated `networks` in CIDR notation that get 403 Forbidden")
+)
+
+func init() {
+	registerFeature(feature{name: "IP filter", kind: "subsystem", active: func() bool {
+		return *allowCIDRs != "" || *denyCIDRs != ""
+	}})
+}
+
+// parseNetworks parses the comma-separated networks in the flag called
+// name, taking single addresses as networks of one.
+func parseNetworks(name, value string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %s", name, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed reports whether the filters let req through. Clients without
+// an IP address, such as unix socket peers, are only let through if there
+// is no allowlist.
+func ipAllowed(req *http.Request) bool {
+	allow, _ := parseNetworks("allow-cidrs", *allowCIDRs)
+	deny, _ := parseNetworks("deny-cidrs", *denyCIDRs)
+	if len(allow) == 0 && len(deny) == 0 {
+		return true
+	}
+	ip := net.ParseIP(clientIP(req))
+	if ip == nil {
+		return len(allow) == 0
+	}
+	if containsIP(deny, ip) {
+		return false
+	}
+	return len(allow) == 0 || containsIP(allow, ip)
+}
+
+// withIPFilter rejects requests from networks that aren't allowed with
+// 403 Forbidden before calling h.
+func withIPFilter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !ipAllowed(req) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
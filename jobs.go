@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// Scheduled jobs periodically generate a credential and push it to a secret
+// sink, covering rotation use cases. They are defined in a JSON file:
+//
+//	[{
+//		"name": "db-password",
+//		"schedule": "0 3 1 * *",
+//		"template": {"mode": "memorable", "pattern": "WdW!"},
+//		"sink": {"type": "vault", "url": "https://vault:8200/v1/secret/data/db"}
+//	}]
+var jobsPath = flag.String("jobs", "", "JSON `file` of scheduled credential rotation jobs")
+
+func init() {
+	registerFeature(feature{name: "credential rotation", kind: "subsystem", active: func() bool {
+		return *jobsPath != ""
+	}})
+}
+
+type jobConfig struct {
+	Name     string      `json:"name"`
+	Schedule string      `json:"schedule"`
+	Template jobOptions  `json:"template"`
+	Sink     sinkConfig  `json:"sink"`
+	Hooks    hooksConfig `json:"hooks"`
+}
+
+// jobOptions are the genOptions for a job, which unlike those from a request
+// aren't clamped, so they are validated when the jobs are loaded.
+type jobOptions struct {
+	Mode    string `json:"mode"`
+	Length  int    `json:"length"`
+	Pattern string `json:"pattern"`
+	Charset string `json:"charset"`
+	// RequireEach guarantees a character of each class, as require-each=1.
+	RequireEach bool `json:"require_each"`
+}
+
+type job struct {
+	name     string
+	schedule schedule
+	opts     genOptions
+	sink     secretSink
+	hooks    *rotationHooks
+}
+
+func loadJobs(path string) ([]*job, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []jobConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	names := make(map[string]bool)
+	jobs := make([]*job, len(configs))
+	for i, c := range configs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("job %d has no name", i+1)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate job name %q", c.Name)
+		}
+		names[c.Name] = true
+		j, err := newJob(c)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %s", c.Name, err)
+		}
+		jobs[i] = j
+	}
+	return jobs, nil
+}
+
+func newJob(c jobConfig) (*job, error) {
+	sched, err := parseSchedule(c.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	opts := genOptions{
+		Mode:        c.Template.Mode,
+		Length:      c.Template.Length,
+		Pattern:     c.Template.Pattern,
+		Charset:     c.Template.Charset,
+		RequireEach: c.Template.RequireEach,
+	}
+	if opts.Length == 0 {
+		opts.Length = *maxPasswordLength
+	}
+	if opts.Length < *minPasswordLength || opts.Length > *maxPasswordLength {
+		return nil, fmt.Errorf("length must be between %d and %d", *minPasswordLength, *maxPasswordLength)
+	}
+	switch opts.Mode {
+	case "", "mobile", "memorable":
+	default:
+		return nil, fmt.Errorf("unknown mode %q", opts.Mode)
+	}
+	if opts.Pattern != "" {
+		if opts.Mode == "mobile" {
+			return nil, fmt.Errorf("mode mobile can't be combined with a pattern")
+		}
+		if _, err := parsePattern(opts.Pattern); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Charset != "" {
+		if opts.Mode != "" || opts.Pattern != "" {
+			return nil, fmt.Errorf("charset only applies to the default mode, without a pattern")
+		}
+		if opts.characters() == "" {
+			return nil, fmt.Errorf("unknown charset %q", opts.Charset)
+		}
+	}
+	if err := checkRequireEach(opts); err != nil {
+		return nil, err
+	}
+	sink, err := newSink(c.Sink)
+	if err != nil {
+		return nil, err
+	}
+	sinksInUse[c.Sink.Type] = true
+	hooks, err := newRotationHooks(c.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	return &job{name: c.Name, schedule: sched, opts: opts, sink: sink, hooks: hooks}, nil
+}
+
+// runJobs runs each job on its schedule, forever.
+func runJobs(jobs []*job) {
+	for _, j := range jobs {
+		go j.loop()
+	}
+}
+
+func (j *job) loop() {
+	for {
+		next := j.schedule.next(time.Now())
+		if next.IsZero() {
+			log.Printf("Job %s: schedule never fires", j.name)
+			return
+		}
+		time.Sleep(time.Until(next))
+		if err := j.rotate(); err != nil {
+			log.Printf("Job %s failed: %s", j.name, err)
+		}
+	}
+}
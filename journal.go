@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The journal keeps the audit entries of recent requests in memory so that
+// operators can find out what just happened without full request tracing.
+// Like the audit log it never holds generated values.
+var (
+	journalWindow = flag.Duration("journal", 0, "keep metadata of requests made in the last `duration`, for /admin/journal (0 to disable)")
+	journalSize   = flag.Int("journal-size", 10000, "maximum number of requests kept in the journal")
+)
+
+func init() {
+	registerFeature(feature{name: "request journal", kind: "subsystem", active: func() bool {
+		return *journalWindow > 0
+	}})
+}
+
+// requestJournal is a ring buffer of audit entries.
+type requestJournal struct {
+	mu      sync.Mutex
+	entries []auditEntry
+	next    int // Index of the oldest entry once the buffer is full.
+}
+
+var journal = &requestJournal{}
+
+func (j *requestJournal) add(e auditEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.entries) < *journalSize {
+		j.entries = append(j.entries, e)
+		return
+	}
+	j.entries[j.next] = e
+	j.next = (j.next + 1) % len(j.entries)
+}
+
+// since returns the entries for requests made at or after t, oldest first.
+func (j *requestJournal) since(t time.Time) []auditEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var entries []auditEntry
+	for i := range j.entries {
+		e := j.entries[(j.next+i)%len(j.entries)]
+		if !e.Time.Before(t) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+type journalDump struct {
+	XMLName  xml.Name     `json:"-" xml:"journal"`
+	Requests []auditEntry `json:"requests" xml:"request"`
+}
+
+func (d journalDump) text() string {
+	var sb strings.Builder
+	for _, e := range d.Requests {
+		identity := e.Identity
+		if identity == "" {
+			identity = "-"
+		}
+		fmt.Fprintf(&sb, "%s %s %s %s %s %d %d %.3fms %s\n", e.Time.Format(time.RFC3339Nano),
+			e.Client, identity, e.Method, e.Route, e.Status, e.Bytes, e.Duration, strings.Join(e.Params, ","))
+	}
+	return sb.String()
+}
+
+func (d journalDump) csvRecords() [][]string {
+	records := [][]string{{"time", "client", "identity", "method", "route", "params", "status", "bytes", "duration_ms"}}
+	for _, e := range d.Requests {
+		records = append(records, []string{
+			e.Time.Format(time.RFC3339Nano), e.Client, e.Identity, e.Method, e.Route,
+			strings.Join(e.Params, " "), strconv.Itoa(e.Status), strconv.Itoa(e.Bytes),
+			strconv.FormatFloat(e.Duration, 'f', 3, 64),
+		})
+	}
+	return records
+}
+
+// journalHandler dumps the journal, or the last minutes of it if the
+// minutes parameter is given.
+func journalHandler(w http.ResponseWriter, req *http.Request) {
+	window := *journalWindow
+	if s := req.FormValue("minutes"); s != "" {
+		m, err := strconv.Atoi(s)
+		if err != nil || m < 1 {
+			http.Error(w, "minutes must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		if d := time.Duration(m) * time.Minute; d < window {
+			window = d
+		}
+	}
+	render(w, req, journalDump{Requests: journal.since(time.Now().Add(-window))})
+}
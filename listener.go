@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The server can listen on any number of plain HTTP and HTTPS addresses,
+// with the same handlers on each, on a unix socket, e.g. behind nginx, or on
+// sockets passed by systemd socket activation.
+var (
+	httpAddrs    = addrList{addrs: []string{defaultAddr()}}
+	httpsAddrs   addrList
+	listenAddr   = flag.String("listen", "", "listen `address`: unix:/path/to.sock or systemd, instead of the -http and -https addresses (default systemd if the server was socket activated)")
+	tlsCertPath  = flag.String("tls-cert", "", "PEM certificate chain `file` for -https")
+	tlsKeyPath   = flag.String("tls-key", "", "PEM private key `file` for -https")
+	redirectHTTP = flag.Bool("redirect-http", false, "redirect requests on the -http addresses to the first -https address")
+	ipStack      = flag.String("ip-stack", "dual", "how -http and -https addresses are bound: dual, separate, ipv4 or ipv6")
+)
+
+// ipStacks are the values of -ip-stack. With dual, an address without a host
+// such as :8080 accepts IPv4 and IPv6 connections on one socket where the
+// system supports it, as does 0.0.0.0:8080. With separate, it is bound as
+// separate IPv4 and IPv6 sockets, and IP addresses only accept their own
+// family, so [::]:8080 can be given alongside 0.0.0.0:8080. ipv4 and ipv6
+// only bind that family.
+var ipStacks = []string{"dual", "separate", "ipv4", "ipv6"}
+
+func init() {
+	flag.Var(&httpAddrs, "http", "http listen `address` (may be repeated)")
+	flag.Var(&httpsAddrs, "https", "https listen `address` (may be repeated)")
+	registerFeature(feature{name: "https", kind: "transport", version: "TLS 1.2+", active: func() bool {
+		return len(httpsAddrs.addrs) > 0
+	}})
+	registerFeature(feature{name: "http/2", kind: "transport", active: func() bool {
+		return len(httpsAddrs.addrs) > 0
+	}})
+}
+
+// addrList is a flag.Value holding listen addresses. Setting it the first
+// time replaces the default, so -http "" disables plain HTTP.
+type addrList struct {
+	addrs []string
+	set   bool
+}
+
+func (l *addrList) String() string {
+	return strings.Join(l.addrs, ",")
+}
+
+func (l *addrList) Values() []string {
+	return l.addrs
+}
+
+func (l *addrList) Set(value string) error {
+	if !l.set {
+		l.addrs, l.set = nil, true
+	}
+	if value == "" {
+		return nil
+	}
+	for _, addr := range l.addrs {
+		if addr == value {
+			return nil
+		}
+	}
+	l.addrs = append(l.addrs, value)
+	return nil
+}
+
+// validateListenAddrs checks that the listen addresses are well formed, so
+// that typos are reported as such rather than as failures to bind.
+func validateListenAddrs() error {
+	if *listenAddr != "" && *listenAddr != "systemd" && !strings.HasPrefix(*listenAddr, "unix:") {
+		return fmt.Errorf("invalid -listen address %q, want unix:/path/to.sock or systemd", *listenAddr)
+	}
+	for _, l := range []struct {
+		flag  string
+		addrs []string
+	}{{"-http", httpAddrs.addrs}, {"-https", httpsAddrs.addrs}} {
+		for _, addr := range l.addrs {
+			_, port, err := net.SplitHostPort(addr)
+			if err == nil {
+				_, err = net.LookupPort("tcp", port)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid %s address %q: %s", l.flag, addr, err)
+			}
+		}
+	}
+	if !contains(ipStacks, *ipStack) {
+		return fmt.Errorf("-ip-stack must be one of %s", strings.Join(ipStacks, ", "))
+	}
+	if *listenAddr == "" && os.Getenv("LISTEN_FDS") == "" && len(httpAddrs.addrs) == 0 && len(httpsAddrs.addrs) == 0 {
+		return errors.New("no listen addresses: give -http, -https or -listen")
+	}
+	return nil
+}
+
+// listener is a listener and whether it serves TLS.
+type listener struct {
+	net.Listener
+	tls bool
+}
+
+// listeners returns the listeners to serve on, wrapped to read PROXY
+// protocol headers if -proxy-protocol is set.
+func listeners() ([]listener, error) {
+	ls, err := rawListeners()
+	if err != nil || !*proxyProtocol {
+		return ls, err
+	}
+	for i := range ls {
+		ls[i].Listener = proxyListener{ls[i].Listener}
+	}
+	return ls, nil
+}
+
+func rawListeners() ([]listener, error) {
+	addr := *listenAddr
+	if addr == "" && os.Getenv("LISTEN_FDS") != "" {
+		addr = "systemd"
+	}
+	switch {
+	case addr == "systemd":
+		return systemdListeners()
+	case strings.HasPrefix(addr, "unix:"):
+		l, err := listenUnix(addr[len("unix:"):])
+		if err != nil {
+			return nil, err
+		}
+		return []listener{{l, false}}, nil
+	case addr != "":
+		return nil, fmt.Errorf("invalid -listen address %q", addr)
+	}
+
+	var ls []listener
+	for _, l := range []struct {
+		addrs []string
+		tls   bool
+	}{{httpAddrs.addrs, false}, {httpsAddrs.addrs, true}} {
+		for _, addr := range l.addrs {
+			tcp, err := tcpListeners(addr)
+			if err != nil {
+				return nil, err
+			}
+			for _, t := range tcp {
+				ls = append(ls, listener{t, l.tls})
+			}
+		}
+	}
+	return ls, nil
+}
+
+// tcpListeners binds addr as set by -ip-stack.
+func tcpListeners(addr string) ([]net.Listener, error) {
+	network := "tcp"
+	switch *ipStack {
+	case "ipv4":
+		network = "tcp4"
+	case "ipv6":
+		network = "tcp6"
+	case "separate":
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" {
+			l4, err := listenTCP("tcp4", net.JoinHostPort("0.0.0.0", port))
+			if err != nil {
+				return nil, err
+			}
+			l6, err := listenTCP("tcp6", net.JoinHostPort("::", port))
+			if err != nil {
+				l4.Close()
+				return nil, err
+			}
+			return []net.Listener{l4, l6}, nil
+		}
+		// Go binds wildcard tcp addresses, even 0.0.0.0, to both families.
+		if ip := net.ParseIP(host); ip.To4() != nil {
+			network = "tcp4"
+		} else if ip != nil {
+			network = "tcp6"
+		}
+	}
+	l, err := listenTCP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{l}, nil
+}
+
+// serve serves h on ls until one of them fails.
+func serve(ls []listener, h http.Handler) error {
+	server := newHTTPServer(h)
+	plain := server
+	if *redirectHTTP {
+		plain = newHTTPServer(withConfigLock(withIPFilter(http.HandlerFunc(redirectToHTTPS))))
+	}
+	if len(httpsAddrs.addrs) > 0 {
+		config, err := tlsConfig()
+		if err != nil {
+			return err
+		}
+		if err := setupSessionTickets(config); err != nil {
+			return err
+		}
+		server.TLSConfig = config
+	}
+
+	errs := make(chan error)
+	for _, l := range ls {
+		l := l
+		if l.tls {
+			log.Print("Running at address https://", l.Addr())
+			go func() {
+				errs <- server.ServeTLS(l, *tlsCertPath, *tlsKeyPath)
+			}()
+		} else {
+			log.Print("Running at address ", l.Addr())
+			go func() {
+				errs <- plain.Serve(l)
+			}()
+		}
+	}
+	go announceReady(ls)
+	return <-errs
+}
+
+// tlsConfig returns the TLS configuration for the -https listeners. Client
+// certificates issued by -client-ca are requested, for mtls
+// authentication, but not required.
+func tlsConfig() (*tls.Config, error) {
+	// NextProtos must offer h2 for the server to set up HTTP/2 if a plain
+	// listener starts serving before the TLS ones.
+	config := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{"h2", "http/1.1"}}
+	if *clientCAPath != "" {
+		pem, err := ioutil.ReadFile(*clientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", *clientCAPath)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// redirectToHTTPS redirects requests to the same URL on the first -https
+// address, on -canonical-host if set.
+func redirectToHTTPS(w http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if *canonicalHost != "" {
+		host = *canonicalHost
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(httpsAddrs.addrs[0]); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// listenUnix listens on a unix socket at path, replacing any socket left
+// there by a previous run.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// systemdListeners returns the sockets passed by systemd, as described in
+// sd_listen_fds(3). They serve plain HTTP.
+func systemdListeners() ([]listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("not socket activated by systemd")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("systemd passed no sockets")
+	}
+	// Don't pass the sockets on to child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	ls := make([]listener, n)
+	for i := range ls {
+		fd := listenFdsStart + i
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("socket %d: %s", fd, err)
+		}
+		f.Close()
+		ls[i] = listener{l, false}
+	}
+	return ls, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The server logs to stderr unless -log-output sends its log to syslog, to
+// the systemd journal over its native protocol, or to a file. Files are
+// rotated once they reach -log-max-size, keeping -log-max-files old ones as
+// file.1, file.2 and so on, and reopened on SIGHUP for external tools such as
+// logrotate that move them aside themselves. Syslog and the journal
+// timestamp messages, so the log's own timestamps are left out.
+var (
+	logOutput   = flag.String("log-output", "stderr", "where the log goes: stderr, syslog, journald or file:path")
+	logMaxSize  = flag.Int("log-max-size", 100, "size in `MiB` at which a -log-output file is rotated (0 to never rotate it)")
+	logMaxFiles = flag.Int("log-max-files", 5, "number of rotated -log-output files kept")
+)
+
+// logIdentifier tags the server's messages in syslog and the journal.
+const logIdentifier = "random-password-please"
+
+// journalSocket is where journald receives messages over its native
+// protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// logFile is the -log-output file, if the log goes to one.
+var logFile *rotatingFile
+
+// setupLogOutput directs the log to -log-output.
+func setupLogOutput() error {
+	if *logMaxSize < 0 || *logMaxFiles < 0 {
+		return errors.New("-log-max-size and -log-max-files must not be negative")
+	}
+	var w io.Writer
+	switch {
+	case *logOutput == "stderr":
+		return nil
+	case *logOutput == "syslog":
+		var err error
+		if w, err = newSyslogWriter(logIdentifier); err != nil {
+			return err
+		}
+		log.SetFlags(0)
+	case *logOutput == "journald":
+		j, err := newJournalWriter(journalSocket)
+		if err != nil {
+			return err
+		}
+		w = j
+		log.SetFlags(0)
+	case strings.HasPrefix(*logOutput, "file:") && len(*logOutput) > len("file:"):
+		f, err := openRotatingFile(strings.TrimPrefix(*logOutput, "file:"), int64(*logMaxSize)<<20, *logMaxFiles)
+		if err != nil {
+			return err
+		}
+		logFile = f
+		w = f
+	default:
+		return fmt.Errorf("invalid -log-output %q, want stderr, syslog, journald or file:path", *logOutput)
+	}
+	log.SetOutput(w)
+	return nil
+}
+
+// rotatingFile appends to a file, rotating it once it would grow past
+// maxSize.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate %s: %s\n", r.path, err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file to path.1, shifting the older ones along and
+// removing the oldest, and starts a new one. r.mu must be held.
+func (r *rotatingFile) rotate() error {
+	backup := func(i int) string {
+		return fmt.Sprintf("%s.%d", r.path, i)
+	}
+	if r.maxFiles == 0 {
+		if err := os.Remove(r.path); err != nil {
+			return err
+		}
+	} else {
+		os.Remove(backup(r.maxFiles))
+		for i := r.maxFiles - 1; i > 0; i-- {
+			os.Rename(backup(i), backup(i+1))
+		}
+		if err := os.Rename(r.path, backup(1)); err != nil {
+			return err
+		}
+	}
+	old := r.f
+	if err := r.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// reopen starts writing to the file at the path again, once it has been
+// moved aside.
+func (r *rotatingFile) reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.f
+	if err := r.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// journalWriter sends each write to journald as a message.
+type journalWriter struct {
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+func newJournalWriter(socket string) (*journalWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalWriter{conn: conn}, nil
+}
+
+func (j *journalWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	buf.WriteString("PRIORITY=6\nSYSLOG_IDENTIFIER=" + logIdentifier + "\n")
+	// Values with newlines, such as stack traces, are given as their
+	// length in 64-bit little endian followed by the bytes.
+	msg := bytes.TrimSuffix(p, []byte("\n"))
+	if bytes.IndexByte(msg, '\n') < 0 {
+		buf.WriteString("MESSAGE=")
+	} else {
+		buf.WriteString("MESSAGE\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(msg)))
+	}
+	buf.Write(msg)
+	buf.WriteByte('\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -2,48 +2,215 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
-	"text/template"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
-const (
-	minPasswordLength = 8
-	maxPasswordLength = 30
-)
+// defaultPageLength is the length of the passwords initially shown on the
+// page, within the configured limits.
+const defaultPageLength = 12
 
 var (
-	httpAddr = flag.String("http", defaultAddr(), "http listen address")
-
-	// Counts number of passwords generated.
-	counter     uint64
-	counterLock sync.Mutex // Overkill?
+	minPasswordLength = flag.Int("min-length", 8, "minimum password length")
+	maxPasswordLength = flag.Int("max-length", 30, "maximum password length")
+	maxCount          = flag.Int("max-count", 100, "maximum number of passwords per request")
+	suggestions       = flag.Int("suggestions", 5, "number of passwords to suggest on the page")
 
 	// Optional file to load/save counter value.
 	counterFilePath = flag.String("counter", "", "password counter file")
 	counterFile     *os.File
 	counterFileLock sync.Mutex
 
-	index *template.Template
+	index pageTemplate
 
 	passwords chan (string)
 )
 
 type indexParams struct {
+	// Password and Usability are those of the first of Passwords.
+	// Counter is empty if it is hidden, and CounterRounded set if it is
+	// rounded.
 	Password, Counter, Host string
+	CounterRounded          bool
+	Usability               usabilityScore
+	Passwords               []passwordResult
+	// Hourly is the number of passwords generated in each of the last 24
+	// hours, oldest first.
+	Hourly []uint64
+
+	MinLength, MaxLength int
+	// Length of the passwords initially shown.
+	Length int
+
+	// Mobile and Passphrase are set if the passwords shown are easy to type
+	// on a phone or passphrases, with the other fields as their options.
+	Mobile, Passphrase        bool
+	Words, MinWords, MaxWords int
+	Separator                 string
+	Capitalize                bool
+	Separators                []passphraseSeparator
+
+	// Charset is the name of the charset chosen from Charsets, and
+	// Alphabet the characters passwords are drawn from. AmbiguousChars is
+	// set if it contains easily confused characters, which the page then
+	// styles distinctly.
+	Charset        string
+	Charsets       []charsetPreset
+	Alphabet       string
+	AmbiguousChars bool
+
+	// Masked is set if passwords are shown masked until revealed, and
+	// RemaskAfter is how many seconds they are then shown for, or 0.
+	Masked      bool
+	RemaskAfter int
+
+	// Branding.
+	Title, LogoURL                         string
+	ThemeColor, BackgroundColor, TextColor string
+	DarkBackgroundColor, DarkTextColor     string
+	FooterLinks                            []link
 }
 
 func main() {
 	flag.Parse()
+	rememberCommandLine()
+
+	if flag.Arg(0) == "version" {
+		fmt.Println(currentVersion().text())
+		return
+	}
+
+	if flag.Arg(0) == "examples" {
+		if err := printExamples(os.Stdout, flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "setup" {
+		path := *configPath
+		if path == "" {
+			path = "config.yaml"
+		}
+		if err := runSetup(os.Stdin, os.Stdout, path); err != nil {
+			log.Fatalf("Setup failed: %s", err)
+		}
+		return
+	}
+
+	if *configPath != "" {
+		if err := loadConfig(*configPath); err != nil {
+			log.Fatalf("Failed to load config: %s", err)
+		}
+	}
+
+	var importedCounter *uint64
+	if *importBundlePath != "" {
+		c, err := readBundle(*importBundlePath)
+		if err != nil {
+			log.Fatalf("Failed to import bundle: %s", err)
+		}
+		importedCounter = &c
+	}
+	if *profileExample != "" {
+		if err := loadExampleProfile(*profileExample); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := setupLogOutput(); err != nil {
+		log.Fatalf("Failed to set up -log-output: %s", err)
+	}
+	if err := validateConfig(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := setupAuth(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupAdminAudit(); err != nil {
+		log.Fatalf("Failed to open the admin audit log: %s", err)
+	}
+	if err := setupClusterPolicy(); err != nil {
+		log.Fatalf("Failed to fetch the cluster policy: %s", err)
+	}
+	if flag.Arg(0) == "openapi" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(newServer().openAPISpec()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := setupHistory(); err != nil {
+		log.Fatalf("Failed to set up password history: %s", err)
+	}
+	if *wordlistDir != "" {
+		if err := loadWordlists(*wordlistDir); err != nil {
+			log.Fatalf("Failed to load wordlists: %s", err)
+		}
+	}
+	if *breachPatterns != "" {
+		if err := loadBreachPatterns(*breachPatterns); err != nil {
+			log.Fatalf("Failed to load breach patterns: %s", err)
+		}
+	}
+	if err := loadPageTemplate(); err != nil {
+		log.Fatalf("Failed to load page template: %s", err)
+	}
+	atomic.StoreInt32(&bufferedLength, int32(*maxPasswordLength))
+
+	if *attestationKeyPath != "" {
+		if err := loadAttestationKey(*attestationKeyPath); err != nil {
+			log.Fatalf("Failed to load attestation key: %s", err)
+		}
+	}
+	if *webPushKeyPath != "" {
+		if err := setupWebPush(); err != nil {
+			log.Fatalf("Failed to set up Web Push: %s", err)
+		}
+	}
+
+	if *secretsKeyPath != "" {
+		if err := setupSecrets(); err != nil {
+			log.Fatalf("Failed to set up one-time secrets: %s", err)
+		}
+	}
+
+	if err := setupTracing(); err != nil {
+		log.Fatalf("Failed to set up tracing: %s", err)
+	}
+
+	if err := validateFIPS(); err != nil {
+		log.Fatalf("Refusing to serve: %s", err)
+	}
+	passwordSource, err := setupRandomness()
+	if err != nil {
+		log.Fatalf("Refusing to serve: %s", err)
+	}
+
+	var jobs []*job
+	if *jobsPath != "" {
+		var err error
+		if jobs, err = loadJobs(*jobsPath); err != nil {
+			log.Fatalf("Failed to load jobs: %s", err)
+		}
+	}
 
 	if *counterFilePath != "" {
 		var err error
@@ -57,26 +224,72 @@ func main() {
 			log.Fatalf("Failed to read counter file: %s", err)
 		}
 		if len(counterBytes) > 0 {
-			counter, err = strconv.ParseUint(string(bytes.TrimSpace(counterBytes)), 10, 64)
+			n, err := strconv.ParseUint(string(bytes.TrimSpace(counterBytes)), 10, 64)
 			if err != nil {
 				log.Fatal("Failed to read counter value")
 			}
+			counter.set(n)
+		}
+		if err := stats.load(statsPath()); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Failed to read stats: %s", err)
 		}
+		if err := limiter.load(limiterPath()); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Failed to read rate limiter state: %s", err)
+		}
+	}
+
+	if importedCounter != nil {
+		counter.set(*importedCounter)
+		saveCounter()
+		log.Printf("Imported bundle %s", *importBundlePath)
 	}
 
-	http.HandleFunc("/", indexHandler)
+	if *exportBundlePath != "" {
+		if err := exportBundle(*exportBundlePath); err != nil {
+			log.Fatalf("Failed to export bundle: %s", err)
+		}
+		log.Printf("Exported bundle to %s", *exportBundlePath)
+		return
+	}
 
-	http.HandleFunc("/password.txt", apiHandler)
+	// Bind before starting anything else so that an unusable address fails
+	// the start straight away.
+	ls, err := listeners()
+	if err != nil {
+		log.Fatalf("Failed to listen: %s", err)
+	}
+	internal, err := internalListener()
+	if err != nil {
+		log.Fatalf("Failed to listen on -internal-addr: %s", err)
+	}
 
-	http.HandleFunc("/counter", counterHandler)
+	s := newServer()
 
 	// Ensure counter is saved on exit.
 	go handleSignals()
 
-	go generatePasswords()
+	generatePasswords(passwordSource)
 
-	log.Print("Running at address ", *httpAddr)
-	log.Fatal(http.ListenAndServe(*httpAddr, nil))
+	go commitments.expire(time.Minute)
+	go rngUsage.watch(*entropyInterval)
+	go publishCounter()
+	if err := setupCounterSync(); err != nil {
+		log.Fatalf("Failed to set up counter aggregation: %s", err)
+	}
+
+	go limiter.expire(limiterPrunePeriod)
+	if counterFile != nil {
+		go limiter.persist(limiterPath(), limiterSavePeriod)
+	}
+
+	runJobs(jobs)
+
+	if internal != nil {
+		go func() {
+			log.Fatal(serveInternal(internal))
+		}()
+	}
+	log.Fatal(serve(ls, s))
 }
 
 func indexHandler(w http.ResponseWriter, req *http.Request) {
@@ -85,62 +298,397 @@ func indexHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	count := *suggestions
+	if s := req.FormValue("count"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= *maxCount {
+			count = n
+		}
+	}
+	// The page's settings are taken from the query so they can be shared
+	// by URL. Invalid ones are replaced by the defaults rather than failing.
+	length := defaultPageLength
+	if n, err := strconv.Atoi(req.FormValue("len")); err == nil {
+		length = n
+	}
+	if length < *minPasswordLength {
+		length = *minPasswordLength
+	} else if length > *maxPasswordLength {
+		length = *maxPasswordLength
+	}
+	opts := genOptions{Length: length}
+	opts.Uncounted, _ = readUncounted(req)
+	if mode := req.FormValue("mode"); mode == "mobile" || mode == "passphrase" {
+		opts.Mode = mode
+	}
+	if c := req.FormValue("charset"); opts.Mode == "" && (genOptions{Charset: c}).characters() != "" {
+		opts.Charset = c
+	}
+	if err := readPassphraseOptions(req, &opts); err != nil {
+		opts.Words, opts.Separator, opts.Capitalize = 0, "", false
+	}
+	if opts.Words == 0 {
+		opts.Words = defaultPassphraseWords
+	}
+	if opts.Separator == "" {
+		opts.Separator = passphraseSeparators[0].Name
+	}
+
+	ctx, span := startGenerateSpan(req.Context(), opts, count)
+	defer span.end()
+	candidates := make([]passwordResult, count)
+	batch := make(batchPasswords)
+	uncounted := opts
+	uncounted.Uncounted = true
+	for i := range candidates {
+		password, err := batch.issue(func() (string, error) {
+			return generate(ctx, uncounted)
+		}, !opts.Uncounted)
+		if err != nil {
+			span.setError(err.Error())
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		u := scoreUsability(password)
+		st := estimateStrength(password)
+		candidates[i] = passwordResult{Password: password, Usability: &u, Strength: &st}
+	}
+	recordIssued(opts, "index", "html", count)
+
+	n, rounded, showCounter := counter.forRequest(req)
+	// The counter is only served on the internal listener if there is one.
+	showCounter = showCounter && *internalAddr == ""
 	params := indexParams{
-		Password: getPassword()[:minPasswordLength],
-		Counter:  fmt.Sprint(counter),
-		Host:     req.Host,
+		Password:  candidates[0].Password,
+		Usability: *candidates[0].Usability,
+		Passwords: candidates,
+		Host:      pageHost(req),
+		MinLength: *minPasswordLength,
+		MaxLength: *maxPasswordLength,
+		Length:    length,
+
+		Mobile:     opts.Mode == "mobile",
+		Passphrase: opts.Mode == "passphrase",
+		Words:      opts.Words,
+		MinWords:   minPassphraseWords,
+		MaxWords:   maxPassphraseWords,
+		Separator:  opts.Separator,
+		Capitalize: opts.Capitalize,
+		Separators: passphraseSeparators,
+
+		Charset:  defaultCharsetName(),
+		Charsets: availableCharsets(),
+		Alphabet: alphabet,
+
+		Masked:      *maskPasswords,
+		RemaskAfter: int(remaskAfter.Seconds()),
+
+		Title:               *pageTitle,
+		LogoURL:             *logoURL,
+		ThemeColor:          *themeColor,
+		BackgroundColor:     *backgroundColor,
+		TextColor:           *textColor,
+		DarkBackgroundColor: *darkBackgroundColor,
+		DarkTextColor:       *darkTextColor,
+		FooterLinks:         pageFooterLinks(),
+	}
+	if opts.Charset != "" {
+		params.Charset = opts.Charset
+	}
+	if opts.Mode == "" {
+		params.Alphabet = opts.characters()
+	}
+	params.AmbiguousChars = strings.ContainsAny(params.Alphabet, ambiguousChars)
+	if showCounter {
+		params.Counter = fmt.Sprint(n)
+		params.CounterRounded = rounded
+		if !rounded {
+			params.Hourly = stats.hourlyCounts()
+		}
+	}
+	page, err := currentPageTemplate()
+	if err != nil {
+		http.Error(w, "page template: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
-	w.Header().Set("Cache-Control", "no-cache")
-	index.Execute(w, params)
+	w.Header().Set("Cache-Control", "no-store")
+	if *devMode {
+		// Render to a buffer so an error can replace the page.
+		var buf bytes.Buffer
+		if err := page.Execute(&buf, params); err != nil {
+			http.Error(w, "page template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(buf.Bytes())
+		return
+	}
+	page.Execute(w, params)
 }
 
-func apiHandler(w http.ResponseWriter, req *http.Request) {
-	n := minPasswordLength
+// validHost matches a host name or address with an optional port.
+var validHost = regexp.MustCompile(`^([A-Za-z0-9.-]+|\[[0-9A-Fa-f:.]+\])(:[0-9]+)?$`)
+
+// pageHost returns -canonical-host if set, or else the host the page was
+// requested from, or an empty string if the client sent something that
+// isn't a host. The template escapes it regardless, but there's no reason to
+// show anything else.
+func pageHost(req *http.Request) string {
+	if *canonicalHost != "" {
+		return *canonicalHost
+	}
+	if !validHost.MatchString(req.Host) {
+		return ""
+	}
+	return req.Host
+}
+
+// readLength returns the password length asked for by req, clamped to the
+// limits, or writes a problem and returns false if it is out of range in
+// strict mode.
+func readLength(w http.ResponseWriter, req *http.Request) (int, bool) {
 	n, err := strconv.Atoi(req.FormValue("len"))
+	if s := req.FormValue("len"); s != "" && isStrict(req) && (err != nil || n < *minPasswordLength || n > *maxPasswordLength) {
+		writeProblem(w, paramProblem(req, "len", s, fmt.Sprintf("len must be between %d and %d", *minPasswordLength, *maxPasswordLength)))
+		return 0, false
+	}
 	if err != nil {
-		n = minPasswordLength
-	} else if n < minPasswordLength {
-		n = minPasswordLength
-	} else if n > maxPasswordLength {
-		n = maxPasswordLength
+		n = *minPasswordLength
+	} else if n < *minPasswordLength {
+		n = *minPasswordLength
+	} else if n > *maxPasswordLength {
+		n = *maxPasswordLength
 	}
-	w.Header().Set("Content-Type", "text/plain")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Content-Length", strconv.Itoa(n))
-	fmt.Fprint(w, getPassword()[:n])
+	return n, true
 }
 
-func counterHandler(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Content-Type", "text/plain")
-	w.Header().Set("Cache-Control", "no-cache")
-	s := strconv.FormatUint(counter, 10)
-	w.Header().Set("Content-Length", strconv.Itoa(len(s)))
-	fmt.Fprint(w, s)
+// readGenOptions returns the options of passwords of length n asked for by
+// req's mode, pattern, lang, passphrase and constraint parameters.
+func readGenOptions(req *http.Request, n int) (genOptions, error) {
+	opts := genOptions{
+		Mode:    req.FormValue("mode"),
+		Length:  n,
+		Pattern: req.FormValue("pattern"),
+		Lang:    req.FormValue("lang"),
+		Charset: req.FormValue("charset"),
+	}
+	if err := readPassphraseOptions(req, &opts); err != nil {
+		return opts, err
+	}
+	if err := readRequireEach(req, &opts); err != nil {
+		return opts, err
+	}
+	return opts, readConstraints(req, &opts)
 }
 
-func generatePasswords() {
-	// Create a buffer of passwords so requests don't have to wait for a password to be generated.
-	passwords = make(chan string, 10)
+func apiHandler(w http.ResponseWriter, req *http.Request) {
+	n, ok := readLength(w, req)
+	if !ok {
+		return
+	}
+
+	count := 1
+	if s := req.FormValue("count"); s != "" {
+		limit := *maxCount
+		if *maxStreamCount > limit {
+			limit = *maxStreamCount
+		}
+		var err error
+		count, err = strconv.Atoi(s)
+		if err != nil || count < 1 || count > limit {
+			http.Error(w, fmt.Sprintf("count must be between 1 and %d", limit), http.StatusBadRequest)
+			return
+		}
+	}
+	stream := count > *maxCount
+	verbose := req.FormValue("verbose") == "1"
+
+	opts, err := readGenOptions(req, n)
+	if err == nil {
+		opts.Uncounted, err = readUncounted(req)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entropy, err := clientEntropy(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if entropy != nil && (opts.Mode != "" || opts.Pattern != "" || opts.constrained() || opts.RequireEach || opts.characters() != alphabet) {
+		http.Error(w, "client entropy is only supported by the default mode and charset, without constraints", http.StatusBadRequest)
+		return
+	}
+	report := req.FormValue("report") == "1"
+	tr, err := readTransforms(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.constrained() && !tr.onlyChunks() {
+		http.Error(w, "start, end and no-leading-digit constrain passwords as generated, so they can't be combined with transformations other than chunk", http.StatusBadRequest)
+		return
+	}
+	if report && len(tr.steps) > 0 {
+		http.Error(w, "a report describes passwords as generated, so it can't be combined with transformations", http.StatusBadRequest)
+		return
+	}
+	if stream && report {
+		http.Error(w, fmt.Sprintf("batches of more than %d passwords are streamed, so they can't have a report", *maxCount), http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startGenerateSpan(req.Context(), opts, count)
+	defer span.end()
+
+	// next generates the batch's next password and returns how many times
+	// it was retried to comply with its policy.
+	// Passwords are only counted once issued, not when they are generated,
+	// since repeats within the batch are regenerated.
+	issued := make(batchPasswords)
+	uncounted := opts
+	uncounted.Uncounted = true
+	bits := policyEntropy(opts)
+	rotateBy := rotationDate(bits)
+	next := func() (passwordResult, int, error) {
+		var retries int
+		password, err := issued.issue(func() (string, error) {
+			var password string
+			var err error
+			switch {
+			case entropy != nil:
+				password, err = mixedPassword(n, entropy, false)
+			case report:
+				password, retries, err = generateCompliant(ctx, uncounted)
+			default:
+				password, err = generate(ctx, uncounted)
+			}
+			if err != nil {
+				return "", err
+			}
+			return tr.apply(password), nil
+		}, !opts.Uncounted)
+		if err != nil {
+			span.setError(err.Error())
+			return passwordResult{}, 0, err
+		}
+		r := passwordResult{Password: password, RotateBy: rotateBy}
+		if verbose {
+			u := scoreUsability(password)
+			st := estimateStrength(password)
+			r.Usability = &u
+			r.Strength = &st
+		}
+		return r, retries, nil
+	}
 
-	// Derived from https://docs.djangoproject.com/en/dev/topics/auth/#django.contrib.auth.models.UserManager.make_random_password
-	alphabet := "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
-	password := make([]byte, maxPasswordLength)
-	for {
-		for i := 0; i < len(password); i++ {
-			password[i] = alphabet[rand.Int()%len(alphabet)]
+	if stream {
+		first, _, err := next()
+		if err != nil {
+			generationFailed(w, req, err)
+			return
 		}
-		passwords <- string(password)
+		describePasswords(w, opts, bits, tr)
+		// The options have been read, so reloads needn't wait for the
+		// stream to end.
+		releaseConfigLock(req)
+		written, err := streamBatch(w, req, first, count, next)
+		recordIssued(opts, "password.txt", formatEndpoint(req), written)
+		if err != nil {
+			// The status has been sent, so the response can only be cut
+			// short for the client to see that it is incomplete.
+			logf(req, "Streaming batch: %s", err)
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+
+	batch := passwordBatch{Passwords: make([]passwordResult, count)}
+	passwords := make([]string, count)
+	retries := make([]int, count)
+	for i := range batch.Passwords {
+		r, tries, err := next()
+		if err != nil {
+			generationFailed(w, req, err)
+			return
+		}
+		batch.Passwords[i] = r
+		passwords[i] = r.Password
+		retries[i] = tries
+	}
+
+	if report {
+		batch.Report, batch.Attestation, err = newComplianceReport(opts, passwords, retries)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	recordIssued(opts, "password.txt", formatEndpoint(req), count)
+
+	describePasswords(w, opts, bits, tr)
+	if req.FormValue("count") == "" && !report {
+		render(w, req, batch.Passwords[0])
+	} else {
+		render(w, req, batch)
 	}
 }
 
-func getPassword() string {
-	counterLock.Lock()
-	defer counterLock.Unlock()
-	counter++
-	if counterFile != nil && counter%100 == 0 {
-		go saveCounter()
+// describePasswords sets headers with the alphabet size and entropy of the
+// passwords generated with opts, for clients of the plain text format that
+// can't parse the others. Passwords with words have no alphabet size, and
+// transformations that can lower the entropy leave both out.
+func describePasswords(w http.ResponseWriter, opts genOptions, bits float64, tr *transforms) {
+	if tr.lossy() {
+		return
+	}
+	if charset := opts.charset(); charset != "" {
+		w.Header().Set("X-Password-Alphabet-Size", strconv.Itoa(len([]rune(charset))))
 	}
-	return <-passwords
+	w.Header().Set("X-Password-Entropy-Bits", strconv.FormatFloat(math.Round(bits*10)/10, 'f', 1, 64))
+}
+
+// generationFailed responds to a request whose passwords couldn't be
+// generated because of err.
+func generationFailed(w http.ResponseWriter, req *http.Request, err error) {
+	if req.Context().Err() != nil {
+		// The client has gone away or the request timed out.
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	var perr *patternError
+	if errors.As(err, &perr) && isStrict(req) {
+		p := paramProblem(req, "pattern", req.FormValue("pattern"), err.Error())
+		p.Position = perr.pos
+		writeProblem(w, p)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func counterHandler(w http.ResponseWriter, req *http.Request) {
+	n, rounded, ok := counter.forRequest(req)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	result := counterResult{Counter: n, Rounded: rounded}
+	if !rounded {
+		var uptime time.Duration
+		result.Endpoints, uptime = counter.breakdown()
+		result.Uptime = math.Round(uptime.Seconds()*1000) / 1000
+	}
+	render(w, req, result)
+}
+
+// formatEndpoint names /password.txt by the format passwords are requested
+// in, e.g. "txt" or "json", for the counter's breakdown.
+func formatEndpoint(req *http.Request) string {
+	format, _ := negotiateFormat(req)
+	if format == "text" {
+		return "txt"
+	}
+	return format
 }
 
 func saveCounter() {
@@ -154,21 +702,58 @@ func saveCounter() {
 	var err error
 
 	if _, err = counterFile.Seek(0, 0); err == nil {
-		if _, err = fmt.Fprint(counterFile, counter); err == nil {
-			err = counterFile.Sync()
+		if err = counterFile.Truncate(0); err == nil {
+			if _, err = fmt.Fprint(counterFile, counter.value()); err == nil {
+				err = counterFile.Sync()
+			}
 		}
 	}
 	if err != nil {
 		// Complain, but doesn't seem worth bailing at this point.
 		log.Print("Failed to write counter:", err)
 	}
+	if err = stats.save(statsPath()); err != nil {
+		log.Print("Failed to write stats:", err)
+	}
 }
 
 func handleSignals() {
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Kill, os.Interrupt)
-	<-sigChan
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if logFile != nil {
+			if err := logFile.reopen(); err != nil {
+				log.Printf("Failed to reopen the log file: %s", err)
+			}
+		}
+		if *configPath == "" {
+			if logFile == nil {
+				log.Print("Ignoring SIGHUP, no -config file to reload")
+			}
+			continue
+		}
+		sdNotify("RELOADING=1")
+		err := reloadConfig()
+		sdNotify("READY=1")
+		if err != nil {
+			log.Printf("Failed to reload config, keeping the current settings: %s", err)
+			continue
+		}
+		log.Printf("Reloaded config from %s", *configPath)
+	}
+	sdNotify("STOPPING=1")
 	saveCounter()
+	if tracer != nil {
+		tracer.flush()
+	}
+	if counterFile != nil && rateLimitEnabled() {
+		if err := limiter.save(limiterPath()); err != nil {
+			log.Print("Failed to write rate limiter state:", err)
+		}
+	}
 	os.Exit(0)
 }
 
@@ -181,72 +766,199 @@ func defaultAddr() string {
 	return ":8080"
 }
 
-func init() {
-	var err error
+var templateFuncs = map[string]interface{}{
+	"percent": func(f float64) string {
+		return fmt.Sprintf("%.0f%%", f*100)
+	},
+	"sparkline": sparkline,
+	"crackTime": func(s *strengthEstimate) string {
+		return s.crackTime("offline_slow_hash").Display
+	},
+}
 
-	// Parse optional on-disk index file.
-	if index, err = template.ParseFiles("./index.html"); err != nil {
-		log.Println(err)
-		log.Println("Using default template")
-		index = template.Must(template.New("index").Parse(indexHtml))
+// sparkline returns the points of an SVG polyline plotting counts in a 100x20
+// box.
+func sparkline(counts []uint64) string {
+	var max uint64 = 1
+	for _, n := range counts {
+		if n > max {
+			max = n
+		}
 	}
-
-	rand.Seed(time.Now().UnixNano())
+	points := make([]string, len(counts))
+	for i, n := range counts {
+		x := 0.0
+		if len(counts) > 1 {
+			x = float64(i) * 100 / float64(len(counts)-1)
+		}
+		y := 20 - float64(n)*20/float64(max)
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return strings.Join(points, " ")
 }
 
 var indexHtml = `
 <!doctype html>
-<html>
+<html lang="en">
 <head>
 	<meta charset="UTF-8">
-	<title>Random Password Please</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="color-scheme" content="light dark">
+	<title>{{.Title}}</title>
 	<style type="text/css">
+		:root {
+			--fg: {{.TextColor}};
+			--bg: {{.BackgroundColor}};
+			--accent: {{.ThemeColor}};
+		}
+		@media (prefers-color-scheme: dark) {
+			:root {
+				--fg: {{.DarkTextColor}};
+				--bg: {{.DarkBackgroundColor}};
+			}
+		}
 		body {
 			font-size: 18px;
+			color: var(--fg);
+			background-color: var(--bg);
+		}
+		a {
+			color: var(--accent);
+		}
+		.logo {
+			max-height: 80px;
+		}
+		#passwords {
+			list-style: none;
+			padding: 0;
+		}
+		.candidate {
+			margin: 8px 0;
+		}
+		.password {
+			font-size: 28px;
+			font-weight: bold;
+			font-family: "DejaVu Sans Mono", "Cascadia Mono", Menlo, Consolas, monospace;
+			font-variant-numeric: slashed-zero;
+			letter-spacing: 0.08em;
+			overflow-wrap: anywhere;
+			padding: 4px 8px;
+			border-radius: 4px;
+		}
+		.usability > span {
+			margin: 0 8px;
+		}
+		.password .digit {
+			color: var(--accent);
+		}
+		.masked .password, .masked .password .digit {
+			color: transparent;
+			user-select: none;
+		}
+		.masked .password {
+			position: relative;
+		}
+		.masked .password::after {
+			content: "\2022\2022\2022\2022\2022\2022\2022\2022";
+			color: var(--fg);
+			position: absolute;
+			left: 8px;
+			top: 4px;
+		}
+		.preview .password {
+			opacity: 0.6;
 		}
 		.slider {
 			width: 50%;
+			min-width: 200px;
+			height: 44px;
+			accent-color: var(--accent);
+		}
+		.sparkline {
+			vertical-align: middle;
+			color: var(--accent);
+		}
+		.copied {
+			transition: background-color 0.2s;
+			background-color: rgba(128, 200, 128, 0.4);
+		}
+		button {
+			color: #fff;
+			background-color: var(--accent);
+			border: none;
+			border-radius: 4px;
+			min-height: 44px;
+			min-width: 44px;
+			padding: 8px 16px;
+			margin: 4px;
+			font-size: 16px;
+		}
+		select {
+			min-height: 44px;
+			font-size: 16px;
+		}
+		button:focus-visible, .slider:focus-visible, select:focus-visible, a:focus-visible {
+			outline: 3px solid var(--accent);
+			outline-offset: 2px;
 		}
 	</style>
 </head>
 <body>
-	<div style="text-align: center">
-		<p>Your random password is:</p>
-		<h1 id="password">{{.Password}}</h1>
-		<input type="range" min="8" max="30" value="12" class="slider" id="slider">
-		<p><span id="length-label">12</span> characters</p>
-		<button id="button">Another Password Please</button>
-		<p><span id="counter">{{.Counter}}</span> passwords generated</p>
-		<p>
-				<a href="https://github.com/jbarham/random-password-please">Source</a> | <attr title="{{.Host}}/password.txt?len=n where n = 8-30">API</attr>
+	<main style="text-align: center">
+		{{if .LogoURL}}<img src="{{.LogoURL}}" alt="{{.Title}}" class="logo">{{end}}
+		<p id="password-label">{{if eq (len .Passwords) 1}}Your random password is:{{else}}Pick a random password:{{end}}</p>
+		<ul id="passwords" aria-labelledby="password-label" aria-live="polite" data-count="{{len .Passwords}}"{{if .AmbiguousChars}} data-distinguish="true"{{end}} data-alphabet="{{.Alphabet}}" data-remask-after="{{.RemaskAfter}}">
+			{{range .Passwords}}<li class="candidate{{if $.Masked}} masked{{end}}">
+				<span class="password">{{.Password}}</span>
+				<span class="usability" aria-label="Usability">
+					<span title="Ease of typing on a phone" aria-label="Typing">&#x1F4F1; <span class="usability-typing">{{percent .Usability.Typing}}</span></span>
+					<span title="Memorability" aria-label="Memorability">&#x1F9E0; <span class="usability-memory">{{percent .Usability.Memory}}</span></span>
+					<span title="Ease of reading out" aria-label="Dictation">&#x1F5E3; <span class="usability-dictation">{{percent .Usability.Dictation}}</span></span>
+					<span title="Time to crack if a slowly hashed copy is stolen" aria-label="Time to crack">&#x23F1; <span class="crack-time">{{crackTime .Strength}}</span></span>
+				</span>
+				<button class="reveal" aria-label="{{if $.Masked}}Show{{else}}Hide{{end}} password">{{if $.Masked}}Show{{else}}Hide{{end}}</button>
+				<button class="copy" aria-label="Copy password to clipboard">Copy</button>
+			</li>
+			{{end}}
+		</ul>
+		<div id="length-controls"{{if .Passphrase}} hidden{{end}}>
+			<input type="range" min="{{.MinLength}}" max="{{.MaxLength}}" value="{{.Length}}" class="slider" id="slider" aria-label="Password length" aria-describedby="length-description">
+			<p id="length-description"><span id="length-label">{{.Length}}</span> characters</p>
+			<p><label><input type="checkbox" id="mobile"{{if .Mobile}} checked{{end}}> Easy to type on a phone</label></p>
+			<p><label>Characters <select id="charset"{{if .Mobile}} disabled{{end}}>{{range .Charsets}}
+				<option value="{{.Name}}" data-chars="{{.Chars}}"{{if .Ambiguous}} data-ambiguous="true"{{end}}{{if eq .Name $.Charset}} selected{{end}}>{{.Label}}</option>{{end}}
+			</select></label></p>
+		</div>
+		<div id="passphrase-controls"{{if not .Passphrase}} hidden{{end}}>
+			<input type="range" min="{{.MinWords}}" max="{{.MaxWords}}" value="{{.Words}}" class="slider" id="words" aria-label="Number of words" aria-describedby="words-description">
+			<p id="words-description"><span id="words-label">{{.Words}}</span> words</p>
+			<p>
+				<label>Separator <select id="separator">{{range .Separators}}
+					<option value="{{.Name}}"{{if eq .Name $.Separator}} selected{{end}}>{{.Label}}</option>{{end}}
+				</select></label>
+				<label><input type="checkbox" id="capitalize"{{if .Capitalize}} checked{{end}}> Capitalize words</label>
+			</p>
+		</div>
+		<p><label><input type="checkbox" id="passphrase"{{if .Passphrase}} checked{{end}}> Passphrase</label>
+			<label><input type="checkbox" id="mask"{{if .Masked}} checked{{end}}> Hide passwords</label></p>
+		<button id="button" title="Shortcut: r" aria-keyshortcuts="r">{{if eq (len .Passwords) 1}}Another Password Please{{else}}More Passwords Please{{end}}</button>
+		<p id="refresh-status" role="status"></p>
+		{{if .Counter}}
+		<p>{{if .CounterRounded}}About {{end}}<span id="counter">{{.Counter}}</span> passwords generated
+			{{if .Hourly}}
+			<svg class="sparkline" viewBox="0 0 100 20" width="100" height="20" preserveAspectRatio="none" role="img" aria-label="Passwords generated per hour over the last day">
+				<polyline points="{{sparkline .Hourly}}" fill="none" stroke="currentColor" stroke-width="1.5" vector-effect="non-scaling-stroke"/>
+			</svg>
+			{{end}}
 		</p>
-	</div>
-	<script src="https://code.jquery.com/jquery-3.4.1.min.js"></script>
-	<script type="text/javascript">
-		$(document).ready(function() {
-			function getNewPassword() {
-				/* Load new password via API. */
-				$('#password').load('/password.txt?len=' + $('#slider').val());
-				$('#counter').load('/counter');
-			};
-
-			$('#slider').on("input", function(event) {
-				var val = $(event.target).val();
-				$('#length-label').html(val);
-			});
-
-			$('#slider').change(function(event) {
-				var val = $(event.target).val();
-				$('#length-label').html(val);
-				getNewPassword();
-			});
-
-			$('#button').click(function(event) {
-				event.preventDefault();
-				getNewPassword();
-			});
-		});
-	</script>
+		{{end}}
+		<nav aria-label="Links">
+			<p>
+				{{range .FooterLinks}}<a href="{{.URL}}">{{.Label}}</a> | {{end}}<abbr title="{{.Host}}/password.txt?len=n where n = {{.MinLength}}-{{.MaxLength}}">API</abbr>
+			</p>
+		</nav>
+	</main>
+	<script src="/static/app.js"></script>
 </body>
 </html>
 `
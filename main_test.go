@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apiclient "github.com/jbarham/random-password-please/client"
+)
+
+// The tests exercise a server with the default configuration. Flags, the
+// counter and the rate limiter are package state, so tests that change a
+// flag restore it, and none run in parallel.
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if err := validateConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadPageTemplate(); err != nil {
+		log.Fatal(err)
+	}
+	atomic.StoreInt32(&bufferedLength, int32(*maxPasswordLength))
+	generatePasswords(systemRandomness{})
+	go commitments.expire(time.Minute)
+
+	// Wait for the generator to fill its buffer.
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		readyHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rec.Code == http.StatusOK {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	os.Exit(m.Run())
+}
+
+// setFlag sets the flag name to value for the rest of the test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	old := f.Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, old) })
+}
+
+// do serves a request to h, with form as its body if it is set.
+func do(h http.Handler, method, target string, form url.Values) *httptest.ResponseRecorder {
+	if method == "" {
+		method = http.MethodGet
+	}
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func bodyContains(s string) func(string) error {
+	return func(body string) error {
+		if !strings.Contains(body, s) {
+			return fmt.Errorf("response doesn't contain %q", s)
+		}
+		return nil
+	}
+}
+
+func bodyMatches(pattern string) func(string) error {
+	re := regexp.MustCompile(pattern)
+	return func(body string) error {
+		if !re.MatchString(body) {
+			return fmt.Errorf("response doesn't match %s", pattern)
+		}
+		return nil
+	}
+}
+
+func passwordLength(length int) func(string) error {
+	return func(body string) error {
+		if n := len(strings.TrimSpace(body)); n != length {
+			return fmt.Errorf("got a password of length %d, want %d", n, length)
+		}
+		return nil
+	}
+}
+
+func validJSON(body string) error {
+	var v interface{}
+	return json.Unmarshal([]byte(body), &v)
+}
+
+// notCached checks that a response can't be cached, as noStore ensures.
+func notCached(h http.Header) error {
+	for name, value := range noStoreHeaders {
+		if got := h.Get(name); got != value {
+			return fmt.Errorf("%s is %q, want %q", name, got, value)
+		}
+	}
+	for _, name := range []string{"ETag", "Last-Modified"} {
+		if h.Get(name) != "" {
+			return fmt.Errorf("%s is set", name)
+		}
+	}
+	return nil
+}
+
+func TestRoutes(t *testing.T) {
+	ok := http.StatusOK
+	// FIPS mode refuses client entropy.
+	entropyWant, entropyCheck := ok, bodyMatches(`^[`+alphabet+`]+\n?$`)
+	if fipsMode() {
+		entropyWant, entropyCheck = http.StatusBadRequest, bodyContains(errEntropyFIPS.Error())
+	}
+	tests := []struct {
+		name   string
+		method string
+		target string
+		form   url.Values
+		want   int
+		// check, if set, checks the body of the response.
+		check func(body string) error
+	}{
+		{name: "page", target: "/", want: ok, check: bodyContains("<html")},
+		{name: "unknown page", target: "/no-such-page", want: http.StatusNotFound},
+		{name: "robots.txt", target: "/robots.txt", want: ok, check: bodyContains("User-agent: *")},
+		{name: "favicon", target: "/favicon.ico", want: ok, check: func(body string) error {
+			if len(body) == 0 {
+				return fmt.Errorf("empty icon")
+			}
+			return nil
+		}},
+		{name: "password", target: "/password.txt?len=12", want: ok, check: bodyMatches(`^[` + alphabet + `]{12}\n?$`)},
+		{name: "password batch as JSON", target: "/password.txt?count=3&verbose=1&format=json", want: ok, check: func(body string) error {
+			var batch struct {
+				Passwords []passwordResult `json:"passwords"`
+			}
+			if err := json.Unmarshal([]byte(body), &batch); err != nil {
+				return err
+			}
+			if len(batch.Passwords) != 3 || batch.Passwords[0].Strength == nil {
+				return fmt.Errorf("got %d passwords, want 3 with strength estimates", len(batch.Passwords))
+			}
+			return nil
+		}},
+		{name: "password as XML", target: "/password.txt?format=xml", want: ok, check: bodyContains("<password>")},
+		{name: "password as CSV", target: "/password.txt?format=csv", want: ok, check: bodyContains("password\n")},
+		{name: "mobile password", target: "/password.txt?mode=mobile", want: ok},
+		{name: "memorable password", target: "/password.txt?mode=memorable", want: ok},
+		{name: "pattern password", target: "/password.txt?pattern=" + url.QueryEscape("u{2}l{4}D{2}"), want: ok, check: bodyMatches(`^[A-Z]{2}[a-z]{4}[0-9]{2}\s*$`)},
+		{name: "password with client entropy", method: http.MethodPost, target: "/password.txt", form: url.Values{"entropy": {"test"}}, want: entropyWant, check: entropyCheck},
+		{name: "unknown wordlist", target: "/password.txt?mode=memorable&lang=xx", want: http.StatusBadRequest},
+		{name: "wordlists", target: "/wordlists?format=json", want: ok, check: bodyContains(`"lang":"en"`)},
+		{name: "invalid mode", target: "/password.txt?mode=nonsense", want: http.StatusBadRequest},
+		{name: "invalid pattern", target: "/password.txt?pattern=" + url.QueryEscape("{"), want: http.StatusBadRequest},
+		{name: "invalid format", target: "/password.txt?format=nonsense", want: http.StatusBadRequest},
+		{name: "token", target: "/token?bytes=16", want: ok, check: bodyMatches(`^[0-9a-f]{32}\s*$`)},
+		{name: "invalid token size", target: "/token?bytes=0", want: http.StatusBadRequest},
+		{name: "UUID", target: "/uuid?version=7", want: ok, check: bodyMatches(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\s*$`)},
+		{name: "invalid UUID version", target: "/uuid?version=5", want: http.StatusBadRequest},
+		{name: "strength", method: http.MethodPost, target: "/strength?format=json", form: url.Values{"password": {"password"}}, want: ok, check: bodyContains(`"score":0`)},
+		{name: "strength in URL", method: http.MethodPost, target: "/strength?password=password", want: http.StatusBadRequest},
+		{name: "strength by GET", target: "/strength", want: http.StatusMethodNotAllowed},
+		{name: "bulk passwords by GET", target: "/passwords/bulk", want: http.StatusMethodNotAllowed},
+		{name: "commit by GET", target: "/commit", want: http.StatusMethodNotAllowed},
+		{name: "reveal unknown commitment", method: http.MethodPost, target: "/reveal", form: url.Values{"id": {"unknown"}, "nonce": {"n"}}, want: http.StatusNotFound},
+		{name: "unknown rotation", method: http.MethodPost, target: "/rotations/confirm", form: url.Values{"rotation": {"unknown"}, "token": {"t"}}, want: http.StatusNotFound},
+		{name: "attestation key without -attestation-key", target: "/attestation-key.pem", want: http.StatusNotFound},
+		{name: "counter", target: "/counter", want: ok},
+		{name: "stats", target: "/stats", want: ok},
+		{name: "static script", target: "/static/app.js", want: ok},
+		{name: "unknown static file", target: "/static/nonsense.js", want: http.StatusNotFound},
+		{name: "metrics", target: "/metrics", want: ok, check: bodyContains(passwordsMetric.Name)},
+		{name: "monitoring description", target: "/.well-known/monitoring", want: ok, check: validJSON},
+		{name: "liveness", target: "/healthz", want: ok},
+		{name: "OpenAPI specification", target: "/openapi.json", want: ok, check: validJSON},
+	}
+	s := newServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(s, tt.method, tt.target, tt.form)
+			if rec.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.check != nil {
+				if err := tt.check(rec.Body.String()); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPasswordLength(t *testing.T) {
+	tests := []struct {
+		len  string
+		want int
+	}{
+		{"", *minPasswordLength},
+		{"x", *minPasswordLength},
+		{"1", *minPasswordLength},
+		{fmt.Sprint(*minPasswordLength), *minPasswordLength},
+		{"16", 16},
+		{fmt.Sprint(*maxPasswordLength), *maxPasswordLength},
+		{fmt.Sprint(*maxPasswordLength + 1), *maxPasswordLength},
+		{"1000000", *maxPasswordLength},
+	}
+	s := newServer()
+	for _, tt := range tests {
+		t.Run("len="+tt.len, func(t *testing.T) {
+			rec := do(s, "", "/password.txt?len="+tt.len, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", rec.Code)
+			}
+			if err := passwordLength(tt.want)(rec.Body.String()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	// In strict mode lengths out of range are refused instead.
+	for _, n := range []int{*minPasswordLength - 1, *maxPasswordLength + 1} {
+		if rec := do(s, "", fmt.Sprintf("/password.txt?strict=1&len=%d", n), nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("len=%d in strict mode got status %d, want 400", n, rec.Code)
+		}
+	}
+	// len always counts ASCII characters, so there is no length-unit to
+	// choose, and strict mode says so rather than ignoring it.
+	if rec := do(s, "", "/password.txt?strict=1&len=12&length-unit=grapheme", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("length-unit in strict mode got status %d, want 400", rec.Code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	s := newServer()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := do(s, "", "/readyz", nil)
+		if rec.Code == http.StatusOK {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got status %d, want 200 once the buffer is filled", rec.Code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		count string
+		code  int
+		want  int
+	}{
+		{"1", http.StatusOK, 1},
+		{"3", http.StatusOK, 3},
+		{fmt.Sprint(*maxCount), http.StatusOK, *maxCount},
+		{"0", http.StatusBadRequest, 0},
+		{"-1", http.StatusBadRequest, 0},
+		{"x", http.StatusBadRequest, 0},
+		{fmt.Sprint(*maxCount + 1), http.StatusBadRequest, 0},
+	}
+	s := newServer()
+	for _, tt := range tests {
+		t.Run("count="+tt.count, func(t *testing.T) {
+			rec := do(s, "", "/password.txt?count="+tt.count, nil)
+			if rec.Code != tt.code {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			if n := len(strings.Fields(rec.Body.String())); n != tt.want {
+				t.Errorf("got %d passwords, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestCharset(t *testing.T) {
+	tests := []struct {
+		charset string
+		code    int
+	}{
+		{"unambiguous", http.StatusOK},
+		{"alnum", http.StatusOK},
+		{"ascii-printable", http.StatusOK},
+		{"safe", http.StatusOK},
+		{"hex", http.StatusOK},
+		{"base58", http.StatusOK},
+		{"nonsense", http.StatusBadRequest},
+		{"custom", http.StatusBadRequest},
+	}
+	s := newServer()
+	for _, tt := range tests {
+		t.Run("charset="+tt.charset, func(t *testing.T) {
+			rec := do(s, "", "/password.txt?len=30&charset="+tt.charset, nil)
+			if rec.Code != tt.code {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			chars := lookupCharset(tt.charset)
+			for _, c := range strings.TrimSpace(rec.Body.String()) {
+				if !strings.ContainsRune(chars, c) {
+					t.Errorf("%q isn't in charset %s", c, tt.charset)
+				}
+			}
+		})
+	}
+	t.Run("with a mode", func(t *testing.T) {
+		if rec := do(s, "", "/password.txt?charset=hex&mode=mobile", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, want 400", rec.Code)
+		}
+	})
+}
+
+// TestSecretsNotCached asks every secret route with a method it doesn't
+// accept, so that its errors are checked too.
+func TestSecretsNotCached(t *testing.T) {
+	s := newServer()
+	for _, r := range s.routes {
+		if !r.secret {
+			continue
+		}
+		for _, method := range []string{http.MethodGet, http.MethodDelete} {
+			rec := do(s, method, r.pattern, nil)
+			if err := notCached(rec.Header()); err != nil {
+				t.Errorf("%s %s: %s", method, r.pattern, err)
+			}
+		}
+	}
+}
+
+func TestCommitReveal(t *testing.T) {
+	s := newServer()
+	rec := do(s, http.MethodPost, "/commit?format=json", nil)
+	var c commitResult
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &c) != nil {
+		t.Fatalf("commit failed with status %d: %s", rec.Code, rec.Body)
+	}
+	form := url.Values{"id": {c.ID}, "nonce": {"test"}, "pick": {"10"}}
+	rec = do(s, http.MethodPost, "/reveal?format=json", form)
+	var r revealResult
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &r) != nil {
+		t.Fatalf("reveal failed with status %d: %s", rec.Code, rec.Body)
+	}
+	if r.Commitment != c.Commitment || r.Pick == nil || *r.Pick >= 10 {
+		t.Errorf("revealed %+v doesn't match the commitment %+v", r, c)
+	}
+	if rec := do(s, http.MethodPost, "/reveal", form); rec.Code != http.StatusNotFound {
+		t.Errorf("revealed a second time with status %d, want 404", rec.Code)
+	}
+}
+
+// concurrentRequests is how many passwords TestConcurrentCount requests at
+// once.
+const concurrentRequests = 50
+
+func TestConcurrentCount(t *testing.T) {
+	ts := httptest.NewServer(newServer())
+	defer ts.Close()
+	before := counter.value()
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrentRequests)
+	for i := 0; i < concurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := ts.Client().Get(ts.URL + "/password.txt")
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				errs <- fmt.Errorf("got status %d", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if counted := counter.value() - before; counted != concurrentRequests {
+		t.Errorf("served %d passwords but counted %d", concurrentRequests, counted)
+	}
+}
+
+// TestGoClient calls the API with the client package, to check that the two
+// agree.
+func TestGoClient(t *testing.T) {
+	ts := httptest.NewServer(newServer())
+	defer ts.Close()
+	c := apiclient.New(ts.URL)
+	c.MaxRetries = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	password, err := c.Password(ctx, &apiclient.PasswordOptions{Length: *minPasswordLength})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(password) != *minPasswordLength {
+		t.Errorf("got password %q, want %d characters", password, *minPasswordLength)
+	}
+	passwords, err := c.Passwords(ctx, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passwords) != 2 {
+		t.Errorf("got %d passwords, want 2", len(passwords))
+	}
+	if _, err := c.UUIDs(ctx, 4, 1); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.Strength(ctx, "P@ssw0rd"); err != nil {
+		t.Error(err)
+	}
+	_, err = c.Token(ctx, 0, "")
+	if e, ok := err.(*apiclient.Error); !ok || e.StatusCode != http.StatusBadRequest {
+		t.Errorf("a token of 0 bytes failed with %v, want a 400 error", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+// Mobile mode minimises switching between keyboard layouts on phones, where
+// capitals need shift and digits and symbols are on a separate plane. Its
+// passwords are a capital (which phones type automatically at the start of a
+// field), a run of lowercase letters, then digits, then a symbol that is on
+// the first symbol plane of both the iOS and Android keyboards, so no long
+// presses are needed.
+//
+// The fixed structure costs some entropy compared to the default mode, so
+// callers wanting the same strength should ask for a slightly longer password.
+
+// mobileSymbols are on the iOS and Android "123" plane.
+const mobileSymbols = "-/:;()&@?!"
+
+// mobileLayout returns how many letters, digits and symbols a mobile mode
+// password of length n has.
+func mobileLayout(n int) (letters, digits, symbols int) {
+	// One symbol and about a quarter digits, leaving most of the password
+	// on the letter plane.
+	symbols = 1
+	digits = n / 4
+	if digits < 1 {
+		digits = 1
+	}
+	letters = n - digits - symbols
+	if letters < 1 {
+		// Too short for all classes: just use letters.
+		letters, digits, symbols = n, 0, 0
+	}
+	return letters, digits, symbols
+}
+
+func mobilePassword(n int) string {
+	letters, digits, symbols := mobileLayout(n)
+	password := make([]byte, 0, n)
+	password = append(password, alphabetUpper[randIntn(len(alphabetUpper))])
+	for i := 1; i < letters; i++ {
+		password = append(password, alphabetLower[randIntn(len(alphabetLower))])
+	}
+	for i := 0; i < digits; i++ {
+		password = append(password, alphabetDigits[randIntn(len(alphabetDigits))])
+	}
+	for i := 0; i < symbols; i++ {
+		password = append(password, mobileSymbols[randIntn(len(mobileSymbols))])
+	}
+	return string(password)
+}
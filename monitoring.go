@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Service level objectives advertised at /.well-known/monitoring so that
+// monitoring systems can set up alerts without manual configuration.
+var (
+	sloAvailability = flag.Float64("slo-availability", 0.999, "target `fraction` of requests served without a server error")
+	sloLatency      = flag.Duration("slo-latency", 250*time.Millisecond, "target 99th percentile request `latency`")
+)
+
+func init() {
+	registerFeature(feature{name: "Prometheus metrics", kind: "integration", version: "0.0.4", active: alwaysActive})
+}
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration
+// histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type metricInfo struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Help   string   `json:"help"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+var (
+	passwordsMetric  = metricInfo{"passwords_generated_total", "counter", "Passwords generated since the counter was created.", nil}
+	requestsMetric   = metricInfo{"http_requests_total", "counter", "HTTP requests by handler and status code.", []string{"handler", "code"}}
+	durationMetric   = metricInfo{"http_request_duration_seconds", "histogram", "HTTP request latency by handler.", []string{"handler"}}
+	randomMetric     = metricInfo{"random_bytes_total", "counter", "Bytes drawn from random number generators.", []string{"source"}}
+	intervalMetric   = metricInfo{"random_bytes_interval", "gauge", "Bytes drawn from random number generators in the last -entropy-interval.", []string{"source"}}
+	alertMetric      = metricInfo{"random_bytes_alert", "gauge", "Whether more than -entropy-alert bytes were drawn in the last -entropy-interval.", nil}
+	kernelMetric     = metricInfo{"kernel_entropy_available_bits", "gauge", "Bits of entropy in the kernel's pool, where reported.", nil}
+	acceptedMetric   = metricInfo{"http_connections_accepted_total", "counter", "Connections accepted.", nil}
+	openMetric       = metricInfo{"http_connections_open", "gauge", "Connections currently open.", nil}
+	reusedMetric     = metricInfo{"http_connections_reused_total", "counter", "Connections that served more than one request.", nil}
+	handshakeMetric  = metricInfo{"tls_handshakes_total", "counter", "TLS handshakes completed.", nil}
+	resumedMetric    = metricInfo{"tls_handshakes_resumed_total", "counter", "TLS handshakes that resumed a session.", nil}
+	historyMetric    = metricInfo{"password_history_size", "gauge", "Passwords remembered for -no-repeat-window.", nil}
+	repeatMetric     = metricInfo{"password_repeats_regenerated_total", "counter", "Passwords regenerated because they were issued within -no-repeat-window.", nil}
+	subscriberMetric = metricInfo{"stream_subscribers", "gauge", "Clients subscribed to event streams by topic.", []string{"topic"}}
+	publishedMetric  = metricInfo{"stream_events_published_total", "counter", "Events published to event streams by topic.", []string{"topic"}}
+	evictedMetric    = metricInfo{"stream_subscribers_evicted_total", "counter", "Subscribers evicted for falling behind by topic.", []string{"topic"}}
+	avoidedMetric    = metricInfo{"passwords_avoided_total", "counter", "Passwords regenerated for matching -avoid-walks or -breach-patterns, by check.", []string{"check"}}
+	challengeMetric  = metricInfo{"challenges_total", "counter", "Challenges POSTed by clients generating passwords rapidly, by result.", []string{"result"}}
+	streamedMetric   = metricInfo{"random_stream_bytes_total", "counter", "Random bytes sent by /random/stream.", nil}
+	streamingMetric  = metricInfo{"random_streams_open", "gauge", "/random/stream responses in progress.", nil}
+	generatorsMetric = metricInfo{"password_generators", "gauge", "Goroutines generating passwords ahead of requests.", nil}
+	bufferedMetric   = metricInfo{"passwords_buffered", "gauge", "Passwords generated ahead of requests and not yet issued.", nil}
+	waitsMetric      = metricInfo{"password_buffer_waits_total", "counter", "Requests that found the password buffer empty and waited for it.", nil}
+	waitedMetric     = metricInfo{"password_buffer_wait_seconds_total", "counter", "Time requests spent waiting for the password buffer.", nil}
+)
+
+type requestKey struct {
+	handler string
+	code    int
+}
+
+type histogram struct {
+	counts []uint64 // Cumulative counts for each of latencyBuckets.
+	sum    float64
+	count  uint64
+}
+
+// requestMetrics records the outcome of every HTTP request.
+type requestMetrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[string]*histogram
+}
+
+var metrics = &requestMetrics{
+	requests:  make(map[requestKey]uint64),
+	durations: make(map[string]*histogram),
+}
+
+func (m *requestMetrics) observe(handler string, code int, d time.Duration) {
+	seconds := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{handler, code}]++
+	h, ok := m.durations[handler]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.durations[handler] = h
+	}
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// statusRecorder remembers the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+	// identity is the authenticated client, if any.
+	identity string
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument records metrics for the requests served by mux, labelled with
+// the pattern of the handler that served them, logs and journals them if
+// configured and recovers from panics.
+func instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		req = withRequestID(w, req)
+		connections.request(req)
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		_, pattern := mux.Handler(req)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		req, span := startRequestSpan(req, pattern)
+		defer func() {
+			endRequestSpan(span, rec.code)
+			metrics.observe(pattern, rec.code, time.Since(start))
+			if *auditLog || *journalWindow > 0 {
+				e := newAuditEntry(req, pattern, rec, start)
+				if *auditLog {
+					logRequest(e)
+				}
+				if *journalWindow > 0 {
+					journal.add(e)
+				}
+			}
+		}()
+		defer recoverPanic(rec, req, pattern)
+		mux.ServeHTTP(rec, req)
+	})
+}
+
+func writeMetricHeader(w *strings.Builder, m metricInfo) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Type)
+}
+
+// metricsHandler serves metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, req *http.Request) {
+	var sb strings.Builder
+
+	writeMetricHeader(&sb, passwordsMetric)
+	fmt.Fprintf(&sb, "%s %d\n", passwordsMetric.Name, counter.value())
+
+	metrics.mu.Lock()
+	keys := make([]requestKey, 0, len(metrics.requests))
+	for k := range metrics.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		return keys[i].code < keys[j].code
+	})
+	writeMetricHeader(&sb, requestsMetric)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%s{handler=%q,code=\"%d\"} %d\n", requestsMetric.Name, k.handler, k.code, metrics.requests[k])
+	}
+
+	handlers := make([]string, 0, len(metrics.durations))
+	for handler := range metrics.durations {
+		handlers = append(handlers, handler)
+	}
+	sort.Strings(handlers)
+	writeMetricHeader(&sb, durationMetric)
+	for _, handler := range handlers {
+		h := metrics.durations[handler]
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(&sb, "%s_bucket{handler=%q,le=\"%g\"} %d\n", durationMetric.Name, handler, le, h.counts[i])
+		}
+		fmt.Fprintf(&sb, "%s_bucket{handler=%q,le=\"+Inf\"} %d\n", durationMetric.Name, handler, h.count)
+		fmt.Fprintf(&sb, "%s_sum{handler=%q} %g\n", durationMetric.Name, handler, h.sum)
+		fmt.Fprintf(&sb, "%s_count{handler=%q} %d\n", durationMetric.Name, handler, h.count)
+	}
+	metrics.mu.Unlock()
+
+	total, last, alerting := rngUsage.snapshot()
+	writeMetricHeader(&sb, randomMetric)
+	for _, source := range rngSources {
+		fmt.Fprintf(&sb, "%s{source=%q} %d\n", randomMetric.Name, source, total[source])
+	}
+	writeMetricHeader(&sb, intervalMetric)
+	for _, source := range rngSources {
+		fmt.Fprintf(&sb, "%s{source=%q} %d\n", intervalMetric.Name, source, last[source])
+	}
+	alert := 0
+	if alerting {
+		alert = 1
+	}
+	writeMetricHeader(&sb, alertMetric)
+	fmt.Fprintf(&sb, "%s %d\n", alertMetric.Name, alert)
+	if bits, ok := kernelEntropy(); ok {
+		writeMetricHeader(&sb, kernelMetric)
+		fmt.Fprintf(&sb, "%s %d\n", kernelMetric.Name, bits)
+	}
+	writeMetricHeader(&sb, streamedMetric)
+	fmt.Fprintf(&sb, "%s %d\n", streamedMetric.Name, atomic.LoadUint64(&randomStreamStats.bytes))
+	writeMetricHeader(&sb, streamingMetric)
+	fmt.Fprintf(&sb, "%s %d\n", streamingMetric.Name, atomic.LoadInt64(&randomStreamStats.open))
+	writeMetricHeader(&sb, generatorsMetric)
+	fmt.Fprintf(&sb, "%s %d\n", generatorsMetric.Name, cap(passwords)/passwordsPerGenerator)
+	writeMetricHeader(&sb, bufferedMetric)
+	fmt.Fprintf(&sb, "%s %d\n", bufferedMetric.Name, len(passwords))
+	writeMetricHeader(&sb, waitsMetric)
+	fmt.Fprintf(&sb, "%s %d\n", waitsMetric.Name, atomic.LoadUint64(&bufferWaits.count))
+	writeMetricHeader(&sb, waitedMetric)
+	fmt.Fprintf(&sb, "%s %g\n", waitedMetric.Name, time.Duration(atomic.LoadUint64(&bufferWaits.nanos)).Seconds())
+
+	conns := connections.snapshot()
+	for _, m := range []struct {
+		info  metricInfo
+		value uint64
+	}{
+		{acceptedMetric, conns.accepted},
+		{openMetric, conns.open},
+		{reusedMetric, conns.reused},
+		{handshakeMetric, conns.handshakes},
+		{resumedMetric, conns.resumed},
+	} {
+		writeMetricHeader(&sb, m.info)
+		fmt.Fprintf(&sb, "%s %d\n", m.info.Name, m.value)
+	}
+
+	size, regenerated := history.snapshot()
+	writeMetricHeader(&sb, historyMetric)
+	fmt.Fprintf(&sb, "%s %d\n", historyMetric.Name, size)
+	writeMetricHeader(&sb, repeatMetric)
+	fmt.Fprintf(&sb, "%s %d\n", repeatMetric.Name, regenerated)
+	avoidedCounts := avoidedSnapshot()
+	writeMetricHeader(&sb, avoidedMetric)
+	for _, check := range []string{avoidWalk, avoidBreach} {
+		fmt.Fprintf(&sb, "%s{check=%q} %d\n", avoidedMetric.Name, check, avoidedCounts[check])
+	}
+	passed, failed := challengeSnapshot()
+	writeMetricHeader(&sb, challengeMetric)
+	fmt.Fprintf(&sb, "%s{result=\"passed\"} %d\n", challengeMetric.Name, passed)
+	fmt.Fprintf(&sb, "%s{result=\"failed\"} %d\n", challengeMetric.Name, failed)
+
+	topics := streams.snapshot()
+	for _, m := range []struct {
+		info  metricInfo
+		value func(hubTopicSnapshot) uint64
+	}{
+		{subscriberMetric, func(t hubTopicSnapshot) uint64 { return t.subscribers }},
+		{publishedMetric, func(t hubTopicSnapshot) uint64 { return t.published }},
+		{evictedMetric, func(t hubTopicSnapshot) uint64 { return t.evicted }},
+	} {
+		writeMetricHeader(&sb, m.info)
+		for _, t := range topics {
+			fmt.Fprintf(&sb, "%s{topic=%q} %d\n", m.info.Name, t.topic, m.value(t))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, sb.String())
+}
+
+// healthHandler reports that the server is up.
+func healthHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintln(w, "ok")
+}
+
+// readyHandler reports whether the server is ready to generate passwords.
+func readyHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	if len(passwords) == 0 {
+		http.Error(w, "password buffer is empty", http.StatusServiceUnavailable)
+		return
+	}
+	if atomic.LoadInt32(&randomSourceBroken) == 1 {
+		http.Error(w, "-random-source can't be read", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+type healthEndpoint struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+type sloInfo struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Objective   float64 `json:"objective"`
+	Window      string  `json:"window"`
+	// Indicator is a PromQL expression for the fraction of good events.
+	Indicator string `json:"indicator"`
+}
+
+type monitoringDescription struct {
+	Service string `json:"service"`
+	Metrics struct {
+		Path   string       `json:"path"`
+		Format string       `json:"format"`
+		Items  []metricInfo `json:"items"`
+	} `json:"metrics"`
+	Health []healthEndpoint `json:"health"`
+	SLOs   []sloInfo        `json:"slos"`
+}
+
+// monitoringHandler describes the service's metrics, health endpoints and
+// objectives.
+func monitoringHandler(w http.ResponseWriter, req *http.Request) {
+	var d monitoringDescription
+	d.Service = "random-password-please"
+	d.Metrics.Path = "/metrics"
+	d.Metrics.Format = "prometheus"
+	d.Metrics.Items = []metricInfo{passwordsMetric, requestsMetric, durationMetric, randomMetric, intervalMetric, alertMetric, kernelMetric,
+		acceptedMetric, openMetric, reusedMetric, handshakeMetric, resumedMetric,
+		historyMetric, repeatMetric, avoidedMetric, challengeMetric, subscriberMetric, publishedMetric, evictedMetric, streamedMetric, streamingMetric,
+		generatorsMetric, bufferedMetric, waitsMetric, waitedMetric}
+	d.Health = []healthEndpoint{
+		{"/healthz", "liveness"},
+		{"/readyz", "readiness"},
+	}
+	d.SLOs = []sloInfo{
+		{
+			Name:        "availability",
+			Description: "Requests served without a server error.",
+			Objective:   *sloAvailability,
+			Window:      "30d",
+			Indicator:   `sum(rate(http_requests_total{code!~"5.."}[5m])) / sum(rate(http_requests_total[5m]))`,
+		},
+		{
+			Name:        "latency",
+			Description: fmt.Sprintf("Requests served within %s.", *sloLatency),
+			Objective:   0.99,
+			Window:      "30d",
+			Indicator: fmt.Sprintf(`sum(rate(http_request_duration_seconds_bucket{le="%g"}[5m])) / sum(rate(http_request_duration_seconds_count[5m]))`,
+				latencyBound(*sloLatency)),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(d)
+}
+
+// latencyBound returns the smallest histogram bucket bound covering d, so the
+// latency indicator can be computed from the histogram.
+func latencyBound(d time.Duration) float64 {
+	for _, le := range latencyBuckets {
+		if d.Seconds() <= le {
+			return le
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
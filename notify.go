@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// Once the server is listening on every address and has passwords ready to
+// serve, it logs a "ready" line with a JSON description of its listeners,
+// and tells systemd through $NOTIFY_SOCKET if it was started with
+// Type=notify. Container orchestrators can wait for either instead of
+// polling, and any misconfiguration exits with status 1 before it.
+
+// readyPollPeriod is how often the password buffer is checked at startup.
+const readyPollPeriod = 10 * time.Millisecond
+
+func init() {
+	registerFeature(feature{name: "systemd notification", kind: "integration", active: func() bool {
+		return os.Getenv("NOTIFY_SOCKET") != ""
+	}})
+}
+
+type readyEntry struct {
+	PID       int      `json:"pid"`
+	Addresses []string `json:"addresses"`
+}
+
+// announceReady waits until the server can generate passwords and then
+// reports that it is ready to serve on ls.
+func announceReady(ls []listener) {
+	for len(passwords) == 0 {
+		time.Sleep(readyPollPeriod)
+	}
+	e := readyEntry{PID: os.Getpid()}
+	for _, l := range ls {
+		scheme := "http"
+		if l.tls {
+			scheme = "https"
+		}
+		if l.Addr().Network() == "unix" {
+			scheme = "unix"
+		}
+		e.Addresses = append(e.Addresses, scheme+"://"+l.Addr().String())
+	}
+	if data, err := json.Marshal(e); err == nil {
+		log.Printf("ready %s", data)
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("Failed to notify systemd: %s", err)
+	}
+}
+
+// sdNotify sends state to systemd's notification socket, if there is one.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	if path[0] == '@' {
+		// An abstract socket.
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
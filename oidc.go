@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcAuth accepts requests carrying an OpenID Connect ID token from the
+// configured issuer as a bearer token. Tokens must be signed with RS256 or
+// ES256 using one of the keys published by the issuer.
+type oidcAuth struct {
+	issuer, audience string
+	client           *http.Client
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+	// The keys aren't refetched before nextFetch, which backs off while
+	// fetches fail; failures counts the failed fetches in a row and
+	// fetchErr is the last one's error.
+	nextFetch time.Time
+	failures  int
+	fetchErr  error
+	// fetching is closed once the fetch in progress, if any, is done, so
+	// that concurrent requests with unknown keys share one fetch.
+	fetching chan struct{}
+}
+
+// oidcRefreshInterval limits how often the issuer's keys are refetched when
+// a token is signed with an unknown key. After failures the interval is
+// doubled for each failure in a row, up to oidcMaxBackoff.
+const (
+	oidcRefreshInterval = time.Minute
+	oidcMaxBackoff      = 30 * time.Minute
+)
+
+// oidcLeeway allows for clock skew when checking token lifetimes.
+const oidcLeeway = time.Minute
+
+func newOIDCAuth(issuer, audience string) (*oidcAuth, error) {
+	if issuer == "" || audience == "" {
+		return nil, errors.New("-oidc-issuer and -oidc-audience are required")
+	}
+	a := &oidcAuth{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		audience: audience,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := a.fetchKeys(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *oidcAuth) getJSON(url string, v interface{}) error {
+	resp, err := a.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// fetchKeys fetches the issuer's signing keys using OpenID Connect
+// discovery, and sets when they may next be fetched.
+func (a *oidcAuth) fetchKeys() error {
+	keys, err := a.discoverKeys()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fetchErr = err
+	if err != nil {
+		a.failures++
+		backoff := oidcMaxBackoff
+		if a.failures < 16 && oidcRefreshInterval<<(a.failures-1) < backoff {
+			backoff = oidcRefreshInterval << (a.failures - 1)
+		}
+		a.nextFetch = time.Now().Add(backoff)
+		return err
+	}
+	a.keys, a.failures = keys, 0
+	a.nextFetch = time.Now().Add(oidcRefreshInterval)
+	return nil
+}
+
+func (a *oidcAuth) discoverKeys() (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := a.getJSON(a.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != a.issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q", discovery.Issuer)
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := a.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		// Skip keys we can't use; the issuer may publish others.
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("issuer publishes no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (a *oidcAuth) key(kid string) (crypto.PublicKey, error) {
+	a.mu.Lock()
+	key, ok := a.keys[kid]
+	a.mu.Unlock()
+	if !ok {
+		// The issuer may have rotated its keys.
+		a.refetch()
+		a.mu.Lock()
+		key, ok = a.keys[kid]
+		err := a.fetchErr
+		a.mu.Unlock()
+		if !ok && err != nil {
+			return nil, fmt.Errorf("unknown signing key %q, and fetching the issuer's keys failed: %s", kid, err)
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// refetch fetches the keys unless they were fetched too recently, or waits
+// for the fetch in progress.
+func (a *oidcAuth) refetch() {
+	a.mu.Lock()
+	done := a.fetching
+	if done != nil {
+		a.mu.Unlock()
+		<-done
+		return
+	}
+	if time.Now().Before(a.nextFetch) {
+		a.mu.Unlock()
+		return
+	}
+	done = make(chan struct{})
+	a.fetching = done
+	a.mu.Unlock()
+
+	a.fetchKeys()
+	a.mu.Lock()
+	a.fetching = nil
+	a.mu.Unlock()
+	close(done)
+}
+
+// audience is the aud claim, which is either a string or an array.
+type audience []string
+
+// claimBool is a boolean claim, which some issuers send as a string.
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*b = s == "true"
+		return nil
+	}
+	return json.Unmarshal(data, (*bool)(b))
+}
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*aud = audience{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(aud))
+}
+
+func (a *oidcAuth) authenticate(req *http.Request) (string, error) {
+	token := bearerToken(req)
+	if token == "" {
+		return "", errors.New("missing bearer token")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed token signature")
+	}
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return "", fmt.Errorf("unsupported algorithm %q", header.Alg)
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return "", errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" {
+			return "", fmt.Errorf("unsupported algorithm %q", header.Alg)
+		}
+		if len(sig) != 64 {
+			return "", errors.New("invalid token signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return "", errors.New("invalid token signature")
+		}
+	}
+
+	var claims struct {
+		Issuer        string    `json:"iss"`
+		Subject       string    `json:"sub"`
+		Email         string    `json:"email"`
+		EmailVerified claimBool `json:"email_verified"`
+		Audience      audience  `json:"aud"`
+		Expires       int64     `json:"exp"`
+		NotBefore     int64     `json:"nbf"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != a.issuer:
+		return "", errors.New("token is from another issuer")
+	case !contains(claims.Audience, a.audience):
+		return "", errors.New("token is for another audience")
+	case now.After(time.Unix(claims.Expires, 0).Add(oidcLeeway)):
+		return "", errors.New("token has expired")
+	case claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-oidcLeeway)):
+		return "", errors.New("token is not valid yet")
+	}
+	// An unverified email may belong to someone else, so the client is only
+	// identified by it once the issuer has verified it.
+	if claims.Email != "" && claims.EmailVerified {
+		return claims.Email, nil
+	}
+	return claims.Subject, nil
+}
+
+func (a *oidcAuth) challenge() string {
+	return `Bearer realm="` + a.issuer + `"`
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
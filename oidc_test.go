@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIssuer is an OpenID Connect issuer publishing one ES256 key.
+type testIssuer struct {
+	*httptest.Server
+	key *ecdsa.PrivateKey
+	// fetches counts the JWKS fetches, and failing makes them fail.
+	fetches int32
+	failing int32
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&iss.fetches, 1)
+		if atomic.LoadInt32(&iss.failing) != 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		enc := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{Kty: "EC", Kid: "k1", Crv: "P-256",
+			X: enc.EncodeToString(pad32(key.X)),
+			Y: enc.EncodeToString(pad32(key.Y))}}})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// token signs claims, adding the issuer, audience and expiry.
+func (iss *testIssuer) token(t *testing.T, kid string, claims map[string]interface{}) string {
+	claims["iss"], claims["aud"], claims["exp"] = iss.URL, "test", time.Now().Add(time.Hour).Unix()
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(cryptorand.Reader, iss.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(pad32(r), pad32(s)...)
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest("GET", "/password.txt", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestOIDCIdentity(t *testing.T) {
+	iss := newTestIssuer(t)
+	a, err := newOIDCAuth(iss.URL, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		claims map[string]interface{}
+		want   string
+	}{
+		{"verified email", map[string]interface{}{"sub": "123", "email": "a@example.com", "email_verified": true}, "a@example.com"},
+		{"verified email as string", map[string]interface{}{"sub": "123", "email": "a@example.com", "email_verified": "true"}, "a@example.com"},
+		{"unverified email", map[string]interface{}{"sub": "123", "email": "a@example.com", "email_verified": false}, "123"},
+		{"email without email_verified", map[string]interface{}{"sub": "123", "email": "a@example.com"}, "123"},
+		{"no email", map[string]interface{}{"sub": "123"}, "123"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := a.authenticate(bearerRequest(iss.token(t, "k1", tc.claims)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("identity %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOIDCRefetch(t *testing.T) {
+	iss := newTestIssuer(t)
+	a, err := newOIDCAuth(iss.URL, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bogus := iss.token(t, "bogus", map[string]interface{}{"sub": "123"})
+	fetches := func() int32 {
+		return atomic.LoadInt32(&iss.fetches)
+	}
+
+	// Within the refresh interval unknown keys don't refetch.
+	if _, err := a.authenticate(bearerRequest(bogus)); err == nil {
+		t.Fatal("token with an unknown key accepted")
+	}
+	if n := fetches(); n != 1 {
+		t.Fatalf("%d fetches, want 1", n)
+	}
+
+	// Once it has passed, concurrent requests share one fetch.
+	a.mu.Lock()
+	a.nextFetch = time.Time{}
+	a.mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.authenticate(bearerRequest(bogus))
+		}()
+	}
+	wg.Wait()
+	if n := fetches(); n != 2 {
+		t.Fatalf("%d fetches, want 2", n)
+	}
+
+	// A failed fetch backs off further fetches.
+	atomic.StoreInt32(&iss.failing, 1)
+	a.mu.Lock()
+	a.nextFetch = time.Time{}
+	a.mu.Unlock()
+	for i := 0; i < 5; i++ {
+		a.authenticate(bearerRequest(bogus))
+	}
+	if n := fetches(); n != 3 {
+		t.Fatalf("%d fetches, want 3", n)
+	}
+	a.mu.Lock()
+	backoff := time.Until(a.nextFetch)
+	a.mu.Unlock()
+	if backoff <= 0 || backoff > oidcRefreshInterval {
+		t.Errorf("next fetch in %s after one failure, want up to %s", backoff, oidcRefreshInterval)
+	}
+
+	// Known keys keep working meanwhile.
+	if _, err := a.authenticate(bearerRequest(iss.token(t, "k1", map[string]interface{}{"sub": "123"}))); err != nil {
+		t.Error(err)
+	}
+}
+
+// pad32 encodes a P-256 coordinate or signature half in 32 bytes.
+func pad32(n *big.Int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, 32-len(b)), b...)
+}
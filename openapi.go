@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The OpenAPI specification at /openapi.json is generated from the
+// registered routes and the current authentication settings, so it always
+// matches what the server accepts. -docs adds a Swagger UI page for it at
+// /docs, loaded from -swagger-ui since the page's scripts aren't served
+// from the binary.
+var (
+	docsPage     = flag.Bool("docs", false, "serve Swagger UI for the OpenAPI specification at /docs")
+	swaggerUIURL = flag.String("swagger-ui", "https://unpkg.com/swagger-ui-dist@5", "base `url` Swagger UI's script and stylesheet are loaded from")
+)
+
+// apiVersion is the version of the API described by the specification.
+const apiVersion = "1.0.0"
+
+func init() {
+	registerFeature(feature{name: "OpenAPI", kind: "integration", version: "3.1.0", active: alwaysActive})
+	registerFeature(feature{name: "Swagger UI", kind: "integration", active: func() bool {
+		return *docsPage
+	}})
+}
+
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components struct {
+		SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes,omitempty"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string        `json:"name"`
+	In          string        `json:"in"`
+	Description string        `json:"description,omitempty"`
+	Required    bool          `json:"required,omitempty"`
+	Schema      openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Type       string                   `json:"type,omitempty"`
+	Enum       []interface{}            `json:"enum,omitempty"`
+	Properties map[string]openAPISchema `json:"properties,omitempty"`
+	Required   []string                 `json:"required,omitempty"`
+	// Description is only used for properties.
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema,omitempty"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPISecurityScheme struct {
+	Type             string `json:"type"`
+	Description      string `json:"description,omitempty"`
+	In               string `json:"in,omitempty"`
+	Name             string `json:"name,omitempty"`
+	OpenIDConnectURL string `json:"openIdConnectUrl,omitempty"`
+}
+
+// openAPISecuritySchemes describe the authentication schemes.
+func openAPISecuritySchemes() map[string]openAPISecurityScheme {
+	return map[string]openAPISecurityScheme{
+		"apikey": {Type: "apiKey", In: "header", Name: "X-API-Key",
+			Description: "An API key, which may instead be sent as a bearer token."},
+		"hmac": {Type: "apiKey", In: "header", Name: "X-Signature",
+			Description: "Hex HMAC-SHA256 of the X-Timestamp header, method, request URI and hex SHA-256 of the body, " +
+				"separated by newlines, using the secret named by the X-Key-Id header."},
+		"mtls": {Type: "mutualTLS", Description: "A client certificate issued by a trusted CA."},
+		"oidc": {Type: "openIdConnect", OpenIDConnectURL: strings.TrimSuffix(*oidcIssuer, "/") + "/.well-known/openid-configuration"},
+	}
+}
+
+// renderedTypes are the media types rendered results can be returned as.
+var renderedTypes = []string{"text/plain", "application/json", "application/xml", "text/csv", "application/yaml"}
+
+// operationID returns an identifier such as getPasswordTxt for method and
+// pattern.
+func operationID(method, pattern string) string {
+	id := strings.ToLower(method)
+	words := strings.FieldsFunc(pattern, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		words = []string{"index"}
+	}
+	for _, w := range words {
+		id += strings.ToUpper(w[:1]) + w[1:]
+	}
+	return id
+}
+
+func (p param) schema() openAPISchema {
+	s := openAPISchema{Type: p.typ}
+	for _, v := range p.enum {
+		if n, err := strconv.Atoi(v); err == nil && p.typ == "integer" {
+			s.Enum = append(s.Enum, n)
+		} else {
+			s.Enum = append(s.Enum, v)
+		}
+	}
+	return s
+}
+
+// operation describes r when called with method.
+func (r route) operation(method string) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: operationID(method, r.pattern),
+		Summary:     r.summary,
+		Responses:   make(map[string]openAPIResponse),
+	}
+	if r.group != "" {
+		op.Tags = []string{r.group}
+	}
+	params := r.params
+	if r.rendered {
+		params = append(params, param{name: "format", in: "query", typ: "string",
+			description: "response format, overriding the Accept header", enum: []string{"text", "json", "xml", "csv", "yaml"}})
+	}
+	if r.rateLimited && *maxQueueWait > 0 {
+		params = append(params, param{name: "wait", in: "query", typ: "integer",
+			description: "seconds to wait for a turn if rate limited, like a Prefer: wait= header"})
+	}
+
+	form := openAPISchema{Type: "object", Properties: make(map[string]openAPISchema)}
+	for _, p := range params {
+		if p.in == "form" {
+			s := p.schema()
+			s.Description = p.description
+			form.Properties[p.name] = s
+			if p.required {
+				form.Required = append(form.Required, p.name)
+			}
+			continue
+		}
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:        p.name,
+			In:          p.in,
+			Description: p.description,
+			Required:    p.required,
+			Schema:      p.schema(),
+		})
+	}
+	if len(form.Properties) > 0 && method != http.MethodGet {
+		op.RequestBody = &openAPIRequestBody{
+			Required: len(form.Required) > 0,
+			Content:  map[string]openAPIMediaType{"application/x-www-form-urlencoded": {Schema: &form}},
+		}
+	}
+
+	switch {
+	case r.rendered:
+		content := make(map[string]openAPIMediaType)
+		for _, t := range renderedTypes {
+			content[t] = openAPIMediaType{}
+		}
+		op.Responses["200"] = openAPIResponse{Description: "OK", Content: content}
+	case r.contentType != "":
+		op.Responses["200"] = openAPIResponse{Description: "OK", Content: map[string]openAPIMediaType{r.contentType: {}}}
+	default:
+		op.Responses["204"] = openAPIResponse{Description: "No Content"}
+	}
+	if len(params) > 0 {
+		op.Responses["400"] = openAPIResponse{Description: "Invalid parameters"}
+	}
+	if scheme := authSchemes[r.group]; scheme != "" && scheme != "none" {
+		op.Security = []map[string][]string{{scheme: {}}}
+		op.Responses["401"] = openAPIResponse{Description: "Unauthorized"}
+	}
+	if r.rateLimited && rateLimitEnabled() {
+		op.Responses["429"] = openAPIResponse{Description: "Rate limited",
+			Content: map[string]openAPIMediaType{"application/problem+json": {}}}
+	}
+	return op
+}
+
+// openAPISpec returns the specification of the server's routes.
+func (s *server) openAPISpec() openAPIDocument {
+	var d openAPIDocument
+	d.OpenAPI = "3.1.0"
+	d.Info.Title = *pageTitle
+	d.Info.Version = apiVersion
+	d.Paths = make(map[string]map[string]*openAPIOperation)
+	schemes := openAPISecuritySchemes()
+	used := make(map[string]openAPISecurityScheme)
+	for _, r := range s.routes {
+		if r.undocumented || routeDisabled(r.pattern) {
+			continue
+		}
+		methods := r.methods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet}
+		}
+		ops := make(map[string]*openAPIOperation)
+		for _, m := range methods {
+			ops[strings.ToLower(m)] = r.operation(m)
+		}
+		d.Paths[r.pattern] = ops
+		if scheme, ok := schemes[authSchemes[r.group]]; ok {
+			used[authSchemes[r.group]] = scheme
+		}
+	}
+	if len(used) > 0 {
+		d.Components.SecuritySchemes = used
+	}
+	return d
+}
+
+func (s *server) openAPIHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.openAPISpec())
+}
+
+// docsHandler serves Swagger UI for the specification.
+func docsHandler(w http.ResponseWriter, req *http.Request) {
+	base := strings.TrimSuffix(*swaggerUIURL, "/")
+	if csp := w.Header().Get("Content-Security-Policy"); csp != "" {
+		if u, err := url.Parse(base); err == nil && u.Host != "" {
+			w.Header().Set("Content-Security-Policy", cspAllowing(csp, u.Scheme+"://"+u.Host))
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, docsHTML, html.EscapeString(*pageTitle), html.EscapeString(base), html.EscapeString(base))
+}
+
+// cspAllowing returns the Content-Security-Policy csp with scripts and
+// stylesheets also allowed from origin.
+func cspAllowing(csp, origin string) string {
+	return cspAllowingIn(csp, origin, "script-src", "style-src")
+}
+
+// cspAllowingIn returns the Content-Security-Policy csp with the named
+// directives also allowing origin.
+func cspAllowingIn(csp, origin string, names ...string) string {
+	directives := strings.Split(csp, ";")
+	found := map[string]bool{}
+	for i, d := range directives {
+		d = strings.TrimSpace(d)
+		for _, name := range names {
+			if strings.HasPrefix(d, name+" ") || d == name {
+				d += " " + origin
+				found[name] = true
+			}
+		}
+		directives[i] = d
+	}
+	for _, name := range names {
+		if !found[name] {
+			directives = append(directives, name+" 'self' "+origin)
+		}
+	}
+	return strings.Join(directives, "; ")
+}
+
+const docsHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>%s API</title>
+	<link rel="stylesheet" href="%s/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="%s/swagger-ui-bundle.js"></script>
+	<script src="/static/docs.js"></script>
+</body>
+</html>
+`
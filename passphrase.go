@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Passphrases (mode=passphrase) are words from the wordlist joined by a
+// separator, such as "correct-horse-battery-staple". They are generated from
+// a pattern built from their options, so they are estimated, checked and
+// reported on like any other pattern.
+const (
+	defaultPassphraseWords = 4
+	minPassphraseWords     = 3
+	maxPassphraseWords     = 10
+)
+
+type passphraseSeparator struct {
+	Name, Label string
+	// elem is the pattern element the separator stands for.
+	elem string
+}
+
+// passphraseSeparators are the separators passphrases can use, the default
+// first.
+var passphraseSeparators = []passphraseSeparator{
+	{"hyphen", "Hyphen (-)", `\-`},
+	{"space", "Space", `\ `},
+	{"period", "Period (.)", `\.`},
+	{"underscore", "Underscore (_)", `\_`},
+	{"none", "None", ""},
+	{"digit", "Random digit", "d"},
+	{"symbol", "Random symbol", "!"},
+}
+
+func separatorNames() []string {
+	names := make([]string, len(passphraseSeparators))
+	for i, s := range passphraseSeparators {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// passphrasePattern returns the pattern opts' passphrases are generated
+// from.
+func (opts genOptions) passphrasePattern() (string, error) {
+	words := opts.Words
+	if words == 0 {
+		words = defaultPassphraseWords
+	}
+	if words < minPassphraseWords || words > maxPassphraseWords {
+		return "", fmt.Errorf("words must be between %d and %d", minPassphraseWords, maxPassphraseWords)
+	}
+	sep, ok := passphraseSeparators[0], opts.Separator == ""
+	for _, s := range passphraseSeparators {
+		if s.Name == opts.Separator {
+			sep, ok = s, true
+		}
+	}
+	if !ok {
+		return "", fmt.Errorf("unknown separator %q", opts.Separator)
+	}
+	word := "w"
+	if opts.Capitalize {
+		word = "W"
+	}
+	elems := make([]string, words)
+	for i := range elems {
+		elems[i] = word
+	}
+	return strings.Join(elems, sep.elem), nil
+}
+
+// readPassphraseOptions sets opts' passphrase options from req's words,
+// separator, capitalize and sampler parameters.
+func readPassphraseOptions(req *http.Request, opts *genOptions) error {
+	if s := req.FormValue("words"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("words must be between %d and %d", minPassphraseWords, maxPassphraseWords)
+		}
+		opts.Words = n
+	}
+	opts.Separator = req.FormValue("separator")
+	opts.Capitalize = req.FormValue("capitalize") == "1"
+	opts.Sampler = req.FormValue("sampler")
+	if opts.Mode == "passphrase" {
+		_, err := opts.passphrasePattern()
+		return err
+	}
+	return nil
+}
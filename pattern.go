@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Patterns describe the structure of a password, one element per
+// character or word:
+//
+//	l      a lowercase letter
+//	u      an uppercase letter
+//	d, D   a digit
+//	!, S   a symbol
+//	W      a capitalised word
+//	w      a lowercase word
+//	\c     the literal character c
+//	{n}    repeat the previous element n times in total
+//
+// For example "Wd!w" gives memorable passwords like "Correct7!horse" and
+// "u{2}l{4}D{2}S" passwords like "KTpwhm47#" that match legacy password
+// rules exactly. Letters exclude easily confused characters as in the
+// default alphabet.
+const defaultMemorablePattern = "Wd!w"
+
+const (
+	patternDigits  = "0123456789"
+	patternSymbols = "!@#$%&*?-+="
+
+	// maxPatternElems limits how long a pattern can expand to.
+	maxPatternElems = 128
+)
+
+type patternElem struct {
+	kind    byte
+	literal rune
+}
+
+// patternError describes an invalid pattern.
+type patternError struct {
+	pos int
+	msg string
+}
+
+func (e *patternError) Error() string {
+	return fmt.Sprintf("invalid pattern at position %d: %s", e.pos, e.msg)
+}
+
+func parsePattern(s string) ([]patternElem, error) {
+	if s == "" {
+		return nil, &patternError{0, "empty pattern"}
+	}
+	runes := []rune(s)
+	var elems []patternElem
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch c {
+		case 'l', 'u', 'W', 'w':
+			elems = append(elems, patternElem{kind: byte(c)})
+		case 'd', 'D':
+			elems = append(elems, patternElem{kind: 'd'})
+		case '!', 'S':
+			elems = append(elems, patternElem{kind: '!'})
+		case '\\':
+			if i+1 == len(runes) {
+				return nil, &patternError{i + 1, "trailing backslash"}
+			}
+			i++
+			elems = append(elems, patternElem{kind: '\\', literal: runes[i]})
+		case '{':
+			if len(elems) == 0 {
+				return nil, &patternError{i + 1, "repetition with nothing to repeat"}
+			}
+			end := i + 1
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, &patternError{i + 1, "unterminated repetition"}
+			}
+			n, err := strconv.Atoi(string(runes[i+1 : end]))
+			if err != nil || n < 1 || n > maxPatternElems {
+				return nil, &patternError{i + 1, fmt.Sprintf("repeat count must be between 1 and %d", maxPatternElems)}
+			}
+			if len(elems)+n-1 > maxPatternElems {
+				return nil, &patternError{i + 1, fmt.Sprintf("pattern expands to more than %d elements", maxPatternElems)}
+			}
+			prev := elems[len(elems)-1]
+			for j := 1; j < n; j++ {
+				elems = append(elems, prev)
+			}
+			i = end
+		default:
+			return nil, &patternError{i + 1, fmt.Sprintf("unknown element %q", c)}
+		}
+		if len(elems) > maxPatternElems {
+			return nil, &patternError{i + 1, fmt.Sprintf("pattern expands to more than %d elements", maxPatternElems)}
+		}
+	}
+	return elems, nil
+}
+
+// composePattern returns a random password with the structure described by
+// elems, drawing words with word.
+func composePattern(elems []patternElem, word func() string) string {
+	var sb strings.Builder
+	for _, e := range elems {
+		switch e.kind {
+		case 'l':
+			sb.WriteByte(alphabetLower[randIntn(len(alphabetLower))])
+		case 'u':
+			sb.WriteByte(alphabetUpper[randIntn(len(alphabetUpper))])
+		case 'd':
+			sb.WriteByte(patternDigits[randIntn(len(patternDigits))])
+		case '!':
+			sb.WriteByte(patternSymbols[randIntn(len(patternSymbols))])
+		case 'W':
+			sb.WriteString(capitalize(word()))
+		case 'w':
+			sb.WriteString(word())
+		case '\\':
+			sb.WriteRune(e.literal)
+		}
+	}
+	return sb.String()
+}
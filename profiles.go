@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Example profiles are known-good combinations of settings for common
+// deployments, in the config file format. -profile-example runs one
+// directly, beneath any -config file and command line flags, which take
+// precedence over it; the examples subcommand prints one to start a config
+// file from.
+var profileExample = flag.String("profile-example", "", "start from the example profile `name`; see the examples subcommand")
+
+// profileSettings are the settings of the example profile, if any.
+var profileSettings map[string][]string
+
+type exampleProfile struct {
+	name, description, config string
+}
+
+var exampleProfiles = []exampleProfile{
+	{"public", "a public site on a single node, protected by rate limits rather than authentication", `
+# Everyone can use the page and the API, so clients are limited by rate
+# and by daily quota, and repeatedly rejected clients are banned for a
+# while. Batches are kept small and the counter is rounded so it doesn't
+# advertise usage.
+http: ":8080"
+counter: "counter.txt"
+public-counter: "rounded"
+max-count: "20"
+rate-limit: "2"
+rate-burst: "10"
+quota: "2000"
+quota-window: "24h"
+ban-threshold: "50"
+ban-duration: "1h"
+avoid-walks: "4"
+`},
+	{"internal-api", "an API for internal services and scripts, which authenticate with API keys", `
+# Services and scripts authenticate with the keys in keys.txt, one
+# "name key" pair per line, which must be created before starting. The
+# page stays open to people on the network. Clients are trusted with large
+# batches, streamed beyond -max-count, and generous rate limits.
+http: ":8080"
+auth:
+  - "api=apikey"
+  - "monitoring=apikey"
+  - "admin=apikey"
+api-keys: "keys.txt"
+counter: "counter.txt"
+max-count: "1000"
+max-stream-count: "100000"
+rate-limit: "50"
+rate-burst: "200"
+journal: "1h"
+`},
+	{"multi-tenant", "one instance shared by several teams, each with its own API key", `
+# Each team, or tenant, has its own key in keys.txt, which must be created
+# before starting, and is identified by the key's name in the audit log
+# and /admin/journal. Tenants that can't set headers can be given their
+# own default format, e.g. with a key named billing:
+#
+#   default-format:
+#     - "billing=json"
+#
+# Tenants can't see each other's usage: the counter is hidden and
+# monitoring needs a key.
+http: ":8080"
+auth:
+  - "ui=apikey"
+  - "api=apikey"
+  - "monitoring=apikey"
+  - "admin=apikey"
+api-keys: "keys.txt"
+counter: "counter.txt"
+public-counter: "hidden"
+audit-log: "true"
+journal: "24h"
+journal-size: "100000"
+rate-limit: "20"
+rate-burst: "50"
+no-repeat-window: "720h"
+`},
+	{"air-gapped", "a host with no internet access, which loads nothing from and sends nothing to other hosts", `
+# Nothing is fetched from other hosts: the API documentation, which loads
+# Swagger UI from a CDN unless -swagger-ui points at a local copy, is off.
+# Leave -logo, -jobs, -oidc-issuer and -web-push-key unset, since they need
+# to reach other services. Isolated hosts, especially VMs, can be short of
+# entropy, so drawing more than 1 MiB of random bytes a minute is logged.
+http: ":8080"
+counter: "counter.txt"
+docs: "false"
+entropy-interval: "1m"
+entropy-alert: "1048576"
+avoid-walks: "4"
+`},
+}
+
+func findExampleProfile(name string) (exampleProfile, bool) {
+	for _, p := range exampleProfiles {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return exampleProfile{}, false
+}
+
+// loadExampleProfile applies the settings of the -profile-example profile
+// that weren't set on the command line or in the config file.
+func loadExampleProfile(name string) error {
+	p, ok := findExampleProfile(name)
+	if !ok {
+		return fmt.Errorf("unknown example profile %q; the examples subcommand lists them", name)
+	}
+	settings, err := parseConfig(strings.NewReader(p.config))
+	if err != nil {
+		return fmt.Errorf("profile %s: %s", name, err)
+	}
+	profileSettings = settings
+	return applySettings(settings)
+}
+
+// printExamples lists the example profiles, or prints the one named.
+func printExamples(w io.Writer, name string) error {
+	if name == "" {
+		for _, p := range exampleProfiles {
+			fmt.Fprintf(w, "%-14s %s\n", p.name, p.description)
+		}
+		return nil
+	}
+	p, ok := findExampleProfile(name)
+	if !ok {
+		return fmt.Errorf("unknown example profile %q", name)
+	}
+	fmt.Fprintf(w, "# The %s example profile: %s.%s", p.name, p.description, p.config)
+	return nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Behind a load balancer such as HAProxy that speaks the PROXY protocol,
+// -proxy-protocol takes each connection's client address from the header
+// the proxy sends at its start, so rate limits and logs see real clients
+// rather than the proxy. Both versions of the protocol are accepted, as
+// described in https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt.
+// Every connection must then start with a header, so the listeners must only
+// be reachable through the proxy.
+var proxyProtocol = flag.Bool("proxy-protocol", false, "read client addresses from the PROXY protocol header every connection must start with")
+
+func init() {
+	registerFeature(feature{name: "PROXY protocol", kind: "transport", version: "v1, v2", active: func() bool {
+		return *proxyProtocol
+	}})
+}
+
+// Behind a reverse proxy that sets X-Forwarded-For or X-Real-IP instead,
+// such as nginx or a cloud load balancer, -trusted-proxies lists the
+// networks the proxies connect from. The headers are only believed on
+// requests from those networks, since anyone else could set them.
+var trustedProxies = flag.String("trusted-proxies", "", "comma-separated `networks` in CIDR notation, or unix for unix socket peers, whose X-Forwarded-For and X-Real-IP headers are trusted")
+
+// proxyHeaderTimeout is how long a connection has to send its header.
+const proxyHeaderTimeout = 5 * time.Second
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyV1MaxLength is the longest a version 1 header can be, including its
+// CRLF.
+const proxyV1MaxLength = 107
+
+var errNoProxyHeader = errors.New("connection didn't start with a PROXY protocol header")
+
+// proxyListener wraps the connections a listener accepts in proxyConns.
+type proxyListener struct {
+	net.Listener
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c}, nil
+}
+
+// proxyConn reads the PROXY protocol header when it is first read from or
+// its remote address is asked for, rather than in Accept, so a slow client
+// can't hold up other connections being accepted.
+type proxyConn struct {
+	net.Conn
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.r = bufio.NewReader(c.Conn)
+		c.remote, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client's address from the header, or the
+// connection's own for connections the proxy made itself.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a version 1 or 2 PROXY protocol header from r and
+// returns the source address it gives, or nil if it doesn't give one.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	if b, err := r.Peek(len(proxyV1Prefix)); err == nil && bytes.Equal(b, proxyV1Prefix) {
+		return readProxyV1(r)
+	}
+	if b, err := r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(b, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	return nil, errNoProxyHeader
+}
+
+// readProxyV1 reads a human-readable header, such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyV1MaxLength {
+			return nil, errors.New("PROXY protocol header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("invalid PROXY protocol header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid PROXY protocol header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads a binary header.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var h [16]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, err
+	}
+	version, command, family := h[12]>>4, h[12]&0xf, h[13]
+	if version != 2 || command > 1 {
+		return nil, errors.New("unsupported PROXY protocol version or command")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(h[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if command == 0 {
+		// LOCAL: a connection from the proxy itself, such as a health check.
+		return nil, nil
+	}
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("PROXY protocol header too short")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("PROXY protocol header too short")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	// Other protocols and families don't have a useful address.
+	return nil, nil
+}
+
+// parseTrustedProxies returns the networks in -trusted-proxies and whether
+// it includes unix socket peers.
+func parseTrustedProxies() (nets []*net.IPNet, unix bool, err error) {
+	for _, s := range strings.Split(*trustedProxies, ",") {
+		s = strings.TrimSpace(s)
+		switch {
+		case s == "":
+			continue
+		case s == "unix":
+			unix = true
+			continue
+		case !strings.Contains(s, "/"):
+			// A single address.
+			if ip := net.ParseIP(s); ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, false, fmt.Errorf("-trusted-proxies: %s", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, unix, nil
+}
+
+// trustedProxy returns the address of the peer req came from, and a
+// function reporting whether an address is a trusted proxy's.
+func trustedProxy(req *http.Request) (host string, trusted func(addr string) bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	nets, unix, _ := parseTrustedProxies()
+	return host, func(addr string) bool {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			// Unix socket peers have no IP address.
+			return unix && addr == host
+		}
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// isHTTPS reports whether req came over TLS, either to the server or, for
+// requests from trusted proxies, to the proxy as its X-Forwarded-Proto says.
+func isHTTPS(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
+	host, trusted := trustedProxy(req)
+	return trusted(host) && req.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// clientIP returns the IP address of the client that made req. For
+// requests from trusted proxies it is the address of the last client before
+// them in X-Forwarded-For, or else X-Real-IP.
+func clientIP(req *http.Request) string {
+	host, trusted := trustedProxy(req)
+	if !trusted(host) {
+		return host
+	}
+
+	// Each proxy appends the address it got the request from, so walk back
+	// from the end to the first address that isn't a trusted proxy.
+	var hops []string
+	for _, h := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	if len(hops) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+		return host
+	}
+	client := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// Anything before a malformed address can't be trusted.
+			break
+		}
+		client = ip.String()
+		if !trusted(client) {
+			break
+		}
+	}
+	return client
+}
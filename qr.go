@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// A minimal QR code encoder, as described in ISO/IEC 18004, for the short
+// URIs authenticator apps scan. It only encodes bytes, at error correction
+// level M, in versions 1 to 10, which hold up to 213 bytes.
+
+// qrBlocks describes the codewords of a version at level M: the total, the
+// error correction codewords per block, and the number of blocks with
+// short data codewords and with one more.
+type qrBlocks struct {
+	total, ec, short, shortData, long int
+}
+
+var qrVersions = []qrBlocks{
+	{26, 10, 1, 16, 0},
+	{44, 16, 1, 28, 0},
+	{70, 26, 1, 44, 0},
+	{100, 18, 2, 32, 0},
+	{134, 24, 2, 43, 0},
+	{172, 16, 4, 27, 0},
+	{196, 18, 4, 31, 0},
+	{242, 22, 2, 38, 2},
+	{292, 22, 3, 36, 2},
+	{346, 26, 4, 43, 1},
+}
+
+// qrAlignment lists the centres of the alignment patterns of versions 2 to
+// 10 on each axis.
+var qrAlignment = [][]int{
+	nil,
+	{6, 18},
+	{6, 22},
+	{6, 26},
+	{6, 30},
+	{6, 34},
+	{6, 22, 38},
+	{6, 24, 42},
+	{6, 26, 46},
+	{6, 28, 50},
+}
+
+var errQRTooLong = errors.New("too long for a QR code")
+
+type qrCode struct {
+	size     int
+	modules  [][]bool // dark modules, by row then column
+	function [][]bool // modules not holding data
+}
+
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// newQRCode returns the QR code of data in the smallest version it fits.
+func newQRCode(data []byte) (*qrCode, error) {
+	version := 0
+	for v := 1; v <= len(qrVersions); v++ {
+		b := qrVersions[v-1]
+		dataBits := (b.total - b.ec*(b.short+b.long)) * 8
+		if 4+qrCountBits(v)+8*len(data) <= dataBits {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errQRTooLong
+	}
+
+	size := 17 + 4*version
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+	q.drawFunctionPatterns(version)
+	q.drawCodewords(qrCodewords(version, data))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // undo
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+	return q, nil
+}
+
+func qrCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// qrCodewords returns the data codewords of a byte segment holding data
+// with their error correction codewords, interleaved.
+func qrCodewords(version int, data []byte) []byte {
+	b := qrVersions[version-1]
+	capacity := b.total - b.ec*(b.short+b.long)
+
+	var bits []bool
+	appendBits := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, v>>uint(i)&1 == 1)
+		}
+	}
+	appendBits(0x4, 4) // byte mode
+	appendBits(len(data), qrCountBits(version))
+	for _, c := range data {
+		appendBits(int(c), 8)
+	}
+	for i := 0; i < 4 && len(bits) < capacity*8; i++ {
+		bits = append(bits, false) // terminator
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	codewords := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var c byte
+		for _, bit := range bits[i : i+8] {
+			c <<= 1
+			if bit {
+				c |= 1
+			}
+		}
+		codewords = append(codewords, c)
+	}
+	for pad := byte(0xec); len(codewords) < capacity; pad ^= 0xec ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+
+	divisor := rsDivisor(b.ec)
+	var blocks, ecBlocks [][]byte
+	for i := 0; i < b.short+b.long; i++ {
+		n := b.shortData
+		if i >= b.short {
+			n++
+		}
+		blocks = append(blocks, codewords[:n])
+		ecBlocks = append(ecBlocks, rsRemainder(codewords[:n], divisor))
+		codewords = codewords[n:]
+	}
+
+	result := make([]byte, 0, b.total)
+	for i := 0; i <= b.shortData; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < b.ec; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11d
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of degree n,
+// highest coefficient first, without the leading 1.
+func rsDivisor(n int) []byte {
+	result := make([]byte, n)
+	result[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			result[j] = gfMul(result[j], root)
+			if j+1 < n {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, c := range data {
+		factor := c ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+func (q *qrCode) drawFunctionPatterns(version int) {
+	for i := 0; i < q.size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {q.size - 4, 3}, {3, q.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= q.size || y < 0 || y >= q.size {
+					continue
+				}
+				d := chebyshev(dx, dy)
+				q.set(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+	pos := qrAlignment[version-1]
+	for i, x := range pos {
+		for j, y := range pos {
+			last := len(pos) - 1
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue // finder patterns
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(x+dx, y+dy, chebyshev(dx, dy) != 1)
+				}
+			}
+		}
+	}
+	q.drawFormat(0) // reserve the format modules
+	if version >= 7 {
+		rem := version << 12
+		for i := 17; i >= 12; i-- {
+			if rem>>uint(i)&1 == 1 {
+				rem ^= 0x1f25 << uint(i-12)
+			}
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>uint(i)&1 == 1
+			a, b := q.size-11+i%3, i/3
+			q.set(a, b, dark)
+			q.set(b, a, dark)
+		}
+	}
+}
+
+func chebyshev(dx, dy int) int {
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	if dx > dy {
+		return dx
+	}
+	return dy
+}
+
+// drawFormat draws both copies of the format information, for level M
+// and mask.
+func (q *qrCode) drawFormat(mask int) {
+	data := mask // level M is 00
+	rem := data << 10
+	for i := 14; i >= 10; i-- {
+		if rem>>uint(i)&1 == 1 {
+			rem ^= 0x537 << uint(i-10)
+		}
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// drawCodewords places data in the zigzag of two-module columns from the
+// bottom right corner.
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert // upwards
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.modules[y][x] = data[i>>3]>>uint(7-i&7)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by mask, so applying it
+// twice undoes it.
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to scan, by the rules masks are
+// chosen by.
+func (q *qrCode) penalty() int {
+	p := 0
+	line := make([]bool, q.size)
+	for _, vertical := range []bool{false, true} {
+		for i := 0; i < q.size; i++ {
+			for j := range line {
+				if vertical {
+					line[j] = q.modules[j][i]
+				} else {
+					line[j] = q.modules[i][j]
+				}
+			}
+			// Runs of five or more modules of the same colour.
+			run := 1
+			for j := 1; j <= q.size; j++ {
+				if j < q.size && line[j] == line[j-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += run - 2
+				}
+				run = 1
+			}
+			// Patterns that look like finder patterns.
+			for j := 0; j+11 <= q.size; j++ {
+				if qrMatches(line[j:j+11], "10111010000") || qrMatches(line[j:j+11], "00001011101") {
+					p += 40
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := q.modules[y][x]
+				if q.modules[y-1][x] == c && q.modules[y][x-1] == c && q.modules[y-1][x-1] == c {
+					p += 3
+				}
+			}
+		}
+	}
+	// How far the proportion of dark modules is from half, in steps of 5%.
+	total := q.size * q.size
+	k := (abs(dark*20-total*10) + total - 1) / total
+	return p + (k-1)*10
+}
+
+func qrMatches(line []bool, pattern string) bool {
+	for i, c := range pattern {
+		if line[i] != (c == '1') {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// png renders the code with scale pixels per module and the 4 module
+// quiet zone readers need.
+func (q *qrCode) png(scale int) ([]byte, error) {
+	const quiet = 4
+	n := (q.size + 2*quiet) * scale
+	img := image.NewGray(image.Rect(0, 0, n, n))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if !q.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetGray((x+quiet)*scale+dx, (y+quiet)*scale+dy, color.Gray{})
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
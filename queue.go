@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rather than getting a 429 and retrying blindly, rate limited clients can
+// ask to wait for their turn, either with a "Prefer: wait=n" header (RFC
+// 7240) or a wait=n parameter, where n is in seconds. Each client's waiting
+// requests are served in order.
+var (
+	maxQueueWait   = flag.Duration("max-queue-wait", 0, "longest a rate limited client may wait for its turn instead of getting a 429 (0 to disable)")
+	maxQueueLength = flag.Int("max-queue-length", 10, "most requests a client may have waiting for their turn")
+)
+
+func init() {
+	registerFeature(feature{name: "request queueing", kind: "subsystem", active: func() bool {
+		return *maxQueueWait > 0
+	}})
+}
+
+// requestedWait returns how long the client that made req is willing to
+// wait for its turn, up to -max-queue-wait.
+func requestedWait(req *http.Request) time.Duration {
+	if *maxQueueWait <= 0 {
+		return 0
+	}
+	s := req.FormValue("wait")
+	for _, pref := range strings.Split(req.Header.Get("Prefer"), ",") {
+		pref = strings.TrimSpace(pref)
+		if strings.HasPrefix(pref, "wait=") {
+			s = pref[len("wait="):]
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	if d := time.Duration(n) * time.Second; d < *maxQueueWait {
+		return d
+	}
+	return *maxQueueWait
+}
+
+// waiter is a request waiting for its turn. turn is closed when it reaches
+// the head of its client's queue.
+type waiter struct {
+	turn chan struct{}
+}
+
+type waitQueues struct {
+	mu     sync.Mutex
+	queues map[string][]*waiter
+}
+
+var queues = &waitQueues{queues: make(map[string][]*waiter)}
+
+// join adds a waiter to the end of the client's queue and returns it with
+// its position, counting from 1, or nil if the queue is full.
+func (q *waitQueues) join(client string) (*waiter, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queue := q.queues[client]
+	if len(queue) >= *maxQueueLength {
+		return nil, len(queue) + 1
+	}
+	w := &waiter{turn: make(chan struct{})}
+	if len(queue) == 0 {
+		close(w.turn)
+	}
+	q.queues[client] = append(queue, w)
+	return w, len(queue) + 1
+}
+
+// leave removes a waiter from the client's queue, passing the turn on if it
+// had it.
+func (q *waitQueues) leave(client string, w *waiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queue := q.queues[client]
+	for i, v := range queue {
+		if v != w {
+			continue
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+		if i == 0 && len(queue) > 0 {
+			close(queue[0].turn)
+		}
+		break
+	}
+	if len(queue) == 0 {
+		delete(q.queues, client)
+	} else {
+		q.queues[client] = queue
+	}
+}
+
+// withConfig calls f holding configLock for reading, for the checks
+// waitForTurn makes without it.
+func withConfig(f func()) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	f()
+}
+
+// waitForTurn waits until the client may make a request or until deadline.
+// It returns whether the request may go ahead, the request's position in
+// the client's queue when it joined and, if not, how long the client must
+// wait before trying again. Only a request that goes ahead is counted
+// against the client's limits. It must be called without configLock held.
+func waitForTurn(ctx context.Context, client string, deadline time.Time) (bool, int, time.Duration) {
+	var w *waiter
+	var position int
+	var wait time.Duration
+	withConfig(func() {
+		if w, position = queues.join(client); w == nil {
+			wait = limiter.peek(client, time.Now())
+		}
+	})
+	if w == nil {
+		return false, position, wait
+	}
+	defer queues.leave(client, w)
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-w.turn:
+	case <-timer.C:
+		withConfig(func() { wait = limiter.peek(client, time.Now()) })
+		return false, position, wait
+	case <-ctx.Done():
+		return false, position, 0
+	}
+
+	for {
+		now := time.Now()
+		var ok bool
+		withConfig(func() {
+			if wait = limiter.peek(client, now); wait == 0 {
+				// Another request of the client's, not queued, may
+				// have taken the token since.
+				ok, wait, _ = limiter.allow(client, now, false)
+			}
+		})
+		if ok {
+			return true, position, 0
+		}
+		if now.Add(wait).After(deadline) {
+			// Don't keep the client waiting in vain.
+			return false, position, wait
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return false, position, 0
+		}
+	}
+}
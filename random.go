@@ -0,0 +1,94 @@
+package main
+
+import (
+	cryptorand "crypto/rand"
+	"flag"
+	"log"
+	"math/rand"
+	"sync"
+)
+
+// Random values are drawn through a randomness source: crypto/rand, both
+// for secrets and for choosing characters and words. For integration tests
+// of downstream systems, -deterministic-seed replaces it with generators
+// seeded from it so that a server answering the same requests in the same
+// order gives the same results on every run. As that makes every value
+// predictable, it is refused unless the binary was built with the dev tag or
+// -insecure-ok is set.
+var (
+	deterministicSeed = flag.Int64("deterministic-seed", 0, "development only: `seed` to generate reproducible values from (0 to disable)")
+	insecureOK        = flag.Bool("insecure-ok", false, "allow development settings such as -deterministic-seed in production builds")
+)
+
+func init() {
+	registerFeature(feature{name: "deterministic randomness", kind: "subsystem", active: func() bool {
+		return *deterministicSeed != 0
+	}})
+}
+
+// randomness is a source of random values.
+type randomness interface {
+	Read(p []byte) (int, error)
+	Intn(n int) int
+}
+
+// systemRandomness draws from crypto/rand.
+type systemRandomness struct{}
+
+func (systemRandomness) Read(p []byte) (int, error) { return cryptorand.Read(p) }
+func (systemRandomness) Intn(n int) int             { return cryptoIntn(n) }
+
+// cryptoIntn returns a uniformly distributed int in [0,n) from crypto/rand.
+// A random value modulo n would favor the smaller results whenever n doesn't
+// divide its range, so uniformUint64 draws again values past the last
+// multiple of n.
+func cryptoIntn(n int) int {
+	v, err := uniformUint64(cryptorand.Reader, uint64(n))
+	if err != nil {
+		panic("reading crypto/rand: " + err.Error())
+	}
+	return int(v)
+}
+
+// seededRandomness draws everything from a seeded math/rand generator.
+type seededRandomness struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newSeededRandomness(seed int64) *seededRandomness {
+	return &seededRandomness{r: rand.New(rand.NewSource(seed))}
+}
+
+func (s *seededRandomness) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Read(p)
+}
+
+func (s *seededRandomness) Intn(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Intn(n)
+}
+
+// random is the source values are drawn from while serving requests.
+var random randomness = systemRandomness{}
+
+// setupRandomness installs the sources random values are drawn from and
+// returns the one for the password buffer. The buffer has its own source so
+// that the passwords in it don't depend on how requests interleave with
+// filling it.
+func setupRandomness() (randomness, error) {
+	if *deterministicSeed == 0 {
+		src, err := setupRandomSource()
+		if err != nil || src == nil {
+			return systemRandomness{}, err
+		}
+		random = src
+		return src, nil
+	}
+	log.Printf("Warning: generating predictable values from -deterministic-seed=%d; never use this in production", *deterministicSeed)
+	random = newSeededRandomness(*deterministicSeed)
+	return newSeededRandomness(^*deterministicSeed), nil
+}
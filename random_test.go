@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"math"
+	"strings"
+	"testing"
+)
+
+// The uniformity tests each draw uniformSamples values and fail by chance
+// with probability chiSquareSignificance, so that they rarely do.
+const (
+	uniformSamples        = 100000
+	chiSquareSignificance = 1e-4
+)
+
+// TestUniformity checks by a chi-squared test that every character of the
+// charsets is as likely as the others, both as drawn for requests and from
+// the stream of client entropy mixed with the server's.
+func TestUniformity(t *testing.T) {
+	skewed := func(chars, drawn string) bool {
+		counts := make([]float64, len(chars))
+		for i := 0; i < len(drawn); i++ {
+			counts[strings.IndexByte(chars, drawn[i])]++
+		}
+		return chiSquareSkewed(counts)
+	}
+	for _, p := range charsetPresets {
+		if skewed(p.Chars, drawCharset(p.Chars, uniformSamples)) {
+			t.Errorf("the characters of charset %s aren't equally likely", p.Name)
+		}
+	}
+	drawn, err := passwordFromStream(cryptorand.Reader, uniformSamples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skewed(alphabet, drawn) {
+		t.Error("the characters of passwords with client entropy aren't equally likely")
+	}
+}
+
+// TestUniformUint64 checks that uniformUint64 draws every value below n
+// equally often, for the small n of shuffles such as require-each's, where
+// Fisher-Yates draws an index below each position, and for charset sizes.
+func TestUniformUint64(t *testing.T) {
+	for _, n := range []uint64{2, 3, 5, 6, 7, 58, 94} {
+		counts := make([]float64, n)
+		for i := 0; i < uniformSamples; i++ {
+			v, err := uniformUint64(cryptorand.Reader, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v >= n {
+				t.Fatalf("uniformUint64(%d) = %d", n, v)
+			}
+			counts[v]++
+		}
+		if chiSquareSkewed(counts) {
+			t.Errorf("the values below %d aren't equally likely, with counts %v", n, counts)
+		}
+	}
+}
+
+// TestUniformUint64Rejects feeds uniformUint64 values past the last multiple
+// of n, which would favor the smallest results if reduced modulo n, and
+// checks that it draws again.
+func TestUniformUint64Rejects(t *testing.T) {
+	max := bytes.Repeat([]byte{0xff}, 8)
+	for _, tt := range []struct {
+		n    uint64
+		in   []byte
+		want uint64
+		read int
+	}{
+		// 2^64-1 is a multiple of 3, so only it is past the last one.
+		{3, append(append([]byte{}, max...), 0, 0, 0, 0, 0, 0, 0, 5), 2, 16},
+		// 2^64 % 58 is 24, so the last 24 values are rejected, from
+		// 2^64-24 on, and the one before them is accepted.
+		{58, append([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xe8}, 0, 0, 0, 0, 0, 0, 0, 59), 1, 16},
+		{58, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xe7}, 57, 8},
+	} {
+		r := bytes.NewReader(tt.in)
+		v, err := uniformUint64(r, tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read := len(tt.in) - r.Len(); v != tt.want || read != tt.read {
+			t.Errorf("n=%d: got %d after reading %d bytes, want %d after %d", tt.n, v, read, tt.want, tt.read)
+		}
+	}
+}
+
+// chiSquareSkewed reports whether counts, which should be equal, differ by
+// more than chance would have them do with probability
+// chiSquareSignificance, by Pearson's chi-squared test.
+func chiSquareSkewed(counts []float64) bool {
+	var total float64
+	for _, c := range counts {
+		total += c
+	}
+	expected := total / float64(len(counts))
+	var stat float64
+	for _, c := range counts {
+		stat += (c - expected) * (c - expected) / expected
+	}
+	// The critical value by the Wilson-Hilferty approximation, from the
+	// normal quantile of 1 - chiSquareSignificance.
+	z := math.Sqrt2 * math.Erfinv(1-2*chiSquareSignificance)
+	df := float64(len(counts) - 1)
+	critical := df * math.Pow(1-2/(9*df)+z*math.Sqrt(2/(9*df)), 3)
+	return stat > critical
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/bits"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -random-source draws generated values from a hardware RNG or an entropy
+// gathering daemon instead of crypto/rand, which reads the kernel's
+// getrandom or its equivalent. Before serving, the source is put through
+// health tests on a sample of its output, and the server refuses to start if
+// it looks broken, since passwords from a stuck or biased source would be
+// guessable.
+var randomSource = flag.String("random-source", "crypto", "`source` of random values: crypto for crypto/rand, hwrng or hwrng:device for a hardware RNG (by default /dev/hwrng), or egd:socket for an entropy gathering daemon at a unix socket path or host:port")
+
+func init() {
+	registerFeature(feature{name: "external randomness", kind: "subsystem", active: func() bool {
+		return *randomSource != "crypto"
+	}})
+}
+
+// defaultHWRNG is the hardware RNG read by -random-source hwrng.
+const defaultHWRNG = "/dev/hwrng"
+
+// readerRandomness draws from a reader, one read at a time.
+type readerRandomness struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+// Failed reads of -random-source are retried randomSourceRetries times,
+// waiting from randomSourceBackoff and doubling, since entropy daemons
+// restart and hardware RNGs report transient errors.
+const randomSourceRetries = 5
+
+var randomSourceBackoff = 50 * time.Millisecond
+
+// randomSourceBroken is 1 while -random-source fails reads despite the
+// retries, which makes the instance not ready. It is accessed atomically.
+var randomSourceBroken int32
+
+func (s *readerRandomness) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	wait := randomSourceBackoff
+	for try := 0; try <= randomSourceRetries; try++ {
+		if try > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		if _, err = io.ReadFull(s.r, p); err == nil {
+			if atomic.CompareAndSwapInt32(&randomSourceBroken, 1, 0) {
+				log.Printf("-random-source %s can be read again", *randomSource)
+			}
+			return len(p), nil
+		}
+	}
+	if atomic.CompareAndSwapInt32(&randomSourceBroken, 0, 1) {
+		log.Printf("Failed to read -random-source %s, so not ready until it can be: %s", *randomSource, err)
+	}
+	return 0, err
+}
+
+// Intn waits for a broken source to recover, since it can't return an
+// error and a value not drawn from the source would be guessable.
+func (s *readerRandomness) Intn(n int) int {
+	for {
+		v, err := uniformUint64(s, uint64(n))
+		if err == nil {
+			return int(v)
+		}
+		time.Sleep(randomSourceBackoff << randomSourceRetries)
+	}
+}
+
+// egdReader reads from an entropy gathering daemon with its blocking read
+// command, redialling after errors.
+type egdReader struct {
+	network, addr string
+	conn          net.Conn
+}
+
+// egdBlockingRead is the EGD command to read bytes, waiting for them if the
+// daemon's pool is short. The count that follows is one byte, so at most
+// egdMaxRead bytes are read at a time.
+const (
+	egdBlockingRead = 0x02
+	egdMaxRead      = 255
+)
+
+func (e *egdReader) Read(p []byte) (int, error) {
+	if e.conn == nil {
+		conn, err := net.Dial(e.network, e.addr)
+		if err != nil {
+			return 0, err
+		}
+		e.conn = conn
+	}
+	if len(p) > egdMaxRead {
+		p = p[:egdMaxRead]
+	}
+	if _, err := e.conn.Write([]byte{egdBlockingRead, byte(len(p))}); err != nil {
+		e.reset()
+		return 0, err
+	}
+	n, err := io.ReadFull(e.conn, p)
+	if err != nil {
+		e.reset()
+	}
+	return n, err
+}
+
+func (e *egdReader) reset() {
+	e.conn.Close()
+	e.conn = nil
+}
+
+// openRandomSource opens -random-source, returning its name for the
+// rngUsage counts, or nil for crypto/rand.
+func openRandomSource() (randomness, string, error) {
+	kind, arg := *randomSource, ""
+	if i := strings.Index(kind, ":"); i >= 0 {
+		kind, arg = kind[:i], kind[i+1:]
+	}
+	switch kind {
+	case "crypto":
+		if arg != "" {
+			break
+		}
+		return nil, rngCrypto, nil
+	case "hwrng":
+		if arg == "" {
+			arg = defaultHWRNG
+		}
+		f, err := os.Open(arg)
+		if err != nil {
+			return nil, "", err
+		}
+		return &readerRandomness{r: f}, rngHWRNG, nil
+	case "egd":
+		if arg == "" {
+			return nil, "", errors.New("-random-source egd needs the daemon's socket, such as egd:/run/egd-pool")
+		}
+		network := "tcp"
+		if strings.HasPrefix(arg, "/") {
+			network = "unix"
+		}
+		return &readerRandomness{r: &egdReader{network: network, addr: arg}}, rngEGD, nil
+	}
+	return nil, "", fmt.Errorf("unknown -random-source %q, want crypto, hwrng[:device] or egd:socket", *randomSource)
+}
+
+// The health tests run on healthSampleBytes from the source. The monobit
+// test is FIPS 140-2's: the 20,000 bits must have between monobitLow and
+// monobitHigh ones, which a good source fails about once in 10,000 starts.
+// The repetition count test is SP 800-90B's, with a cutoff for a false
+// positive rate of 2^-30 a byte assuming 8 bits of entropy each: no byte may
+// repeat repetitionCutoff times in a row.
+const (
+	healthSampleBytes = 2500
+	monobitLow        = 9725
+	monobitHigh       = 10275
+	repetitionCutoff  = 5
+)
+
+// checkRandomSource runs the health tests on a sample from src, and again on
+// a new sample if they fail, so that a good source is only refused about
+// once in 10^8 starts.
+func checkRandomSource(src io.Reader) error {
+	err := healthTest(src)
+	if err != nil {
+		err = healthTest(src)
+	}
+	return err
+}
+
+// healthTest runs the health tests on one sample from src.
+func healthTest(src io.Reader) error {
+	sample := make([]byte, healthSampleBytes)
+	if _, err := io.ReadFull(src, sample); err != nil {
+		return fmt.Errorf("reading a sample: %s", err)
+	}
+	if err := repetitionTest(sample); err != nil {
+		return err
+	}
+	return monobitTest(sample)
+}
+
+// repetitionTest fails if a byte repeats repetitionCutoff times in a row,
+// as a stuck source's do.
+func repetitionTest(sample []byte) error {
+	run := 1
+	for i := 1; i < len(sample); i++ {
+		if sample[i] != sample[i-1] {
+			run = 1
+			continue
+		}
+		if run++; run >= repetitionCutoff {
+			return fmt.Errorf("repetition count test failed: byte %#02x repeated %d times in a row", sample[i], run)
+		}
+	}
+	return nil
+}
+
+// monobitTest fails if the sample has too many or too few ones, as a biased
+// source's does.
+func monobitTest(sample []byte) error {
+	ones := 0
+	for _, b := range sample {
+		ones += bits.OnesCount8(b)
+	}
+	if ones <= monobitLow || ones >= monobitHigh {
+		return fmt.Errorf("monobit test failed: %d of %d bits set, want between %d and %d", ones, len(sample)*8, monobitLow, monobitHigh)
+	}
+	return nil
+}
+
+// setupRandomSource opens -random-source and checks it, returning nil for
+// crypto/rand.
+func setupRandomSource() (randomness, error) {
+	src, name, err := openRandomSource()
+	if err != nil {
+		return nil, err
+	}
+	var check io.Reader = systemRandomness{}
+	if src != nil {
+		check = src
+	}
+	if err := checkRandomSource(check); err != nil {
+		return nil, fmt.Errorf("-random-source %s looks broken: %s", *randomSource, err)
+	}
+	rngSource = name
+	if src != nil {
+		log.Printf("Drawing random values from %s", *randomSource)
+	}
+	return src, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestHealthTests checks that the random source's health tests pass
+// crypto/rand and catch a stuck and a biased source.
+func TestHealthTests(t *testing.T) {
+	if err := checkRandomSource(randomReader{}); err != nil {
+		t.Errorf("crypto/rand failed: %s", err)
+	}
+	stuck := bytes.Repeat([]byte{0x5a}, healthSampleBytes)
+	if checkRandomSource(bytes.NewReader(append(stuck, stuck...))) == nil {
+		t.Error("a stuck source passed")
+	}
+	biased := make([]byte, healthSampleBytes*2)
+	cryptorand.Read(biased)
+	for i := range biased {
+		biased[i] |= 0x01
+	}
+	if checkRandomSource(bytes.NewReader(biased)) == nil {
+		t.Error("a biased source passed")
+	}
+}
+
+// TestEGDRedial checks that reads from an entropy gathering daemon survive
+// its first connection failing.
+func TestEGDRedial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for first := true; ; first = false {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if first {
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				cmd := make([]byte, 2)
+				for {
+					if _, err := io.ReadFull(conn, cmd); err != nil || cmd[0] != egdBlockingRead {
+						return
+					}
+					buf := make([]byte, cmd[1])
+					cryptorand.Read(buf)
+					if _, err := conn.Write(buf); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	defer func(d time.Duration) { randomSourceBackoff = d }(randomSourceBackoff)
+	randomSourceBackoff = time.Millisecond
+	src := &readerRandomness{r: &egdReader{network: "tcp", addr: l.Addr().String()}}
+	for i := 0; i < 300; i++ {
+		if v := src.Intn(58); v < 0 || v >= 58 {
+			t.Fatalf("Intn(58) returned %d", v)
+		}
+	}
+	if atomic.LoadInt32(&randomSourceBroken) != 0 {
+		t.Error("the source is marked broken")
+	}
+}
+
+type failingReader struct{ fail *int32 }
+
+func (r failingReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(r.fail) == 1 {
+		return 0, errors.New("EIO")
+	}
+	return cryptorand.Read(p)
+}
+
+// TestBrokenSourceNotReady checks that a source failing every retry makes
+// the instance not ready, rather than panicking, until it recovers.
+func TestBrokenSourceNotReady(t *testing.T) {
+	defer func(d time.Duration) { randomSourceBackoff = d }(randomSourceBackoff)
+	randomSourceBackoff = time.Millisecond
+	fail := int32(1)
+	src := &readerRandomness{r: failingReader{&fail}}
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		readyHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+
+	if _, err := src.Read(make([]byte, 8)); err == nil {
+		t.Fatal("read from a failing source")
+	}
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz is %d with the source failing, want 503", code)
+	}
+	done := make(chan int)
+	go func() { done <- src.Intn(10) }()
+	time.Sleep(10 * time.Millisecond)
+	atomic.StoreInt32(&fail, 0)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Intn didn't return after the source recovered")
+	}
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("/readyz is %d after the source recovered, want 200", code)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Clients are rate limited by IP address, or by /64 network for IPv6 since
+// each host usually gets a whole one, with a token bucket, an optional
+// quota of requests per window and optional temporary bans for clients that
+// keep exceeding their limits. The limiter's state is persisted next to the
+// counter file so that restarts don't give every client a fresh allowance.
+var (
+	rateLimit    = flag.Float64("rate-limit", 0, "sustained requests per second allowed per client (0 for no limit)")
+	rateBurst    = flag.Int("rate-burst", 20, "requests a client may make in a burst")
+	quota        = flag.Int("quota", 0, "requests allowed per client per quota window (0 for no quota)")
+	quotaWindow  = flag.Duration("quota-window", 24*time.Hour, "quota window `duration`")
+	banThreshold = flag.Int("ban-threshold", 0, "consecutive rejected requests after which a client is banned (0 to never ban)")
+	banDuration  = flag.Duration("ban-duration", time.Hour, "how long bans last")
+)
+
+func init() {
+	registerFeature(feature{name: "rate limiting", kind: "subsystem", active: rateLimitEnabled})
+}
+
+// limiterSavePeriod is how often the limiter's state is saved, and
+// limiterPrunePeriod how often clients back to a new client's state are
+// forgotten.
+const (
+	limiterSavePeriod  = time.Minute
+	limiterPrunePeriod = time.Minute
+)
+
+type clientLimit struct {
+	Tokens      float64   `json:"tokens"`
+	Updated     time.Time `json:"updated"`
+	WindowStart time.Time `json:"window_start,omitempty"`
+	WindowCount int       `json:"window_count,omitempty"`
+	// Rejected is the number of consecutive rejected requests.
+	Rejected    int       `json:"rejected,omitempty"`
+	BannedUntil time.Time `json:"banned_until,omitempty"`
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	Clients map[string]*clientLimit `json:"clients"`
+}
+
+var limiter = &rateLimiter{Clients: make(map[string]*clientLimit)}
+
+// limiterPath returns the file the limiter's state is persisted to.
+func limiterPath() string {
+	return *counterFilePath + ".ratelimit"
+}
+
+func rateLimitEnabled() bool {
+	return *rateLimit > 0 || *quota > 0
+}
+
+// rateLimitKey returns the key the client at addr is limited by: its
+// address, or its /64 network for IPv6.
+func rateLimitKey(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() != nil {
+		return addr
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// allow reports whether the client may make a request at now and, if not,
+// how long it must wait before trying again and how many requests in a row
+// have been rejected. If penalize is false, a rejection doesn't count
+// towards a ban.
+func (l *rateLimiter) allow(client string, now time.Time, penalize bool) (bool, time.Duration, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.Clients[client]
+	if !ok {
+		c = &clientLimit{Tokens: float64(*rateBurst), Updated: now, WindowStart: now}
+		l.Clients[client] = c
+	}
+	if now.Before(c.BannedUntil) {
+		return false, c.BannedUntil.Sub(now), 0
+	}
+
+	if *rateLimit > 0 {
+		c.Tokens = math.Min(float64(*rateBurst), c.Tokens+now.Sub(c.Updated).Seconds()**rateLimit)
+	}
+	c.Updated = now
+	if *quota > 0 && now.Sub(c.WindowStart) >= *quotaWindow {
+		c.WindowStart, c.WindowCount = now, 0
+	}
+
+	var wait time.Duration
+	switch {
+	case *quota > 0 && c.WindowCount >= *quota:
+		wait = c.WindowStart.Add(*quotaWindow).Sub(now)
+	case *rateLimit > 0 && c.Tokens < 1:
+		wait = time.Duration((1 - c.Tokens) / *rateLimit * float64(time.Second))
+	default:
+		c.Tokens--
+		c.WindowCount++
+		c.Rejected = 0
+		return true, 0, 0
+	}
+
+	if !penalize {
+		return false, wait, c.Rejected
+	}
+	c.Rejected++
+	rejected := c.Rejected
+	if *banThreshold > 0 && c.Rejected >= *banThreshold {
+		c.BannedUntil, c.Rejected = now.Add(*banDuration), 0
+		log.Printf("Banned %s until %s", client, c.BannedUntil.Format(time.RFC3339))
+		return false, *banDuration, 0
+	}
+	return false, wait, rejected
+}
+
+// peek returns how long the client must wait before a request at now would
+// be allowed, or zero if it would be, without counting a request.
+func (l *rateLimiter) peek(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.Clients[client]
+	if !ok {
+		return 0
+	}
+	if now.Before(c.BannedUntil) {
+		return c.BannedUntil.Sub(now)
+	}
+	tokens := c.Tokens
+	if *rateLimit > 0 {
+		tokens = math.Min(float64(*rateBurst), tokens+now.Sub(c.Updated).Seconds()**rateLimit)
+	}
+	windowOver := now.Sub(c.WindowStart) >= *quotaWindow
+	switch {
+	case *quota > 0 && !windowOver && c.WindowCount >= *quota:
+		return c.WindowStart.Add(*quotaWindow).Sub(now)
+	case *rateLimit > 0 && tokens < 1:
+		return time.Duration((1 - tokens) / *rateLimit * float64(time.Second))
+	}
+	return 0
+}
+
+// maxBackoff caps the backoff suggested to clients that keep retrying.
+const maxBackoff = 5 * time.Minute
+
+// backoffPolicy tells clients how to space out retries. The nth retry
+// should wait for Initial * Multiplier^(n-1) seconds, up to Max, or for the
+// Retry-After time if that's longer. Jitter, if "full", means waiting a
+// random time between zero and that.
+type backoffPolicy struct {
+	Strategy   string  `json:"strategy"`
+	Initial    float64 `json:"initial_seconds"`
+	Multiplier float64 `json:"multiplier"`
+	Max        float64 `json:"max_seconds"`
+	Jitter     string  `json:"jitter"`
+}
+
+// rateLimitProblem is an RFC 7807 problem details body for a rejected
+// request.
+type rateLimitProblem struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail"`
+	RetryAfter int    `json:"retry_after"`
+	// Available is exactly when, in seconds, the next request would be
+	// allowed. RetryAfter may be later to slow down repeated retries.
+	Available float64       `json:"available_in_seconds"`
+	Attempt   int           `json:"attempt,omitempty"`
+	Backoff   backoffPolicy `json:"backoff"`
+}
+
+// backoff returns the policy clients should follow and how long the client
+// that has had rejected requests rejected in a row should wait, which is at
+// least wait.
+func backoff(wait time.Duration, rejected int) (backoffPolicy, time.Duration) {
+	initial := time.Second
+	if *rateLimit > 0 {
+		initial = time.Duration(float64(time.Second) / *rateLimit)
+	}
+	policy := backoffPolicy{
+		Strategy:   "exponential",
+		Initial:    initial.Seconds(),
+		Multiplier: 2,
+		Max:        maxBackoff.Seconds(),
+		Jitter:     "none",
+	}
+	suggested := initial
+	for i := 1; i < rejected && suggested < maxBackoff; i++ {
+		suggested *= 2
+	}
+	if suggested > maxBackoff {
+		suggested = maxBackoff
+	}
+	if suggested < wait {
+		suggested = wait
+	}
+	return policy, suggested
+}
+
+// prune forgets clients whose state is the same as a new client's would be.
+// l.mu must be held.
+func (l *rateLimiter) prune(now time.Time) {
+	for client, c := range l.Clients {
+		full := *rateLimit <= 0 || c.Tokens+now.Sub(c.Updated).Seconds()**rateLimit >= float64(*rateBurst)
+		windowOver := *quota <= 0 || now.Sub(c.WindowStart) >= *quotaWindow
+		if full && windowOver && !now.Before(c.BannedUntil) {
+			delete(l.Clients, client)
+		}
+	}
+}
+
+func (l *rateLimiter) load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := json.Unmarshal(data, l); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if l.Clients == nil {
+		l.Clients = make(map[string]*clientLimit)
+	}
+	l.prune(time.Now())
+	return nil
+}
+
+func (l *rateLimiter) save(path string) error {
+	l.mu.Lock()
+	l.prune(time.Now())
+	data, err := json.Marshal(l)
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// expire prunes the limiter every period, so that clients seen once don't
+// stay in memory.
+func (l *rateLimiter) expire(period time.Duration) {
+	for now := range time.Tick(period) {
+		configLock.RLock()
+		l.mu.Lock()
+		l.prune(now)
+		l.mu.Unlock()
+		configLock.RUnlock()
+	}
+}
+
+// persist saves the limiter's state every period while rate limiting is
+// enabled.
+func (l *rateLimiter) persist(path string, period time.Duration) {
+	for range time.Tick(period) {
+		configLock.RLock()
+		enabled := rateLimitEnabled()
+		configLock.RUnlock()
+		if !enabled {
+			continue
+		}
+		if err := l.save(path); err != nil {
+			log.Print("Failed to write rate limiter state:", err)
+		}
+	}
+}
+
+// rateLimited rejects requests from clients that have exceeded their limits
+// with 429 Too Many Requests, unless they asked to wait for their turn.
+func rateLimited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !rateLimitEnabled() {
+			h(w, req)
+			return
+		}
+		client := rateLimitKey(clientIP(req))
+		if maxWait := requestedWait(req); maxWait > 0 {
+			// Waiting mustn't hold up reloads, which the request is
+			// served after instead.
+			start := time.Now()
+			releaseConfigLock(req)
+			ok, position, wait := waitForTurn(req.Context(), client, start.Add(maxWait))
+			reacquireConfigLock(req)
+			w.Header().Set("X-Queue-Position", strconv.Itoa(position))
+			w.Header().Set("X-Queue-Wait", strconv.FormatFloat(time.Since(start).Seconds(), 'f', 3, 64))
+			if ok {
+				h(w, req)
+			} else {
+				tooManyRequests(w, wait, 0)
+			}
+			return
+		}
+
+		ok, wait, rejected := limiter.allow(client, time.Now(), true)
+		if ok {
+			h(w, req)
+			return
+		}
+		tooManyRequests(w, wait, rejected)
+	}
+}
+
+// tooManyRequests responds with 429 Too Many Requests. The response
+// suggests how long to wait, which grows the more often a client retries too
+// soon.
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, rejected int) {
+	policy, suggested := backoff(wait, rejected)
+	retryAfter := int(math.Ceil(suggested.Seconds()))
+	problem := rateLimitProblem{
+		Type:       "about:blank",
+		Title:      http.StatusText(http.StatusTooManyRequests),
+		Status:     http.StatusTooManyRequests,
+		Detail:     fmt.Sprintf("rate limit exceeded, retry in %d seconds", retryAfter),
+		RetryAfter: retryAfter,
+		Available:  math.Round(wait.Seconds()*1000) / 1000,
+		Attempt:    rejected,
+		Backoff:    policy,
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(problem)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.1":            "192.0.2.1",
+		"::ffff:192.0.2.1":     "::ffff:192.0.2.1",
+		"2001:db8:1:2:3:4:5:6": "2001:db8:1:2::/64",
+		"2001:db8:1:2:ffff::1": "2001:db8:1:2::/64",
+		"2001:db8:1:3::1":      "2001:db8:1:3::/64",
+		"/run/rpp.sock":        "/run/rpp.sock",
+	} {
+		if got := rateLimitKey(addr); got != want {
+			t.Errorf("rateLimitKey(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestRateLimitPrune(t *testing.T) {
+	setFlag(t, "rate-limit", "1")
+	setFlag(t, "rate-burst", "2")
+	l := &rateLimiter{Clients: make(map[string]*clientLimit)}
+	now := time.Now()
+	l.allow("a", now, true)
+	l.allow("b", now, true)
+	l.allow("b", now, true)
+
+	l.prune(now.Add(time.Second))
+	if _, ok := l.Clients["a"]; ok {
+		t.Error("client back to a full bucket not pruned")
+	}
+	if _, ok := l.Clients["b"]; !ok {
+		t.Error("client still short of tokens pruned")
+	}
+	l.prune(now.Add(2 * time.Second))
+	if len(l.Clients) != 0 {
+		t.Errorf("%d clients left once all are full", len(l.Clients))
+	}
+}
+
+func TestQueueCountsAdmissionsOnly(t *testing.T) {
+	setFlag(t, "rate-limit", "1")
+	setFlag(t, "rate-burst", "2")
+	const client = "198.51.100.7"
+	defer func() {
+		limiter.mu.Lock()
+		delete(limiter.Clients, client)
+		limiter.mu.Unlock()
+	}()
+
+	for i := 0; i < 3; i++ {
+		if wait := limiter.peek(client, time.Now()); wait != 0 {
+			t.Fatalf("new client must wait %s", wait)
+		}
+	}
+	limiter.mu.Lock()
+	_, known := limiter.Clients[client]
+	limiter.mu.Unlock()
+	if known {
+		t.Fatal("peek recorded the client")
+	}
+
+	// A request turned away by a full queue takes no token.
+	ctx := context.Background()
+	setFlag(t, "max-queue-length", "0")
+	if ok, _, _ := waitForTurn(ctx, client, time.Now().Add(10*time.Millisecond)); ok {
+		t.Fatal("request admitted past a full queue")
+	}
+	flag.Set("max-queue-length", "10")
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := waitForTurn(ctx, client, time.Now().Add(10*time.Millisecond)); !ok {
+			t.Fatalf("request %d within the burst refused", i+1)
+		}
+	}
+	if ok, _, wait := waitForTurn(ctx, client, time.Now().Add(10*time.Millisecond)); ok || wait <= 0 {
+		t.Fatalf("request past the burst: ok %t, wait %s", ok, wait)
+	}
+	limiter.mu.Lock()
+	c := *limiter.Clients[client]
+	limiter.mu.Unlock()
+	if c.Tokens < -0.01 || c.Tokens > 0.1 || c.WindowCount != 2 || c.Rejected != 0 {
+		t.Errorf("after two admissions: %+v", c)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisClient speaks just enough of the Redis protocol, RESP, for the
+// secret store, over one connection that is redialled if it fails.
+type redisClient struct {
+	addr     string
+	tls      bool
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+const redisTimeout = 5 * time.Second
+
+// newRedisClient returns a client for a redis:// or rediss:// URL such as
+// redis://:password@localhost:6379/0.
+func newRedisClient(rawurl string) (*redisClient, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid Redis URL %q, want redis://host:port/db", rawurl)
+	}
+	c := &redisClient{addr: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	return c, nil
+}
+
+// do sends a command and returns its reply: a string, an int64, nil for a
+// null reply or a []interface{}.
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(args)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// The connection is in an unknown state.
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+func (c *redisClient) dial() error {
+	var conn net.Conn
+	var err error
+	d := &net.Dialer{Timeout: redisTimeout}
+	if c.tls {
+		host, _, _ := net.SplitHostPort(c.addr)
+		conn, err = tls.DialWithDialer(d, "tcp", c.addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = d.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	if c.password != "" {
+		if _, err := c.roundTrip([]string{"AUTH", c.password}); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) roundTrip(args []string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.conn, sb.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// redisError is an error reply, after which the connection can be reused.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (c *redisClient) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", rest)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: malformed array length %q", rest)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The config file is reloaded on SIGHUP. Requests hold configLock for
+// reading while they are served, so a reload waits for the requests in
+// progress to finish and new ones wait for the reload; no connections are
+// dropped. Long waits and streams release it early.
+var configLock sync.RWMutex
+
+// restartOnly lists the flags that only take effect at startup.
+var restartOnly = map[string]bool{
+	"config":                  true,
+	"export":                  true,
+	"import":                  true,
+	"bundle-passphrase":       true,
+	"http":                    true,
+	"https":                   true,
+	"listen":                  true,
+	"internal-addr":           true,
+	"secrets-key":             true,
+	"secrets-store":           true,
+	"tls-cert":                true,
+	"tls-key":                 true,
+	"client-ca":               true,
+	"redirect-http":           true,
+	"read-timeout":            true,
+	"write-timeout":           true,
+	"idle-timeout":            true,
+	"max-header-bytes":        true,
+	"counter":                 true,
+	"counter-redis":           true,
+	"counter-redis-key":       true,
+	"counter-sync-interval":   true,
+	"cluster-serve":           true,
+	"cluster-primary":         true,
+	"cluster-key":             true,
+	"entropy-interval":        true,
+	"generators":              true,
+	"jobs":                    true,
+	"docs":                    true,
+	"journal":                 true,
+	"journal-size":            true,
+	"attestation-key":         true,
+	"deterministic-seed":      true,
+	"random-source":           true,
+	"fips":                    true,
+	"admin-audit-log":         true,
+	"log-output":              true,
+	"log-max-size":            true,
+	"log-max-files":           true,
+	"insecure-ok":             true,
+	"template-engine":         true,
+	"template-dir":            true,
+	"wordlists":               true,
+	"breach-patterns":         true,
+	"profile-example":         true,
+	"web-push-key":            true,
+	"web-push-subject":        true,
+	"web-push-subscriptions":  true,
+	"no-repeat-window":        true,
+	"no-repeat-size":          true,
+	"keep-alives":             true,
+	"tcp-keep-alive":          true,
+	"session-ticket-rotation": true,
+	"session-ticket-keys":     true,
+	"admin-api":               true,
+	"ip-stack":                true,
+	"proxy-protocol":          true,
+	"random-stream":           true,
+	"ssh-keys":                true,
+	"dev":                     true,
+}
+
+// commandLineFlags are the flags given on the command line, which take
+// precedence over the config file when it is reloaded too.
+var commandLineFlags = make(map[string]bool)
+
+func rememberCommandLine() {
+	flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+}
+
+type configLockKey struct{}
+
+// heldConfigLock is the read hold on configLock of a request, which may
+// give it up while it waits or streams.
+type heldConfigLock struct {
+	mu   sync.Mutex
+	held bool
+}
+
+func (l *heldConfigLock) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held {
+		configLock.RUnlock()
+		l.held = false
+	}
+}
+
+func (l *heldConfigLock) reacquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held {
+		configLock.RLock()
+		l.held = true
+	}
+}
+
+func withConfigLock(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		configLock.RLock()
+		hold := &heldConfigLock{held: true}
+		defer hold.release()
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), configLockKey{}, hold)))
+	})
+}
+
+// releaseConfigLock releases the lock held while serving req early, for
+// long-lived responses such as event streams and requests waiting in a queue
+// that would otherwise hold up reloads until they end.
+func releaseConfigLock(req *http.Request) {
+	if hold, ok := req.Context().Value(configLockKey{}).(*heldConfigLock); ok {
+		hold.release()
+	}
+}
+
+// reacquireConfigLock takes the lock released by releaseConfigLock again,
+// waiting for a reload in progress to finish.
+func reacquireConfigLock(req *http.Request) {
+	if hold, ok := req.Context().Value(configLockKey{}).(*heldConfigLock); ok {
+		hold.reacquire()
+	}
+}
+
+// resettable is implemented by flag values that accumulate values, and so
+// can't be restored to their default by setting it.
+type resettable interface {
+	reset()
+}
+
+func (l *linkList) reset() {
+	*l = nil
+}
+
+func (g authGroups) reset() {
+	for group := range g {
+		delete(g, group)
+	}
+}
+
+// setFlags sets every flag that can be reloaded and wasn't given on the
+// command line to its values in settings, or to its default.
+func setFlags(settings map[string][]string) error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || commandLineFlags[f.Name] || restartOnly[f.Name] {
+			return
+		}
+		if r, ok := f.Value.(resettable); ok {
+			r.reset()
+		} else if err = f.Value.Set(f.DefValue); err != nil {
+			return
+		}
+		for _, v := range settings[f.Name] {
+			if err = f.Value.Set(v); err != nil {
+				err = fmt.Errorf("setting %s: %s", f.Name, err)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// reloadConfig rereads the config file and applies it, along with the
+// settings from the imported bundle and example profile, if any. If the new settings are
+// invalid the current ones are kept.
+func reloadConfig() error {
+	settings, err := readConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	target := make(map[string][]string)
+	for name, values := range profileSettings {
+		target[name] = values
+	}
+	for name, values := range bundleSettings {
+		target[name] = values
+	}
+	for name, values := range settings {
+		if flag.Lookup(name) == nil {
+			log.Printf("Ignoring unknown setting %q", name)
+			continue
+		}
+		target[name] = values
+	}
+
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	// A replica's policy comes from the primary.
+	for name, values := range clusterSettings {
+		target[name] = values
+	}
+	current := settingValues()
+	var pending []settingChange
+	for name := range restartOnly {
+		if commandLineFlags[name] || bundleExcluded[name] {
+			continue
+		}
+		want, ok := target[name]
+		if !ok {
+			want = []string{flag.Lookup(name).DefValue}
+		}
+		for _, c := range diffSettings(map[string][]string{name: current[name]}, map[string][]string{name: want}) {
+			c.Pending = true
+			pending = append(pending, c)
+		}
+	}
+
+	err = setFlags(target)
+	if err == nil {
+		err = validateConfig()
+	}
+	if err == nil {
+		err = setupAuth()
+	}
+	if err != nil {
+		// Restore the current settings, which were valid.
+		setFlags(current)
+		setupAuth()
+		return err
+	}
+	atomic.StoreInt32(&bufferedLength, int32(*maxPasswordLength))
+	changes := append(diffSettings(current, settingValues()), pending...)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	recordConfigDiff(&configDiff{Time: time.Now(), Source: "reload", Changes: changes})
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestQueuedRequestsDontBlockReloads checks that a request waiting for its
+// turn doesn't hold configLock, which a reload needs.
+func TestQueuedRequestsDontBlockReloads(t *testing.T) {
+	setFlag(t, "rate-limit", "2")
+	setFlag(t, "rate-burst", "1")
+	setFlag(t, "max-queue-wait", "5s")
+	s := newServer()
+	defer func() {
+		limiter.mu.Lock()
+		delete(limiter.Clients, "192.0.2.1")
+		limiter.mu.Unlock()
+	}()
+
+	if rec := do(s, "", "/password.txt", nil); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", rec.Code)
+	}
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/password.txt", nil)
+		req.Header.Set("Prefer", "wait=5")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		done <- rec.Code
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	locked := make(chan struct{})
+	go func() {
+		configLock.Lock()
+		close(locked)
+		configLock.Unlock()
+	}()
+	select {
+	case <-locked:
+	case <-time.After(300 * time.Millisecond):
+		t.Fatal("a reload waited for a queued request")
+	}
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("queued request: status %d", code)
+	}
+}
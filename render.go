@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Handlers build a result value and leave it to render to write it in the
+// format the client asked for, either with the format query parameter or the
+// Accept header. Plain text is the default, unless -default-format gives
+// the authenticated client another.
+//
+// Responses are written byte for byte as rendered, without a byte order
+// mark, so that piping them into a clipboard tool copies exactly the
+// password. Single passwords in plain text have no final newline and the
+// other formats end with one; newline=0 (or trim=1) and newline=1 override
+// that for any format.
+
+// renderable is implemented by results that can be rendered as plain text
+// and CSV. All results must also be encodable as JSON and XML.
+type renderable interface {
+	text() string
+	csvRecords() [][]string
+}
+
+var formatContentTypes = map[string]string{
+	"text": "text/plain; charset=utf-8",
+	"json": "application/json",
+	"xml":  "application/xml",
+	"csv":  "text/csv; charset=utf-8",
+	"yaml": "application/yaml",
+}
+
+// formatAliases maps media types and format parameter values to formats.
+var formatAliases = map[string]string{
+	"text":               "text",
+	"txt":                "text",
+	"text/plain":         "text",
+	"json":               "json",
+	"application/json":   "json",
+	"xml":                "xml",
+	"application/xml":    "xml",
+	"text/xml":           "xml",
+	"csv":                "csv",
+	"text/csv":           "csv",
+	"yaml":               "yaml",
+	"yml":                "yaml",
+	"application/yaml":   "yaml",
+	"application/x-yaml": "yaml",
+	"text/yaml":          "yaml",
+}
+
+// defaultFormats is a flag.Value holding identity=format pairs.
+type defaultFormats map[string]string
+
+func (d defaultFormats) String() string {
+	return strings.Join(d.Values(), ",")
+}
+
+func (d defaultFormats) Values() []string {
+	s := make([]string, 0, len(d))
+	for id, format := range d {
+		s = append(s, id+"="+format)
+	}
+	sort.Strings(s)
+	return s
+}
+
+func (d defaultFormats) Set(value string) error {
+	i := strings.LastIndex(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid default format %q, want identity=format", value)
+	}
+	format := strings.ToLower(value[i+1:])
+	if _, ok := formatContentTypes[format]; !ok {
+		return fmt.Errorf("unknown format %q", value[i+1:])
+	}
+	d[value[:i]] = format
+	return nil
+}
+
+func (d defaultFormats) reset() {
+	for id := range d {
+		delete(d, id)
+	}
+}
+
+// clientFormats are the formats authenticated clients get when they don't
+// ask for one, for clients that can't set headers or parameters.
+var clientFormats = defaultFormats{}
+
+func init() {
+	flag.Var(&clientFormats, "default-format", "format for the client with an `identity=format` to get when it doesn't ask for one, e.g. legacy=json (may be repeated)")
+}
+
+// defaultFormat returns the format req gets when it doesn't ask for one.
+func defaultFormat(req *http.Request) string {
+	if format, ok := clientFormats[requestIdentity(req)]; ok {
+		return format
+	}
+	return "text"
+}
+
+// negotiateFormat returns the format to use for req, or an empty string if
+// none of the client's acceptable formats are supported.
+func negotiateFormat(req *http.Request) (string, error) {
+	if f := req.FormValue("format"); f != "" {
+		format, ok := formatAliases[strings.ToLower(f)]
+		if !ok {
+			return "", fmt.Errorf("unsupported format %q", f)
+		}
+		return format, nil
+	}
+
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return defaultFormat(req), nil
+	}
+
+	type mediaRange struct {
+		typ string
+		q   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{typ, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	for _, r := range ranges {
+		// HTTP libraries send */* by default, which expresses no
+		// preference.
+		if r.typ == "*/*" {
+			return defaultFormat(req), nil
+		}
+		if r.typ == "text/*" {
+			return "text", nil
+		}
+		if format, ok := formatAliases[r.typ]; ok {
+			return format, nil
+		}
+	}
+	return "", nil
+}
+
+// finalNewline returns whether req asks for responses to end with a
+// newline, and set false if it leaves that to the format.
+func finalNewline(req *http.Request) (want, set bool, err error) {
+	if req.FormValue("trim") == "1" {
+		return false, true, nil
+	}
+	switch req.FormValue("newline") {
+	case "":
+		return false, false, nil
+	case "0":
+		return false, true, nil
+	case "1":
+		return true, true, nil
+	}
+	return false, false, errors.New("newline must be 0 or 1")
+}
+
+// render writes v to w in the format negotiated for req.
+func render(w http.ResponseWriter, req *http.Request, v renderable) {
+	format, err := negotiateFormat(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	want, set, err := finalNewline(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if format == "" {
+		http.Error(w, "none of the acceptable formats are supported", http.StatusNotAcceptable)
+		return
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "text":
+		buf.WriteString(v.text())
+	case "json":
+		err = json.NewEncoder(&buf).Encode(v)
+	case "xml":
+		buf.WriteString(xml.Header)
+		if err = xml.NewEncoder(&buf).Encode(v); err == nil {
+			buf.WriteByte('\n')
+		}
+	case "csv":
+		cw := csv.NewWriter(&buf)
+		err = cw.WriteAll(v.csvRecords())
+	case "yaml":
+		var n yamlNode
+		if n, err = yamlTree(v); err == nil {
+			writeYAML(&buf, n)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body := buf.Bytes()
+	if set {
+		body = bytes.TrimSuffix(body, []byte("\n"))
+		if want {
+			body = append(body, '\n')
+		}
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	// Handlers of secrets may have set no-store.
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Add("Vary", "Accept")
+	w.Write(body)
+}
+
+type passwordResult struct {
+	XMLName   xml.Name          `json:"-" xml:"password"`
+	Password  string            `json:"password" xml:"value"`
+	Usability *usabilityScore   `json:"usability,omitempty" xml:"usability,omitempty"`
+	Strength  *strengthEstimate `json:"strength,omitempty" xml:"strength,omitempty"`
+	// RotateBy is the date by which -rotation-policy suggests rotating
+	// the password.
+	RotateBy string `json:"rotate_by,omitempty" xml:"rotate_by,omitempty"`
+}
+
+func (r passwordResult) text() string {
+	if r.Usability == nil {
+		return r.Password
+	}
+	s := r.Password + "\n" + r.Usability.String()
+	if r.Strength != nil {
+		s += r.Strength.String()
+	}
+	return s
+}
+
+func (r passwordResult) csvRecords() [][]string {
+	return passwordBatch{Passwords: []passwordResult{r}}.csvRecords()
+}
+
+// passwordBatch is the result of a request for more than one password.
+type passwordBatch struct {
+	XMLName     xml.Name          `json:"-" xml:"passwords"`
+	Passwords   []passwordResult  `json:"passwords" xml:"password"`
+	Report      *complianceReport `json:"report,omitempty" xml:"report,omitempty"`
+	Attestation *attestation      `json:"attestation,omitempty" xml:"attestation,omitempty"`
+}
+
+func (b passwordBatch) text() string {
+	var sb strings.Builder
+	for i, r := range b.Passwords {
+		if r.Usability != nil && i > 0 {
+			// Separate the blocks of scores.
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(r.Password)
+		sb.WriteByte('\n')
+		if r.Usability != nil {
+			sb.WriteString(r.Usability.String())
+		}
+		if r.Strength != nil {
+			sb.WriteString(r.Strength.String())
+		}
+	}
+	if b.Report != nil {
+		sb.WriteByte('\n')
+		sb.WriteString(b.Report.String())
+	}
+	return sb.String()
+}
+
+func (b passwordBatch) csvRecords() [][]string {
+	verbose := len(b.Passwords) > 0 && b.Passwords[0].Usability != nil
+	header := []string{"password"}
+	if verbose {
+		header = append(header, "usability", "typing", "memory", "dictation", "strength", "guesses_log10")
+	}
+	records := [][]string{header}
+	for _, r := range b.Passwords {
+		record := []string{r.Password}
+		if verbose {
+			u := r.Usability
+			for _, f := range []float64{u.Overall, u.Typing, u.Memory, u.Dictation} {
+				record = append(record, strconv.FormatFloat(f, 'f', 2, 64))
+			}
+			record = append(record, strconv.Itoa(r.Strength.Score), strconv.FormatFloat(r.Strength.GuessesLog10, 'f', 2, 64))
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+type counterResult struct {
+	XMLName xml.Name `json:"-" xml:"counter"`
+	Counter uint64   `json:"counter" xml:",chardata"`
+	// Rounded is set if Counter is rounded or approximate for privacy.
+	Rounded bool `json:"rounded,omitempty" xml:"rounded,attr,omitempty"`
+	// Endpoints breaks down the passwords generated since the server
+	// started, Uptime seconds ago, unless Counter is rounded. They are left
+	// out of plain text and CSV so those stay a single number.
+	Endpoints []endpointCount `json:"endpoints,omitempty" xml:"endpoint,omitempty"`
+	Uptime    float64         `json:"uptime_seconds,omitempty" xml:"uptime,attr,omitempty"`
+}
+
+func (c counterResult) text() string {
+	return strconv.FormatUint(c.Counter, 10)
+}
+
+func (c counterResult) csvRecords() [][]string {
+	return [][]string{{"counter"}, {c.text()}}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Batches requested with report=1 include a compliance report: every
+// password is independently checked against the policy it was generated
+// under, and regenerated if it fails. If an attestation key is configured,
+// the report is also signed so that it can be attached to credential
+// issuance tickets as evidence.
+var attestationKeyPath = flag.String("attestation-key", "", "PEM PKCS #8 Ed25519 private key `file` for signing compliance reports")
+
+var attestationKey ed25519.PrivateKey
+
+func init() {
+	registerFeature(feature{name: "signed compliance reports", kind: "subsystem", active: func() bool {
+		return attestationKey != nil
+	}})
+}
+
+// maxPolicyRetries limits how many times a password that fails its policy
+// check is regenerated.
+const maxPolicyRetries = 10
+
+type complianceReport struct {
+	Policy     genOptions `json:"policy" xml:"policy"`
+	Count      int        `json:"count" xml:"count"`
+	Compliant  bool       `json:"compliant" xml:"compliant"`
+	Retries    int        `json:"retries" xml:"retries"`
+	MaxRetries int        `json:"max_retries" xml:"max_retries"`
+	MinEntropy float64    `json:"min_entropy_bits" xml:"min_entropy_bits"`
+	MaxEntropy float64    `json:"max_entropy_bits" xml:"max_entropy_bits"`
+	Generated  time.Time  `json:"generated" xml:"generated"`
+	// BatchDigest is the SHA-256 hash of the salt followed by the
+	// passwords, each terminated by a newline. It ties the report to the
+	// batch without revealing the passwords.
+	BatchSalt   string `json:"batch_salt" xml:"batch_salt"`
+	BatchDigest string `json:"batch_digest" xml:"batch_digest"`
+}
+
+func (r *complianceReport) String() string {
+	return fmt.Sprintf("compliant: %t\ncount: %d\nretries: %d\nmax retries: %d\nentropy bits: %.1f-%.1f\nbatch digest: %s\n",
+		r.Compliant, r.Count, r.Retries, r.MaxRetries, r.MinEntropy, r.MaxEntropy, r.BatchDigest)
+}
+
+// attestation is a compliance report signed with the attestation key.
+type attestation struct {
+	// Document is the JSON encoded report that was signed.
+	Document  string `json:"document" xml:"document"`
+	Signature string `json:"signature" xml:"signature"`
+	PublicKey string `json:"public_key" xml:"public_key"`
+	Algorithm string `json:"algorithm" xml:"algorithm,attr"`
+}
+
+func loadAttestationKey(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("%s: no PEM data found", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	var ok bool
+	if attestationKey, ok = key.(ed25519.PrivateKey); !ok {
+		return fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return nil
+}
+
+// generateCompliant generates a password and checks it against opts,
+// regenerating it if necessary. It returns the number of retries needed.
+func generateCompliant(ctx context.Context, opts genOptions) (string, int, error) {
+	for retries := 0; retries <= maxPolicyRetries; retries++ {
+		password, err := generate(ctx, opts)
+		if err != nil {
+			return "", retries, err
+		}
+		if checkPolicy(opts, password) == nil {
+			return password, retries, nil
+		}
+	}
+	return "", maxPolicyRetries, fmt.Errorf("failed to generate a compliant password after %d retries", maxPolicyRetries)
+}
+
+// checkPolicy checks password against opts independently of how it was
+// generated.
+func checkPolicy(opts genOptions, password string) error {
+	if opts.constrained() && !opts.satisfiesConstraints(password) {
+		return errors.New("password doesn't satisfy start, end or no-leading-digit")
+	}
+	if pattern := opts.pattern(); pattern != "" {
+		elems, err := parsePattern(pattern)
+		if err != nil {
+			return err
+		}
+		if !matchPattern(elems, password, opts.wordlist()) {
+			return errors.New("password doesn't match pattern")
+		}
+		return nil
+	}
+
+	if len(password) != opts.Length {
+		return fmt.Errorf("password length %d, want %d", len(password), opts.Length)
+	}
+	allowed := opts.characters()
+	if opts.Mode == "mobile" {
+		allowed = alphabet + mobileSymbols
+	}
+	for _, c := range password {
+		if !strings.ContainsRune(allowed, c) {
+			return fmt.Errorf("password contains disallowed character")
+		}
+	}
+	if opts.RequireEach && !coversClasses(password, allowed) {
+		return errors.New("password lacks a character of each class")
+	}
+	return nil
+}
+
+// matchPattern reports whether s could have been generated from elems with
+// words from list.
+func matchPattern(elems []patternElem, s string, list []string) bool {
+	if len(elems) == 0 {
+		return s == ""
+	}
+	e, rest := elems[0], elems[1:]
+	switch e.kind {
+	case 'W', 'w':
+		for _, w := range list {
+			if e.kind == 'W' {
+				w = capitalize(w)
+			}
+			if strings.HasPrefix(s, w) && matchPattern(rest, s[len(w):], list) {
+				return true
+			}
+		}
+		return false
+	case '\\':
+		lit := string(e.literal)
+		return strings.HasPrefix(s, lit) && matchPattern(rest, s[len(lit):], list)
+	}
+	if s == "" {
+		return false
+	}
+	var set string
+	switch e.kind {
+	case 'l':
+		set = alphabetLower
+	case 'u':
+		set = alphabetUpper
+	case 'd':
+		set = patternDigits
+	case '!':
+		set = patternSymbols
+	}
+	return strings.IndexByte(set, s[0]) >= 0 && matchPattern(rest, s[1:], list)
+}
+
+// policyEntropy returns the entropy in bits of passwords generated with opts.
+// Words drawn by weight are credited with their min-entropy.
+func policyEntropy(opts genOptions) float64 {
+	if opts.constrained() {
+		free := opts
+		free.Start, free.End, free.NoLeadingDigit = "", "", false
+		return math.Max(0, policyEntropy(free)-constraintLoss(opts))
+	}
+	bits := func(n int) float64 {
+		return math.Log2(float64(n))
+	}
+
+	if pattern := opts.pattern(); pattern != "" {
+		elems, err := parsePattern(pattern)
+		if err != nil {
+			return 0
+		}
+		var total float64
+		for _, e := range elems {
+			switch e.kind {
+			case 'l':
+				total += bits(len(alphabetLower))
+			case 'u':
+				total += bits(len(alphabetUpper))
+			case 'd':
+				total += bits(len(patternDigits))
+			case '!':
+				total += bits(len(patternSymbols))
+			case 'W', 'w':
+				if opts.Sampler == "weighted" {
+					total += opts.weightedList().minEntropy
+				} else {
+					total += bits(len(opts.wordlist()))
+				}
+			}
+		}
+		return total
+	}
+
+	if opts.Mode == "mobile" {
+		letters, digits, symbols := mobileLayout(opts.Length)
+		total := float64(digits)*bits(len(alphabetDigits)) + float64(symbols)*bits(len(mobileSymbols))
+		if letters > 0 {
+			total += bits(len(alphabetUpper)) + float64(letters-1)*bits(len(alphabetLower))
+		}
+		return total
+	}
+	if opts.RequireEach {
+		return coveringEntropy(opts.characters(), opts.Length)
+	}
+	return float64(opts.Length) * bits(len(opts.characters()))
+}
+
+// newComplianceReport returns a report for a batch generated under opts,
+// signing it if an attestation key is configured.
+func newComplianceReport(opts genOptions, passwords []string, retries []int) (*complianceReport, *attestation, error) {
+	if opts.pattern() != "" {
+		// Length is ignored when using a pattern.
+		opts.Length = 0
+	}
+	r := &complianceReport{
+		Policy:    opts,
+		Count:     len(passwords),
+		Compliant: true,
+		Generated: time.Now().UTC(),
+	}
+	for i, p := range passwords {
+		if checkPolicy(opts, p) != nil {
+			r.Compliant = false
+		}
+		r.Retries += retries[i]
+		if retries[i] > r.MaxRetries {
+			r.MaxRetries = retries[i]
+		}
+	}
+	entropy := math.Round(policyEntropy(opts)*10) / 10
+	r.MinEntropy, r.MaxEntropy = entropy, entropy
+
+	salt := make([]byte, 16)
+	if _, err := readRandom(salt); err != nil {
+		return nil, nil, err
+	}
+	h := sha256.New()
+	h.Write(salt)
+	for _, p := range passwords {
+		h.Write([]byte(p + "\n"))
+	}
+	r.BatchSalt = hex.EncodeToString(salt)
+	r.BatchDigest = hex.EncodeToString(h.Sum(nil))
+
+	if attestationKey == nil {
+		return r, nil, nil
+	}
+	doc, err := json.Marshal(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, &attestation{
+		Document:  base64.StdEncoding.EncodeToString(doc),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(attestationKey, doc)),
+		PublicKey: base64.StdEncoding.EncodeToString(attestationKey.Public().(ed25519.PublicKey)),
+		Algorithm: "Ed25519",
+	}, nil
+}
+
+// attestationKeyHandler serves the public key reports are signed with.
+func attestationKeyHandler(w http.ResponseWriter, req *http.Request) {
+	if attestationKey == nil {
+		http.NotFound(w, req)
+		return
+	}
+	der, err := x509.MarshalPKIXPublicKey(attestationKey.Public())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Every request has an ID, returned in the X-Request-ID header and
+// attached to the log lines and audit entries about it, so a client's
+// report of a failed request can be matched with the server's logs. An ID
+// sent by the client or a proxy in X-Request-ID is used if it is safe to
+// log, so the ID can follow the request through several services.
+
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// validRequestID reports whether id can be used as a request ID: it must
+// be short and only contain characters that can't forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':' || c == '/' || c == '+' || c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	cryptorand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// withRequestID returns req with its request ID, the one it was sent with
+// or a new one, in its context, and sets the X-Request-ID header of w.
+func withRequestID(w http.ResponseWriter, req *http.Request) *http.Request {
+	id := req.Header.Get("X-Request-ID")
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", id)
+	return req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+}
+
+// requestID returns the ID of req, or "-" if it has none.
+func requestID(req *http.Request) string {
+	if id, ok := req.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return "-"
+}
+
+// logf logs a line about req, prefixed with its request ID.
+func logf(req *http.Request, format string, v ...interface{}) {
+	log.Printf("[%s] %s", requestID(req), fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every byte drawn from a random number generator is counted, so operators
+// of VMs and embedded hosts with little entropy can spot clients draining it
+// and correlate that with the kernel's entropy pool.
+var (
+	entropyInterval = flag.Duration("entropy-interval", time.Minute, "`duration` over which random bytes drawn are totalled")
+	entropyAlert    = flag.Int64("entropy-alert", 0, "log a warning when more than this many random `bytes` are drawn in an -entropy-interval (0 to disable)")
+)
+
+// The random number generators bytes are drawn from.
+const (
+	rngCrypto = "crypto"
+	rngHWRNG  = "hwrng"
+	rngEGD    = "egd"
+	rngMath   = "math"
+)
+
+var rngSources = []string{rngCrypto, rngHWRNG, rngEGD, rngMath}
+
+// rngSource is the generator of -random-source.
+var rngSource = rngCrypto
+
+// intnBytes is how many bytes each draw of an int consumes, not counting
+// those drawn again to avoid modulo bias.
+const intnBytes = 8
+
+// intnSource is the generator ints are drawn from: math/rand only with
+// -deterministic-seed.
+func intnSource() string {
+	if *deterministicSeed != 0 {
+		return rngMath
+	}
+	return rngSource
+}
+
+// kernelEntropyPath reports the bits of entropy in the Linux kernel's pool.
+const kernelEntropyPath = "/proc/sys/kernel/random/entropy_avail"
+
+// rngBudget counts the random bytes drawn by each generator.
+type rngBudget struct {
+	mu    sync.Mutex
+	total map[string]uint64
+	// current is what has been drawn in the interval in progress, last in
+	// the most recent complete interval.
+	current, last map[string]uint64
+	alerting      bool
+}
+
+var rngUsage = &rngBudget{
+	total:   make(map[string]uint64),
+	current: make(map[string]uint64),
+	last:    make(map[string]uint64),
+}
+
+func (b *rngBudget) add(source string, n int) {
+	b.mu.Lock()
+	b.total[source] += uint64(n)
+	b.current[source] += uint64(n)
+	b.mu.Unlock()
+}
+
+// rotate ends the current interval and reports how many bytes were drawn in
+// it.
+func (b *rngBudget) rotate() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var sum uint64
+	for _, n := range b.current {
+		sum += n
+	}
+	b.last, b.current = b.current, make(map[string]uint64)
+	return sum
+}
+
+// watch ends an interval every period, warning when more bytes than
+// -entropy-alert were drawn in it.
+func (b *rngBudget) watch(period time.Duration) {
+	for range time.Tick(period) {
+		drawn := b.rotate()
+		configLock.RLock()
+		threshold := *entropyAlert
+		configLock.RUnlock()
+
+		alerting := threshold > 0 && drawn > uint64(threshold)
+		if alerting {
+			log.Printf("Warning: %d random bytes drawn in the last %s, more than -entropy-alert=%d", drawn, period, threshold)
+		}
+		b.mu.Lock()
+		b.alerting = alerting
+		b.mu.Unlock()
+	}
+}
+
+// snapshot returns the bytes drawn in total and in the last interval by each
+// generator, and whether the last interval exceeded -entropy-alert.
+func (b *rngBudget) snapshot() (total, last map[string]uint64, alerting bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	total = make(map[string]uint64, len(b.total))
+	for k, v := range b.total {
+		total[k] = v
+	}
+	last = make(map[string]uint64, len(b.last))
+	for k, v := range b.last {
+		last[k] = v
+	}
+	return total, last, b.alerting
+}
+
+// readRandom reads random bytes, by default from crypto/rand, counting them.
+func readRandom(p []byte) (int, error) {
+	n, err := random.Read(p)
+	rngUsage.add(rngSource, n)
+	return n, err
+}
+
+// randIntn returns a uniformly distributed int in [0,n), by default from
+// crypto/rand, counting the bytes drawn.
+func randIntn(n int) int {
+	rngUsage.add(intnSource(), intnBytes)
+	return random.Intn(n)
+}
+
+// kernelEntropy returns the bits of entropy available in the kernel's pool,
+// if the platform reports it.
+func kernelEntropy() (int, bool) {
+	data, err := ioutil.ReadFile(kernelEntropyPath)
+	if err != nil {
+		return 0, false
+	}
+	bits, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return bits, err == nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Rotation hooks turn a job into a minimal rotation coordinator. Before
+// rotating, the pre hook is told a rotation is about to happen and can veto
+// it by failing. After the new value is stored, the post hook is told where
+// to find it along with a rotation id and confirmation token. If the
+// consumer doesn't POST these to /rotations/confirm within the confirmation
+// timeout, the sink is rolled back to its previous value.
+type hooksConfig struct {
+	Pre            string `json:"pre"`
+	Post           string `json:"post"`
+	ConfirmTimeout string `json:"confirm_timeout"`
+	// Secret, if set, is used to sign hook requests with HMAC-SHA256 in
+	// the X-Rotation-Signature header.
+	Secret string `json:"secret"`
+}
+
+type rotationHooks struct {
+	pre, post      string
+	confirmTimeout time.Duration
+	secret         []byte
+}
+
+func newRotationHooks(c hooksConfig) (*rotationHooks, error) {
+	h := &rotationHooks{pre: c.Pre, post: c.Post, secret: []byte(c.Secret)}
+	if c.ConfirmTimeout != "" {
+		d, err := time.ParseDuration(c.ConfirmTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("confirm_timeout: %s", err)
+		}
+		if c.Post == "" {
+			return nil, errors.New("confirm_timeout needs a post hook to send the confirmation token to")
+		}
+		h.confirmTimeout = d
+	}
+	return h, nil
+}
+
+// hookEvent is the body POSTed to hooks.
+type hookEvent struct {
+	Event    string `json:"event"`
+	Job      string `json:"job"`
+	Rotation string `json:"rotation"`
+	Location string `json:"location,omitempty"`
+	// Token and ConfirmBy are set when the rotation must be confirmed.
+	Token     string     `json:"token,omitempty"`
+	ConfirmBy *time.Time `json:"confirm_by,omitempty"`
+}
+
+var hookClient = &http.Client{Timeout: 30 * time.Second}
+
+func (h *rotationHooks) send(url string, event hookEvent) error {
+	// Stream the event, without the confirmation token, for the status page.
+	status := event
+	status.Token = ""
+	streams.publishJSON("jobs", status)
+	notifyRotation(status)
+
+	if url == "" {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(h.secret) > 0 {
+		mac := hmac.New(sha256.New, h.secret)
+		mac.Write(body)
+		req.Header.Set("X-Rotation-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := hookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s hook %s: %s", event.Event, url, resp.Status)
+	}
+	return nil
+}
+
+// pendingRotation is a rotation awaiting confirmation.
+type pendingRotation struct {
+	job      *job
+	token    string
+	previous string
+	timer    *time.Timer
+}
+
+var pendingRotations = struct {
+	sync.Mutex
+	m map[string]*pendingRotation
+}{m: make(map[string]*pendingRotation)}
+
+// rotate runs a rotation for j, calling its hooks.
+func (j *job) rotate() error {
+	b := make([]byte, 32)
+	if _, err := readRandom(b); err != nil {
+		return err
+	}
+	id, token := hex.EncodeToString(b[:16]), hex.EncodeToString(b[16:])
+	event := hookEvent{Job: j.name, Rotation: id}
+
+	event.Event = "rotation.pending"
+	if err := j.hooks.send(j.hooks.pre, event); err != nil {
+		return fmt.Errorf("rotation aborted: %s", err)
+	}
+
+	var previous string
+	if j.hooks.confirmTimeout > 0 {
+		var err error
+		if previous, err = j.sink.read(); err != nil {
+			return fmt.Errorf("reading current value for rollback: %s", err)
+		}
+	}
+
+	value, err := generate(context.Background(), j.opts)
+	if err != nil {
+		return err
+	}
+	stats.record("jobs", 1)
+	counter.record("jobs", 1)
+	if err := j.sink.write(value); err != nil {
+		return err
+	}
+	log.Printf("Job %s: stored new credential in %s (rotation %s)", j.name, j.sink, id)
+
+	event.Event = "rotation.stored"
+	event.Location = j.sink.String()
+	if j.hooks.confirmTimeout > 0 {
+		deadline := time.Now().Add(j.hooks.confirmTimeout).UTC()
+		event.Token, event.ConfirmBy = token, &deadline
+
+		p := &pendingRotation{job: j, token: token, previous: previous}
+		pendingRotations.Lock()
+		pendingRotations.m[id] = p
+		p.timer = time.AfterFunc(j.hooks.confirmTimeout, func() {
+			j.rollback(id)
+		})
+		pendingRotations.Unlock()
+	}
+	if err := j.hooks.send(j.hooks.post, event); err != nil {
+		// The consumer may still pick up the value, so leave it to the
+		// confirmation timeout to roll back.
+		return err
+	}
+	return nil
+}
+
+// rollback restores the previous value of an unconfirmed rotation.
+func (j *job) rollback(id string) {
+	pendingRotations.Lock()
+	p, ok := pendingRotations.m[id]
+	delete(pendingRotations.m, id)
+	pendingRotations.Unlock()
+	if !ok {
+		return
+	}
+
+	if p.previous == "" {
+		log.Printf("Job %s: rotation %s was not confirmed but there is no previous value to restore", j.name, id)
+		return
+	}
+	if err := j.sink.write(p.previous); err != nil {
+		log.Printf("Job %s: rotation %s was not confirmed and rollback failed: %s", j.name, id, err)
+		return
+	}
+	log.Printf("Job %s: rotation %s was not confirmed, restored previous value", j.name, id)
+	if err := j.hooks.send(j.hooks.post, hookEvent{Event: "rotation.rolled_back", Job: j.name, Rotation: id, Location: j.sink.String()}); err != nil {
+		log.Printf("Job %s: %s", j.name, err)
+	}
+}
+
+func confirmRotationHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "confirmations must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, token := req.FormValue("rotation"), req.FormValue("token")
+
+	pendingRotations.Lock()
+	p, ok := pendingRotations.m[id]
+	if ok && subtle.ConstantTimeCompare([]byte(p.token), []byte(token)) == 1 {
+		p.timer.Stop()
+		delete(pendingRotations.m, id)
+	} else {
+		ok = false
+	}
+	pendingRotations.Unlock()
+
+	if !ok {
+		http.Error(w, "unknown rotation or invalid token", http.StatusNotFound)
+		return
+	}
+	log.Printf("Job %s: rotation %s confirmed", p.job.name, id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -rotation-policy suggests when passwords should be rotated, from how much
+// entropy they have: stronger passwords can be kept longer. Responses in
+// JSON and XML then give each password a rotate_by date, and the policy is
+// served at /rotation-policy, e.g. for onboarding emails to quote.
+var (
+	rotationPolicy = flag.String("rotation-policy", "", "comma-separated `bits=duration` tiers: passwords with at least bits of entropy should be rotated within duration")
+	rotationNote   = flag.String("rotation-policy-note", "", "`text` explaining the rotation policy, served with it")
+)
+
+func init() {
+	registerFeature(feature{name: "rotation hints", kind: "subsystem", active: func() bool {
+		return *rotationPolicy != ""
+	}})
+}
+
+type rotationTier struct {
+	MinEntropy  float64 `json:"min_entropy_bits" xml:"min_entropy_bits,attr"`
+	RotateAfter string  `json:"rotate_after" xml:"rotate_after,attr"`
+	Days        int     `json:"rotate_after_days" xml:"rotate_after_days,attr"`
+	after       time.Duration
+}
+
+type rotationPolicyResult struct {
+	XMLName xml.Name       `json:"-" xml:"rotation_policy"`
+	Tiers   []rotationTier `json:"tiers" xml:"tier"`
+	Note    string         `json:"note,omitempty" xml:"note,omitempty"`
+}
+
+func (r rotationPolicyResult) text() string {
+	var sb strings.Builder
+	for _, t := range r.Tiers {
+		fmt.Fprintf(&sb, "%g+ bits: rotate within %d days\n", t.MinEntropy, t.Days)
+	}
+	if r.Note != "" {
+		sb.WriteString(r.Note + "\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (r rotationPolicyResult) csvRecords() [][]string {
+	records := [][]string{{"min_entropy_bits", "rotate_after", "rotate_after_days"}}
+	for _, t := range r.Tiers {
+		records = append(records, []string{strconv.FormatFloat(t.MinEntropy, 'g', -1, 64), t.RotateAfter, strconv.Itoa(t.Days)})
+	}
+	return records
+}
+
+// parseRotationPolicy returns the tiers of -rotation-policy, weakest first.
+func parseRotationPolicy() ([]rotationTier, error) {
+	var tiers []rotationTier
+	for _, s := range strings.Split(*rotationPolicy, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		i := strings.Index(s, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("-rotation-policy: %q isn't bits=duration", s)
+		}
+		bits, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil || bits < 0 {
+			return nil, fmt.Errorf("-rotation-policy: %q isn't a number of bits", s[:i])
+		}
+		d, err := time.ParseDuration(s[i+1:])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("-rotation-policy: %q isn't a positive duration", s[i+1:])
+		}
+		tiers = append(tiers, rotationTier{MinEntropy: bits, RotateAfter: d.String(), Days: int(d.Hours() / 24), after: d})
+	}
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].MinEntropy < tiers[j].MinEntropy
+	})
+	return tiers, nil
+}
+
+// rotationDate returns the date by which a password with bits of entropy
+// generated now should be rotated, or an empty string if the policy doesn't
+// cover it.
+func rotationDate(bits float64) string {
+	tiers, _ := parseRotationPolicy()
+	var after time.Duration
+	for _, t := range tiers {
+		// Round as reports do, so a password reported with a tier's
+		// entropy gets that tier.
+		if math.Round(bits*10)/10 >= t.MinEntropy {
+			after = t.after
+		}
+	}
+	if after == 0 {
+		return ""
+	}
+	return time.Now().UTC().Add(after).Format("2006-01-02")
+}
+
+// rotationPolicyHandler serves the rotation policy.
+func rotationPolicyHandler(w http.ResponseWriter, req *http.Request) {
+	tiers, _ := parseRotationPolicy()
+	if len(tiers) == 0 {
+		http.Error(w, "no rotation policy is configured", http.StatusNotFound)
+		return
+	}
+	render(w, req, rotationPolicyResult{Tiers: tiers, Note: *rotationNote})
+}
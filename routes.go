@@ -0,0 +1,440 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+)
+
+// Every endpoint is described by a route, which is used both to register it
+// and to document it in the OpenAPI specification.
+
+// Routes other than the health checks and admin routes can be turned off
+// with -disabled-routes, for example to take an endpoint out of service
+// while it is misbehaving without restarting.
+var disabledRoutes = flag.String("disabled-routes", "", "comma-separated `patterns` of routes to respond to with 404 Not Found, such as /token,/uuid")
+
+// param is a parameter a route accepts. Query parameters may also be given
+// in a form body, as handlers read them with FormValue; form parameters are
+// only accepted in the body.
+type param struct {
+	name, in, typ, description string
+	required                   bool
+	enum                       []string
+}
+
+type route struct {
+	// group is the route group, or empty for routes that are always
+	// public.
+	group   string
+	pattern string
+	handler http.HandlerFunc
+	// rateLimited routes are subject to the per-client rate limits.
+	rateLimited bool
+	// challenged routes generate passwords, so anonymous clients using
+	// them rapidly are challenged if -challenge is set.
+	challenged bool
+	// methods are the methods the route accepts, GET if empty.
+	methods []string
+	summary string
+	params  []param
+	// rendered routes return results in the negotiated format; others
+	// return contentType.
+	rendered    bool
+	contentType string
+	// undocumented routes are left out of the specification.
+	undocumented bool
+	// internal routes are served on -internal-addr instead of the public
+	// addresses, if it is set.
+	internal bool
+	// secret routes return passwords, keys or other secrets, so their
+	// responses must never be cached.
+	secret bool
+	// unapproved routes rely on primitives FIPS 140-3 doesn't approve, so
+	// they aren't served in FIPS mode.
+	unapproved bool
+}
+
+// server serves the configured routes. Each server has its own mux, so
+// handlers can be exercised in isolation with net/http/httptest.
+type server struct {
+	mux *http.ServeMux
+	// routes are the registered routes, in the order they were registered.
+	routes  []route
+	handler http.Handler
+	// internal is set for the -internal-addr server.
+	internal bool
+}
+
+// newServer returns a server for the configured routes, wrapped in the
+// middleware every request passes through.
+func newServer() *server {
+	s := &server{mux: http.NewServeMux()}
+	for _, r := range s.configuredRoutes() {
+		if s.serves(r) {
+			s.register(r)
+		}
+	}
+	s.handler = withConfigLock(withIPFilter(securityHeaders(withCanonicalHost(compressed(instrument(s.mux))))))
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.handler.ServeHTTP(w, req)
+}
+
+func (s *server) register(r route) {
+	// Strict parameter checks are innermost, so that authentication, which
+	// records the client on the response writer, is given the recorder.
+	var h http.Handler = strictParams(r, r.handler)
+	if r.rateLimited {
+		h = rateLimited(h.ServeHTTP)
+	}
+	if r.challenged {
+		h = challenged(r.pattern, h)
+	}
+	if r.group != "" {
+		h = requireAuth(r.group, h)
+	}
+	if !alwaysEnabled(r.pattern) {
+		h = disableable(r.pattern, h)
+	}
+	if r.secret {
+		h = noStore(h)
+	}
+	s.mux.Handle(r.pattern, h)
+	s.routes = append(s.routes, r)
+}
+
+// alwaysEnabled reports whether the route for pattern can't be disabled.
+func alwaysEnabled(pattern string) bool {
+	return pattern == "/healthz" || pattern == "/readyz" || strings.HasPrefix(pattern, "/admin/")
+}
+
+func disabledPatterns() []string {
+	var patterns []string
+	for _, p := range strings.Split(*disabledRoutes, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+func routeDisabled(pattern string) bool {
+	for _, p := range disabledPatterns() {
+		if p == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// disableable responds with 404 Not Found instead of calling h while the
+// route for pattern is disabled.
+func disableable(pattern string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if routeDisabled(pattern) {
+			http.NotFound(w, req)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+var (
+	countParam = param{name: "count", in: "query", typ: "integer", description: "number of results, up to -max-count"}
+	lenParam   = param{name: "len", in: "query", typ: "integer", description: "password length, between -min-length and -max-length"}
+
+	wordsParam      = param{name: "words", in: "query", typ: "integer", description: "number of words in passphrases, from 3 to 10"}
+	separatorParam  = param{name: "separator", in: "query", typ: "string", description: "what separates the words of passphrases", enum: separatorNames()}
+	capitalizeParam = param{name: "capitalize", in: "query", typ: "integer", description: "1 to capitalize the words of passphrases", enum: []string{"1"}}
+
+	startParam          = param{name: "start", in: "query", typ: "string", description: "class the first character must be in", enum: positionClassNames}
+	endParam            = param{name: "end", in: "query", typ: "string", description: "class the last character must be in", enum: positionClassNames}
+	noLeadingDigitParam = param{name: "no-leading-digit", in: "query", typ: "integer", description: "1 to not start passwords with a digit", enum: []string{"1"}}
+
+	nocountParam     = param{name: "nocount", in: "query", typ: "integer", description: "1 to generate previews, which aren't counted as passwords issued", enum: []string{"1"}}
+	requireEachParam = param{name: "require-each", in: "query", typ: "integer", description: "1 to include a character of each of the charset's classes", enum: []string{"1"}}
+	charsetParam     = param{name: "charset", in: "query", typ: "string", description: "characters passwords in the default mode are drawn from, by default -charset", enum: append(charsetNames(), customCharset)}
+)
+
+// configuredRoutes returns the routes enabled by the configuration.
+func (s *server) configuredRoutes() []route {
+	rs := []route{
+		{group: "ui", pattern: "/", handler: indexHandler, secret: true, rateLimited: true, challenged: true, contentType: "text/html",
+			summary: "The password page",
+			params: []param{
+				countParam,
+				lenParam,
+				{name: "mode", in: "query", typ: "string", description: "kind of password", enum: []string{"mobile", "passphrase"}},
+				charsetParam,
+				wordsParam,
+				separatorParam,
+				capitalizeParam,
+				nocountParam,
+			}},
+		{group: "api", pattern: "/password.txt", handler: apiHandler, secret: true, rateLimited: true, challenged: true, rendered: true,
+			methods: []string{http.MethodGet, http.MethodPost},
+			summary: "Generate passwords",
+			params: []param{
+				lenParam,
+				{name: "count", in: "query", typ: "integer", description: "number of passwords, up to -max-count, or streamed up to -max-stream-count"},
+				{name: "mode", in: "query", typ: "string", description: "kind of password", enum: []string{"mobile", "memorable", "passphrase"}},
+				wordsParam,
+				separatorParam,
+				capitalizeParam,
+				{name: "pattern", in: "query", typ: "string", description: "structure of the passwords, such as u{2}l{4}D{2}S"},
+				charsetParam,
+				requireEachParam,
+				startParam,
+				endParam,
+				noLeadingDigitParam,
+				{name: "transform", in: "query", typ: "string", description: "comma-separated transformations to apply in order: " + strings.Join(transformStepNames(), ", ")},
+				{name: "chunk", in: "query", typ: "integer", description: "split passwords into groups of this many characters"},
+				{name: "chunk-sep", in: "query", typ: "string", description: "what separates the groups, by default a hyphen"},
+				{name: "verbose", in: "query", typ: "integer", description: "1 to include usability and strength estimates", enum: []string{"1"}},
+				{name: "report", in: "query", typ: "integer", description: "1 to include a compliance report", enum: []string{"1"}},
+				{name: "lang", in: "query", typ: "string", description: "language of the words in memorable passwords, as listed by /wordlists"},
+				{name: "sampler", in: "query", typ: "string", description: "how words are drawn: uniformly, or by weight from the language's weighted wordlist", enum: []string{"uniform", "weighted"}},
+				{name: "entropy", in: "form", typ: "string", description: "client entropy to mix with the server's"},
+				nocountParam,
+			}},
+		{group: "api", pattern: "/passwords/bulk", handler: bulkHandler, secret: true, rateLimited: true, challenged: true, contentType: "text/csv",
+			methods: []string{http.MethodPost},
+			summary: "Generate a password for each username in a JSON array or CSV or TSV file, returned as CSV or TSV",
+			params: []param{
+				lenParam,
+				{name: "mode", in: "query", typ: "string", description: "kind of password", enum: []string{"mobile", "memorable", "passphrase"}},
+				wordsParam,
+				separatorParam,
+				capitalizeParam,
+				{name: "pattern", in: "query", typ: "string", description: "structure of the passwords, such as u{2}l{4}D{2}S"},
+				charsetParam,
+				requireEachParam,
+				{name: "lang", in: "query", typ: "string", description: "language of the words in memorable passwords, as listed by /wordlists"},
+				{name: "sampler", in: "query", typ: "string", description: "how words are drawn", enum: []string{"uniform", "weighted"}},
+				startParam,
+				endParam,
+				noLeadingDigitParam,
+				{name: "format", in: "query", typ: "string", description: "format of the response", enum: []string{"csv", "tsv"}},
+				{name: "usernames", in: "form", typ: "string", description: "CSV or TSV file of usernames, in the first column, if the body is a multipart form"},
+			}},
+		{group: "api", pattern: "/password/hashed", handler: hashedHandler, secret: true, unapproved: true, rateLimited: true, challenged: true, rendered: true,
+			methods: []string{http.MethodGet, http.MethodPost},
+			summary: "Generate a password with its argon2id or bcrypt hash",
+			params: []param{
+				{name: "algorithm", in: "query", typ: "string", description: "hash algorithm, by default -hash-algorithm", enum: hashAlgorithms},
+				lenParam,
+				{name: "mode", in: "query", typ: "string", description: "kind of password", enum: []string{"mobile", "memorable", "passphrase"}},
+				wordsParam,
+				separatorParam,
+				capitalizeParam,
+				{name: "pattern", in: "query", typ: "string", description: "structure of the password, such as u{2}l{4}D{2}S"},
+				charsetParam,
+				requireEachParam,
+				{name: "lang", in: "query", typ: "string", description: "language of the words in memorable passwords, as listed by /wordlists"},
+				{name: "sampler", in: "query", typ: "string", description: "how words are drawn", enum: []string{"uniform", "weighted"}},
+				startParam,
+				endParam,
+				noLeadingDigitParam,
+			}},
+		{group: "api", pattern: "/attestation-key.pem", handler: attestationKeyHandler, contentType: "application/x-pem-file",
+			summary: "The public key compliance reports are signed with"},
+		{group: "api", pattern: "/token", handler: tokenHandler, secret: true, rateLimited: true, rendered: true,
+			summary: "Generate a random token",
+			params: []param{
+				{name: "bytes", in: "query", typ: "integer", description: "number of random bytes, from 1 to 1024"},
+				{name: "encoding", in: "query", typ: "string", description: "encoding of the bytes", enum: []string{"hex", "base64url", "base32"}},
+			}},
+		{group: "api", pattern: "/uuid", handler: uuidHandler, rateLimited: true, rendered: true,
+			summary: "Generate UUIDs",
+			params: []param{
+				{name: "version", in: "query", typ: "integer", description: "UUID version", enum: []string{"4", "7"}},
+				countParam,
+			}},
+		{group: "api", pattern: "/wireguard", handler: wireGuardHandler, secret: true, unapproved: true, rateLimited: true, rendered: true,
+			summary: "Generate a WireGuard key pair",
+			params: []param{
+				{name: "preshared", in: "query", typ: "integer", description: "1 to include a preshared key", enum: []string{"1"}},
+			}},
+		{group: "api", pattern: "/totp", handler: totpHandler, secret: true, rateLimited: true, rendered: true,
+			summary: "Generate a TOTP shared secret and its otpauth URI",
+			params: []param{
+				{name: "account", in: "query", typ: "string", description: "account the secret is for, such as alice@example.com", required: true},
+				{name: "issuer", in: "query", typ: "string", description: "service the account is with"},
+				{name: "algorithm", in: "query", typ: "string", description: "HMAC hash function", enum: totpAlgorithms},
+				{name: "digits", in: "query", typ: "integer", description: "digits in each one-time password", enum: totpDigits},
+				{name: "period", in: "query", typ: "integer", description: "seconds each one-time password is valid for", enum: totpPeriods},
+				{name: "bytes", in: "query", typ: "integer", description: "length of the secret, from 16 to 64 bytes"},
+				{name: "qr", in: "query", typ: "integer", description: "1 for a PNG QR code of the URI instead", enum: []string{"1"}},
+			}},
+		{group: "api", pattern: "/rotation-policy", handler: rotationPolicyHandler, rendered: true,
+			summary: "How soon passwords should be rotated, by entropy"},
+		{group: "api", pattern: "/wordlists", handler: wordlistsHandler, rendered: true,
+			summary: "The wordlists memorable passwords can be composed from"},
+		{group: "api", pattern: "/strength", handler: strengthHandler, rateLimited: true, rendered: true,
+			methods: []string{http.MethodPost},
+			summary: "Estimate a password's strength",
+			params: []param{
+				{name: "password", in: "form", typ: "string", description: "the password to rate", required: true},
+			}},
+		{group: "api", pattern: "/rotations/confirm", handler: confirmRotationHandler,
+			methods: []string{http.MethodPost},
+			summary: "Confirm a scheduled rotation",
+			params: []param{
+				{name: "rotation", in: "query", typ: "string", description: "rotation id", required: true},
+				{name: "token", in: "query", typ: "string", description: "confirmation token", required: true},
+			}},
+		{group: "api", pattern: "/commit", handler: commitHandler, rateLimited: true, rendered: true,
+			methods: []string{http.MethodPost},
+			summary: "Commit to a secret random value"},
+		{group: "api", pattern: "/reveal", handler: revealHandler, secret: true, rateLimited: true, rendered: true,
+			methods: []string{http.MethodPost},
+			summary: "Reveal a committed value and derive results from it",
+			params: []param{
+				{name: "id", in: "query", typ: "string", description: "commitment id", required: true},
+				{name: "nonce", in: "query", typ: "string", description: "client nonce", required: true},
+				{name: "pick", in: "query", typ: "integer", description: "pick a number from 0 to pick-1"},
+				lenParam,
+			}},
+		{group: "api", pattern: "/openapi.json", handler: s.openAPIHandler, contentType: "application/json",
+			summary: "This specification"},
+		{group: "ui", pattern: "/counter", handler: counterHandler, rendered: true, internal: true,
+			summary: "Passwords generated"},
+		{group: "ui", pattern: "/counter/events", handler: counterEventsHandler, contentType: "text/event-stream", internal: true,
+			summary: "The counter as server-sent events"},
+		{group: "monitoring", pattern: "/stats", handler: statsHandler, rendered: true, internal: true,
+			summary: "Passwords generated by hour, day and endpoint"},
+		{group: "ui", pattern: "/static/", handler: staticHandler, undocumented: true},
+		{group: "monitoring", pattern: "/version", handler: versionHandler, rendered: true, internal: true,
+			summary: "The server's version, commit, Go version and platform"},
+		{group: "monitoring", pattern: "/compliance", handler: s.complianceHandler, rendered: true, internal: true,
+			summary: "Whether the server is in FIPS mode, with its cryptographic module and random source"},
+		{group: "monitoring", pattern: "/metrics", handler: metricsHandler, contentType: "text/plain", internal: true,
+			summary: "Prometheus metrics"},
+		// Health checks are always public so load balancers can probe them.
+		{pattern: "/healthz", handler: healthHandler, contentType: "text/plain", internal: true,
+			summary: "Liveness check"},
+		{pattern: "/readyz", handler: readyHandler, contentType: "text/plain", internal: true,
+			summary: "Readiness check"},
+		{pattern: "/robots.txt", handler: robotsHandler, contentType: "text/plain",
+			summary: "Which paths crawlers may visit, set by -robots"},
+		{pattern: "/favicon.ico", handler: faviconHandler, contentType: "image/x-icon",
+			summary: "The site icon, drawn in -theme-color unless -favicon is set"},
+		{pattern: "/.well-known/security.txt", handler: securityTxtHandler, contentType: "text/plain",
+			summary: "Where to report vulnerabilities, if -security-contact is set"},
+		{group: "monitoring", pattern: "/.well-known/monitoring", handler: monitoringHandler, contentType: "application/json", internal: true,
+			summary: "Metrics, health checks and objectives for monitoring systems"},
+	}
+	rs = append(rs, debugRoutes()...)
+	if *journalWindow > 0 {
+		rs = append(rs, route{group: "admin", pattern: "/admin/journal", handler: journalHandler, rendered: true,
+			summary: "Recent requests",
+			params: []param{
+				{name: "minutes", in: "query", typ: "integer", description: "how many minutes back to go"},
+			}})
+	}
+	if *jobsPath != "" {
+		rs = append(rs, route{group: "admin", pattern: "/admin/events/jobs", handler: streamHandler("jobs"), contentType: "text/event-stream",
+			summary: "Rotation events as server-sent events"})
+	}
+	if *webPushKeyPath != "" {
+		rs = append(rs,
+			route{group: "admin", pattern: "/admin/push", handler: pushPageHandler, contentType: "text/html",
+				summary: "Page to get browser notifications of rotations on"},
+			route{group: "admin", pattern: "/admin/push/subscribe", handler: pushSubscribeHandler,
+				methods: []string{http.MethodPost},
+				summary: "Send notifications of rotations to the push subscription in the JSON body"},
+			route{group: "admin", pattern: "/admin/push/unsubscribe", handler: pushUnsubscribeHandler,
+				methods: []string{http.MethodPost},
+				summary: "Stop sending notifications to the push subscription in the JSON body"})
+	}
+	rs = append(rs, route{group: "admin", pattern: "/admin/config/diff", handler: configDiffHandler, rendered: true,
+		summary: "What the last reload or settings change changed"})
+	rs = append(rs, route{group: "admin", pattern: "/admin/capabilities", handler: capabilitiesHandler, rendered: true,
+		summary: "The build's versions and which optional features it has and are enabled"})
+	if *adminAPI {
+		rs = append(rs,
+			route{group: "admin", pattern: "/admin/settings", handler: settingsHandler, rendered: true,
+				methods: []string{http.MethodGet, http.MethodPost},
+				summary: "Settings that can be changed at runtime, changed by POSTing new values",
+				params: []param{
+					{name: "rate-limit", in: "form", typ: "number", description: "sustained requests per second allowed per client"},
+					{name: "rate-burst", in: "form", typ: "integer", description: "requests a client may make in a burst"},
+					{name: "quota", in: "form", typ: "integer", description: "requests allowed per client per quota window"},
+					{name: "quota-window", in: "form", typ: "string", description: "quota window, such as 24h"},
+					{name: "min-length", in: "form", typ: "integer", description: "minimum password length"},
+					{name: "max-length", in: "form", typ: "integer", description: "maximum password length"},
+					{name: "max-count", in: "form", typ: "integer", description: "maximum number of passwords per request"},
+					{name: "suggestions", in: "form", typ: "integer", description: "number of passwords to suggest on the page"},
+					{name: "disabled-routes", in: "form", typ: "string", description: "comma-separated patterns of routes to disable"},
+				}},
+			route{group: "admin", pattern: "/admin/counter/reset", handler: resetCounterHandler,
+				methods: []string{http.MethodPost},
+				summary: "Reset the counter and stats to zero"})
+	}
+	if *clusterServe {
+		rs = append(rs, route{group: "admin", pattern: "/admin/cluster/policy", handler: clusterPolicyHandler, contentType: "application/json",
+			summary: "The generation policy, for replicas started with -cluster-primary",
+			params: []param{
+				{name: "wait", in: "query", typ: "string", description: "version of the policy the replica has, to wait until it changes"},
+			}})
+	}
+	if *randomStream {
+		rs = append(rs, route{group: "api", pattern: "/random/stream", handler: randomStreamHandler, secret: true, rateLimited: true, contentType: "application/octet-stream",
+			summary: "Random bytes, streamed at up to -random-stream-rate bytes per second",
+			params: []param{
+				{name: "bytes", in: "query", typ: "integer", description: "number of bytes, up to -random-stream-max-bytes", required: true},
+			}})
+	}
+	if *sshKeys {
+		rs = append(rs, route{group: "api", pattern: "/sshkey", handler: sshKeyHandler, secret: true, rateLimited: true, challenged: true, rendered: true,
+			summary: "Generate an SSH key pair, or the ssh-keygen command to generate it locally instead",
+			params: []param{
+				{name: "type", in: "query", typ: "string", description: "key type", enum: []string{"ed25519", "rsa"}},
+				{name: "bits", in: "query", typ: "integer", description: "size of RSA keys", enum: rsaKeySizes},
+				{name: "comment", in: "query", typ: "string", description: "comment on the public key, such as user@host"},
+				{name: "local", in: "query", typ: "integer", description: "1 to only return the ssh-keygen command, so the private key never leaves the client", enum: []string{"1"}},
+				{name: "format", in: "query", typ: "string", description: "zip to download id_<type> and id_<type>.pub as a zip file", enum: []string{"zip"}},
+			}})
+	}
+	if *secretsKeyPath != "" {
+		rs = append(rs,
+			route{group: "api", pattern: "/secrets", handler: createSecretHandler, secret: true, rateLimited: true, challenged: true, rendered: true,
+				methods: []string{http.MethodPost},
+				summary: "Store a secret, or a generated password, behind a link that works once",
+				params: []param{
+					{name: "secret", in: "form", typ: "string", description: "the secret to share, or empty to generate a password"},
+					{name: "ttl", in: "query", typ: "string", description: "how long the secret lasts unviewed, such as 1h, up to -secret-max-ttl"},
+					lenParam,
+					{name: "mode", in: "query", typ: "string", description: "kind of generated password", enum: []string{"mobile", "memorable", "passphrase"}},
+					wordsParam,
+					separatorParam,
+					capitalizeParam,
+					{name: "pattern", in: "query", typ: "string", description: "structure of the generated password, such as u{2}l{4}D{2}S"},
+					charsetParam,
+					requireEachParam,
+					{name: "lang", in: "query", typ: "string", description: "language of the words in memorable passwords, as listed by /wordlists"},
+					{name: "sampler", in: "query", typ: "string", description: "how words are drawn", enum: []string{"uniform", "weighted"}},
+					startParam,
+					endParam,
+					noLeadingDigitParam,
+				}},
+			route{group: "ui", pattern: "/s/", handler: viewSecretHandler, secret: true, rateLimited: true, rendered: true,
+				methods: []string{http.MethodGet, http.MethodPost},
+				summary: "A one-time secret's page on GET, and the secret, which is then deleted, on POST"})
+	}
+	if *challengeKind != "" {
+		rs = append(rs, route{group: "ui", pattern: "/challenge", handler: challengeHandler, rateLimited: true, contentType: "text/html",
+			methods: []string{http.MethodGet, http.MethodPost},
+			summary: "The challenge anonymous clients generating passwords rapidly must pass, which is POSTed back solved",
+			params: []param{
+				{name: "next", in: "query", typ: "string", description: "path to return to once the challenge is passed"},
+			}})
+	}
+	if *docsPage {
+		rs = append(rs, route{group: "api", pattern: "/docs", handler: docsHandler, undocumented: true})
+	}
+	return rs
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// One-time secrets share a password through a link that works once: the
+// password is stored encrypted with AES-GCM under -secrets-key, and the
+// first POST to its link returns and deletes it. Unviewed secrets expire.
+// Only a hash of each link's ID is stored, so the store alone can't be used
+// to find the links.
+var (
+	secretsKeyPath = flag.String("secrets-key", "", "`file` of hex 32-byte AES keys, newest first, to encrypt one-time secrets with, enabling /secrets")
+	secretsStore   = flag.String("secrets-store", "memory", "where one-time secrets are stored: memory, or a redis:// or rediss:// `url`")
+	secretTTL      = flag.Duration("secret-ttl", 24*time.Hour, "how long one-time secrets last unviewed by default")
+	secretMaxTTL   = flag.Duration("secret-max-ttl", 7*24*time.Hour, "longest ttl one-time secrets can be given")
+)
+
+func init() {
+	registerFeature(feature{name: "one-time secrets", kind: "subsystem", active: func() bool {
+		return *secretsKeyPath != ""
+	}})
+}
+
+const (
+	// maxSecretLength limits secrets that are sent rather than generated.
+	maxSecretLength = 10 << 10
+	secretIDBytes   = 16
+	// secretPurgePeriod is how often expired secrets are purged from
+	// memory.
+	secretPurgePeriod = time.Minute
+)
+
+var errSecretNotFound = errors.New("secret not found: it has been viewed or has expired")
+
+// secretStore stores encrypted secrets by the hash of their IDs.
+type secretStore interface {
+	// put stores value under key until expires.
+	put(key string, value []byte, expires time.Time) error
+	// take returns the value under key and deletes it, or
+	// errSecretNotFound if there is none.
+	take(key string) ([]byte, error)
+}
+
+// secretKeys are the AES keys from -secrets-key. Secrets are encrypted with
+// the first and decrypted with any, so keys can be rotated.
+var (
+	secretKeys [][32]byte
+	secrets    secretStore
+)
+
+// setupSecrets reads the keys and opens the store.
+func setupSecrets() error {
+	keys, err := readSessionTicketKeys(*secretsKeyPath)
+	if err != nil {
+		return err
+	}
+	secretKeys = keys
+	if *secretsStore == "memory" {
+		s := &memorySecretStore{secrets: make(map[string]storedSecret)}
+		go s.purge(secretPurgePeriod)
+		secrets = s
+		return nil
+	}
+	c, err := newRedisClient(*secretsStore)
+	if err != nil {
+		return fmt.Errorf("-secrets-store: %s", err)
+	}
+	secrets = redisSecretStore{c}
+	return nil
+}
+
+type storedSecret struct {
+	value   []byte
+	expires time.Time
+}
+
+// memorySecretStore keeps secrets in memory, so they are lost on restart.
+type memorySecretStore struct {
+	mu      sync.Mutex
+	secrets map[string]storedSecret
+}
+
+func (s *memorySecretStore) put(key string, value []byte, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[key] = storedSecret{value, expires}
+	return nil
+}
+
+func (s *memorySecretStore) take(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[key]
+	delete(s.secrets, key)
+	if !ok || time.Now().After(secret.expires) {
+		return nil, errSecretNotFound
+	}
+	return secret.value, nil
+}
+
+// purge deletes expired secrets every period.
+func (s *memorySecretStore) purge(period time.Duration) {
+	for range time.Tick(period) {
+		now := time.Now()
+		s.mu.Lock()
+		for key, secret := range s.secrets {
+			if now.After(secret.expires) {
+				delete(s.secrets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// redisSecretStore keeps secrets in Redis, which expires them. It needs
+// Redis 6.2 or later, for GETDEL.
+type redisSecretStore struct {
+	c *redisClient
+}
+
+const redisSecretPrefix = "random-password-please:secret:"
+
+func (s redisSecretStore) put(key string, value []byte, expires time.Time) error {
+	ms := time.Until(expires).Milliseconds()
+	_, err := s.c.do("SET", redisSecretPrefix+key, string(value), "PX", fmt.Sprint(ms))
+	return err
+}
+
+func (s redisSecretStore) take(key string) ([]byte, error) {
+	reply, err := s.c.do("GETDEL", redisSecretPrefix+key)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return nil, errSecretNotFound
+	}
+	return []byte(value), nil
+}
+
+// secretStoreKey returns the key the secret with id is stored under.
+func secretStoreKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// sealSecret encrypts secret with the newest key, authenticating the key it
+// is stored under.
+func sealSecret(key, secret string) ([]byte, error) {
+	aead, err := secretAEAD(secretKeys[0])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := cryptorand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(secret), []byte(key)), nil
+}
+
+// openSecret decrypts a sealed secret with whichever key sealed it.
+func openSecret(key string, sealed []byte) (string, error) {
+	for _, k := range secretKeys {
+		aead, err := secretAEAD(k)
+		if err != nil {
+			return "", err
+		}
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if secret, err := aead.Open(nil, nonce, ciphertext, []byte(key)); err == nil {
+			return string(secret), nil
+		}
+	}
+	return "", errors.New("secret can't be decrypted with any of the -secrets-key keys")
+}
+
+func secretAEAD(key [32]byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type secretLink struct {
+	XMLName xml.Name  `json:"-" xml:"secret"`
+	URL     string    `json:"url" xml:"url"`
+	Expires time.Time `json:"expires" xml:"expires"`
+}
+
+func (l secretLink) text() string {
+	return l.URL
+}
+
+func (l secretLink) csvRecords() [][]string {
+	return [][]string{{"url", "expires"}, {l.URL, l.Expires.Format(time.RFC3339)}}
+}
+
+type secretResult struct {
+	XMLName xml.Name `json:"-" xml:"secret"`
+	Secret  string   `json:"secret" xml:",chardata"`
+}
+
+func (r secretResult) text() string {
+	return r.Secret
+}
+
+func (r secretResult) csvRecords() [][]string {
+	return [][]string{{"secret"}, {r.Secret}}
+}
+
+// createSecretHandler stores the secret POSTed, or else a password
+// generated with the policy parameters of /password.txt, and returns its
+// link.
+func createSecretHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "secrets must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := req.URL.Query()["secret"]; ok {
+		http.Error(w, "secrets are only accepted in the request body", http.StatusBadRequest)
+		return
+	}
+	ttl := *secretTTL
+	if s := req.FormValue("ttl"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 || d > *secretMaxTTL {
+			http.Error(w, fmt.Sprintf("ttl must be a duration such as 1h, up to %s", *secretMaxTTL), http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	secret := req.PostFormValue("secret")
+	if len(secret) > maxSecretLength {
+		http.Error(w, fmt.Sprintf("secret must be at most %d bytes", maxSecretLength), http.StatusBadRequest)
+		return
+	}
+	if secret == "" {
+		n, ok := readLength(w, req)
+		if !ok {
+			return
+		}
+		opts, err := readGenOptions(req, n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, span := startGenerateSpan(req.Context(), opts, 1)
+		secret, err = generate(ctx, opts)
+		span.end()
+		if err != nil {
+			generationFailed(w, req, err)
+			return
+		}
+		stats.record("secrets", 1)
+		counter.record("secret", 1)
+	}
+
+	b := make([]byte, secretIDBytes)
+	if _, err := cryptorand.Read(b); err != nil {
+		http.Error(w, "failed to read random bytes", http.StatusInternalServerError)
+		return
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+	key := secretStoreKey(id)
+	sealed, err := sealSecret(key, secret)
+	if err == nil {
+		err = secrets.put(key, sealed, time.Now().Add(ttl))
+	}
+	if err != nil {
+		logf(req, "Storing secret: %s", err)
+		http.Error(w, "failed to store the secret", http.StatusServiceUnavailable)
+		return
+	}
+	link := secretLink{URL: secretURL(req, id), Expires: time.Now().Add(ttl).UTC().Truncate(time.Second)}
+	w.Header().Set("Cache-Control", "no-store")
+	render(w, req, link)
+}
+
+// secretURL returns the link to the secret with id. Behind a trusted proxy
+// the scheme is taken from its X-Forwarded-Proto.
+func secretURL(req *http.Request, id string) string {
+	scheme := "http"
+	if isHTTPS(req) {
+		scheme = "https"
+	}
+	host := pageHost(req)
+	if host == "" {
+		return "/s/" + id
+	}
+	return scheme + "://" + host + "/s/" + id
+}
+
+// viewSecretHandler shows a page with a button to reveal the secret on GET,
+// so that link previews don't use it up, and reveals and deletes it on POST.
+func viewSecretHandler(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/s/")
+	if b, err := base64.RawURLEncoding.DecodeString(id); err != nil || len(b) != secretIDBytes {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	page := strings.Contains(req.Header.Get("Accept"), "text/html")
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, secretHTML, html.EscapeString(*pageTitle),
+			`<form method="post"><p>This secret can only be viewed once.</p><button>Show the secret</button></form>`)
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := secretStoreKey(id)
+	sealed, err := secrets.take(key)
+	if err == errSecretNotFound {
+		if page {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, secretHTML, html.EscapeString(*pageTitle), "<p>This secret has already been viewed or has expired.</p>")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var secret string
+	if err == nil {
+		secret, err = openSecret(key, sealed)
+	}
+	if err != nil {
+		logf(req, "Taking secret: %s", err)
+		http.Error(w, "failed to retrieve the secret", http.StatusServiceUnavailable)
+		return
+	}
+	if page {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, secretHTML, html.EscapeString(*pageTitle),
+			"<p>This secret has now been deleted, so copy it before leaving the page.</p><pre>"+html.EscapeString(secret)+"</pre>")
+		return
+	}
+	render(w, req, secretResult{Secret: secret})
+}
+
+var secretHTML = `<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<title>%s: one-time secret</title>
+</head>
+<body>
+	<main style="text-align: center">
+		%s
+	</main>
+</body>
+</html>
+`
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// useSecrets enables one-time secrets with an in-memory store for the rest
+// of the test.
+func useSecrets(t *testing.T) {
+	setFlag(t, "secrets-key", "test")
+	oldKeys, oldStore := secretKeys, secrets
+	secretKeys = [][32]byte{{1}}
+	secrets = &memorySecretStore{secrets: make(map[string]storedSecret)}
+	t.Cleanup(func() { secretKeys, secrets = oldKeys, oldStore })
+}
+
+// TestSecretPagesEscape checks that the pages secrets.go writes with fmt
+// escape the title and the secret.
+func TestSecretPagesEscape(t *testing.T) {
+	useSecrets(t)
+	setFlag(t, "title", `<b>title</b>`)
+	s := newServer()
+	const secret = `"><script>alert(1)</script>&amp;`
+
+	rec := do(s, http.MethodPost, "/secrets?format=json", url.Values{"secret": {secret}})
+	var link secretLink
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &link) != nil {
+		t.Fatalf("creating the secret failed with status %d: %s", rec.Code, rec.Body)
+	}
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view := func(method string) string {
+		req := httptest.NewRequest(method, u.Path, nil)
+		req.Header.Set("Accept", "text/html")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		body := rec.Body.String()
+		if strings.Contains(body, "<b>title") {
+			t.Errorf("%s %s: the title is unescaped", method, u.Path)
+		}
+		if !strings.Contains(body, "&lt;b&gt;title&lt;/b&gt;") {
+			t.Errorf("%s %s: the title is missing", method, u.Path)
+		}
+		return body
+	}
+	view(http.MethodGet)
+	body := view(http.MethodPost)
+	if strings.Contains(body, "<script>") {
+		t.Error("the secret is unescaped")
+	}
+	if !strings.Contains(body, "&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;&amp;amp;") {
+		t.Errorf("the secret is missing, escaped:\n%s", body)
+	}
+	// The page saying it's gone.
+	view(http.MethodPost)
+}
+
+func TestSecretURLScheme(t *testing.T) {
+	setFlag(t, "trusted-proxies", "10.0.0.0/8")
+	for _, tt := range []struct {
+		remote, proto string
+		want          string
+	}{
+		{"192.0.2.1:1234", "", "http://example.com/s/id"},
+		{"192.0.2.1:1234", "https", "http://example.com/s/id"},
+		{"10.0.0.1:1234", "https", "https://example.com/s/id"},
+		{"10.0.0.1:1234", "http", "http://example.com/s/id"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/secrets", nil)
+		req.RemoteAddr = tt.remote
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if got := secretURL(req, "id"); got != tt.want {
+			t.Errorf("from %s with X-Forwarded-Proto %q: %s, want %s", tt.remote, tt.proto, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"time"
+)
+
+// Without timeouts a slow or malicious client can hold a connection, and the
+// goroutine serving it, open indefinitely.
+var (
+	readTimeout    = flag.Duration("read-timeout", 10*time.Second, "maximum `duration` for reading a request, including its body")
+	writeTimeout   = flag.Duration("write-timeout", 30*time.Second, "maximum `duration` for handling a request and writing the response")
+	idleTimeout    = flag.Duration("idle-timeout", 2*time.Minute, "maximum `duration` to keep an idle keep-alive connection open")
+	maxHeaderBytes = flag.Int("max-header-bytes", 64<<10, "maximum size of request headers in `bytes`")
+)
+
+// newHTTPServer returns an HTTP server for h with the configured limits.
+func newHTTPServer(h http.Handler) *http.Server {
+	s := &http.Server{
+		Handler:           withTimeout(h, *writeTimeout),
+		ReadTimeout:       *readTimeout,
+		ReadHeaderTimeout: *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    *maxHeaderBytes,
+		ConnState:         connections.track,
+		ConnContext:       connections.connContext,
+	}
+	s.SetKeepAlivesEnabled(*keepAlives)
+	return s
+}
+
+// withTimeout cancels the request's context after d so that handlers stop
+// generating passwords for a response that can no longer be written.
+func withTimeout(h http.Handler, d time.Duration) http.Handler {
+	if d <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), d)
+		defer cancel()
+		h.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// setupQuestion prompts for the value of a single flag. Optional questions
+// may be left blank, and questions whose when func returns false, given the
+// answers so far, aren't asked. For the auth flag, group is the route group
+// asked about, and the answer its scheme.
+type setupQuestion struct {
+	flag, prompt string
+	validate     func(string) error
+	optional     bool
+	when         func() bool
+	group        string
+}
+
+var setupQuestions = []setupQuestion{
+	{flag: "http", prompt: "Listen address (host:port)", validate: validateAddr},
+	{flag: "https", prompt: "HTTPS listen address (host:port, blank for none)", validate: validateAddr, optional: true},
+	{flag: "tls-cert", prompt: "PEM certificate chain file for HTTPS", validate: validateReadable, when: httpsSet},
+	{flag: "tls-key", prompt: "PEM private key file for HTTPS", validate: validateKeyPair, when: httpsSet},
+
+	{flag: "counter", prompt: "Counter file to persist the password count (blank to not persist)", validate: validateWritable, optional: true},
+	{flag: "counter-redis", prompt: "Redis URL to add up the counts of replicas in (blank for none)", validate: validateRedisURL, optional: true},
+	{flag: "secrets-key", prompt: "File of AES keys for one-time secret links (blank to disable them)", validate: validateSecretsKey, optional: true},
+	{flag: "secrets-store", prompt: "Where to store one-time secrets: memory, or a Redis URL", validate: validateSecretsStore, when: func() bool {
+		return *secretsKeyPath != ""
+	}},
+
+	{flag: "auth", group: "ui", prompt: "Authentication for the page (none, apikey, hmac, mtls or oidc)", validate: validateAuthScheme},
+	{flag: "auth", group: "api", prompt: "Authentication for the API", validate: validateAuthScheme},
+	{flag: "auth", group: "monitoring", prompt: "Authentication for metrics and stats", validate: validateAuthScheme},
+	{flag: "auth", group: "admin", prompt: "Authentication for the admin API", validate: validateAuthScheme},
+	{flag: "api-keys", prompt: "File of API keys, one \"name key\" pair per line", validate: validateReadable, when: authSchemeChosen("apikey")},
+	{flag: "hmac-keys", prompt: "File of HMAC signing keys, one \"id secret\" pair per line", validate: validateReadable, when: authSchemeChosen("hmac")},
+	{flag: "client-ca", prompt: "PEM file of the CAs that issue client certificates", validate: validateReadable, when: authSchemeChosen("mtls")},
+	{flag: "oidc-issuer", prompt: "OpenID Connect issuer URL", validate: validateHTTPSURL, when: authSchemeChosen("oidc")},
+	{flag: "oidc-audience", prompt: "OpenID Connect client ID", when: authSchemeChosen("oidc")},
+
+	{flag: "rate-limit", prompt: "Requests per second allowed per client (0 for no limit)", validate: validateNonNegativeFloat},
+	{flag: "rate-burst", prompt: "Requests a client may make in a burst", validate: validatePositiveInt, when: func() bool {
+		return *rateLimit > 0
+	}},
+	{flag: "quota", prompt: "Requests allowed per client per quota window, a day unless -quota-window says otherwise (0 for no quota)", validate: validateNonNegativeInt},
+	{flag: "min-length", prompt: "Minimum password length", validate: validatePositiveInt},
+	{flag: "max-length", prompt: "Maximum password length", validate: validateMaxLength},
+	{flag: "max-count", prompt: "Maximum number of passwords per request", validate: validatePositiveInt},
+}
+
+func httpsSet() bool {
+	return len(httpsAddrs.addrs) > 0
+}
+
+// authSchemeChosen returns a func reporting whether a route group has been
+// given scheme.
+func authSchemeChosen(scheme string) func() bool {
+	return func() bool {
+		return usesAuthScheme(scheme)
+	}
+}
+
+// current returns q's current value, shown as its default.
+func (q setupQuestion) current() string {
+	if q.group == "" {
+		return flag.Lookup(q.flag).Value.String()
+	}
+	if scheme, ok := authSchemes[q.group]; ok {
+		return scheme
+	}
+	return "none"
+}
+
+// runSetup interactively asks for the most important settings and writes
+// them to a config file.
+func runSetup(in io.Reader, out io.Writer, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists, remove it or choose another -config file", path)
+	}
+
+	fmt.Fprintf(out, "Writing configuration to %s. Press enter to accept the [default].\n\n", path)
+
+	// Keep any settings given on the command line.
+	settings := make(map[string][]string)
+	for name := range commandLineFlags {
+		if !bundleExcluded[name] {
+			settings[name] = flagValues(flag.Lookup(name))
+		}
+	}
+
+	scanner := bufio.NewScanner(in)
+	for _, q := range setupQuestions {
+		if q.when != nil && !q.when() {
+			continue
+		}
+		f := flag.Lookup(q.flag)
+		for {
+			fmt.Fprintf(out, "%s [%s]: ", q.prompt, q.current())
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return err
+				}
+				return errors.New("setup aborted")
+			}
+			answer := strings.TrimSpace(scanner.Text())
+			if answer == "" {
+				answer = q.current()
+			}
+			if answer == q.current() {
+				break
+			}
+			if answer == "" && !q.optional {
+				fmt.Fprintln(out, "  an answer is needed")
+				continue
+			}
+			if answer != "" && q.validate != nil {
+				if err := q.validate(answer); err != nil {
+					fmt.Fprintf(out, "  %s\n", err)
+					continue
+				}
+			}
+			value := answer
+			if q.group != "" {
+				value = q.group + "=" + answer
+			}
+			if err := flag.Set(q.flag, value); err != nil {
+				fmt.Fprintf(out, "  %s\n", err)
+				continue
+			}
+			if values := flagValues(f); f.Value.String() != f.DefValue {
+				settings[q.flag] = values
+			} else {
+				delete(settings, q.flag)
+			}
+			break
+		}
+	}
+	if err := setupAuth(); err != nil {
+		return err
+	}
+	if err := validateConfig(); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(f, "# Generated by random-password-please setup.")
+	if err := writeConfig(f, settings); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\nDone. Start the server with:\n\n\trandom-password-please -config %s\n", path)
+	return nil
+}
+
+func validateAddr(s string) error {
+	_, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return err
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateWritable checks that path can be written, without creating it
+// if it doesn't exist, by creating and removing a file next to it.
+func validateWritable(path string) error {
+	dir := filepath.Dir(path)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if _, err := os.Stat(path); err == nil {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	probe, err := ioutil.TempFile(dir, ".setup-probe-")
+	if err != nil {
+		return fmt.Errorf("can't create files in %s: %s", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+func validateReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// validateKeyPair checks that the key matches the -tls-cert given.
+func validateKeyPair(path string) error {
+	if _, err := tls.LoadX509KeyPair(*tlsCertPath, path); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateSecretsKey(path string) error {
+	_, err := readSessionTicketKeys(path)
+	return err
+}
+
+func validateRedisURL(s string) error {
+	_, err := newRedisClient(s)
+	return err
+}
+
+func validateSecretsStore(s string) error {
+	if s == "memory" {
+		return nil
+	}
+	return validateRedisURL(s)
+}
+
+func validateAuthScheme(s string) error {
+	if !contains(authSchemeNames, s) {
+		return fmt.Errorf("must be one of %s", strings.Join(authSchemeNames, ", "))
+	}
+	return nil
+}
+
+func validateHTTPSURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return errors.New("must be an https URL")
+	}
+	return nil
+}
+
+func validateNonNegativeInt(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return errors.New("must be a number, 0 or more")
+	}
+	return nil
+}
+
+func validateNonNegativeFloat(s string) error {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return errors.New("must be a number, 0 or more")
+	}
+	return nil
+}
+
+func validatePositiveInt(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return errors.New("must be a positive number")
+	}
+	return nil
+}
+
+func validateMaxLength(s string) error {
+	if err := validatePositiveInt(s); err != nil {
+		return err
+	}
+	if n, _ := strconv.Atoi(s); n < *minPasswordLength {
+		return fmt.Errorf("must be at least the minimum length (%d)", *minPasswordLength)
+	}
+	return nil
+}
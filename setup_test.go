@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The wizard sets the flags it asks about, so put them back.
+	for _, q := range setupQuestions {
+		if q.group == "" {
+			setFlag(t, q.flag, flag.Lookup(q.flag).Value.String())
+		}
+	}
+	defer func() {
+		authSchemes = authGroups{}
+		setupAuth()
+	}()
+
+	keys := filepath.Join(dir, "keys.txt")
+	if err := ioutil.WriteFile(keys, []byte("ci 0123456789abcdef0123456789abcdef\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	counterPath := filepath.Join(dir, "counter.txt")
+	answers := []string{
+		"",          // http
+		"",          // https
+		counterPath, // counter
+		"mysql://x", // counter-redis, refused
+		"",          // counter-redis
+		"",          // secrets-key
+		"",          // ui
+		"apikey",    // api
+		"",          // monitoring
+		"basic",     // admin, refused
+		"apikey",    // admin
+		keys,        // api-keys
+		"5",         // rate-limit
+		"",          // rate-burst
+		"",          // quota
+		"12",        // min-length
+		"8",         // max-length, refused
+		"40",        // max-length
+		"",          // max-count
+	}
+	var out bytes.Buffer
+	path := filepath.Join(dir, "config.yaml")
+	if err := runSetup(strings.NewReader(strings.Join(answers, "\n")+"\n"), &out, path); err != nil {
+		t.Fatalf("%s\n%s", err, out.String())
+	}
+	if _, err := os.Stat(counterPath); !os.IsNotExist(err) {
+		t.Errorf("checking the counter file created it")
+	}
+	if n := strings.Count(out.String(), "]:   "); n != 3 {
+		t.Errorf("got %d answers refused, want 3:\n%s", n, out.String())
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`counter: "` + counterPath + `"`, `- "admin=apikey"`, `- "api=apikey"`, `api-keys: "` + keys + `"`, `rate-limit: "5"`, `min-length: "12"`, `max-length: "40"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config doesn't contain %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "ui=") || strings.Contains(string(data), "tls-cert") {
+		t.Errorf("config has settings that weren't changed:\n%s", data)
+	}
+}
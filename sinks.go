@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// A secretSink stores generated credentials somewhere other programs can
+// read them.
+type secretSink interface {
+	write(value string) error
+	// read returns the current value, or an empty string if there is none,
+	// so that a rotation can be rolled back.
+	read() (string, error)
+	String() string
+}
+
+// sinkConfig configures a sink in the jobs file.
+type sinkConfig struct {
+	// Type is "vault", "kubernetes" or "file".
+	Type string `json:"type"`
+
+	// URL of the Vault KV version 2 data path (e.g.
+	// https://vault:8200/v1/secret/data/db) or the Kubernetes Secret (e.g.
+	// https://kubernetes.default.svc/api/v1/namespaces/default/secrets/db).
+	URL string `json:"url"`
+	// Path of the file for file sinks.
+	Path string `json:"path"`
+	// Key the credential is stored under. Defaults to "password".
+	Key string `json:"key"`
+
+	// TokenFile holds the Vault token or Kubernetes bearer token. Vault
+	// sinks fall back to $VAULT_TOKEN and Kubernetes sinks to the pod's
+	// service account token.
+	TokenFile string `json:"token_file"`
+	// CAFile optionally holds the CA certificate to verify the server with.
+	CAFile string `json:"ca_file"`
+}
+
+// sinksInUse records the types of the sinks the jobs write to.
+var sinksInUse = make(map[string]bool)
+
+func init() {
+	for _, f := range []feature{
+		{name: "vault", version: "KV v2"},
+		{name: "kubernetes", version: "v1"},
+		{name: "file"},
+	} {
+		name := f.name
+		f.kind = "secret sink"
+		f.active = func() bool { return sinksInUse[name] }
+		registerFeature(f)
+	}
+}
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+func newSink(c sinkConfig) (secretSink, error) {
+	if c.Key == "" {
+		c.Key = "password"
+	}
+	switch c.Type {
+	case "file":
+		if c.Path == "" {
+			return nil, errors.New("file sink needs a path")
+		}
+		return fileSink{c.Path}, nil
+	case "vault", "kubernetes":
+		if c.URL == "" {
+			return nil, fmt.Errorf("%s sink needs a url", c.Type)
+		}
+		if c.Type == "kubernetes" {
+			if c.TokenFile == "" {
+				c.TokenFile = serviceAccountDir + "token"
+			}
+			if c.CAFile == "" {
+				if _, err := os.Stat(serviceAccountDir + "ca.crt"); err == nil {
+					c.CAFile = serviceAccountDir + "ca.crt"
+				}
+			}
+		}
+		client, err := sinkClient(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		return &httpSink{config: c, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q", c.Type)
+}
+
+func sinkClient(caFile string) (*http.Client, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	return client, nil
+}
+
+type fileSink struct {
+	path string
+}
+
+func (s fileSink) write(value string) error {
+	// Write to a temporary file first so readers never see a partial value.
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(value+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s fileSink) read() (string, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSuffix(string(b), "\n"), err
+}
+
+func (s fileSink) String() string {
+	return "file " + s.path
+}
+
+// httpSink writes to Vault's KV version 2 API or a Kubernetes Secret.
+type httpSink struct {
+	config sinkConfig
+	client *http.Client
+}
+
+func (s *httpSink) String() string {
+	return s.config.Type + " " + s.config.URL
+}
+
+func (s *httpSink) token() (string, error) {
+	if s.config.TokenFile != "" {
+		b, err := ioutil.ReadFile(s.config.TokenFile)
+		return strings.TrimSpace(string(b)), err
+	}
+	if t := os.Getenv("VAULT_TOKEN"); t != "" {
+		return t, nil
+	}
+	return "", errors.New("no token configured")
+}
+
+func (s *httpSink) write(value string) error {
+	var method, contentType string
+	var body interface{}
+	switch s.config.Type {
+	case "vault":
+		method, contentType = http.MethodPost, "application/json"
+		body = map[string]interface{}{"data": map[string]string{s.config.Key: value}}
+	case "kubernetes":
+		method, contentType = http.MethodPatch, "application/merge-patch+json"
+		body = map[string]interface{}{"stringData": map[string]string{s.config.Key: value}}
+	}
+	_, err := s.do(method, contentType, body)
+	return err
+}
+
+func (s *httpSink) read() (string, error) {
+	data, err := s.do(http.MethodGet, "", nil)
+	if err == errSinkNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	switch s.config.Type {
+	case "vault":
+		var resp struct {
+			Data struct {
+				Data map[string]interface{} `json:"data"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return "", err
+		}
+		v, _ := resp.Data.Data[s.config.Key].(string)
+		return v, nil
+	case "kubernetes":
+		var secret struct {
+			Data map[string][]byte `json:"data"`
+		}
+		if err := json.Unmarshal(data, &secret); err != nil {
+			return "", err
+		}
+		return string(secret.Data[s.config.Key]), nil
+	}
+	return "", nil
+}
+
+var errSinkNotFound = errors.New("secret not found")
+
+func (s *httpSink) do(method, contentType string, body interface{}) ([]byte, error) {
+	token, err := s.token()
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, s.config.URL, r)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.config.Type == "vault" {
+		req.Header.Set("X-Vault-Token", token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return nil, errSinkNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		// Don't include the response body, which might echo the secret.
+		return nil, fmt.Errorf("%s %s: %s", method, s.config.URL, resp.Status)
+	}
+	return data, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// -ssh-keys serves SSH key pairs at /sshkey for users who would otherwise
+// pair the password API with a separate keygen tool. A key generated on the
+// server has passed through it, so local=1 gives the ssh-keygen command that
+// makes the same key on the user's own machine instead, and every response
+// says so. Private keys are unencrypted, in the formats ssh-keygen writes.
+var sshKeys = flag.Bool("ssh-keys", false, "serve SSH key pairs at /sshkey")
+
+func init() {
+	registerFeature(feature{name: "SSH keys", kind: "generator", version: "ed25519, rsa", active: func() bool {
+		return *sshKeys
+	}})
+}
+
+const (
+	defaultRSABits = 3072
+	maxSSHComment  = 256
+)
+
+var rsaKeySizes = []string{"2048", "3072", "4096"}
+
+// randomReader reads from the random source values are drawn from.
+type randomReader struct{}
+
+func (randomReader) Read(p []byte) (int, error) {
+	return readRandom(p)
+}
+
+type sshKeyResult struct {
+	XMLName     xml.Name `json:"-" xml:"ssh_key"`
+	Type        string   `json:"type" xml:"type,attr"`
+	Bits        int      `json:"bits,omitempty" xml:"bits,attr,omitempty"`
+	Fingerprint string   `json:"fingerprint,omitempty" xml:"fingerprint,omitempty"`
+	PublicKey   string   `json:"public_key,omitempty" xml:"public_key,omitempty"`
+	PrivateKey  string   `json:"private_key,omitempty" xml:"private_key,omitempty"`
+	// GenerateLocally is the ssh-keygen command that makes the same kind
+	// of key without it leaving the user's machine.
+	GenerateLocally string `json:"generate_locally" xml:"generate_locally"`
+}
+
+func (r sshKeyResult) text() string {
+	if r.PrivateKey == "" {
+		return r.GenerateLocally
+	}
+	return r.PrivateKey + r.PublicKey
+}
+
+func (r sshKeyResult) csvRecords() [][]string {
+	return [][]string{
+		{"type", "bits", "fingerprint", "public_key", "private_key", "generate_locally"},
+		{r.Type, strconv.Itoa(r.Bits), r.Fingerprint, strings.TrimSuffix(r.PublicKey, "\n"), r.PrivateKey, r.GenerateLocally},
+	}
+}
+
+// sshString appends b to buf as an SSH wire format string.
+func sshString(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
+
+// sshMPInt appends n to buf as an SSH wire format mpint.
+func sshMPInt(buf *bytes.Buffer, n *big.Int) {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	sshString(buf, b)
+}
+
+// authorizedKey returns the public key in wire format as an
+// authorized_keys line.
+func authorizedKey(typ string, wire []byte, comment string) string {
+	line := typ + " " + base64.StdEncoding.EncodeToString(wire)
+	if comment != "" {
+		line += " " + comment
+	}
+	return line + "\n"
+}
+
+// sshFingerprint returns the SHA256 fingerprint ssh-keygen -l shows.
+func sshFingerprint(wire []byte) string {
+	sum := sha256.Sum256(wire)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// newEd25519Key returns an Ed25519 key pair, with the private key in the
+// openssh-key-v1 format, as described in OpenSSH's PROTOCOL.key.
+func newEd25519Key(comment string) (sshKeyResult, error) {
+	pub, priv, err := ed25519.GenerateKey(randomReader{})
+	if err != nil {
+		return sshKeyResult{}, err
+	}
+	var wire bytes.Buffer
+	sshString(&wire, []byte("ssh-ed25519"))
+	sshString(&wire, pub)
+
+	var check [4]byte
+	if _, err := readRandom(check[:]); err != nil {
+		return sshKeyResult{}, err
+	}
+	var private bytes.Buffer
+	private.Write(check[:])
+	private.Write(check[:])
+	sshString(&private, []byte("ssh-ed25519"))
+	sshString(&private, pub)
+	sshString(&private, priv)
+	sshString(&private, []byte(comment))
+	// Pad to the cipher's block size, 8 for none.
+	for i := byte(1); private.Len()%8 != 0; i++ {
+		private.WriteByte(i)
+	}
+
+	var key bytes.Buffer
+	key.WriteString("openssh-key-v1\x00")
+	sshString(&key, []byte("none"))
+	sshString(&key, []byte("none"))
+	sshString(&key, nil)
+	binary.Write(&key, binary.BigEndian, uint32(1))
+	sshString(&key, wire.Bytes())
+	sshString(&key, private.Bytes())
+
+	return sshKeyResult{
+		Type:        "ed25519",
+		Fingerprint: sshFingerprint(wire.Bytes()),
+		PublicKey:   authorizedKey("ssh-ed25519", wire.Bytes(), comment),
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: key.Bytes()})),
+	}, nil
+}
+
+// newRSAKey returns an RSA key pair, with the private key in PKCS #1 PEM
+// form, which OpenSSH reads too.
+func newRSAKey(bits int, comment string) (sshKeyResult, error) {
+	key, err := rsa.GenerateKey(randomReader{}, bits)
+	if err != nil {
+		return sshKeyResult{}, err
+	}
+	var wire bytes.Buffer
+	sshString(&wire, []byte("ssh-rsa"))
+	sshMPInt(&wire, big.NewInt(int64(key.E)))
+	sshMPInt(&wire, key.N)
+	return sshKeyResult{
+		Type:        "rsa",
+		Bits:        bits,
+		Fingerprint: sshFingerprint(wire.Bytes()),
+		PublicKey:   authorizedKey("ssh-rsa", wire.Bytes(), comment),
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+	}, nil
+}
+
+// keygenCommand returns the ssh-keygen command for a key of typ.
+func keygenCommand(typ string, bits int, comment string) string {
+	cmd := "ssh-keygen -t " + typ
+	if bits > 0 {
+		cmd += " -b " + strconv.Itoa(bits)
+	}
+	if comment != "" {
+		cmd += " -C '" + strings.Replace(comment, "'", `'\''`, -1) + "'"
+	}
+	return cmd
+}
+
+func sshKeyHandler(w http.ResponseWriter, req *http.Request) {
+	typ := req.FormValue("type")
+	if typ == "" {
+		typ = "ed25519"
+	}
+	if typ != "ed25519" && typ != "rsa" {
+		http.Error(w, "type must be ed25519 or rsa", http.StatusBadRequest)
+		return
+	}
+	var bits int
+	if typ == "rsa" {
+		bits = defaultRSABits
+		if s := req.FormValue("bits"); s != "" {
+			if !contains(rsaKeySizes, s) {
+				http.Error(w, "bits must be one of "+strings.Join(rsaKeySizes, ", "), http.StatusBadRequest)
+				return
+			}
+			bits, _ = strconv.Atoi(s)
+		}
+	}
+	comment := req.FormValue("comment")
+	if len(comment) > maxSSHComment || strings.ContainsAny(comment, "\r\n") {
+		http.Error(w, fmt.Sprintf("comment must be a single line of at most %d bytes", maxSSHComment), http.StatusBadRequest)
+		return
+	}
+	local := keygenCommand(typ, bits, comment)
+
+	// Keys never go in caches.
+	w.Header().Set("Cache-Control", "no-store")
+	if req.FormValue("local") == "1" {
+		render(w, req, sshKeyResult{Type: typ, Bits: bits, GenerateLocally: local})
+		return
+	}
+
+	var r sshKeyResult
+	var err error
+	if typ == "rsa" {
+		r, err = newRSAKey(bits, comment)
+	} else {
+		r, err = newEd25519Key(comment)
+	}
+	if err != nil {
+		http.Error(w, "failed to generate key", http.StatusInternalServerError)
+		return
+	}
+	r.GenerateLocally = local
+	if req.FormValue("format") == "zip" {
+		writeSSHKeyZip(w, r)
+		return
+	}
+	render(w, req, r)
+}
+
+// writeSSHKeyZip writes the key pair as a zip of the files ssh-keygen
+// would write, e.g. id_ed25519 and id_ed25519.pub.
+func writeSSHKeyZip(w http.ResponseWriter, r sshKeyResult) {
+	name := "id_" + r.Type
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct {
+		name, body string
+		mode       os.FileMode
+	}{
+		{name, r.PrivateKey, 0600},
+		{name + ".pub", r.PublicKey, 0644},
+	} {
+		h := &zip.FileHeader{Name: f.name, Method: zip.Deflate}
+		h.SetMode(f.mode)
+		fw, err := zw.CreateHeader(h)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fw.Write([]byte(f.body))
+	}
+	if err := zw.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type staticFile struct {
+	contentType, body string
+}
+
+// Front-end assets served from the binary so the page has no third-party
+// dependencies.
+var staticFiles = map[string]staticFile{
+	"app.js":     {"application/javascript; charset=utf-8", appJs},
+	"docs.js":    {"application/javascript; charset=utf-8", docsJs},
+	"push.js":    {"application/javascript; charset=utf-8", pushJs},
+	"push-sw.js": {"application/javascript; charset=utf-8", pushSwJs},
+}
+
+func staticHandler(w http.ResponseWriter, req *http.Request) {
+	f, ok := staticFiles[strings.TrimPrefix(req.URL.Path, "/static/")]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", f.contentType)
+	if *devMode {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(f.body)))
+	w.Write([]byte(f.body))
+}
+
+var appJs = `
+(function() {
+	"use strict";
+
+	var list = document.getElementById("passwords");
+	var slider = document.getElementById("slider");
+	var lengthLabel = document.getElementById("length-label");
+	var counter = document.getElementById("counter");
+	var mobile = document.getElementById("mobile");
+	var passphrase = document.getElementById("passphrase");
+	var words = document.getElementById("words");
+	var wordsLabel = document.getElementById("words-label");
+	var separator = document.getElementById("separator");
+	var capitalize = document.getElementById("capitalize");
+	var charset = document.getElementById("charset");
+	var mask = document.getElementById("mask");
+	var remaskAfter = Number(list.dataset.remaskAfter || 0) * 1000;
+	var refreshStatus = document.getElementById("refresh-status");
+
+	/* Settings changes refresh the passwords after refreshDelay without
+	   another change, so a drag of the slider or a held arrow key makes one
+	   request. While rate limited, no requests are made until retryAt. */
+	var refreshDelay = 300;
+	var refreshTimer;
+	var retryAt = 0;
+	var latest = 0;
+
+	if (counter && window.EventSource) {
+		new EventSource("/counter/events").onmessage = function(e) {
+			counter.textContent = JSON.parse(e.data).counter;
+		};
+	}
+
+	function candidates() {
+		return Array.prototype.slice.call(list.querySelectorAll(".candidate"));
+	}
+
+	function fetchResponse(url) {
+		return fetch(url, {cache: "no-store"}).then(function(resp) {
+			var challenge = resp.headers.get("X-Challenge");
+			if (resp.status === 403 && challenge) {
+				location.href = challenge + "?next=" + encodeURIComponent(location.pathname + location.search);
+			}
+			if (resp.status === 429) {
+				var err = new Error("429 " + resp.statusText);
+				err.retryAfter = Number(resp.headers.get("Retry-After")) || 1;
+				throw err;
+			}
+			if (!resp.ok) {
+				throw new Error(resp.status + " " + resp.statusText);
+			}
+			return resp;
+		});
+	}
+
+	/* Wrap digits in spans so they can be told apart from similar letters
+	   (0/O, 1/l) when the alphabet contains both. */
+	function showPassword(el, text) {
+		el.fullPassword = text;
+		el.closest(".candidate").classList.remove("preview");
+		renderPassword(el, text);
+	}
+
+	function renderPassword(el, text) {
+		el.textContent = "";
+		if (!list.dataset.distinguish) {
+			el.textContent = text;
+			return;
+		}
+		for (var i = 0; i < text.length; i++) {
+			var c = text.charAt(i);
+			if (c >= "0" && c <= "9") {
+				var span = document.createElement("span");
+				span.className = "digit";
+				span.textContent = c;
+				el.appendChild(span);
+			} else {
+				el.appendChild(document.createTextNode(c));
+			}
+		}
+	}
+
+	function showUsability(li, usability) {
+		["typing", "memory", "dictation"].forEach(function(name) {
+			var el = li.querySelector(".usability-" + name);
+			if (el && usability) {
+				el.textContent = Math.round(usability[name] * 100) + "%";
+			}
+		});
+	}
+
+	function showStrength(li, strength) {
+		var el = li.querySelector(".crack-time");
+		if (!el || !strength) {
+			return;
+		}
+		strength.crack_times.forEach(function(c) {
+			if (c.scenario === "offline_slow_hash") {
+				el.textContent = c.display;
+			}
+		});
+	}
+
+	/* settings returns the query for the page's current settings, which the
+	   page and the API both accept. */
+	function settings() {
+		if (passphrase && passphrase.checked) {
+			var query = "mode=passphrase&words=" + words.value + "&separator=" + encodeURIComponent(separator.value);
+			return capitalize.checked ? query + "&capitalize=1" : query;
+		}
+		var query = "len=" + slider.value;
+		if (mobile && mobile.checked) {
+			return query + "&mode=mobile";
+		}
+		return charset ? query + "&charset=" + encodeURIComponent(charset.value) : query;
+	}
+
+	/* Show the controls for the kind of password chosen. */
+	function showControls() {
+		if (passphrase) {
+			document.getElementById("length-controls").hidden = passphrase.checked;
+			document.getElementById("passphrase-controls").hidden = !passphrase.checked;
+		}
+	}
+
+	/* Show the length being picked without asking the server, by cutting
+	   the passwords short or padding them with dots, until the new ones
+	   load. */
+	function previewLength(n) {
+		candidates().forEach(function(li) {
+			var el = li.querySelector(".password");
+			var text = el.fullPassword || el.textContent;
+			while (text.length < n) {
+				text += "\u00b7";
+			}
+			li.classList.add("preview");
+			renderPassword(el, text.slice(0, n));
+		});
+	}
+
+	function showRefreshStatus(text) {
+		if (refreshStatus) {
+			refreshStatus.textContent = text;
+		}
+	}
+
+	function getNewPasswords(preview) {
+		/* Load new passwords via API, and put the settings in the URL so it
+		   can be shared. Only the latest request's passwords are shown. */
+		clearTimeout(refreshTimer);
+		var wait = retryAt - Date.now();
+		if (wait > 0) {
+			refreshTimer = setTimeout(function() {
+				getNewPasswords(preview);
+			}, wait);
+			return;
+		}
+		var request = ++latest;
+		var lis = candidates();
+		var url = "/password.txt?verbose=1&format=json&count=" + lis.length + "&" + settings();
+		if (preview) {
+			url += "&nocount=1";
+		}
+		if (window.history && history.replaceState) {
+			history.replaceState(null, "", "?" + settings());
+		}
+		fetchResponse(url).then(function(resp) {
+			return resp.json();
+		}).then(function(batch) {
+			if (request !== latest) {
+				return;
+			}
+			showRefreshStatus("");
+			batch.passwords.forEach(function(p, i) {
+				if (lis[i]) {
+					showPassword(lis[i].querySelector(".password"), p.password);
+					if (mask && mask.checked) {
+						setMasked(lis[i], true);
+					}
+					showUsability(lis[i], p.usability);
+					showStrength(lis[i], p.strength);
+				}
+			});
+			if (!counter) {
+				return;
+			}
+			return fetchResponse("/counter").then(function(resp) {
+				return resp.text();
+			}).then(function(text) {
+				counter.textContent = text;
+			});
+		}).catch(function(err) {
+			if (err.retryAfter && request === latest) {
+				/* Retrying before Retry-After would only lengthen the wait. */
+				retryAt = Date.now() + err.retryAfter * 1000;
+				showRefreshStatus("Too many requests; new passwords in " + err.retryAfter + "s.");
+				refreshTimer = setTimeout(function() {
+					getNewPasswords(preview);
+				}, err.retryAfter * 1000);
+				return;
+			}
+			console.error("Failed to load passwords:", err);
+		});
+	}
+
+	/* Mask or reveal a candidate. While masking, a revealed one is masked
+	   again after remaskAfter. */
+	function setMasked(li, masked) {
+		li.classList.toggle("masked", masked);
+		var button = li.querySelector(".reveal");
+		if (button) {
+			button.textContent = masked ? "Show" : "Hide";
+			button.setAttribute("aria-label", button.textContent + " password");
+		}
+		clearTimeout(li.remaskTimer);
+		if (!masked && mask && mask.checked && remaskAfter > 0) {
+			li.remaskTimer = setTimeout(function() {
+				setMasked(li, true);
+			}, remaskAfter);
+		}
+	}
+
+	/* Refreshes as the settings change are previews, which aren't counted
+	   as passwords issued, and are debounced. */
+	function previewPasswords() {
+		clearTimeout(refreshTimer);
+		refreshTimer = setTimeout(function() {
+			getNewPasswords(true);
+		}, refreshDelay);
+	}
+
+	function flash(el, cls) {
+		el.classList.add(cls);
+		setTimeout(function() {
+			el.classList.remove(cls);
+		}, 1000);
+	}
+
+	function fallbackCopy(text) {
+		var ta = document.createElement("textarea");
+		ta.value = text;
+		ta.setAttribute("readonly", "");
+		ta.style.position = "absolute";
+		ta.style.left = "-9999px";
+		document.body.appendChild(ta);
+		ta.select();
+		var ok = document.execCommand("copy");
+		document.body.removeChild(ta);
+		return ok ? Promise.resolve() : Promise.reject(new Error("copy failed"));
+	}
+
+	function copyPassword(li) {
+		var password = li.querySelector(".password");
+		var button = li.querySelector(".copy");
+		var text = password.fullPassword || password.textContent;
+		var copied = navigator.clipboard && window.isSecureContext ?
+			navigator.clipboard.writeText(text) : fallbackCopy(text);
+		copied.then(function() {
+			button.textContent = "Copied!";
+			flash(password, "copied");
+			setTimeout(function() {
+				button.textContent = "Copy";
+			}, 1000);
+		}, function(err) {
+			button.textContent = "Copy failed";
+			console.error(err);
+		});
+	}
+
+	candidates().forEach(function(li) {
+		var password = li.querySelector(".password");
+		showPassword(password, password.textContent);
+		li.querySelector(".copy").addEventListener("click", function(event) {
+			event.preventDefault();
+			copyPassword(li);
+		});
+		var reveal = li.querySelector(".reveal");
+		if (reveal) {
+			reveal.addEventListener("click", function(event) {
+				event.preventDefault();
+				setMasked(li, !li.classList.contains("masked"));
+			});
+		}
+	});
+
+	if (mask) {
+		mask.addEventListener("change", function() {
+			candidates().forEach(function(li) {
+				setMasked(li, mask.checked);
+			});
+		});
+	}
+
+	slider.addEventListener("input", function() {
+		lengthLabel.textContent = slider.value;
+		previewLength(Number(slider.value));
+	});
+
+	slider.addEventListener("change", function() {
+		lengthLabel.textContent = slider.value;
+		previewPasswords();
+	});
+
+	if (mobile) {
+		mobile.addEventListener("change", function() {
+			if (charset) {
+				charset.disabled = mobile.checked;
+			}
+			previewPasswords();
+		});
+	}
+
+	/* Digits are only styled distinctly if the charset has look-alikes. */
+	if (charset) {
+		charset.addEventListener("change", function() {
+			var option = charset.options[charset.selectedIndex];
+			list.dataset.alphabet = option.dataset.chars;
+			if (option.dataset.ambiguous) {
+				list.dataset.distinguish = "true";
+			} else {
+				delete list.dataset.distinguish;
+			}
+			previewPasswords();
+		});
+	}
+
+	if (passphrase) {
+		passphrase.addEventListener("change", function() {
+			showControls();
+			previewPasswords();
+		});
+		words.addEventListener("input", function() {
+			wordsLabel.textContent = words.value;
+		});
+		words.addEventListener("change", function() {
+			wordsLabel.textContent = words.value;
+			previewPasswords();
+		});
+		separator.addEventListener("change", previewPasswords);
+		capitalize.addEventListener("change", previewPasswords);
+	}
+
+	document.getElementById("button").addEventListener("click", function(event) {
+		event.preventDefault();
+		getNewPasswords();
+	});
+
+	document.addEventListener("keydown", function(event) {
+		if (event.ctrlKey || event.metaKey || event.altKey || event.target.tagName === "SELECT" ||
+				event.target.tagName === "INPUT" && event.target.type !== "range") {
+			return;
+		}
+		if (event.key === "r") {
+			getNewPasswords();
+		} else if (event.key === "c") {
+			/* Copy the focused candidate, or else the first. */
+			var li = document.activeElement && document.activeElement.closest && document.activeElement.closest(".candidate");
+			copyPassword(li || candidates()[0]);
+		}
+	});
+})();
+`
+
+// docsJs starts Swagger UI on the /docs page.
+var docsJs = `
+SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+`
+
+// pushJs subscribes the browser on /admin/push.
+var pushJs = `
+(function() {
+	"use strict";
+
+	var status = document.getElementById("status");
+
+	function decode(s) {
+		var raw = atob(s.replace(/-/g, "+").replace(/_/g, "/"));
+		var key = new Uint8Array(raw.length);
+		for (var i = 0; i < raw.length; i++) {
+			key[i] = raw.charCodeAt(i);
+		}
+		return key;
+	}
+
+	function post(url, subscription) {
+		return fetch(url, {
+			method: "POST",
+			headers: {"Content-Type": "application/json"},
+			body: JSON.stringify(subscription)
+		}).then(function(resp) {
+			if (!resp.ok) {
+				throw new Error(resp.status + " " + resp.statusText);
+			}
+		});
+	}
+
+	function fail(err) {
+		status.textContent = "Failed: " + err.message;
+	}
+
+	if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+		status.textContent = "This browser doesn't support push notifications.";
+		return;
+	}
+	var registration = navigator.serviceWorker.register("/static/push-sw.js");
+
+	document.getElementById("subscribe").onclick = function() {
+		registration.then(function(reg) {
+			return reg.pushManager.subscribe({
+				userVisibleOnly: true,
+				applicationServerKey: decode(document.body.dataset.key)
+			});
+		}).then(function(subscription) {
+			return post("/admin/push/subscribe", subscription);
+		}).then(function() {
+			status.textContent = "This browser will be notified of rotations.";
+		}).catch(fail);
+	};
+
+	document.getElementById("unsubscribe").onclick = function() {
+		registration.then(function(reg) {
+			return reg.pushManager.getSubscription();
+		}).then(function(subscription) {
+			if (!subscription) {
+				return;
+			}
+			return post("/admin/push/unsubscribe", subscription).then(function() {
+				return subscription.unsubscribe();
+			});
+		}).then(function() {
+			status.textContent = "This browser won't be notified of rotations.";
+		}).catch(fail);
+	};
+})();
+`
+
+// pushSwJs is the service worker that shows the notifications.
+var pushSwJs = `
+"use strict";
+
+self.addEventListener("push", function(e) {
+	var n = e.data.json();
+	e.waitUntil(self.registration.showNotification(n.title, {body: n.body, tag: n.tag}));
+});
+
+self.addEventListener("notificationclick", function(e) {
+	e.notification.close();
+	e.waitUntil(self.clients.openWindow("/admin/push"));
+});
+`
+
+// challengeJs solves proof of work challenges, and submits the challenge
+// once a captcha widget is solved.
+var challengeJs = `
+(function() {
+	"use strict";
+
+	var form = document.getElementById("challenge");
+
+	// hCaptcha and Turnstile call this once their widget is solved.
+	window.challengeSolved = function() {
+		form.submit();
+	};
+
+	var token = form.elements.token;
+	if (!token) {
+		return;
+	}
+	var progress = document.getElementById("progress");
+	if (!window.crypto || !crypto.subtle || !window.TextEncoder) {
+		progress.textContent = "This check needs a browser with Web Crypto, over HTTPS.";
+		return;
+	}
+	var difficulty = parseInt(token.dataset.difficulty, 10);
+	var encoder = new TextEncoder();
+
+	function leadingZeros(hash) {
+		var bytes = new Uint8Array(hash);
+		var n = 0;
+		for (var i = 0; i < bytes.length; i++) {
+			if (bytes[i] !== 0) {
+				return n + Math.clz32(bytes[i]) - 24;
+			}
+			n += 8;
+		}
+		return n;
+	}
+
+	/* Find a counter whose hash with the token has enough leading zero
+	   bits, hashing a batch at a time since each digest is asynchronous. */
+	function search(start) {
+		var batch = [];
+		for (var i = start; i < start + 1000; i++) {
+			batch.push(crypto.subtle.digest("SHA-256", encoder.encode(token.value + ":" + i)));
+		}
+		return Promise.all(batch).then(function(hashes) {
+			for (var i = 0; i < hashes.length; i++) {
+				if (leadingZeros(hashes[i]) >= difficulty) {
+					return start + i;
+				}
+			}
+			return search(start + hashes.length);
+		});
+	}
+
+	search(0).then(function(counter) {
+		form.elements.counter.value = counter;
+		form.submit();
+	});
+})();
+`
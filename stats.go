@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alongside the lifetime counter, generated passwords are counted in hourly
+// buckets by endpoint. Buckets older than statsRetention are dropped. Times
+// are UTC, so "today" starts at midnight UTC.
+const statsRetention = 35 * 24 * time.Hour
+
+type usageStats struct {
+	mu sync.Mutex
+	// Hours maps the start of each hour, in Unix seconds, to the number of
+	// passwords generated in it by each endpoint.
+	Hours map[int64]map[string]uint64 `json:"hours"`
+	// Previews maps hours likewise to the passwords generated but not
+	// issued, with nocount=1 or by HEAD requests.
+	Previews map[int64]map[string]uint64 `json:"previews,omitempty"`
+}
+
+var stats = &usageStats{Hours: make(map[int64]map[string]uint64), Previews: make(map[int64]map[string]uint64)}
+
+// Monitoring checks and the page's refreshes as its settings change
+// generate passwords nobody takes, which would inflate the counter, so
+// requests with nocount=1, and HEAD requests, which have no body to take
+// them from, are only counted as previews.
+
+// readUncounted reports whether req asks for previews.
+func readUncounted(req *http.Request) (bool, error) {
+	switch req.FormValue("nocount") {
+	case "":
+		return req.Method == http.MethodHead, nil
+	case "1":
+		return true, nil
+	}
+	return false, errors.New("nocount must be 1")
+}
+
+// recordIssued records n passwords generated with opts by endpoint, named
+// counterEndpoint in the counter's breakdown, or as previews if they are
+// uncounted.
+func recordIssued(opts genOptions, endpoint, counterEndpoint string, n int) {
+	if opts.Uncounted {
+		stats.recordPreviews(endpoint, n)
+		return
+	}
+	stats.record(endpoint, n)
+	counter.record(counterEndpoint, n)
+}
+
+// statsPath returns the file the stats are persisted to, next to the
+// counter file.
+func statsPath() string {
+	return *counterFilePath + ".stats"
+}
+
+func (s *usageStats) record(endpoint string, n int) {
+	s.add(false, endpoint, n)
+}
+
+func (s *usageStats) recordPreviews(endpoint string, n int) {
+	s.add(true, endpoint, n)
+}
+
+// add adds n to endpoint's count for the current hour, of previews or of
+// passwords issued.
+func (s *usageStats) add(previews bool, endpoint string, n int) {
+	hour := time.Now().Truncate(time.Hour).Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hours := s.Hours
+	if previews {
+		hours = s.Previews
+	}
+	b, ok := hours[hour]
+	if !ok {
+		b = make(map[string]uint64)
+		hours[hour] = b
+		s.prune(time.Unix(hour, 0))
+	}
+	b[endpoint] += uint64(n)
+}
+
+// prune drops buckets that are older than statsRetention at now. s.mu must
+// be held.
+func (s *usageStats) prune(now time.Time) {
+	cutoff := now.Add(-statsRetention).Unix()
+	for _, hours := range []map[int64]map[string]uint64{s.Hours, s.Previews} {
+		for hour := range hours {
+			if hour < cutoff {
+				delete(hours, hour)
+			}
+		}
+	}
+}
+
+func (s *usageStats) load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := json.Unmarshal(data, s); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if s.Hours == nil {
+		s.Hours = make(map[int64]map[string]uint64)
+	}
+	if s.Previews == nil {
+		s.Previews = make(map[int64]map[string]uint64)
+	}
+	s.prune(time.Now())
+	return nil
+}
+
+func (s *usageStats) save(path string) error {
+	s.mu.Lock()
+	data, err := json.Marshal(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash can't leave a truncated file.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sum returns the number of passwords generated from start up to but not
+// including end, in total and by endpoint.
+func (s *usageStats) sum(start, end time.Time) (uint64, map[string]uint64) {
+	return s.sumHours(false, start, end)
+}
+
+// sumPreviews returns the number of previews likewise.
+func (s *usageStats) sumPreviews(start, end time.Time) (uint64, map[string]uint64) {
+	return s.sumHours(true, start, end)
+}
+
+func (s *usageStats) sumHours(previews bool, start, end time.Time) (uint64, map[string]uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hours := s.Hours
+	if previews {
+		hours = s.Previews
+	}
+	var total uint64
+	endpoints := make(map[string]uint64)
+	for hour, b := range hours {
+		if hour < start.Unix() || hour >= end.Unix() {
+			continue
+		}
+		for endpoint, n := range b {
+			total += n
+			endpoints[endpoint] += n
+		}
+	}
+	return total, endpoints
+}
+
+// series returns the counts for n consecutive periods of length d, the last
+// of which contains now.
+func (s *usageStats) series(now time.Time, d time.Duration, n int) []statsBucket {
+	start := now.Truncate(d).Add(-time.Duration(n-1) * d)
+	buckets := make([]statsBucket, n)
+	for i := range buckets {
+		t := start.Add(time.Duration(i) * d)
+		buckets[i].Start = t.UTC()
+		buckets[i].Count, _ = s.sum(t, t.Add(d))
+	}
+	return buckets
+}
+
+// hourlyCounts returns the counts for each of the last 24 hours.
+func (s *usageStats) hourlyCounts() []uint64 {
+	counts := make([]uint64, 24)
+	for i, b := range s.series(time.Now(), time.Hour, len(counts)) {
+		counts[i] = b.Count
+	}
+	return counts
+}
+
+type statsBucket struct {
+	Start time.Time `json:"start" xml:"start,attr"`
+	Count uint64    `json:"count" xml:",chardata"`
+}
+
+type endpointStats struct {
+	Endpoint string `json:"endpoint" xml:"name,attr"`
+	Today    uint64 `json:"today" xml:"today"`
+	Week     uint64 `json:"week" xml:"week"`
+}
+
+type statsResult struct {
+	XMLName xml.Name `json:"-" xml:"stats"`
+	Total   uint64   `json:"total" xml:"total"`
+	Today   uint64   `json:"today" xml:"today"`
+	Week    uint64   `json:"week" xml:"week"`
+	// PreviewsToday and PreviewsWeek are the passwords generated but not
+	// issued.
+	PreviewsToday uint64          `json:"previews_today" xml:"previews_today"`
+	PreviewsWeek  uint64          `json:"previews_week" xml:"previews_week"`
+	Endpoints     []endpointStats `json:"endpoints" xml:"endpoints>endpoint"`
+	Hourly        []statsBucket   `json:"hourly" xml:"hourly>count"`
+	Daily         []statsBucket   `json:"daily" xml:"daily>count"`
+}
+
+func (r statsResult) text() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "total: %d\ntoday: %d\nweek: %d\n", r.Total, r.Today, r.Week)
+	fmt.Fprintf(&sb, "previews: %d today, %d this week\n", r.PreviewsToday, r.PreviewsWeek)
+	for _, e := range r.Endpoints {
+		fmt.Fprintf(&sb, "%s: %d today, %d this week\n", e.Endpoint, e.Today, e.Week)
+	}
+	return sb.String()
+}
+
+func (r statsResult) csvRecords() [][]string {
+	records := [][]string{{"period", "start", "count"}}
+	for _, series := range []struct {
+		period  string
+		buckets []statsBucket
+	}{{"hour", r.Hourly}, {"day", r.Daily}} {
+		for _, b := range series.buckets {
+			records = append(records, []string{series.period, b.Start.Format(time.RFC3339), strconv.FormatUint(b.Count, 10)})
+		}
+	}
+	return records
+}
+
+func statsHandler(w http.ResponseWriter, req *http.Request) {
+	if _, rounded, ok := counter.forRequest(req); rounded || !ok {
+		// Usage volume is only for authenticated clients.
+		http.NotFound(w, req)
+		return
+	}
+	now := time.Now()
+	today := now.Truncate(24 * time.Hour)
+	week := today.Add(-6 * 24 * time.Hour)
+	end := now.Add(time.Hour)
+
+	result := statsResult{Total: counter.value()}
+
+	var todayEndpoints, weekEndpoints map[string]uint64
+	result.Today, todayEndpoints = stats.sum(today, end)
+	result.Week, weekEndpoints = stats.sum(week, end)
+	result.PreviewsToday, _ = stats.sumPreviews(today, end)
+	result.PreviewsWeek, _ = stats.sumPreviews(week, end)
+	for endpoint, n := range weekEndpoints {
+		result.Endpoints = append(result.Endpoints, endpointStats{
+			Endpoint: endpoint,
+			Today:    todayEndpoints[endpoint],
+			Week:     n,
+		})
+	}
+	sort.Slice(result.Endpoints, func(i, j int) bool {
+		return result.Endpoints[i].Endpoint < result.Endpoints[j].Endpoint
+	})
+	result.Hourly = stats.series(now, time.Hour, 24)
+	result.Daily = stats.series(now, 24*time.Hour, 30)
+	render(w, req, result)
+}
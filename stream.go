@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"net/http"
+)
+
+// Batches of more than -max-count passwords, up to -max-stream-count, are
+// written as they are generated rather than rendered whole, so a request
+// for 100,000 passwords doesn't hold them all in memory. Streamed responses
+// have no Content-Length and so are sent chunked. Generation stops when the
+// client goes away or -write-timeout passes, cutting the response short.
+var maxStreamCount = flag.Int("max-stream-count", 0, "maximum number of passwords per request when streaming batches larger than -max-count (0 to not stream)")
+
+func init() {
+	registerFeature(feature{name: "streamed batches", kind: "subsystem", active: func() bool {
+		return *maxStreamCount > *maxCount
+	}})
+}
+
+// streamFlushInterval is how many passwords are written between flushes.
+const streamFlushInterval = 256
+
+// streamBatch writes first and the rest of a batch of count passwords from
+// next to w in the format negotiated for req, the same as render would write
+// the whole batch. It returns how many passwords were written, and an error
+// if the batch was cut short after the response had started.
+func streamBatch(w http.ResponseWriter, req *http.Request, first passwordResult, count int, next func() (passwordResult, int, error)) (int, error) {
+	format, err := negotiateFormat(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, nil
+	}
+	if format == "" {
+		http.Error(w, "none of the acceptable formats are supported", http.StatusNotAcceptable)
+		return 0, nil
+	}
+	want, set, err := finalNewline(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, nil
+	}
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept")
+
+	bw := bufio.NewWriter(w)
+	out := &newlineHolder{w: bw}
+	cw := csv.NewWriter(out)
+	switch format {
+	case "json":
+		out.WriteString(`{"passwords":[`)
+	case "xml":
+		out.WriteString(xml.Header + "<passwords>")
+	case "yaml":
+		out.WriteString("passwords:\n")
+	}
+
+	r := first
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			if r, _, err = next(); err != nil {
+				return i, err
+			}
+		}
+		switch format {
+		case "text":
+			if r.Usability != nil && i > 0 {
+				out.WriteString("\n")
+			}
+			out.WriteString(passwordBatch{Passwords: []passwordResult{r}}.text())
+		case "json":
+			if i > 0 {
+				out.WriteString(",")
+			}
+			b, _ := json.Marshal(r)
+			out.Write(b)
+		case "xml":
+			b, _ := xml.Marshal(r)
+			out.Write(b)
+		case "csv":
+			records := passwordBatch{Passwords: []passwordResult{r}}.csvRecords()
+			if i == 0 {
+				cw.Write(records[0])
+			}
+			cw.Write(records[1])
+			cw.Flush()
+		case "yaml":
+			n, _ := yamlTree(r)
+			n.writeItem(out, "")
+		}
+		if (i+1)%streamFlushInterval == 0 {
+			if err := bw.Flush(); err != nil {
+				return i + 1, err
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+
+	switch format {
+	case "json":
+		out.WriteString("]}\n")
+	case "xml":
+		out.WriteString("</passwords>\n")
+	}
+	if set && want || !set && out.held {
+		bw.WriteByte('\n')
+	}
+	return count, bw.Flush()
+}
+
+// newlineHolder writes to w, holding back a final newline until more
+// follows so that the end of the response can drop or keep it.
+type newlineHolder struct {
+	w    *bufio.Writer
+	held bool
+}
+
+func (h *newlineHolder) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if h.held {
+		h.w.WriteByte('\n')
+		h.held = false
+	}
+	if p[len(p)-1] != '\n' {
+		return h.w.Write(p)
+	}
+	h.held = true
+	n, err := h.w.Write(p[:len(p)-1])
+	return n + 1, err
+}
+
+func (h *newlineHolder) WriteString(s string) {
+	h.Write([]byte(s))
+}
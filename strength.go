@@ -0,0 +1,635 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Strength is estimated the way zxcvbn does it (Wheeler, "zxcvbn:
+// Low-Budget Password Strength Estimation", USENIX Security 2016): the
+// password is split into the sequence of patterns an attacker would need
+// the fewest guesses to find (common passwords and words, keyboard walks,
+// repeats, sequences, years and brute force runs) and the number of guesses
+// is converted to crack times for typical attacks. Nothing here depends on
+// how passwords are generated, so any password can be rated.
+
+// maxStrengthLength limits the characters analysed, as the search for the
+// best sequence is cubic in the password's length. Longer passwords are
+// rated on their first maxStrengthLength characters, which can only
+// underestimate them.
+const maxStrengthLength = 100
+
+// Guesses a match must take at least, so a password isn't rated weak just
+// because it can be split into many tiny matches.
+const (
+	minGuessesSingleChar = 10
+	minGuessesMultiChar  = 50
+)
+
+// bruteforceCardinality is the assumed number of guesses per brute forced
+// character.
+const bruteforceCardinality = 10
+
+// minYearSpace is the fewest years an attacker is assumed to try.
+const minYearSpace = 20
+
+// commonPasswords are among the most used passwords, most common first.
+var commonPasswords = strings.Fields(`
+123456 password 12345678 qwerty 123456789 12345 1234 111111 1234567 dragon
+123123 baseball abc123 football monkey letmein 696969 shadow master 666666
+qwertyuiop 123321 mustang 1234567890 michael 654321 superman 1qaz2wsx 7777777
+121212 000000 qazwsx 123qwe killer trustno1 jordan jennifer zxcvbnm asdfgh
+hunter buster soccer harley batman andrew tigger sunshine iloveyou 2000
+charlie robert thomas hockey ranger daniel starwars klaster 112233 george
+computer michelle jessica pepper 1111 zxcvbn 555555 11111111 131313 freedom
+777777 pass maggie 159753 aaaaaa ginger princess joshua cheese amanda summer
+love ashley nicole chelsea biteme matthew access yankees 987654321 dallas
+austin thunder taylor matrix admin welcome login secret passw0rd qwerty123
+`)
+
+// rankedDictionaries maps each dictionary's lowercase words to their rank.
+// The generator's word list isn't ordered by frequency, so each of its
+// words is ranked as if an attacker had to try the whole list.
+var rankedDictionaries = map[string]map[string]int{
+	"passwords": rankList(commonPasswords, false),
+	"words":     rankList(words, true),
+}
+
+func rankList(list []string, uniform bool) map[string]int {
+	ranks := make(map[string]int, len(list))
+	for i, w := range list {
+		rank := i + 1
+		if uniform {
+			rank = len(list)
+		}
+		if _, ok := ranks[w]; !ok {
+			ranks[w] = rank
+		}
+	}
+	return ranks
+}
+
+// l33tTables undo common character substitutions. There are two as 1 and |
+// can stand for either i or l.
+var l33tTables = []map[rune]rune{
+	{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '{': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i', '|': 'i', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z'},
+	{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '{': 'c', '3': 'e', '6': 'g', '1': 'l', '!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z'},
+}
+
+// qwertyRows are the rows of a US keyboard, unshifted then shifted.
+var qwertyRows = [2][]string{
+	{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"},
+	{"~!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", "ZXCVBNM<>?"},
+}
+
+type keyPosition struct {
+	row, col int
+	shifted  bool
+}
+
+var keyPositions, keyboardDegree = qwertyGraph()
+
+// qwertyGraph returns the position of each key and the average number of
+// keys adjacent to a key.
+func qwertyGraph() (map[rune]keyPosition, float64) {
+	positions := make(map[rune]keyPosition)
+	for shift, rows := range qwertyRows {
+		for row, keys := range rows {
+			for col, r := range keys {
+				positions[r] = keyPosition{row, col, shift == 1}
+			}
+		}
+	}
+	rows := qwertyRows[0]
+	var neighbours, keys int
+	for row, k := range rows {
+		for col := range k {
+			keys++
+			for _, d := range keyDirections {
+				r, c := row+d[0], col+d[1]
+				if r >= 0 && r < len(rows) && c >= 0 && c < len(rows[r]) {
+					neighbours++
+				}
+			}
+		}
+	}
+	return positions, float64(neighbours) / float64(keys)
+}
+
+// keyDirections are the offsets of the keys adjacent to a key. Each row is
+// offset half a key to the right of the one above it.
+var keyDirections = [][2]int{{0, -1}, {0, 1}, {-1, 0}, {-1, 1}, {1, -1}, {1, 0}}
+
+// keyDirection returns the index in keyDirections of the step from a to b,
+// or -1 if they aren't adjacent.
+func keyDirection(a, b rune) int {
+	pa, ok := keyPositions[a]
+	if !ok {
+		return -1
+	}
+	pb, ok := keyPositions[b]
+	if !ok {
+		return -1
+	}
+	for i, d := range keyDirections {
+		if pb.row == pa.row+d[0] && pb.col == pa.col+d[1] {
+			return i
+		}
+	}
+	return -1
+}
+
+// strengthMatch is a run of a password matching a pattern. Start and End
+// are character offsets, End exclusive. The matched text isn't included so
+// that results never echo passwords.
+type strengthMatch struct {
+	Pattern string  `json:"pattern" xml:"pattern,attr"`
+	Start   int     `json:"start" xml:"start,attr"`
+	End     int     `json:"end" xml:"end,attr"`
+	Guesses float64 `json:"guesses" xml:"guesses,attr"`
+}
+
+type crackTime struct {
+	Scenario string  `json:"scenario" xml:"scenario,attr"`
+	Seconds  float64 `json:"seconds" xml:"seconds,attr"`
+	Display  string  `json:"display" xml:",chardata"`
+}
+
+// crackScenarios are the attacks crack times are estimated for, in guesses
+// per second.
+var crackScenarios = []struct {
+	name string
+	rate float64
+}{
+	{"online_throttled", 100.0 / 3600},
+	{"online_unthrottled", 10},
+	{"offline_slow_hash", 1e4},
+	{"offline_fast_hash", 1e10},
+}
+
+type strengthEstimate struct {
+	XMLName xml.Name `json:"-" xml:"strength"`
+	// Score is from 0 (too guessable) to 4 (very unguessable).
+	Score        int             `json:"score" xml:"score"`
+	Guesses      float64         `json:"guesses" xml:"guesses"`
+	GuessesLog10 float64         `json:"guesses_log10" xml:"guesses_log10"`
+	CrackTimes   []crackTime     `json:"crack_times" xml:"crack_time"`
+	Sequence     []strengthMatch `json:"sequence" xml:"match"`
+}
+
+// crackTime returns the estimated time to crack the password in scenario.
+func (s strengthEstimate) crackTime(scenario string) crackTime {
+	for _, c := range s.CrackTimes {
+		if c.Scenario == scenario {
+			return c
+		}
+	}
+	return crackTime{}
+}
+
+func (s strengthEstimate) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "strength: %d/4\nguesses: 10^%.1f\n", s.Score, s.GuessesLog10)
+	for _, c := range s.CrackTimes {
+		fmt.Fprintf(&sb, "%s: %s\n", c.Scenario, c.Display)
+	}
+	return sb.String()
+}
+
+func (s strengthEstimate) text() string {
+	return s.String()
+}
+
+func (s strengthEstimate) csvRecords() [][]string {
+	header := []string{"score", "guesses_log10"}
+	record := []string{strconv.Itoa(s.Score), strconv.FormatFloat(s.GuessesLog10, 'f', 2, 64)}
+	for _, c := range s.CrackTimes {
+		header = append(header, c.Scenario+"_seconds")
+		record = append(record, strconv.FormatFloat(c.Seconds, 'g', 3, 64))
+	}
+	return [][]string{header, record}
+}
+
+// estimateStrength rates password.
+func estimateStrength(password string) strengthEstimate {
+	runes := []rune(password)
+	if len(runes) > maxStrengthLength {
+		runes = runes[:maxStrengthLength]
+	}
+	guesses, sequence := mostGuessableSequence(runes)
+	s := strengthEstimate{
+		Guesses:      guesses,
+		GuessesLog10: math.Round(math.Log10(guesses)*100) / 100,
+		Sequence:     sequence,
+	}
+	for _, threshold := range []float64{1e3, 1e6, 1e8, 1e10} {
+		if guesses >= threshold+5 {
+			s.Score++
+		}
+	}
+	for _, scenario := range crackScenarios {
+		seconds := guesses / scenario.rate
+		s.CrackTimes = append(s.CrackTimes, crackTime{scenario.name, seconds, displayCrackTime(seconds)})
+	}
+	return s
+}
+
+// displayCrackTime describes a number of seconds roughly.
+func displayCrackTime(seconds float64) string {
+	const (
+		minute = 60
+		hour   = 60 * minute
+		day    = 24 * hour
+		month  = 31 * day
+		year   = 12 * month
+	)
+	units := []struct {
+		name    string
+		seconds float64
+	}{{"year", year}, {"month", month}, {"day", day}, {"hour", hour}, {"minute", minute}, {"second", 1}}
+	switch {
+	case seconds < 1:
+		return "less than a second"
+	case seconds >= 100*year:
+		return "centuries"
+	}
+	for _, u := range units {
+		if seconds >= u.seconds {
+			n := int(math.Round(seconds / u.seconds))
+			if n == 1 {
+				return "1 " + u.name
+			}
+			return fmt.Sprintf("%d %ss", n, u.name)
+		}
+	}
+	return "less than a second"
+}
+
+// mostGuessableSequence returns the fewest guesses needed for password and
+// the sequence of matches that needs them.
+func mostGuessableSequence(password []rune) (float64, []strengthMatch) {
+	n := len(password)
+	if n == 0 {
+		return 1, []strengthMatch{}
+	}
+	byEnd := make([][]strengthMatch, n)
+	for _, m := range findMatches(password) {
+		byEnd[m.End-1] = append(byEnd[m.End-1], m)
+	}
+
+	// best[k][l] is the best sequence of l matches covering password[:k+1],
+	// scored by l! * (product of guesses) + 10000^(l-1) to penalise
+	// splitting the password into many matches.
+	type step struct {
+		match   strengthMatch
+		product float64
+		score   float64
+	}
+	best := make([]map[int]step, n)
+	for k := range best {
+		best[k] = make(map[int]step)
+	}
+	update := func(m strengthMatch, l int) {
+		k := m.End - 1
+		product := m.Guesses
+		if l > 1 {
+			product *= best[m.Start-1][l-1].product
+		}
+		score := factorial(l)*product + math.Pow(10000, float64(l-1))
+		for other, s := range best[k] {
+			if other <= l && s.score <= score {
+				return
+			}
+		}
+		best[k][l] = step{m, product, score}
+	}
+
+	for k := 0; k < n; k++ {
+		for _, m := range byEnd[k] {
+			if m.Start == 0 {
+				update(m, 1)
+				continue
+			}
+			for l := range best[m.Start-1] {
+				update(m, l+1)
+			}
+		}
+		update(bruteforceMatch(0, k+1), 1)
+		for i := 1; i <= k; i++ {
+			m := bruteforceMatch(i, k+1)
+			for l, s := range best[i-1] {
+				// Adjacent brute force runs are better as one.
+				if s.match.Pattern != "bruteforce" {
+					update(m, l+1)
+				}
+			}
+		}
+	}
+
+	bestL, bestScore := 0, math.Inf(1)
+	for l, s := range best[n-1] {
+		if s.score < bestScore {
+			bestL, bestScore = l, s.score
+		}
+	}
+	sequence := make([]strengthMatch, bestL)
+	for k, l := n-1, bestL; l > 0; l-- {
+		m := best[k][l].match
+		sequence[l-1] = m
+		k = m.Start - 1
+	}
+	return bestScore, sequence
+}
+
+func factorial(n int) float64 {
+	f := 1.0
+	for i := 2; i <= n; i++ {
+		f *= float64(i)
+	}
+	return f
+}
+
+func bruteforceMatch(start, end int) strengthMatch {
+	guesses := math.Pow(bruteforceCardinality, float64(end-start))
+	floor := float64(minGuessesMultiChar)
+	if end-start == 1 {
+		floor = minGuessesSingleChar
+	}
+	return strengthMatch{"bruteforce", start, end, math.Max(guesses, floor+1)}
+}
+
+// findMatches returns every match of every pattern in password.
+func findMatches(password []rune) []strengthMatch {
+	var matches []strengthMatch
+	add := func(pattern string, start, end int, guesses float64) {
+		floor := float64(minGuessesMultiChar)
+		if end-start == 1 {
+			floor = minGuessesSingleChar
+		}
+		matches = append(matches, strengthMatch{pattern, start, end, math.Max(guesses, floor)})
+	}
+	lower := []rune(strings.ToLower(string(password)))
+	if len(lower) != len(password) {
+		// Lowercasing changed the length; match case sensitively.
+		lower = password
+	}
+
+	// Dictionary words, possibly reversed or with l33t substitutions.
+	for i := range password {
+		for j := i + 1; j <= len(password); j++ {
+			token := password[i:j]
+			word := string(lower[i:j])
+			reversed := reverseRunes(lower[i:j])
+			for _, dict := range rankedDictionaries {
+				if rank, ok := dict[word]; ok {
+					add("dictionary", i, j, float64(rank)*uppercaseVariations(token))
+				}
+				if rank, ok := dict[reversed]; ok && reversed != word {
+					add("dictionary", i, j, 2*float64(rank)*uppercaseVariations(token))
+				}
+				for _, table := range l33tTables {
+					unl33t, subs := unl33t(lower[i:j], table)
+					if subs == 0 {
+						continue
+					}
+					if rank, ok := dict[unl33t]; ok {
+						add("dictionary", i, j, float64(rank)*uppercaseVariations(token)*l33tVariations(lower[i:j], table))
+					}
+				}
+			}
+		}
+	}
+
+	// Keyboard walks of three or more keys.
+	for i := 0; i+2 < len(password); {
+		j, turns, shifted := i+1, 0, 0
+		if keyPositions[password[i]].shifted {
+			shifted++
+		}
+		last := -1
+		for ; j < len(password); j++ {
+			d := keyDirection(password[j-1], password[j])
+			if d < 0 {
+				break
+			}
+			if d != last {
+				turns++
+				last = d
+			}
+			if keyPositions[password[j]].shifted {
+				shifted++
+			}
+		}
+		if j-i >= 3 {
+			add("spatial", i, j, spatialGuesses(j-i, turns, shifted))
+			i = j
+			continue
+		}
+		i++
+	}
+
+	// Repeats of a base string, such as "aaa" or "abcabc".
+	for i := 0; i < len(password); {
+		baseLen, reps := 0, 0
+		for b := 1; i+2*b <= len(password); b++ {
+			r := 1
+			for i+(r+1)*b <= len(password) && string(password[i+r*b:i+(r+1)*b]) == string(password[i:i+b]) {
+				r++
+			}
+			if r > 1 && r*b > reps*baseLen {
+				baseLen, reps = b, r
+			}
+		}
+		if reps == 0 {
+			i++
+			continue
+		}
+		baseGuesses, _ := mostGuessableSequence(password[i : i+baseLen])
+		add("repeat", i, i+baseLen*reps, baseGuesses*float64(reps))
+		i += baseLen * reps
+	}
+
+	// Sequences with a constant step, such as "abc" or "9753".
+	for i := 0; i+2 < len(password); {
+		delta := password[i+1] - password[i]
+		j := i + 2
+		for j < len(password) && password[j]-password[j-1] == delta {
+			j++
+		}
+		if delta != 0 && delta >= -5 && delta <= 5 && j-i >= 3 {
+			add("sequence", i, j, sequenceGuesses(password[i], delta, j-i))
+			i = j
+			continue
+		}
+		i++
+	}
+
+	// Recent years.
+	thisYear := time.Now().Year()
+	for i := 0; i+4 <= len(password); i++ {
+		year, err := strconv.Atoi(string(password[i : i+4]))
+		if err != nil || year < 1900 || year > thisYear+30 {
+			continue
+		}
+		add("year", i, i+4, math.Max(math.Abs(float64(year-thisYear)), minYearSpace))
+	}
+	return matches
+}
+
+func reverseRunes(r []rune) string {
+	rev := make([]rune, len(r))
+	for i, c := range r {
+		rev[len(r)-1-i] = c
+	}
+	return string(rev)
+}
+
+// unl33t returns token with the substitutions in table undone and how many
+// characters were substituted.
+func unl33t(token []rune, table map[rune]rune) (string, int) {
+	out := make([]rune, len(token))
+	subs := 0
+	for i, r := range token {
+		if c, ok := table[r]; ok {
+			out[i] = c
+			subs++
+		} else {
+			out[i] = r
+		}
+	}
+	return string(out), subs
+}
+
+// binomial returns n choose k.
+func binomial(n, k int) float64 {
+	if k > n {
+		return 0
+	}
+	r := 1.0
+	for i := 1; i <= k; i++ {
+		r = r * float64(n-k+i) / float64(i)
+	}
+	return r
+}
+
+// variations is the number of ways of choosing which of a+b characters are
+// the a changed ones, assuming attackers try fewer changes first.
+func variations(a, b int) float64 {
+	switch {
+	case a == 0:
+		return 1
+	case b == 0:
+		return 2
+	}
+	v := 0.0
+	for i := 1; i <= a && i <= b; i++ {
+		v += binomial(a+b, i)
+	}
+	return v
+}
+
+// uppercaseVariations returns how many capitalisations of a lowercase word
+// an attacker tries before token's.
+func uppercaseVariations(token []rune) float64 {
+	var upper, lower int
+	for _, r := range token {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	first, last := unicode.IsUpper(token[0]), unicode.IsUpper(token[len(token)-1])
+	if lower == 0 || upper == 1 && (first || last) {
+		return 2
+	}
+	return variations(upper, lower)
+}
+
+// l33tVariations returns how many combinations of substitutions an attacker
+// tries before token's.
+func l33tVariations(token []rune, table map[rune]rune) float64 {
+	subbed := make(map[rune]int)
+	plain := make(map[rune]int)
+	for _, r := range token {
+		if c, ok := table[r]; ok {
+			subbed[c]++
+		} else {
+			plain[r]++
+		}
+	}
+	v := 1.0
+	for c, s := range subbed {
+		v *= variations(s, plain[c])
+	}
+	return v
+}
+
+// spatialGuesses estimates the guesses for a keyboard walk of length keys
+// with the given number of turns and shifted keys.
+func spatialGuesses(length, turns, shifted int) float64 {
+	starts := float64(len(keyPositions) / 2)
+	guesses := 0.0
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= turns && j <= i-1; j++ {
+			guesses += binomial(i-1, j-1) * starts * math.Pow(keyboardDegree, float64(j))
+		}
+	}
+	if shifted > 0 {
+		guesses *= variations(shifted, length-shifted)
+	}
+	return guesses
+}
+
+// sequenceGuesses estimates the guesses for a sequence starting at first.
+func sequenceGuesses(first, delta rune, length int) float64 {
+	var base float64
+	switch {
+	case strings.ContainsRune("aAzZ019", first):
+		// Obvious starting points.
+		base = 4
+	case unicode.IsDigit(first):
+		base = 10
+	default:
+		base = 26
+	}
+	if delta < 0 {
+		base *= 2
+	}
+	return base * float64(length)
+}
+
+// maxStrengthBody limits the size of requests to /strength.
+const maxStrengthBody = 4096
+
+// strengthHandler rates a password POSTed in the "password" form field.
+// Passwords are only accepted in the body, so they don't end up in access
+// logs, and are never logged or stored.
+func strengthHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "passwords to rate must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := req.URL.Query()["password"]; ok {
+		http.Error(w, "the password must be in the request body, not the URL", http.StatusBadRequest)
+		return
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, maxStrengthBody)
+	password := req.PostFormValue("password")
+	if password == "" {
+		http.Error(w, "missing password", http.StatusBadRequest)
+		return
+	}
+	render(w, req, estimateStrength(password))
+}
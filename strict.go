@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// In strict mode, asked for with strict=1 or by accepting
+// application/problem+json, parameters that would otherwise be ignored,
+// clamped or replaced by defaults are rejected, and errors are RFC 9457
+// problem details in JSON, so client bugs surface instead of being masked.
+
+// commonParams are accepted by every route, besides its own parameters.
+var commonParams = []string{"strict", "format", "newline", "wait"}
+
+// problem describes what was wrong with a request.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	// Parameter is the invalid parameter, if the problem is with one.
+	Parameter string `json:"parameter,omitempty"`
+	// Value is its value, if it was given in the query, since values in
+	// request bodies may be secrets.
+	Value string `json:"value,omitempty"`
+	// Position is the 1-based position in Value of the first character
+	// that was rejected.
+	Position int      `json:"position,omitempty"`
+	Allowed  []string `json:"allowed,omitempty"`
+}
+
+// isStrict reports whether req asked for strict mode.
+func isStrict(req *http.Request) bool {
+	if req.URL.Query().Get("strict") == "1" {
+		return true
+	}
+	for _, v := range req.Header["Accept"] {
+		if strings.Contains(v, "application/problem+json") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeProblem writes p as the response, with the status 400 Bad Request
+// if p has none.
+func writeProblem(w http.ResponseWriter, p problem) {
+	if p.Status == 0 {
+		p.Status = http.StatusBadRequest
+	}
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	body, _ := json.Marshal(p)
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/problem+json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(append(body, '\n'))
+}
+
+// paramProblem returns the problem with the parameter name of req, which
+// has the value v.
+func paramProblem(req *http.Request, name, v, detail string) problem {
+	p := problem{Detail: detail, Parameter: name}
+	if _, ok := req.URL.Query()[name]; ok {
+		p.Value = v
+	}
+	return p
+}
+
+// checkParams returns the problem with the first parameter of req that
+// isn't one of params or the common parameters, or whose value isn't of
+// its type or one of its values.
+func checkParams(req *http.Request, params []param) *problem {
+	req.ParseMultipartForm(32 << 20)
+	for name, values := range req.Form {
+		var found *param
+		for i := range params {
+			if params[i].name == name {
+				found = &params[i]
+			}
+		}
+		if found == nil {
+			if contains(commonParams, name) {
+				continue
+			}
+			p := problem{Detail: fmt.Sprintf("unknown parameter %q", name), Parameter: name}
+			for _, known := range params {
+				p.Allowed = append(p.Allowed, known.name)
+			}
+			return &p
+		}
+		for _, v := range values {
+			if len(found.enum) > 0 && !contains(found.enum, v) {
+				p := paramProblem(req, name, v, fmt.Sprintf("%s must be one of %s", name, strings.Join(found.enum, ", ")))
+				p.Allowed = found.enum
+				return &p
+			}
+			if found.typ == "integer" {
+				if _, err := strconv.Atoi(v); err != nil {
+					p := paramProblem(req, name, v, name+" must be an integer")
+					return &p
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// strictParams checks the parameters of strict requests against those of
+// r before calling h, whose plain text client errors are turned into
+// problems.
+func strictParams(r route, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isStrict(req) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if p := checkParams(req, r.params); p != nil {
+			writeProblem(w, *p)
+			return
+		}
+		sw := &strictResponseWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, req)
+		sw.close()
+	})
+}
+
+// strictResponseWriter holds back the body of plain text client errors,
+// such as those from http.Error, to write them as problems.
+type strictResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *strictResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code >= 400 && code < 500 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *strictResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != 0 {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush.
+func (w *strictResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.status == 0 {
+		f.Flush()
+	}
+}
+
+func (w *strictResponseWriter) close() {
+	if w.status != 0 {
+		writeProblem(w.ResponseWriter, problem{Status: w.status, Detail: strings.TrimSpace(w.body.String())})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// newSyslogWriter returns an error, as there is no syslog daemon here.
+func newSyslogWriter(tag string) (io.Writer, error) {
+	return nil, errors.New("syslog isn't available on this platform")
+}
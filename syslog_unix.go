@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// newSyslogWriter returns a writer to the local syslog daemon, each write a
+// message from the daemon facility tagged with tag.
+func newSyslogWriter(tag string) (io.Writer, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// The page is rendered with html/template, which escapes every value for
+// the context it appears in, so request and configuration values can't
+// inject markup or script. -template-engine chooses how templates are
+// loaded:
+//
+//	html    index.html from -template-dir if there is one, and the built-in
+//	        page otherwise (the default)
+//	layout  every .html file in -template-dir parsed together, so index.html
+//	        can be built from shared layouts and partials with {{template}}
+//	        and {{block}}
+var (
+	templateEngine = flag.String("template-engine", "html", "page template `engine`: html or layout")
+	templateDir    = flag.String("template-dir", ".", "`directory` custom page templates are loaded from")
+)
+
+// -dev is for working on templates: they are parsed again for every
+// request, so edits show up when the page is reloaded, and template errors
+// are shown on the page instead of being logged. Otherwise templates are
+// parsed once at startup. Static assets are compiled in, so they can only
+// change with the binary, but they aren't cached in -dev mode either.
+var devMode = flag.Bool("dev", false, "parse page templates for every request and show their errors, for developing templates")
+
+// pageTemplate renders the page.
+type pageTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// templateEngines load the page template from a directory.
+var templateEngines = map[string]func(dir string) (pageTemplate, error){
+	"html": func(dir string) (pageTemplate, error) {
+		t, err := template.New("index.html").Funcs(template.FuncMap(templateFuncs)).ParseFiles(filepath.Join(dir, "index.html"))
+		if err != nil {
+			if *devMode {
+				if !os.IsNotExist(err) {
+					return nil, err
+				}
+				// Not logged, as it would be for every request.
+				return template.New("index").Funcs(template.FuncMap(templateFuncs)).Parse(indexHtml)
+			}
+			log.Println(err)
+			log.Println("Using default template")
+			return template.New("index").Funcs(template.FuncMap(templateFuncs)).Parse(indexHtml)
+		}
+		return t, nil
+	},
+	"layout": func(dir string) (pageTemplate, error) {
+		t, err := template.New("").Funcs(template.FuncMap(templateFuncs)).ParseGlob(filepath.Join(dir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		if t.Lookup("index.html") == nil {
+			return nil, errors.New("the layout engine requires an index.html template")
+		}
+		return namedTemplate{t, "index.html"}, nil
+	},
+}
+
+func init() {
+	for name := range templateEngines {
+		name := name
+		registerFeature(feature{name: name, kind: "template engine", active: func() bool {
+			return *templateEngine == name
+		}})
+	}
+}
+
+// namedTemplate renders one template of a set.
+type namedTemplate struct {
+	set  *template.Template
+	name string
+}
+
+func (t namedTemplate) Execute(w io.Writer, data interface{}) error {
+	return t.set.ExecuteTemplate(w, t.name, data)
+}
+
+// loadPageTemplate loads the page template with the configured engine.
+func loadPageTemplate() error {
+	load, ok := templateEngines[*templateEngine]
+	if !ok {
+		return errors.New("-template-engine must be html or layout")
+	}
+	t, err := load(*templateDir)
+	if err != nil {
+		if *devMode {
+			log.Printf("Page template: %s; it is loaded again for each request in -dev mode", err)
+			return nil
+		}
+		return err
+	}
+	index = t
+	return nil
+}
+
+// currentPageTemplate returns the template to render the page with: the
+// one loaded at startup or, in -dev mode, one parsed afresh.
+func currentPageTemplate() (pageTemplate, error) {
+	if !*devMode {
+		return index, nil
+	}
+	return templateEngines[*templateEngine](*templateDir)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestPageEscapesHost sends markup as the Host header, which clients can't
+// send over a real connection, to check that the page escapes it.
+func TestPageEscapesHost(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = `"><script>alert(1)</script>`
+	rec := httptest.NewRecorder()
+	newServer().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "<script>alert") {
+		t.Error("the Host header was inserted unescaped")
+	}
+}
+
+// TestPageEscapesSettings sets the branding flags to markup and script, which
+// html/template must escape for the HTML, attribute, URL and CSS contexts
+// they're used in.
+func TestPageEscapesSettings(t *testing.T) {
+	const script = `"><script>alert(1)</script>`
+	setFlag(t, "title", script)
+	setFlag(t, "logo", `javascript:alert(1)`)
+	setFlag(t, "theme-color", `red;}</style><script>alert(1)</script>`)
+	old := footerLinks
+	footerLinks = linkList{{Label: script, URL: `javascript:alert(1)`}}
+	defer func() { footerLinks = old }()
+
+	rec := do(newServer(), "", "/", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, unescaped := range []string{"<script>alert", `href="javascript:`, `src="javascript:`, "</style><script>"} {
+		if strings.Contains(body, unescaped) {
+			t.Errorf("the page contains %q", unescaped)
+		}
+	}
+	if !strings.Contains(body, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Error("the title isn't on the page, escaped")
+	}
+}
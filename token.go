@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Tokens are machine secrets such as API keys, so unlike passwords they are
+// read straight from crypto/rand rather than drawn from a human friendly
+// alphabet.
+
+const (
+	defaultTokenBytes = 32
+	maxTokenBytes     = 1024
+)
+
+var tokenEncodings = map[string]func([]byte) string{
+	"hex":       hex.EncodeToString,
+	"base64url": base64.RawURLEncoding.EncodeToString,
+	"base32":    base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString,
+}
+
+type tokenResult struct {
+	XMLName  xml.Name `json:"-" xml:"token"`
+	Token    string   `json:"token" xml:"value"`
+	Encoding string   `json:"encoding" xml:"encoding,attr"`
+	Bytes    int      `json:"bytes" xml:"bytes,attr"`
+}
+
+func (t tokenResult) text() string {
+	return t.Token
+}
+
+func (t tokenResult) csvRecords() [][]string {
+	return [][]string{{"token", "encoding", "bytes"}, {t.Token, t.Encoding, strconv.Itoa(t.Bytes)}}
+}
+
+func tokenHandler(w http.ResponseWriter, req *http.Request) {
+	n := defaultTokenBytes
+	if s := req.FormValue("bytes"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxTokenBytes {
+			http.Error(w, fmt.Sprintf("bytes must be between 1 and %d", maxTokenBytes), http.StatusBadRequest)
+			return
+		}
+	}
+
+	encoding := req.FormValue("encoding")
+	if encoding == "" {
+		encoding = "hex"
+	}
+	encode, ok := tokenEncodings[encoding]
+	if !ok {
+		http.Error(w, "encoding must be one of hex, base64url or base32", http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, n)
+	if _, err := readRandom(b); err != nil {
+		http.Error(w, "failed to read random bytes", http.StatusInternalServerError)
+		return
+	}
+	render(w, req, tokenResult{Token: encode(b), Encoding: encoding, Bytes: n})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TOTP secrets are the shared secrets of RFC 6238 one-time passwords,
+// encoded in unpadded base32 in the otpauth:// URIs that authenticator apps
+// import, as described by Google Authenticator's Key Uri Format.
+
+const (
+	defaultTOTPBytes = 20 // the length of an HMAC-SHA1 key
+	minTOTPBytes     = 16 // the minimum RFC 4226 allows
+	maxTOTPBytes     = 64
+	maxTOTPLabel     = 64
+)
+
+var (
+	totpAlgorithms = []string{"SHA1", "SHA256", "SHA512"}
+	totpDigits     = []string{"6", "8"}
+	totpPeriods    = []string{"30", "60"}
+)
+
+type totpResult struct {
+	XMLName   xml.Name `json:"-" xml:"totp"`
+	Secret    string   `json:"secret" xml:"secret"`
+	URI       string   `json:"uri" xml:"uri"`
+	Algorithm string   `json:"algorithm" xml:"algorithm,attr"`
+	Digits    int      `json:"digits" xml:"digits,attr"`
+	Period    int      `json:"period" xml:"period,attr"`
+}
+
+func (r totpResult) text() string {
+	return r.Secret + "\n" + r.URI
+}
+
+func (r totpResult) csvRecords() [][]string {
+	return [][]string{
+		{"secret", "uri", "algorithm", "digits", "period"},
+		{r.Secret, r.URI, r.Algorithm, strconv.Itoa(r.Digits), strconv.Itoa(r.Period)},
+	}
+}
+
+// totpURI returns the otpauth URI for secret. Parameters with their
+// default values are left out, since some apps ignore them anyway.
+func totpURI(secret, issuer, account, algorithm string, digits, period int) string {
+	label := url.PathEscape(account)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	q := "secret=" + secret
+	if issuer != "" {
+		// Spaces as %20, as the Key Uri Format asks.
+		q += "&issuer=" + strings.Replace(url.QueryEscape(issuer), "+", "%20", -1)
+	}
+	if algorithm != "SHA1" {
+		q += "&algorithm=" + algorithm
+	}
+	if digits != 6 {
+		q += "&digits=" + strconv.Itoa(digits)
+	}
+	if period != 30 {
+		q += "&period=" + strconv.Itoa(period)
+	}
+	return "otpauth://totp/" + label + "?" + q
+}
+
+// totpOption returns the value of the parameter name, which must be one of
+// allowed, or def if it is unset.
+func totpOption(req *http.Request, name, def string, allowed []string) (string, error) {
+	v := req.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	if !contains(allowed, v) {
+		return "", fmt.Errorf("%s must be one of %s", name, strings.Join(allowed, ", "))
+	}
+	return v, nil
+}
+
+func totpHandler(w http.ResponseWriter, req *http.Request) {
+	account := req.FormValue("account")
+	issuer := req.FormValue("issuer")
+	if account == "" {
+		http.Error(w, "account is required, e.g. alice@example.com", http.StatusBadRequest)
+		return
+	}
+	if len(account) > maxTOTPLabel || len(issuer) > maxTOTPLabel || strings.Contains(issuer, ":") {
+		http.Error(w, fmt.Sprintf("account and issuer must be at most %d bytes, and issuer can't contain a colon", maxTOTPLabel), http.StatusBadRequest)
+		return
+	}
+	algorithm, err := totpOption(req, "algorithm", "SHA1", totpAlgorithms)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	digits, err := totpOption(req, "digits", "6", totpDigits)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	period, err := totpOption(req, "period", "30", totpPeriods)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := defaultTOTPBytes
+	if s := req.FormValue("bytes"); s != "" {
+		n, err = strconv.Atoi(s)
+		if err != nil || n < minTOTPBytes || n > maxTOTPBytes {
+			http.Error(w, fmt.Sprintf("bytes must be between %d and %d", minTOTPBytes, maxTOTPBytes), http.StatusBadRequest)
+			return
+		}
+	}
+
+	b := make([]byte, n)
+	if _, err := readRandom(b); err != nil {
+		http.Error(w, "failed to read random bytes", http.StatusInternalServerError)
+		return
+	}
+	r := totpResult{
+		Secret:    base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b),
+		Algorithm: algorithm,
+	}
+	r.Digits, _ = strconv.Atoi(digits)
+	r.Period, _ = strconv.Atoi(period)
+	r.URI = totpURI(r.Secret, issuer, account, algorithm, r.Digits, r.Period)
+
+	w.Header().Set("Cache-Control", "no-store")
+	if req.FormValue("qr") == "1" {
+		q, err := newQRCode([]byte(r.URI))
+		if err != nil {
+			http.Error(w, "account and issuer are "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		img, err := q.png(8)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(len(img)))
+		w.Write(img)
+		return
+	}
+	render(w, req, r)
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requests and password generation are traced with OpenTelemetry spans when
+// an OTLP endpoint is configured with the standard environment variables,
+// e.g. OTEL_EXPORTER_OTLP_ENDPOINT=http://collector:4318. Spans are
+// exported in batches over OTLP/HTTP with JSON encoding, which collectors
+// accept alongside protobuf, so no OpenTelemetry SDK is needed. Incoming
+// W3C traceparent headers are honoured, so the server's spans join the
+// traces of the apps that call it.
+
+func init() {
+	registerFeature(feature{name: "OpenTelemetry", kind: "subsystem", version: "OTLP/HTTP JSON", active: func() bool {
+		return tracer != nil
+	}})
+}
+
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+
+	spanStatusError = 2
+
+	maxQueuedSpans   = 2048
+	maxExportedSpans = 512
+)
+
+// tracer exports spans, if tracing is configured.
+var tracer *spanExporter
+
+type spanExporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+	resource []otlpAttribute
+	sampler  func(parent *spanContext, traceID [16]byte) bool
+	delay    time.Duration
+
+	mu      sync.Mutex
+	queue   []*span
+	dropped int
+	flushed chan struct{}
+}
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type span struct {
+	spanContext
+	parent      [8]byte
+	name        string
+	kind        int
+	start, stop time.Time
+	attributes  []otlpAttribute
+	err         string
+}
+
+type spanKey struct{}
+
+// otlpAttribute is an attribute in the OTLP JSON encoding, whose values
+// are objects with one field naming their type.
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{key, map[string]interface{}{"stringValue": value}}
+}
+
+func intAttribute(key string, value int) otlpAttribute {
+	// 64-bit integers are strings in JSON.
+	return otlpAttribute{key, map[string]interface{}{"intValue": strconv.Itoa(value)}}
+}
+
+// setupTracing configures the exporter from the OTEL_* environment
+// variables, leaving tracing off if no endpoint is set.
+func setupTracing() error {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return fmt.Errorf("OTLP endpoint: %s", err)
+	}
+	protocol := otelEnv("PROTOCOL")
+	if protocol != "" && protocol != "http/json" && protocol != "http/protobuf" {
+		return fmt.Errorf("OTLP protocol %s isn't supported; use http/json", protocol)
+	}
+
+	headers, err := parseKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %s", err)
+	}
+	traceHeaders, err := parseKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS"))
+	if err != nil {
+		return fmt.Errorf("OTEL_EXPORTER_OTLP_TRACES_HEADERS: %s", err)
+	}
+	for k, v := range traceHeaders {
+		headers[k] = v
+	}
+
+	timeout := 10 * time.Second
+	if s := otelEnv("TIMEOUT"); s != "" {
+		ms, err := strconv.Atoi(s)
+		if err != nil || ms <= 0 {
+			return fmt.Errorf("OTLP timeout %q must be a positive number of milliseconds", s)
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	delay := 5 * time.Second
+	if s := os.Getenv("OTEL_BSP_SCHEDULE_DELAY"); s != "" {
+		ms, err := strconv.Atoi(s)
+		if err != nil || ms <= 0 {
+			return fmt.Errorf("OTEL_BSP_SCHEDULE_DELAY %q must be a positive number of milliseconds", s)
+		}
+		delay = time.Duration(ms) * time.Millisecond
+	}
+
+	sampler, err := newSampler(os.Getenv("OTEL_TRACES_SAMPLER"), os.Getenv("OTEL_TRACES_SAMPLER_ARG"))
+	if err != nil {
+		return err
+	}
+
+	attrs, err := parseKeyValues(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		return fmt.Errorf("OTEL_RESOURCE_ATTRIBUTES: %s", err)
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		attrs["service.name"] = name
+	} else if attrs["service.name"] == "" {
+		attrs["service.name"] = "random-password-please"
+	}
+	attrs["service.version"] = buildVersion()
+	var resource []otlpAttribute
+	for k, v := range attrs {
+		resource = append(resource, stringAttribute(k, v))
+	}
+
+	tracer = &spanExporter{
+		endpoint: endpoint,
+		headers:  headers,
+		client:   &http.Client{Timeout: timeout},
+		resource: resource,
+		sampler:  sampler,
+		delay:    delay,
+		flushed:  make(chan struct{}, 1),
+	}
+	go tracer.run()
+	log.Printf("Exporting traces to %s", endpoint)
+	return nil
+}
+
+// otelEnv returns the OTLP exporter setting name for traces, falling back
+// to the one for all signals.
+func otelEnv(name string) string {
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_" + name); v != "" {
+		return v
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + name)
+}
+
+// parseKeyValues parses the comma-separated, URL-encoded key=value pairs
+// of OTEL_* variables.
+func parseKeyValues(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q isn't key=value", kv)
+		}
+		k, err := url.QueryUnescape(strings.TrimSpace(kv[:i]))
+		if err != nil {
+			return nil, err
+		}
+		v, err := url.PathUnescape(strings.TrimSpace(kv[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// newSampler returns the sampler named by OTEL_TRACES_SAMPLER, which
+// decides whether a new span is sampled.
+func newSampler(name, arg string) (func(parent *spanContext, traceID [16]byte) bool, error) {
+	ratio := 1.0
+	if strings.HasSuffix(name, "traceidratio") && arg != "" {
+		var err error
+		if ratio, err = strconv.ParseFloat(arg, 64); err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("OTEL_TRACES_SAMPLER_ARG %q must be a ratio from 0 to 1", arg)
+		}
+	}
+	var root func(traceID [16]byte) bool
+	switch strings.TrimPrefix(name, "parentbased_") {
+	case "", "always_on":
+		root = func([16]byte) bool { return true }
+	case "always_off":
+		root = func([16]byte) bool { return false }
+	case "traceidratio":
+		// Compare the random low 8 bytes of the trace ID with the ratio,
+		// so every service sampling the trace by ratio agrees.
+		bound := uint64(ratio * (1 << 63))
+		root = func(id [16]byte) bool { return binary.BigEndian.Uint64(id[8:])>>1 < bound }
+	default:
+		return nil, fmt.Errorf("OTEL_TRACES_SAMPLER %q isn't supported", name)
+	}
+	if name != "" && !strings.HasPrefix(name, "parentbased_") {
+		return func(_ *spanContext, id [16]byte) bool { return root(id) }, nil
+	}
+	return func(parent *spanContext, id [16]byte) bool {
+		if parent != nil {
+			return parent.sampled
+		}
+		return root(id)
+	}, nil
+}
+
+// parseTraceparent returns the span context of a W3C traceparent header,
+// or nil if it isn't valid.
+func parseTraceparent(h string) *spanContext {
+	parts := strings.Split(h, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil
+	}
+	var sc spanContext
+	flags, err1 := hex.DecodeString(parts[3])
+	_, err2 := hex.Decode(sc.traceID[:], []byte(parts[1]))
+	_, err3 := hex.Decode(sc.spanID[:], []byte(parts[2]))
+	if err1 != nil || err2 != nil || err3 != nil || sc.traceID == [16]byte{} || sc.spanID == [8]byte{} {
+		return nil
+	}
+	sc.sampled = flags[0]&1 == 1
+	return &sc
+}
+
+// startSpan starts a span as a child of the one in ctx, or of remote if
+// there is none, returning a context holding the new span. Without
+// tracing the span is nil, and its methods do nothing.
+func startSpan(ctx context.Context, name string, kind int, remote *spanContext) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	parent := remote
+	if p, ok := ctx.Value(spanKey{}).(*span); ok {
+		parent = &p.spanContext
+	}
+	s := &span{name: name, kind: kind, start: time.Now()}
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parent = parent.spanID
+	} else {
+		cryptorand.Read(s.traceID[:])
+	}
+	cryptorand.Read(s.spanID[:])
+	s.sampled = tracer.sampler(parent, s.traceID)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) setAttributes(attrs ...otlpAttribute) {
+	if s != nil {
+		s.attributes = append(s.attributes, attrs...)
+	}
+}
+
+// setError marks the span as failed.
+func (s *span) setError(msg string) {
+	if s != nil {
+		s.err = msg
+	}
+}
+
+// end ends the span and queues it for export if it is sampled.
+func (s *span) end() {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.stop = time.Now()
+	tracer.enqueue(s)
+}
+
+func (e *spanExporter) enqueue(s *span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= maxQueuedSpans {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, s)
+	if len(e.queue) >= maxExportedSpans {
+		select {
+		case e.flushed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run exports the queued spans every delay, or sooner when a full batch
+// is queued.
+func (e *spanExporter) run() {
+	t := time.NewTicker(e.delay)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-e.flushed:
+		}
+		e.flush()
+	}
+}
+
+// flush exports the queued spans.
+func (e *spanExporter) flush() {
+	for {
+		e.mu.Lock()
+		batch := e.queue
+		if len(batch) > maxExportedSpans {
+			batch = batch[:maxExportedSpans]
+		}
+		e.queue = e.queue[len(batch):]
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+
+		if dropped > 0 {
+			log.Printf("Dropped %d spans: the export queue was full", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			log.Printf("Failed to export %d spans: %s", len(batch), err)
+			return
+		}
+	}
+}
+
+func (e *spanExporter) export(spans []*span) error {
+	type otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	type otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		out[i] = otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.stop.UnixNano(), 10),
+			Attributes:        s.attributes,
+		}
+		if s.parent != [8]byte{} {
+			out[i].ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			out[i].Status = otlpStatus{Code: spanStatusError, Message: s.err}
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": e.resource},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "random-password-please", "version": buildVersion()},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// startGenerateSpan starts the span of generating count passwords with
+// opts. The passwords themselves are never recorded.
+func startGenerateSpan(ctx context.Context, opts genOptions, count int) (context.Context, *span) {
+	ctx, s := startSpan(ctx, "generate", spanKindInternal, nil)
+	s.setAttributes(intAttribute("password.count", count), intAttribute("password.length", opts.Length))
+	if opts.Mode != "" {
+		s.setAttributes(stringAttribute("password.mode", opts.Mode))
+	}
+	if opts.Pattern != "" {
+		s.setAttributes(stringAttribute("password.pattern", opts.Pattern))
+	}
+	return ctx, s
+}
+
+// startRequestSpan starts the server span of req, which is for the route
+// pattern, returning req with the span in its context. Like the audit log,
+// spans record the route rather than the path, which for /s/ is a secret
+// link.
+func startRequestSpan(req *http.Request, pattern string) (*http.Request, *span) {
+	if tracer == nil {
+		return req, nil
+	}
+	ctx, s := startSpan(req.Context(), req.Method+" "+pattern, spanKindServer, parseTraceparent(req.Header.Get("Traceparent")))
+	s.setAttributes(
+		stringAttribute("http.request.method", req.Method),
+		stringAttribute("http.route", pattern),
+	)
+	return req.WithContext(ctx), s
+}
+
+// endRequestSpan ends the server span of a request answered with code.
+func endRequestSpan(s *span, code int) {
+	s.setAttributes(intAttribute("http.response.status_code", code))
+	if code >= 500 {
+		s.setError(http.StatusText(code))
+	}
+	s.end()
+}
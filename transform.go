@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Transformations reformat generated passwords, e.g. into license key style
+// XXXX-XXXX-XXXX groups with a check character. They are applied in the
+// order given by the transform parameter, after the password is generated,
+// so they don't change how it is drawn.
+
+const maxChunk = 64
+
+// leetSubstitutions are the letters leet replaces and their replacements.
+var leetSubstitutions = strings.NewReplacer(
+	"a", "4", "A", "4",
+	"e", "3", "E", "3",
+	"i", "1", "I", "1",
+	"o", "0", "O", "0",
+	"s", "5", "S", "5",
+	"t", "7", "T", "7",
+)
+
+type transformStep struct {
+	name string
+	// lossy steps can map different passwords to the same one, so they
+	// lower its entropy by an amount that depends on the password.
+	lossy bool
+	apply func(t *transforms, password string) string
+}
+
+var transformSteps = []transformStep{
+	{"upper", true, func(_ *transforms, s string) string { return strings.ToUpper(s) }},
+	{"lower", true, func(_ *transforms, s string) string { return strings.ToLower(s) }},
+	{"title", true, func(_ *transforms, s string) string { return titleCase(s) }},
+	{"leet", true, func(_ *transforms, s string) string { return leetSubstitutions.Replace(s) }},
+	{"checksum", false, func(_ *transforms, s string) string { return s + string(luhnCheck(s)) }},
+	{"chunk", false, func(t *transforms, s string) string { return chunk(s, t.size, t.sep) }},
+}
+
+func transformStepNames() []string {
+	names := make([]string, len(transformSteps))
+	for i, s := range transformSteps {
+		names[i] = s.name
+	}
+	return names
+}
+
+// transforms is the pipeline of steps a request asked for.
+type transforms struct {
+	steps []transformStep
+	size  int
+	sep   string
+}
+
+// readTransforms returns the transformations asked for by req's transform
+// parameter, and chunk and chunk-sep, which imply a final chunk step if
+// transform doesn't include one.
+func readTransforms(req *http.Request) (*transforms, error) {
+	t := &transforms{sep: req.FormValue("chunk-sep")}
+	if s := req.FormValue("chunk"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxChunk {
+			return nil, fmt.Errorf("chunk must be between 1 and %d", maxChunk)
+		}
+		t.size = n
+	}
+	if _, ok := req.Form["chunk-sep"]; !ok {
+		t.sep = "-"
+	}
+	chunked := false
+	if s := req.FormValue("transform"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			step, ok := findTransformStep(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown transform %q; transforms are %s", name, strings.Join(transformStepNames(), ", "))
+			}
+			chunked = chunked || name == "chunk"
+			t.steps = append(t.steps, step)
+		}
+	}
+	if chunked && t.size == 0 {
+		return nil, fmt.Errorf("transform=chunk needs chunk, the size of the groups")
+	}
+	if t.size > 0 && !chunked {
+		step, _ := findTransformStep("chunk")
+		t.steps = append(t.steps, step)
+	}
+	return t, nil
+}
+
+func findTransformStep(name string) (transformStep, bool) {
+	for _, s := range transformSteps {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return transformStep{}, false
+}
+
+func (t *transforms) apply(password string) string {
+	for _, s := range t.steps {
+		password = s.apply(t, password)
+	}
+	return password
+}
+
+// lossy reports whether the pipeline can lower the passwords' entropy.
+func (t *transforms) lossy() bool {
+	for _, s := range t.steps {
+		if s.lossy {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyChunks reports whether the pipeline only splits passwords into
+// groups, which keeps their first and last characters.
+func (t *transforms) onlyChunks() bool {
+	for _, s := range t.steps {
+		if s.name != "chunk" {
+			return false
+		}
+	}
+	return true
+}
+
+// titleCase upper cases the letters that begin words and lower cases the
+// rest, where anything other than a letter separates words.
+func titleCase(s string) string {
+	var b strings.Builder
+	start := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			start = true
+			b.WriteRune(r)
+			continue
+		}
+		if start {
+			b.WriteRune(unicode.ToUpper(r))
+		} else {
+			b.WriteRune(unicode.ToLower(r))
+		}
+		start = false
+	}
+	return b.String()
+}
+
+// chunk splits s into groups of size characters joined by sep.
+func chunk(s string, size int, sep string) string {
+	runes := []rune(s)
+	var groups []string
+	for len(runes) > size {
+		groups = append(groups, string(runes[:size]))
+		runes = runes[size:]
+	}
+	groups = append(groups, string(runes))
+	return strings.Join(groups, sep)
+}
+
+// luhnCheck returns the Luhn mod N check character of s over the default
+// alphabet, ignoring characters outside it, which detects any single
+// mistyped character and most transpositions. To verify a password,
+// compute the check character of all but its last character, ignoring
+// any separators, and compare.
+func luhnCheck(s string) byte {
+	n := len(alphabet)
+	var codes []int
+	for _, r := range s {
+		if i := strings.IndexRune(alphabet, r); i >= 0 {
+			codes = append(codes, i)
+		}
+	}
+	sum := 0
+	double := true
+	for i := len(codes) - 1; i >= 0; i-- {
+		c := codes[i]
+		if double {
+			c *= 2
+			c = c/n + c%n
+		}
+		sum += c
+		double = !double
+	}
+	return alphabet[(n-sum%n)%n]
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Usability scoring complements strength: given candidates of equal strength
+// it helps users pick one that is easier to live with. Each component is in
+// the range [0, 1] where higher is easier.
+var usabilityWeights = weights{"typing": 1, "memory": 1, "dictation": 1}
+
+func init() {
+	flag.Var(&usabilityWeights, "usability-weights", "relative weights of the usability score `components` (typing, memory, dictation)")
+}
+
+type usabilityScore struct {
+	// Typing is how easy the password is to type on a phone keyboard,
+	// where switching between letters, digits and symbols and toggling
+	// shift each cost a key press.
+	Typing float64 `json:"typing" xml:"typing"`
+	// Memory is a rough measure of how pronounceable, and so memorable,
+	// the password is.
+	Memory float64 `json:"memory" xml:"memory"`
+	// Dictation is how easy the password is to read out over the phone.
+	Dictation float64 `json:"dictation" xml:"dictation"`
+	// Overall is the weighted mean of the above.
+	Overall float64 `json:"overall" xml:"overall"`
+}
+
+func scoreUsability(password string) usabilityScore {
+	s := usabilityScore{
+		Typing:    typingScore(password),
+		Memory:    memoryScore(password),
+		Dictation: dictationScore(password),
+	}
+	s.Overall = usabilityWeights.mean(map[string]float64{
+		"typing":    s.Typing,
+		"memory":    s.Memory,
+		"dictation": s.Dictation,
+	})
+	for _, f := range []*float64{&s.Typing, &s.Memory, &s.Dictation, &s.Overall} {
+		*f = math.Round(*f*100) / 100
+	}
+	return s
+}
+
+func (s usabilityScore) String() string {
+	return fmt.Sprintf("usability: %.2f\ntyping: %.2f\nmemory: %.2f\ndictation: %.2f\n",
+		s.Overall, s.Typing, s.Memory, s.Dictation)
+}
+
+// keyboardPlane returns which on-screen keyboard layout is needed to type r.
+func keyboardPlane(r rune) int {
+	switch {
+	case unicode.IsLower(r):
+		return 0
+	case unicode.IsUpper(r):
+		return 1
+	case unicode.IsDigit(r):
+		return 2
+	}
+	return 3
+}
+
+func typingScore(password string) float64 {
+	runes := []rune(password)
+	if len(runes) < 2 {
+		return 1
+	}
+	switches := 0
+	for i := 1; i < len(runes); i++ {
+		if keyboardPlane(runes[i]) != keyboardPlane(runes[i-1]) {
+			switches++
+		}
+	}
+	return 1 - float64(switches)/float64(len(runes)-1)
+}
+
+func isVowel(r rune) bool {
+	return strings.ContainsRune("aeiouAEIOU", r)
+}
+
+func memoryScore(password string) float64 {
+	runes := []rune(password)
+	if len(runes) < 2 {
+		return 1
+	}
+	// Alternating consonants and vowels are easier to pronounce.
+	pronounceable := 0
+	for i := 1; i < len(runes); i++ {
+		a, b := runes[i-1], runes[i]
+		if unicode.IsLetter(a) && unicode.IsLetter(b) && isVowel(a) != isVowel(b) {
+			pronounceable++
+		}
+	}
+	alternation := float64(pronounceable) / float64(len(runes)-1)
+
+	// Longer passwords are harder to remember regardless.
+	brevity := 8 / float64(len(runes))
+	if brevity > 1 {
+		brevity = 1
+	}
+	return (alternation + brevity) / 2
+}
+
+// soundsAlike are letters that are often misheard when read out.
+const soundsAlike = "bcdegptvzmnfs"
+
+func dictationScore(password string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 1
+	}
+	hard := 0
+	for i, r := range runes {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			// Symbols have to be named.
+			hard++
+		case strings.ContainsRune(soundsAlike, unicode.ToLower(r)):
+			hard++
+		case i > 0 && unicode.IsUpper(r) != unicode.IsUpper(runes[i-1]):
+			// Case changes have to be called out.
+			hard++
+		}
+	}
+	return 1 - float64(hard)/float64(len(runes))
+}
+
+// weights is a flag.Value holding name=weight pairs.
+type weights map[string]float64
+
+func (w weights) String() string {
+	names := make([]string, 0, len(w))
+	for name := range w {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s := make([]string, len(names))
+	for i, name := range names {
+		s[i] = name + "=" + strconv.FormatFloat(w[name], 'g', -1, 64)
+	}
+	return strings.Join(s, ",")
+}
+
+func (w weights) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid weight %q, want name=weight", pair)
+		}
+		name := strings.TrimSpace(pair[:i])
+		if _, ok := w[name]; !ok {
+			return fmt.Errorf("unknown component %q", name)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(pair[i+1:]), 64)
+		if err != nil || f < 0 {
+			return fmt.Errorf("invalid weight for %s", name)
+		}
+		w[name] = f
+	}
+	return nil
+}
+
+func (w weights) mean(values map[string]float64) float64 {
+	var sum, total float64
+	for name, v := range values {
+		sum += w[name] * v
+		total += w[name]
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / total
+}
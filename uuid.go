@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newUUID returns a random (version 4) or time-ordered (version 7) UUID as
+// described in RFC 9562.
+func newUUID(version int) (string, error) {
+	var u [16]byte
+	if _, err := readRandom(u[:]); err != nil {
+		return "", err
+	}
+	if version == 7 {
+		// The first 48 bits are the Unix time in milliseconds.
+		var ms [8]byte
+		binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+		copy(u[:6], ms[2:])
+	}
+	u[6] = u[6]&0x0f | byte(version)<<4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:]), nil
+}
+
+type uuidBatch struct {
+	XMLName xml.Name `json:"-" xml:"uuids"`
+	Version int      `json:"version" xml:"version,attr"`
+	UUIDs   []string `json:"uuids" xml:"uuid"`
+}
+
+func (b uuidBatch) text() string {
+	return strings.Join(b.UUIDs, "\n")
+}
+
+func (b uuidBatch) csvRecords() [][]string {
+	records := [][]string{{"uuid"}}
+	for _, u := range b.UUIDs {
+		records = append(records, []string{u})
+	}
+	return records
+}
+
+func uuidHandler(w http.ResponseWriter, req *http.Request) {
+	version := 4
+	if s := req.FormValue("version"); s != "" {
+		var err error
+		if version, err = strconv.Atoi(s); err != nil || (version != 4 && version != 7) {
+			http.Error(w, "version must be 4 or 7", http.StatusBadRequest)
+			return
+		}
+	}
+
+	count := 1
+	if s := req.FormValue("count"); s != "" {
+		var err error
+		count, err = strconv.Atoi(s)
+		if err != nil || count < 1 || count > *maxCount {
+			http.Error(w, fmt.Sprintf("count must be between 1 and %d", *maxCount), http.StatusBadRequest)
+			return
+		}
+	}
+
+	batch := uuidBatch{Version: version, UUIDs: make([]string, count)}
+	for i := range batch.UUIDs {
+		u, err := newUUID(version)
+		if err != nil {
+			http.Error(w, "failed to read random bytes", http.StatusInternalServerError)
+			return
+		}
+		batch.UUIDs[i] = u
+	}
+	render(w, req, batch)
+}
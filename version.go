@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// version and commit identify release builds, and are set when linking,
+// as make release does:
+//
+//	go build -ldflags "-X main.version=v1.4.0 -X main.commit=$(git rev-parse HEAD)"
+//
+// The page, its assets and the English wordlist are compiled in, so a
+// release binary is all a deployment needs.
+var (
+	version string
+	commit  string
+)
+
+// buildVersion returns the version set when linking, or else the module
+// version go install records.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+type versionResult struct {
+	XMLName   xml.Name `json:"-" xml:"version"`
+	Version   string   `json:"version" xml:"version"`
+	Commit    string   `json:"commit,omitempty" xml:"commit,omitempty"`
+	GoVersion string   `json:"go_version" xml:"go_version"`
+	Platform  string   `json:"platform" xml:"platform"`
+}
+
+func currentVersion() versionResult {
+	return versionResult{
+		Version:   buildVersion(),
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+func (r versionResult) text() string {
+	s := "random-password-please " + r.Version
+	if r.Commit != "" {
+		s += " (" + r.Commit + ")"
+	}
+	return fmt.Sprintf("%s %s %s", s, r.GoVersion, r.Platform)
+}
+
+func (r versionResult) csvRecords() [][]string {
+	return [][]string{
+		{"version", "commit", "go_version", "platform"},
+		{r.Version, r.Commit, r.GoVersion, r.Platform},
+	}
+}
+
+// versionHandler serves the build's version, for deployment tooling to
+// check what is running.
+func versionHandler(w http.ResponseWriter, req *http.Request) {
+	render(w, req, currentVersion())
+}
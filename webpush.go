@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Web Push notifies administrators' browsers of scheduled rotations, so
+// they know when a new credential has been stored or rolled back without
+// watching the logs. A browser subscribes on /admin/push; the server then
+// sends each rotation event to its push service, encrypted for that browser
+// (RFC 8291) and signed with the server's VAPID key (RFC 8292). The key is
+// created on first use and kept in -web-push-key, since subscriptions are
+// tied to it.
+var (
+	webPushKeyPath = flag.String("web-push-key", "", "PEM `file` of the VAPID key to sign Web Push notifications of rotations with, created if it doesn't exist")
+	webPushSubject = flag.String("web-push-subject", "", "mailto: or https: `URL` push services can contact the operator at")
+	webPushStore   = flag.String("web-push-subscriptions", "", "JSON `file` to keep push subscriptions in across restarts")
+)
+
+func init() {
+	registerFeature(feature{name: "Web Push", kind: "integration", version: "RFC 8291, RFC 8292", active: func() bool {
+		return *webPushKeyPath != ""
+	}})
+}
+
+// pushTTL is how long push services keep notifications for browsers that
+// are offline.
+const pushTTL = 24 * time.Hour
+
+// pushRecordSize is the aes128gcm record size. Notifications are sent as
+// a single record, so must be shorter.
+const pushRecordSize = 4096
+
+// maxPushSubscriptionSize limits the size of a subscription request.
+const maxPushSubscriptionSize = 4096
+
+var pushClient = &http.Client{Timeout: 30 * time.Second}
+
+var b64url = base64.RawURLEncoding
+
+// pushRand is where the salts and ephemeral keys of notifications, and the
+// VAPID signatures' randomness, are read from.
+var pushRand io.Reader = cryptorand.Reader
+
+// pushSubscription is a browser's PushSubscription, as serialised by
+// toJSON().
+type pushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+var webPush struct {
+	key *ecdsa.PrivateKey
+	mu  sync.Mutex
+	// subscriptions are keyed by endpoint.
+	subscriptions map[string]pushSubscription
+}
+
+// setupWebPush loads or creates the VAPID key and loads the stored
+// subscriptions.
+func setupWebPush() error {
+	key, err := loadVAPIDKey(*webPushKeyPath)
+	if os.IsNotExist(err) {
+		key, err = createVAPIDKey(*webPushKeyPath)
+	}
+	if err != nil {
+		return err
+	}
+	webPush.key = key
+	webPush.subscriptions = make(map[string]pushSubscription)
+	if *webPushStore == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*webPushStore)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var subs []pushSubscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return fmt.Errorf("%s: %s", *webPushStore, err)
+	}
+	for _, s := range subs {
+		webPush.subscriptions[s.Endpoint] = s
+	}
+	return nil
+}
+
+func loadVAPIDKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return checkVAPIDKey(path, key)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	ec, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ECDSA key", path)
+	}
+	return checkVAPIDKey(path, ec)
+}
+
+func checkVAPIDKey(path string, key *ecdsa.PrivateKey) (*ecdsa.PrivateKey, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%s: VAPID keys must be on the P-256 curve", path)
+	}
+	return key, nil
+}
+
+func createVAPIDKey(path string) (*ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Created VAPID key %s", path)
+	return key, nil
+}
+
+// vapidPublicKey returns the uncompressed public key browsers subscribe
+// with, as the applicationServerKey.
+func vapidPublicKey() []byte {
+	return elliptic.Marshal(elliptic.P256(), webPush.key.X, webPush.key.Y)
+}
+
+// saveSubscriptions writes the subscriptions to -web-push-subscriptions.
+// webPush.mu must be held.
+func saveSubscriptions() error {
+	if *webPushStore == "" {
+		return nil
+	}
+	subs := make([]pushSubscription, 0, len(webPush.subscriptions))
+	for _, s := range webPush.subscriptions {
+		subs = append(subs, s)
+	}
+	data, err := json.Marshal(subs)
+	if err != nil {
+		return err
+	}
+	tmp := *webPushStore + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, *webPushStore)
+}
+
+// readPushSubscription reads and checks the subscription in req's body.
+func readPushSubscription(req *http.Request) (pushSubscription, error) {
+	var s pushSubscription
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, maxPushSubscriptionSize))
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(body, &s); err != nil {
+		return s, err
+	}
+	if u, err := url.Parse(s.Endpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+		return s, errors.New("endpoint must be an https URL")
+	}
+	return s, nil
+}
+
+// pushSubscribeHandler adds the subscription POSTed as JSON.
+func pushSubscribeHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "subscriptions must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	s, err := readPushSubscription(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := pushKeys(s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	webPush.mu.Lock()
+	webPush.subscriptions[s.Endpoint] = s
+	err = saveSubscriptions()
+	webPush.mu.Unlock()
+	if err != nil {
+		logf(req, "Failed to save push subscriptions: %s", err)
+	}
+	logf(req, "Push subscription added by %s", requestIdentity(req))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pushUnsubscribeHandler removes the subscription POSTed as JSON.
+func pushUnsubscribeHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "unsubscriptions must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	s, err := readPushSubscription(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	webPush.mu.Lock()
+	delete(webPush.subscriptions, s.Endpoint)
+	err = saveSubscriptions()
+	webPush.mu.Unlock()
+	if err != nil {
+		logf(req, "Failed to save push subscriptions: %s", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pushPageHandler serves the page browsers subscribe on.
+func pushPageHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, pushHTML, html.EscapeString(*pageTitle), b64url.EncodeToString(vapidPublicKey()))
+}
+
+var pushHTML = `<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>%s: rotation notifications</title>
+</head>
+<body data-key="%s">
+	<main style="text-align: center">
+		<p>Get a notification in this browser when a scheduled rotation stores a new credential or is rolled back.</p>
+		<button id="subscribe">Notify me</button>
+		<button id="unsubscribe">Stop notifying me</button>
+		<p id="status" role="status"></p>
+	</main>
+	<script src="/static/push.js"></script>
+</body>
+</html>
+`
+
+// pushNotification is the payload the service worker shows.
+type pushNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	// Tag groups the notifications of a rotation.
+	Tag string `json:"tag"`
+}
+
+// notifyRotation sends a notification of event to every subscription, if
+// Web Push is configured and the event is one administrators act on.
+func notifyRotation(event hookEvent) {
+	if webPush.key == nil {
+		return
+	}
+	var n pushNotification
+	switch event.Event {
+	case "rotation.stored":
+		n.Title = "Credential rotated"
+		n.Body = fmt.Sprintf("Job %s stored a new credential in %s.", event.Job, event.Location)
+	case "rotation.rolled_back":
+		n.Title = "Rotation rolled back"
+		n.Body = fmt.Sprintf("Job %s's rotation wasn't confirmed, so the previous credential was restored in %s.", event.Job, event.Location)
+	default:
+		return
+	}
+	n.Tag = event.Rotation
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return
+	}
+
+	webPush.mu.Lock()
+	subs := make([]pushSubscription, 0, len(webPush.subscriptions))
+	for _, s := range webPush.subscriptions {
+		subs = append(subs, s)
+	}
+	webPush.mu.Unlock()
+	for _, s := range subs {
+		go func(s pushSubscription) {
+			gone, err := sendPush(s, payload)
+			if err != nil {
+				log.Printf("Failed to send push notification to %s: %s", pushServiceHost(s), err)
+			}
+			if gone {
+				webPush.mu.Lock()
+				delete(webPush.subscriptions, s.Endpoint)
+				saveSubscriptions()
+				webPush.mu.Unlock()
+			}
+		}(s)
+	}
+}
+
+// pushServiceHost returns the host of s's push service, to log in place of
+// its endpoint, which identifies the browser.
+func pushServiceHost(s pushSubscription) string {
+	if u, err := url.Parse(s.Endpoint); err == nil {
+		return u.Host
+	}
+	return "unknown push service"
+}
+
+// sendPush sends payload to s, reporting whether the push service says the
+// subscription no longer exists.
+func sendPush(s pushSubscription, payload []byte) (gone bool, err error) {
+	body, err := encryptPush(s, payload)
+	if err != nil {
+		return false, err
+	}
+	auth, err := vapidAuthorization(s.Endpoint)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprint(int(pushTTL.Seconds())))
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return true, nil
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("push service responded %s", resp.Status)
+	}
+	return false, nil
+}
+
+// pushKeys returns the browser's public key from s after checking its keys.
+func pushKeys(s pushSubscription) (public []byte, err error) {
+	public, err = b64url.DecodeString(strings.TrimRight(s.Keys.P256dh, "="))
+	if err != nil {
+		return nil, errors.New("keys.p256dh must be base64url encoded")
+	}
+	if x, _ := elliptic.Unmarshal(elliptic.P256(), public); x == nil {
+		return nil, errors.New("keys.p256dh isn't a P-256 public key")
+	}
+	auth, err := b64url.DecodeString(strings.TrimRight(s.Keys.Auth, "="))
+	if err != nil || len(auth) != 16 {
+		return nil, errors.New("keys.auth must be 16 base64url encoded bytes")
+	}
+	return public, nil
+}
+
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// encryptPush encrypts payload for s with the aes128gcm content coding
+// (RFC 8188) as Web Push specifies it (RFC 8291): a key agreed with an
+// ephemeral ECDH key and the browser's, mixed with its auth secret, in a
+// single record.
+func encryptPush(s pushSubscription, payload []byte) ([]byte, error) {
+	uaPublic, err := pushKeys(s)
+	if err != nil {
+		return nil, err
+	}
+	authSecret, _ := b64url.DecodeString(strings.TrimRight(s.Keys.Auth, "="))
+	curve := elliptic.P256()
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+
+	// The salt and the ephemeral private key are read from pushRand, a key
+	// outside [1, n) being vanishingly unlikely.
+	salt := make([]byte, 16)
+	asPrivate := make([]byte, 32)
+	if _, err := io.ReadFull(pushRand, salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(pushRand, asPrivate); err != nil {
+		return nil, err
+	}
+	if k := new(big.Int).SetBytes(asPrivate); k.Sign() == 0 || k.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("invalid ephemeral key")
+	}
+	x, y := curve.ScalarBaseMult(asPrivate)
+	asPublic := elliptic.Marshal(curve, x, y)
+	sx, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	ecdhSecret := make([]byte, 32)
+	sxBytes := sx.Bytes()
+	copy(ecdhSecret[32-len(sxBytes):], sxBytes)
+
+	// HKDF-SHA-256 with outputs no longer than a hash is an extract and a
+	// single expand step.
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := hmacSHA256(hmacSHA256(authSecret, ecdhSecret), keyInfo, []byte{1})
+	prk := hmacSHA256(salt, ikm)
+	cek := hmacSHA256(prk, []byte("Content-Encoding: aes128gcm\x00\x01"))[:16]
+	nonce := hmacSHA256(prk, []byte("Content-Encoding: nonce\x00\x01"))[:12]
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// A single record ends with the delimiter 2 and needs no padding.
+	plaintext := append(append([]byte{}, payload...), 2)
+
+	var header bytes.Buffer
+	header.Write(salt)
+	binary.Write(&header, binary.BigEndian, uint32(pushRecordSize))
+	header.WriteByte(byte(len(asPublic)))
+	header.Write(asPublic)
+	return gcm.Seal(header.Bytes(), nonce, plaintext, nil), nil
+}
+
+// vapidAuthorization returns the Authorization header identifying the
+// server to endpoint's push service: a JWT signed with the VAPID key, and
+// the key.
+func vapidAuthorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}{u.Scheme + "://" + u.Host, time.Now().Add(12 * time.Hour).Unix(), *webPushSubject})
+	if err != nil {
+		return "", err
+	}
+	signed := b64url.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`)) + "." + b64url.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(pushRand, webPush.key, hash[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(sig[32-len(rb):32], rb)
+	copy(sig[64-len(sb):], sb)
+	return "vapid t=" + signed + "." + b64url.EncodeToString(sig) + ", k=" + b64url.EncodeToString(vapidPublicKey()), nil
+}
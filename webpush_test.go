@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEncryptPush checks encryptPush against the example of RFC 8291
+// section 5.
+func TestEncryptPush(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := b64url.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	salt := decode("DGv6ra1nlYgDCS1FRnbzlw")
+	asPrivate := decode("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw")
+	defer func(r io.Reader) { pushRand = r }(pushRand)
+	pushRand = bytes.NewReader(append(salt, asPrivate...))
+
+	var s pushSubscription
+	s.Keys.P256dh = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	s.Keys.Auth = "BTBZMqHH6r4Tts7J_aSIgg"
+	got, err := encryptPush(s, []byte("When I grow up, I want to be a watermelon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27ml" +
+		"mlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPT" +
+		"pK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if b64url.EncodeToString(got) != want {
+		t.Errorf("got %s\nwant %s", b64url.EncodeToString(got), want)
+	}
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(key *ecdsa.PrivateKey) { webPush.key = key }(webPush.key)
+	webPush.key = key
+	setFlag(t, "web-push-subject", "mailto:ops@example.com")
+
+	auth, err := vapidAuthorization("https://push.example.net/send/abc?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(auth, "vapid t=") {
+		t.Fatalf("Authorization is %q", auth)
+	}
+	fields := strings.SplitN(strings.TrimPrefix(auth, "vapid t="), ", k=", 2)
+	if len(fields) != 2 {
+		t.Fatalf("Authorization is %q", auth)
+	}
+	if k, _ := b64url.DecodeString(fields[1]); !bytes.Equal(k, elliptic.Marshal(elliptic.P256(), key.X, key.Y)) {
+		t.Errorf("k is %s, not the VAPID public key", fields[1])
+	}
+
+	parts := strings.Split(fields[0], ".")
+	if len(parts) != 3 {
+		t.Fatalf("JWT %q doesn't have three parts", fields[0])
+	}
+	sig, err := b64url.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		t.Fatalf("signature %q isn't 64 base64url encoded bytes", parts[2])
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&key.PublicKey, hash[:], r, s) {
+		t.Error("the JWT's signature doesn't verify with the VAPID key")
+	}
+
+	var header struct{ Typ, Alg string }
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	for i, v := range []interface{}{&header, &claims} {
+		data, err := b64url.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if header.Alg != "ES256" {
+		t.Errorf("alg is %q, want ES256", header.Alg)
+	}
+	if claims.Aud != "https://push.example.net" || claims.Sub != "mailto:ops@example.com" {
+		t.Errorf("claims are %+v", claims)
+	}
+	if exp := time.Unix(claims.Exp, 0); exp.Before(time.Now()) || exp.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("exp is %s, want within 24 hours", exp)
+	}
+}
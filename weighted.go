@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Weighted wordlists make common words more likely, so passphrases drawn
+// from them are easier to remember. They are lang.freq files in the
+// -wordlists directory, with a word and its positive integer weight, such as
+// its count in a corpus, on each line. Clients ask for them with
+// sampler=weighted in mode=passphrase and mode=memorable.
+//
+// Words drawn by weight are easier to guess than uniform ones: an attacker
+// tries the most likely first. So passwords from weighted lists are credited
+// with the min-entropy of their words, -log2 of the likeliest's probability,
+// rather than their Shannon entropy, and requests whose passwords would have
+// less than -weighted-min-entropy bits are refused.
+var weightedMinEntropy = flag.Float64("weighted-min-entropy", 40, "least min-entropy in `bits` of passwords drawn from weighted wordlists")
+
+func init() {
+	registerFeature(feature{name: "weighted wordlists", kind: "generator", active: func() bool {
+		return len(weightedWordlists) > 0
+	}})
+}
+
+// maxWordlistWeight limits the total weight of a weighted list, so that it
+// can be drawn from with randIntn on any platform.
+const maxWordlistWeight = math.MaxInt32
+
+type weightedList struct {
+	words []string
+	// cumulative[i] is the total weight of words[:i+1].
+	cumulative []int
+	// shannon and minEntropy are the Shannon entropy and min-entropy in bits
+	// of a word drawn from the list.
+	shannon, minEntropy float64
+}
+
+// weightedWordlists are the weighted lists by language. They are only
+// changed at startup.
+var weightedWordlists = map[string]*weightedList{}
+
+// loadWeightedWordlist adds the weighted list at path. Its words are also
+// used for uniform draws if the language has no other list.
+func loadWeightedWordlist(path string) error {
+	lang := strings.TrimSuffix(filepath.Base(path), ".freq")
+	if !langCode.MatchString(lang) {
+		return fmt.Errorf("%s: %q isn't a language code", path, lang)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	list, err := parseWeightedWordlist(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	weightedWordlists[lang] = list
+	if wordlists[lang] == nil {
+		wordlists[lang] = list.words
+		rankedDictionaries[dictionaryName(lang)] = rankList(list.words, true)
+	}
+	return nil
+}
+
+// parseWeightedWordlist parses lines of words and their weights. Blank lines
+// and lines starting with # are ignored.
+func parseWeightedWordlist(s string) (*weightedList, error) {
+	weights := make(map[string]int)
+	var words []string
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want a word and its weight", line)
+		}
+		w := strings.ToLower(fields[0])
+		for _, r := range w {
+			if !unicode.IsLetter(r) {
+				return nil, fmt.Errorf("line %d: %q isn't a word", line, w)
+			}
+		}
+		weight, err := strconv.Atoi(fields[1])
+		if err != nil || weight < 1 {
+			return nil, fmt.Errorf("line %d: weight must be a positive integer", line)
+		}
+		if _, ok := weights[w]; !ok {
+			words = append(words, w)
+		}
+		weights[w] += weight
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(words) < minWordlistWords {
+		return nil, fmt.Errorf("%d distinct words, at least %d are needed", len(words), minWordlistWords)
+	}
+
+	list := &weightedList{words: words, cumulative: make([]int, len(words))}
+	total, max := 0, 0
+	for i, w := range words {
+		if weights[w] > maxWordlistWeight-total {
+			return nil, fmt.Errorf("total weight is over %d", maxWordlistWeight)
+		}
+		total += weights[w]
+		list.cumulative[i] = total
+		if weights[w] > max {
+			max = weights[w]
+		}
+	}
+	for _, w := range words {
+		p := float64(weights[w]) / float64(total)
+		list.shannon -= p * math.Log2(p)
+	}
+	list.minEntropy = -math.Log2(float64(max) / float64(total))
+	return list, nil
+}
+
+// draw returns a word chosen with probability proportional to its weight.
+func (l *weightedList) draw() string {
+	n := randIntn(l.cumulative[len(l.cumulative)-1])
+	return l.words[sort.Search(len(l.cumulative), func(i int) bool {
+		return l.cumulative[i] > n
+	})]
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Crawlers and browsers ask every site for /robots.txt and /favicon.ico,
+// and security researchers for /.well-known/security.txt, so they are
+// served rather than left to 404.
+var (
+	robotsPolicy    = flag.String("robots", "allow", "robots.txt policy: allow crawling the page but not the API, or deny crawling altogether")
+	faviconPath     = flag.String("favicon", "", "ICO, PNG or SVG `file` to serve as /favicon.ico instead of the built-in icon")
+	securityContact = flag.String("security-contact", "", "comma-separated mailto: or https: `urls` to report vulnerabilities to, served in /.well-known/security.txt")
+	securityPolicy  = flag.String("security-policy", "", "`url` of the vulnerability disclosure policy for /.well-known/security.txt")
+)
+
+var robotsPolicies = []string{"allow", "deny"}
+
+// robotsHandler allows crawling only the page and its assets, with the
+// Allow rules and $ anchor of RFC 9309, so crawlers don't generate
+// passwords or use up secret links, or with -robots deny nothing at all.
+func robotsHandler(w http.ResponseWriter, req *http.Request) {
+	body := "User-agent: *\nAllow: /$\nAllow: /static/\nDisallow: /\n"
+	if *robotsPolicy == "deny" {
+		body = "User-agent: *\nDisallow: /\n"
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	fmt.Fprint(w, body)
+}
+
+// favicon is the built-in icon, drawn in the theme color the last time it
+// was asked for.
+var favicon struct {
+	sync.Mutex
+	color string
+	ico   []byte
+}
+
+func faviconHandler(w http.ResponseWriter, req *http.Request) {
+	var b []byte
+	contentType := "image/x-icon"
+	if *faviconPath != "" {
+		var err error
+		if b, err = ioutil.ReadFile(*faviconPath); err != nil {
+			logf(req, "Reading -favicon: %s", err)
+			http.Error(w, "failed to read the icon", http.StatusInternalServerError)
+			return
+		}
+		contentType = http.DetectContentType(b)
+		if strings.HasSuffix(*faviconPath, ".svg") {
+			contentType = "image/svg+xml"
+		}
+	} else {
+		favicon.Lock()
+		if favicon.ico == nil || favicon.color != *themeColor {
+			favicon.color, favicon.ico = *themeColor, drawFavicon(*themeColor)
+		}
+		b = favicon.ico
+		favicon.Unlock()
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
+const faviconSize = 32
+
+// drawFavicon returns an ICO file of a circle in the CSS hex color accent
+// with three white dots, as a password field shows.
+func drawFavicon(accent string) []byte {
+	fill := color.NRGBA{0x2c, 0x6e, 0xbd, 0xff}
+	if c, ok := parseHexColor(accent); ok {
+		fill = c
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, faviconSize, faviconSize))
+	inCircle := func(x, y, cx, cy, r float64) bool {
+		return (x-cx)*(x-cx)+(y-cy)*(y-cy) <= r*r
+	}
+	for y := 0; y < faviconSize; y++ {
+		for x := 0; x < faviconSize; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			switch {
+			case inCircle(px, py, 8, 16, 3), inCircle(px, py, 16, 16, 3), inCircle(px, py, 24, 16, 3):
+				img.Set(x, y, color.White)
+			case inCircle(px, py, 16, 16, 15.5):
+				img.Set(x, y, fill)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+
+	// An ICO file with a single PNG image.
+	var ico bytes.Buffer
+	binary.Write(&ico, binary.LittleEndian, []uint16{0, 1, 1})
+	ico.Write([]byte{faviconSize, faviconSize, 0, 0})
+	binary.Write(&ico, binary.LittleEndian, []uint16{1, 32})
+	binary.Write(&ico, binary.LittleEndian, []uint32{uint32(buf.Len()), 22})
+	ico.Write(buf.Bytes())
+	return ico.Bytes()
+}
+
+// parseHexColor parses a CSS color such as #2c6ebd or #fff.
+func parseHexColor(s string) (color.NRGBA, bool) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	if len(s) != 6 || err != nil {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{uint8(n >> 16), uint8(n >> 8), uint8(n), 0xff}, true
+}
+
+// securityTxtLifetime is how far ahead security.txt's Expires is, which RFC
+// 9116 recommends be less than a year.
+const securityTxtLifetime = 180 * 24 * time.Hour
+
+// securityTxtHandler serves RFC 9116's security.txt, if -security-contact
+// is set.
+func securityTxtHandler(w http.ResponseWriter, req *http.Request) {
+	if *securityContact == "" {
+		http.NotFound(w, req)
+		return
+	}
+	var sb strings.Builder
+	for _, contact := range strings.Split(*securityContact, ",") {
+		contact = strings.TrimSpace(contact)
+		if !strings.Contains(contact, ":") {
+			contact = "mailto:" + contact
+		}
+		fmt.Fprintf(&sb, "Contact: %s\n", contact)
+	}
+	expires := time.Now().UTC().Add(securityTxtLifetime).Truncate(24 * time.Hour)
+	fmt.Fprintf(&sb, "Expires: %s\n", expires.Format(time.RFC3339))
+	if *securityPolicy != "" {
+		fmt.Fprintf(&sb, "Policy: %s\n", *securityPolicy)
+	}
+	if host := pageHost(req); host != "" && req.TLS != nil {
+		fmt.Fprintf(&sb, "Canonical: https://%s/.well-known/security.txt\n", host)
+	}
+	sb.WriteString("Preferred-Languages: en\n")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
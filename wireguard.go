@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"math/big"
+	"net/http"
+)
+
+// WireGuard keys are Curve25519 key pairs, encoded in base64 as wg genkey
+// and wg pubkey print them.
+
+var (
+	curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	curve25519A = big.NewInt(121665) // (486662 - 2) / 4
+)
+
+// x25519Base returns the public key of the private key k, the X25519
+// function of RFC 7748 at the base point 9. math/big isn't constant time,
+// which is acceptable here only because the private key is sent to the
+// client that asked for it anyway.
+func x25519Base(k [32]byte) [32]byte {
+	k[0] &= 248
+	k[31] &= 127
+	k[31] |= 64
+	scalar := new(big.Int).SetBytes(reverse(k[:]))
+
+	p := curve25519P
+	x1 := big.NewInt(9)
+	x2, z2 := big.NewInt(1), big.NewInt(0)
+	x3, z3 := big.NewInt(9), big.NewInt(1)
+	mod := func(n *big.Int) *big.Int { return n.Mod(n, p) }
+	sq := func(n *big.Int) *big.Int { return mod(n.Mul(n, n)) }
+	swap := uint(0)
+	for t := 254; t >= 0; t-- {
+		bit := scalar.Bit(t)
+		if swap^bit == 1 {
+			x2, x3 = x3, x2
+			z2, z3 = z3, z2
+		}
+		swap = bit
+
+		a := mod(new(big.Int).Add(x2, z2))
+		aa := sq(new(big.Int).Set(a))
+		b := mod(new(big.Int).Sub(x2, z2))
+		bb := sq(new(big.Int).Set(b))
+		e := mod(new(big.Int).Sub(aa, bb))
+		c := mod(new(big.Int).Add(x3, z3))
+		d := mod(new(big.Int).Sub(x3, z3))
+		da := mod(new(big.Int).Mul(d, a))
+		cb := mod(new(big.Int).Mul(c, b))
+
+		x3 = sq(new(big.Int).Add(da, cb))
+		z3 = mod(new(big.Int).Mul(x1, sq(new(big.Int).Sub(da, cb))))
+		x2 = mod(new(big.Int).Mul(aa, bb))
+		z2 = mod(new(big.Int).Mul(e, new(big.Int).Add(aa, new(big.Int).Mul(curve25519A, e))))
+	}
+	if swap == 1 {
+		x2, z2 = x3, z3
+	}
+	u := new(big.Int).Mul(x2, new(big.Int).ModInverse(z2, p))
+	u.Mod(u, p)
+
+	var out [32]byte
+	b := u.Bytes()
+	copy(out[:], reverse(b))
+	return out
+}
+
+// reverse returns a reversed copy of b, to convert between the little
+// endian numbers of X25519 and big.Int's big endian ones.
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+	return r
+}
+
+type wireGuardResult struct {
+	XMLName      xml.Name `json:"-" xml:"wireguard"`
+	PrivateKey   string   `json:"private_key" xml:"private_key"`
+	PublicKey    string   `json:"public_key" xml:"public_key"`
+	PresharedKey string   `json:"preshared_key,omitempty" xml:"preshared_key,omitempty"`
+}
+
+// text returns the keys as the lines of a WireGuard configuration file
+// they go in.
+func (r wireGuardResult) text() string {
+	s := "PrivateKey = " + r.PrivateKey + "\nPublicKey = " + r.PublicKey
+	if r.PresharedKey != "" {
+		s += "\nPresharedKey = " + r.PresharedKey
+	}
+	return s
+}
+
+func (r wireGuardResult) csvRecords() [][]string {
+	return [][]string{
+		{"private_key", "public_key", "preshared_key"},
+		{r.PrivateKey, r.PublicKey, r.PresharedKey},
+	}
+}
+
+func wireGuardHandler(w http.ResponseWriter, req *http.Request) {
+	var private [32]byte
+	if _, err := readRandom(private[:]); err != nil {
+		http.Error(w, "failed to read random bytes", http.StatusInternalServerError)
+		return
+	}
+	// Clamp the private key as wg genkey does.
+	private[0] &= 248
+	private[31] = private[31]&127 | 64
+	public := x25519Base(private)
+	r := wireGuardResult{
+		PrivateKey: base64.StdEncoding.EncodeToString(private[:]),
+		PublicKey:  base64.StdEncoding.EncodeToString(public[:]),
+	}
+	if req.FormValue("preshared") == "1" {
+		var psk [32]byte
+		if _, err := readRandom(psk[:]); err != nil {
+			http.Error(w, "failed to read random bytes", http.StatusInternalServerError)
+			return
+		}
+		r.PresharedKey = base64.StdEncoding.EncodeToString(psk[:])
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	render(w, req, r)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+// TestX25519Base checks x25519Base against the key pairs of RFC 7748
+// section 6.1.
+func TestX25519Base(t *testing.T) {
+	for _, tt := range []struct {
+		name, private, public string
+	}{
+		{"Alice", "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a",
+			"8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a"},
+		{"Bob", "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb",
+			"de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f"},
+	} {
+		var k [32]byte
+		b, _ := hex.DecodeString(tt.private)
+		copy(k[:], b)
+		public := x25519Base(k)
+		if got := hex.EncodeToString(public[:]); got != tt.public {
+			t.Errorf("%s's public key is %s, want %s", tt.name, got, tt.public)
+		}
+	}
+}
+
+func TestWireGuardKeys(t *testing.T) {
+	if fipsMode() {
+		t.Skip("/wireguard isn't available in FIPS mode")
+	}
+	rec := do(newServer(), "", "/wireguard?format=json&preshared=1", nil)
+	if rec.Code != 200 {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	var r wireGuardResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	var private [32]byte
+	if b, err := base64.StdEncoding.DecodeString(r.PrivateKey); err != nil || len(b) != 32 {
+		t.Fatalf("private key %q isn't 32 base64 encoded bytes", r.PrivateKey)
+	} else {
+		copy(private[:], b)
+	}
+	if private[0]&7 != 0 || private[31]&0xc0 != 0x40 {
+		t.Errorf("private key %q isn't clamped", r.PrivateKey)
+	}
+	public := x25519Base(private)
+	if got := base64.StdEncoding.EncodeToString(public[:]); r.PublicKey != got {
+		t.Errorf("public key is %s, want %s for the private key", r.PublicKey, got)
+	}
+	if b, err := base64.StdEncoding.DecodeString(r.PresharedKey); err != nil || len(b) != 32 {
+		t.Errorf("preshared key %q isn't 32 base64 encoded bytes", r.PresharedKey)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import "strings"
+
+// words is the list memorable passwords are composed from: short, common,
+// concrete English words that are easy to spell.
+var words = strings.Fields(wordList)
+
+const wordList = `
+able acid acre actor adapt admit adopt adult agent agree ahead aim air alarm
+album alert alien alley allow alpha amber amend angle ankle apple apron arch
+arena argue armor army arrow art aspen atom attic audio aunt autumn avoid
+awake award axis bacon badge bagel baker balance ball bamboo banana band
+banjo bank barn barrel basil basin basket batch bath beach beam bean bear
+beaver bed beef bell belt bench berry bike bird biscuit blade blanket blast
+blend blink block bloom blue board boat bone bonus book boot border bottle
+bounce box brain branch brave bread breeze brick bridge brief bright broom
+brush bubble bucket budget buffalo bulb bunny butter button cabin cable
+cactus cake camel camera camp candle candy canoe canvas canyon cape captain
+carbon card cargo carpet carrot cart castle cat cedar cell cello chair chalk
+chapter charm chart cheese cherry chess chest chief chili chin chip choice
+chorus cider cinema circle citrus city clam clay clever cliff climb clock
+cloud clover club coach coast cobra cocoa coconut code coffee coin comet
+comic copper coral cotton couch cousin cover crab craft crane crayon cream
+creek crisp crown cube cup curtain cushion daisy dance dawn deck deer delta
+denim desert desk detail dial diamond diary dinner disk dock dollar dolphin
+donkey door dove dragon drama dream dress drift drill drum duck dune dust
+eagle earth easel echo eclipse edge elbow elder elk ember empty energy
+engine equal error event exact exit expert fabric falcon fancy farm feast
+feather fence ferry fiber field fig film finch fire fish flag flame flash
+fleet flint flock flower flute focus fog folder forest fork fossil fox frame
+fresh frog frost fruit fudge galaxy garden garlic gate gear gecko gem giant
+ginger giraffe glass globe glove goat gold golf goose gorilla grain grape
+graph grass gravel green grid grill guitar gull habit hammer hand harbor
+harp harvest hat hawk hazel heart hedge helmet hero heron hill hinge hobby
+honey hook horizon horse hotel house humble hunter husky ice icon idea igloo
+index ink input insect iron island item ivory ivy jacket jaguar jam jar jazz
+jeans jelly jet jewel jigsaw joke journey judge juice jumbo jungle junior
+kayak kettle key kid kidney king kiosk kite kitten kiwi knee knife knot
+koala ladder lake lamb lamp lantern laptop large laser lava lawn layer leaf
+lemon lens level lever liberty light lilac lily lime linen lion liquid
+lizard llama lobster locket lodge logic lotus lucky lumber lunar lunch
+magnet mango manor maple marble market marsh mask meadow medal melon memo
+menu mercury metal meteor middle mild mint mirror mitten model monkey moon
+moose morning moss motor mountain mouse muffin mural museum music nail
+napkin narrow native nature navy nectar needle nest net nickel night noble
+noodle north novel nugget number nurse nutmeg oak oasis oath ocean olive
+omega onion opera orange orbit orchid organ otter oven owl oxygen oyster
+paddle page paint palace palm panda paper parade parrot party pasta patch
+path peach peanut pear pebble pelican pencil penguin pepper piano pickle
+picnic pigeon pillow pilot pine pirate pizza planet plaza plum pocket poem
+polar pond pony poppy potato powder prism pulse pumpkin puppy puzzle quail
+quartz queen quest quick quiet quill quilt quiz rabbit radar radio radish
+raft rain rainbow raisin ranch raven razor recipe reef relay remote ribbon
+rice ridge river road robin robot rocket rodeo roof rose ruby rudder ruler
+saddle sail salad salmon salt sand satin sauce scarf school scout season
+seed shadow shark shell shelf ship shoe shore silk silver singer siren skate
+sketch skunk sled slope smile snail snake snow soap socket sofa solar sonic
+soup spark spider spoon spring square squid stable stamp star steam stone
+storm straw stream sugar summer sun swan sweater swing table tablet taco
+tail talent tango tape target tea teapot temple tennis tent thunder ticket
+tiger timber toast tomato tooth torch tower toy tractor trail train tree
+trophy trout tulip tuna tunnel turkey turtle tuxedo twig ukulele umbrella
+uncle unicorn union unit upper urban useful vacuum valley valve vanilla
+vapor vase velvet vendor venus verse vest violet violin visit visor vivid
+voice volcano voyage waffle wagon walnut walrus wand water wave wax weasel
+wheat wheel whistle willow window winter wizard wolf wombat wood wool world
+yacht yak yard yarn year yellow yeti yoga yogurt young zebra zero zigzag
+zinc zipper zone zoo
+`
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Memorable passwords can be composed from other languages' words by adding
+// wordlists to the -wordlists directory, one file per language named by its
+// code, e.g. de.txt, with the words separated by white space. Clients pick
+// a language with lang=. The built-in list is en unless en.txt replaces it.
+// Each word of a list adds log2 of its length bits of entropy, so lists
+// must have at least minWordlistWords distinct words. Lists can also be
+// weighted by how common their words are; see weightedWordlists.
+var wordlistDir = flag.String("wordlists", "", "`directory` of additional wordlists, one lang.txt or weighted lang.freq file per language")
+
+func init() {
+	registerFeature(feature{name: "additional wordlists", kind: "subsystem", active: func() bool {
+		return *wordlistDir != ""
+	}})
+}
+
+const (
+	defaultLang      = "en"
+	minWordlistWords = 512
+)
+
+// wordlists are the lists memorable passwords are composed from, by
+// language. They are only changed at startup.
+var wordlists = map[string][]string{defaultLang: words}
+
+// langCode matches language codes such as de and pt-br.
+var langCode = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// loadWordlists adds the wordlists in dir.
+func loadWordlists(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	weighted, err := filepath.Glob(filepath.Join(dir, "*.freq"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 && len(weighted) == 0 {
+		return fmt.Errorf("no wordlists in %s", dir)
+	}
+	for _, path := range paths {
+		lang := strings.TrimSuffix(filepath.Base(path), ".txt")
+		if !langCode.MatchString(lang) {
+			return fmt.Errorf("%s: %q isn't a language code", path, lang)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		list, err := parseWordlist(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		wordlists[lang] = list
+		rankedDictionaries[dictionaryName(lang)] = rankList(list, true)
+	}
+	for _, path := range weighted {
+		if err := loadWeightedWordlist(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dictionaryName returns the name of lang's wordlist among the strength
+// estimator's dictionaries.
+func dictionaryName(lang string) string {
+	if lang == defaultLang {
+		return "words"
+	}
+	return "words_" + lang
+}
+
+// parseWordlist returns the distinct words in s, in lowercase.
+func parseWordlist(s string) ([]string, error) {
+	seen := make(map[string]bool)
+	var list []string
+	for _, w := range strings.Fields(s) {
+		w = strings.ToLower(w)
+		for _, r := range w {
+			if !unicode.IsLetter(r) {
+				return nil, fmt.Errorf("%q isn't a word", w)
+			}
+		}
+		if !seen[w] {
+			seen[w] = true
+			list = append(list, w)
+		}
+	}
+	if len(list) < minWordlistWords {
+		return nil, fmt.Errorf("%d distinct words, at least %d are needed", len(list), minWordlistWords)
+	}
+	return list, nil
+}
+
+// capitalize returns w with its first letter in uppercase.
+func capitalize(w string) string {
+	r, n := utf8.DecodeRuneInString(w)
+	return string(unicode.ToUpper(r)) + w[n:]
+}
+
+type wordlistInfo struct {
+	Lang        string  `json:"lang" xml:"lang,attr"`
+	Words       int     `json:"words" xml:"words,attr"`
+	BitsPerWord float64 `json:"bits_per_word" xml:"bits_per_word,attr"`
+	// Weighted describes the language's weighted list, if it has one.
+	Weighted *weightedInfo `json:"weighted,omitempty" xml:"weighted,omitempty"`
+}
+
+type weightedInfo struct {
+	Words int `json:"words" xml:"words,attr"`
+	// ShannonBits is the average entropy of a word drawn by weight, and
+	// MinBits the entropy passwords are credited with per word.
+	ShannonBits float64 `json:"shannon_bits_per_word" xml:"shannon_bits_per_word,attr"`
+	MinBits     float64 `json:"min_bits_per_word" xml:"min_bits_per_word,attr"`
+}
+
+type wordlistsResult struct {
+	XMLName   xml.Name       `json:"-" xml:"wordlists"`
+	Wordlists []wordlistInfo `json:"wordlists" xml:"wordlist"`
+}
+
+func (r wordlistsResult) text() string {
+	var sb strings.Builder
+	for _, l := range r.Wordlists {
+		fmt.Fprintf(&sb, "%s\t%d words\t%.1f bits per word", l.Lang, l.Words, l.BitsPerWord)
+		if w := l.Weighted; w != nil {
+			fmt.Fprintf(&sb, "\tweighted: %d words, %.1f bits per word (%.1f min)", w.Words, w.ShannonBits, w.MinBits)
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (r wordlistsResult) csvRecords() [][]string {
+	records := [][]string{{"lang", "words", "bits_per_word", "weighted_words", "shannon_bits_per_word", "min_bits_per_word"}}
+	for _, l := range r.Wordlists {
+		record := []string{l.Lang, strconv.Itoa(l.Words), strconv.FormatFloat(l.BitsPerWord, 'f', 1, 64), "", "", ""}
+		if w := l.Weighted; w != nil {
+			record[3] = strconv.Itoa(w.Words)
+			record[4] = strconv.FormatFloat(w.ShannonBits, 'f', 1, 64)
+			record[5] = strconv.FormatFloat(w.MinBits, 'f', 1, 64)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// wordlistsHandler lists the available wordlists.
+func wordlistsHandler(w http.ResponseWriter, req *http.Request) {
+	var r wordlistsResult
+	for lang, list := range wordlists {
+		bits := math.Round(math.Log2(float64(len(list)))*10) / 10
+		info := wordlistInfo{Lang: lang, Words: len(list), BitsPerWord: bits}
+		if w := weightedWordlists[lang]; w != nil {
+			info.Weighted = &weightedInfo{len(w.words), math.Round(w.shannon*10) / 10, math.Round(w.minEntropy*10) / 10}
+		}
+		r.Wordlists = append(r.Wordlists, info)
+	}
+	sort.Slice(r.Wordlists, func(i, j int) bool {
+		return r.Wordlists[i].Lang < r.Wordlists[j].Lang
+	})
+	render(w, req, r)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Results are rendered as YAML by converting their JSON encoding, so they
+// have the same fields in the same order. Strings are always double-quoted,
+// which YAML reads exactly as JSON does, so that passwords such as "no" or
+// "0x1f" aren't read as booleans or numbers.
+
+// yamlNode is a JSON value with the order of its object's keys.
+type yamlNode struct {
+	// scalar is the YAML of a string, number, boolean or null.
+	scalar string
+	// keys and values are those of an object, or values those of an
+	// array if isArray.
+	keys    []string
+	values  []yamlNode
+	isArray bool
+}
+
+// yamlTree returns the tree of v's JSON encoding.
+func yamlTree(v interface{}) (yamlNode, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return yamlNode{}, err
+	}
+	dec := json.NewDecoder(&buf)
+	dec.UseNumber()
+	return readYAMLNode(dec)
+}
+
+func readYAMLNode(dec *json.Decoder) (yamlNode, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return yamlNode{}, err
+	}
+	var n yamlNode
+	switch t := t.(type) {
+	case json.Delim:
+		n.isArray = t == '['
+		for dec.More() {
+			if !n.isArray {
+				k, err := dec.Token()
+				if err != nil {
+					return n, err
+				}
+				n.keys = append(n.keys, k.(string))
+			}
+			v, err := readYAMLNode(dec)
+			if err != nil {
+				return n, err
+			}
+			n.values = append(n.values, v)
+		}
+		// Consume the closing delimiter.
+		if _, err := dec.Token(); err != nil {
+			return n, err
+		}
+	case string:
+		n.scalar = yamlString(t)
+	case json.Number:
+		n.scalar = t.String()
+	case bool:
+		n.scalar = "false"
+		if t {
+			n.scalar = "true"
+		}
+	case nil:
+		n.scalar = "null"
+	}
+	return n, nil
+}
+
+func (n yamlNode) isCollection() bool {
+	return n.isArray || n.keys != nil || n.scalar == ""
+}
+
+// yamlString returns s as a double-quoted YAML scalar.
+func yamlString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+var plainYAMLKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+func yamlKey(k string) string {
+	if plainYAMLKey.MatchString(k) {
+		return k
+	}
+	return yamlString(k)
+}
+
+// writeYAML writes n as a YAML document.
+func writeYAML(w io.Writer, n yamlNode) {
+	if !n.isCollection() || len(n.values) == 0 {
+		io.WriteString(w, n.inline()+"\n")
+		return
+	}
+	n.write(w, "")
+}
+
+// inline returns n on a single line, for scalars and empty collections.
+func (n yamlNode) inline() string {
+	switch {
+	case n.isArray:
+		return "[]"
+	case n.isCollection():
+		return "{}"
+	}
+	return n.scalar
+}
+
+// write writes the collection n in block style, indenting each line.
+func (n yamlNode) write(w io.Writer, indent string) {
+	for i, v := range n.values {
+		prefix := "- "
+		if !n.isArray {
+			prefix = yamlKey(n.keys[i]) + ":"
+		}
+		if !v.isCollection() || len(v.values) == 0 {
+			if !n.isArray {
+				prefix += " "
+			}
+			io.WriteString(w, indent+prefix+v.inline()+"\n")
+			continue
+		}
+		if n.isArray {
+			v.writeItem(w, indent)
+			continue
+		}
+		io.WriteString(w, indent+prefix+"\n")
+		if v.isArray {
+			// Sequences in mappings needn't be indented.
+			v.write(w, indent)
+		} else {
+			v.write(w, indent+"  ")
+		}
+	}
+}
+
+// writeItem writes the non-empty collection n as an item of a sequence,
+// starting on the line of its dash.
+func (n yamlNode) writeItem(w io.Writer, indent string) {
+	var buf bytes.Buffer
+	n.write(&buf, indent+"  ")
+	b := buf.Bytes()
+	w.Write([]byte(indent + "- "))
+	w.Write(b[len(indent)+2:])
+}